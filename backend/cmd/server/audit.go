@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	auditAllowed = "allowed"
+	auditDenied  = "denied"
+	auditFailed  = "failed"
+)
+
+// An audit entry records who tried to do what, so that every retirement can be traced
+// back to the credential that asked for it.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Method    string    `json:"method"`
+	Remote    string    `json:"remote"`
+	Route     string    `json:"route"`
+	Action    string    `json:"action"`
+	Contract  string    `json:"contract,omitempty"`
+	KYC       string    `json:"kyc,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// auditLog writes one JSON object per line. If it has no writer then entries go to
+// the normal server log.
+type auditLog struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func newAuditLog(writer io.Writer) *auditLog {
+	return &auditLog{
+		writer: writer,
+	}
+}
+
+func (a *auditLog) record(r *http.Request, caller principal, action string, contract string, kyc string, outcome string, detail string) {
+	entry := auditEntry{
+		Time:      time.Now().UTC(),
+		Principal: caller.Name,
+		Method:    caller.Method,
+		Remote:    r.RemoteAddr,
+		Route:     r.Method + " " + r.URL.Path,
+		Action:    action,
+		Contract:  contract,
+		KYC:       kyc,
+		Outcome:   outcome,
+		Detail:    detail,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+
+	if a == nil || a.writer == nil {
+		log.Printf("AUDIT %s", data)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.writer.Write(append(data, '\n'))
+	if err != nil {
+		log.Printf("Failed to write audit entry %s: %v", data, err)
+	}
+}
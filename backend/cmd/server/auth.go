@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+
+	// Used in a list of contracts or KYCs to allow all of them
+	authWildcard = "*"
+)
+
+// An API key is stored as the hex encoded SHA-256 of the key, so the config file
+// isn't itself a secret.
+type apiKeyConfig struct {
+	Name      string   `json:"name"`
+	KeyHash   string   `json:"keyHash"`
+	Contracts []string `json:"contracts"`
+	KYCs      []string `json:"kycs"`
+}
+
+type authConfig struct {
+	APIKeys []apiKeyConfig `json:"apiKeys"`
+	JWT     *jwtConfig     `json:"jwt,omitempty"`
+}
+
+// principal is who made a request, and what they're allowed to act on.
+type principal struct {
+	Name      string
+	Method    string
+	Contracts []string
+	KYCs      []string
+}
+
+// Used when authentication is turned off.
+var anonymousPrincipal = principal{
+	Name:      "anonymous",
+	Method:    "none",
+	Contracts: []string{authWildcard},
+	KYCs:      []string{authWildcard},
+}
+
+func scopeAllows(scope []string, value string) bool {
+	for _, allowed := range scope {
+		if allowed == authWildcard || allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p principal) canAccessContract(contract string) bool {
+	return scopeAllows(p.Contracts, contract)
+}
+
+func (p principal) canAccessKYC(kyc string) bool {
+	return scopeAllows(p.KYCs, kyc)
+}
+
+func (p principal) allows(contract string, kyc string) bool {
+	return p.canAccessContract(contract) && p.canAccessKYC(kyc)
+}
+
+type authenticator struct {
+	api_keys []apiKeyConfig
+	jwt      *jwtVerifier
+}
+
+func loadAuthenticator(path string) (*authenticator, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth config: %w", err)
+	}
+	var config authConfig
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode auth config: %w", err)
+	}
+	return newAuthenticator(config)
+}
+
+func newAuthenticator(config authConfig) (*authenticator, error) {
+	auth := &authenticator{
+		api_keys: config.APIKeys,
+	}
+	for index, key := range config.APIKeys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("API key %d has no name", index)
+		}
+		hash, err := hex.DecodeString(key.KeyHash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("API key %s must have a hex encoded SHA-256 keyHash", key.Name)
+		}
+	}
+	if config.JWT != nil {
+		verifier, err := loadJWTVerifier(*config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		auth.jwt = verifier
+	}
+	if len(auth.api_keys) == 0 && auth.jwt == nil {
+		return nil, fmt.Errorf("auth config has no API keys or JWT settings")
+	}
+	return auth, nil
+}
+
+func (a *authenticator) authenticateAPIKey(key string) (principal, error) {
+	hash := sha256.Sum256([]byte(key))
+	for _, api_key := range a.api_keys {
+		expected, _ := hex.DecodeString(api_key.KeyHash)
+		if subtle.ConstantTimeCompare(hash[:], expected) == 1 {
+			return principal{
+				Name:      api_key.Name,
+				Method:    "api-key",
+				Contracts: api_key.Contracts,
+				KYCs:      api_key.KYCs,
+			}, nil
+		}
+	}
+	return principal{}, fmt.Errorf("unknown API key")
+}
+
+func (a *authenticator) authenticateBearer(token string) (principal, error) {
+	if a.jwt == nil {
+		return principal{}, fmt.Errorf("bearer tokens are not accepted")
+	}
+	claims, err := a.jwt.verify(token)
+	if err != nil {
+		return principal{}, err
+	}
+	return principal{
+		Name:      claims.Subject,
+		Method:    "jwt",
+		Contracts: claims.Contracts,
+		KYCs:      claims.KYCs,
+	}, nil
+}
+
+func (a *authenticator) authenticate(r *http.Request) (principal, error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, found := strings.Cut(authorization, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return principal{}, fmt.Errorf("unsupported authorization scheme")
+		}
+		return a.authenticateBearer(strings.TrimSpace(token))
+	}
+	return principal{}, fmt.Errorf("no credentials provided")
+}
+
+type principalContextKey struct{}
+
+func principalFromContext(ctx context.Context) principal {
+	if p, ok := ctx.Value(principalContextKey{}).(principal); ok {
+		return p
+	}
+	return anonymousPrincipal
+}
+
+// authenticated wraps a route so that it can only be called with valid credentials.
+// Handlers then use principalFromContext to check what the caller may access.
+func (s *server) authenticated(handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		caller := anonymousPrincipal
+		if s.auth != nil {
+			var err error
+			caller, err = s.auth.authenticate(r)
+			if err != nil {
+				s.audit.record(r, principal{Name: "unknown"}, "authenticate", "", "", auditDenied, err.Error())
+				w.Header().Set("WWW-Authenticate", `Bearer realm="x4c"`)
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), principalContextKey{}, caller)
+		handler(w, r.WithContext(ctx), ps)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+const (
+	testCustodian = "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"
+	testAPIKey    = "secret-key"
+	testAllKey    = "all-key"
+)
+
+func hashTestKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func newAuthTestServer(t *testing.T, client tzclient.MockClient, keys testJWTKeys, audit *bytes.Buffer) server {
+	auth, err := newAuthenticator(authConfig{
+		APIKeys: []apiKeyConfig{
+			{
+				Name:      "compsci-frontend",
+				KeyHash:   hashTestKey(testAPIKey),
+				Contracts: []string{testCustodian},
+				KYCs:      []string{"compsci"},
+			},
+			{
+				Name:      "admin",
+				KeyHash:   hashTestKey(testAllKey),
+				Contracts: []string{authWildcard},
+				KYCs:      []string{authWildcard},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	auth.jwt, err = newJWTVerifier(keys.KeySet, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	return SetupMyHandlers(client, operator, serverOptions{
+		Auth:  auth,
+		Audit: newAuditLog(audit),
+	})
+}
+
+func TestAuthenticatorConfig(t *testing.T) {
+	testcases := []struct {
+		Config      authConfig
+		ExpectError bool
+	}{
+		{
+			Config:      authConfig{},
+			ExpectError: true,
+		},
+		{
+			Config: authConfig{
+				APIKeys: []apiKeyConfig{{Name: "test", KeyHash: hashTestKey("key")}},
+			},
+			ExpectError: false,
+		},
+		{
+			Config: authConfig{
+				APIKeys: []apiKeyConfig{{Name: "test", KeyHash: "key"}},
+			},
+			ExpectError: true,
+		},
+		{
+			Config: authConfig{
+				APIKeys: []apiKeyConfig{{KeyHash: hashTestKey("key")}},
+			},
+			ExpectError: true,
+		},
+		{
+			Config: authConfig{
+				JWT: &jwtConfig{JWKSFile: "/does/not/exist"},
+			},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		_, err := newAuthenticator(testcase.Config)
+		if testcase.ExpectError && err == nil {
+			t.Errorf("%d: Expected error", index)
+		} else if !testcase.ExpectError && err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+		}
+	}
+}
+
+func TestRetireAuthorization(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwt_claims := func(kycs []string) map[string]interface{} {
+		return map[string]interface{}{
+			"sub":           "jwt-user",
+			"exp":           time.Now().Add(time.Hour).Unix(),
+			"x4c_contracts": []string{testCustodian},
+			"x4c_kycs":      kycs,
+		}
+	}
+
+	testcases := []struct {
+		Contract       string
+		KYC            string
+		Headers        map[string]string
+		ExpectedStatus int
+		ExpectedAudit  string
+	}{
+		{
+			Contract:       testCustodian,
+			KYC:            "compsci",
+			ExpectedStatus: http.StatusUnauthorized,
+			ExpectedAudit:  `"outcome":"denied"`,
+		},
+		{
+			Contract:       testCustodian,
+			KYC:            "compsci",
+			Headers:        map[string]string{apiKeyHeader: "wrong"},
+			ExpectedStatus: http.StatusUnauthorized,
+			ExpectedAudit:  `"action":"authenticate"`,
+		},
+		{
+			Contract:       testCustodian,
+			KYC:            "compsci",
+			Headers:        map[string]string{apiKeyHeader: testAPIKey},
+			ExpectedStatus: http.StatusAccepted,
+			ExpectedAudit:  `"principal":"compsci-frontend"`,
+		},
+		{
+			Contract:       testCustodian,
+			KYC:            "other org",
+			Headers:        map[string]string{apiKeyHeader: testAPIKey},
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedAudit:  `"kyc":"other org","outcome":"denied"`,
+		},
+		{
+			Contract:       "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			KYC:            "compsci",
+			Headers:        map[string]string{apiKeyHeader: testAPIKey},
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedAudit:  `"outcome":"denied"`,
+		},
+		{
+			Contract:       "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			KYC:            "other org",
+			Headers:        map[string]string{apiKeyHeader: testAllKey},
+			ExpectedStatus: http.StatusAccepted,
+			ExpectedAudit:  `"principal":"admin"`,
+		},
+		{
+			Contract:       testCustodian,
+			KYC:            "compsci",
+			Headers:        map[string]string{"Authorization": "Bearer " + signTestJWT(t, keys.EC, "ES256", "ec", jwt_claims([]string{"compsci"}))},
+			ExpectedStatus: http.StatusAccepted,
+			ExpectedAudit:  `"principal":"jwt-user","method":"jwt"`,
+		},
+		{
+			Contract:       testCustodian,
+			KYC:            "compsci",
+			Headers:        map[string]string{"Authorization": "Bearer " + signTestJWT(t, keys.EC, "ES256", "ec", jwt_claims([]string{"other org"}))},
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedAudit:  `"principal":"jwt-user"`,
+		},
+		{
+			Contract:       testCustodian,
+			KYC:            "compsci",
+			Headers:        map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			ExpectedStatus: http.StatusUnauthorized,
+			ExpectedAudit:  `"outcome":"denied"`,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		var audit bytes.Buffer
+		server := newAuthTestServer(t, tzclient.NewMockClient(), keys, &audit)
+
+		request := CreditRetireRequest{
+			Minter:  "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			KYC:     testcase.KYC,
+			TokenID: "123",
+			Amount:  "10",
+			Reason:  "fun",
+		}
+		requestBody, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.NewRequest("POST", "/contract/"+testcase.Contract+"/retire", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range testcase.Headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		resp.Body.Close()
+		if resp.StatusCode != testcase.ExpectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.ExpectedStatus, resp.StatusCode)
+		}
+		if !strings.Contains(audit.String(), testcase.ExpectedAudit) {
+			t.Errorf("%d: Expected audit log to contain %s, got %s", idx, testcase.ExpectedAudit, audit.String())
+		}
+		lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+		if len(lines) != 1 {
+			t.Errorf("%d: Expected one audit entry, got %d", idx, len(lines))
+		}
+	}
+}
+
+func TestCreditSourcesAuthorization(t *testing.T) {
+	keys := newTestJWTKeys(t)
+
+	client := tzclient.NewMockClient()
+	client.AddBigMap(1234, []tzkt.BigMapItem{
+		{
+			Active: true,
+			Key:    json.RawMessage(`{"token": {"token_id": 42, "token_address": "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB"}, "kyc": "0501000000096f74686572206f7267"}`),
+			Value:  json.RawMessage(`1234`),
+		},
+	})
+
+	testcases := []struct {
+		Custodian      string
+		Key            string
+		ExpectedStatus int
+		ExpectedItems  int
+	}{
+		{
+			Custodian:      testCustodian,
+			Key:            "",
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			// Allowed the contract, but not the KYC in it
+			Custodian:      testCustodian,
+			Key:            testAPIKey,
+			ExpectedStatus: http.StatusOK,
+			ExpectedItems:  0,
+		},
+		{
+			Custodian:      testCustodian,
+			Key:            testAllKey,
+			ExpectedStatus: http.StatusOK,
+			ExpectedItems:  1,
+		},
+		{
+			Custodian:      "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			Key:            testAPIKey,
+			ExpectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client.Storage = &x4c.CustodianStorage{Ledger: 1234}
+		var audit bytes.Buffer
+		server := newAuthTestServer(t, client, keys, &audit)
+
+		r, err := http.NewRequest("GET", "/credit/sources/"+testcase.Custodian, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.Key != "" {
+			r.Header.Set(apiKeyHeader, testcase.Key)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.ExpectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.ExpectedStatus, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var result CreditSourcesResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Errorf("%d: Failed to decode response: %v", idx, err)
+			continue
+		}
+		if len(result.Data) != testcase.ExpectedItems {
+			t.Errorf("%d: Expected %d items, got %v", idx, testcase.ExpectedItems, result.Data)
+		}
+	}
+}
+
+func TestGetJobAuthorization(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	var audit bytes.Buffer
+	server := newAuthTestServer(t, tzclient.NewMockClient(), keys, &audit)
+
+	job, err := server.jobs.enqueue(newTestJob("other org"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	testcases := []struct {
+		Key            string
+		ExpectedStatus int
+	}{
+		{
+			Key:            "",
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Key:            testAPIKey,
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Key:            testAllKey,
+			ExpectedStatus: http.StatusOK,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		r, err := http.NewRequest("GET", "/jobs/"+job.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.Key != "" {
+			r.Header.Set(apiKeyHeader, testcase.Key)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		resp.Body.Close()
+		if resp.StatusCode != testcase.ExpectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.ExpectedStatus, resp.StatusCode)
+		}
+	}
+}
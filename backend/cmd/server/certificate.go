@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"blockwatch.cc/tzgo/tezos"
+	"github.com/julienschmidt/httprouter"
+
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+// The certificate key is kept in a file rather than the environment so that it doesn't
+// end up in process listings or logs.
+func loadCertificateKey(path string) (*tezos.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := tezos.ParsePrivateKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+	return &key, nil
+}
+
+// Returns a signed certificate for the retirements made by an operation on the registry
+// contracts. The format query parameter picks pdf (the default), html, or json.
+func (s *server) getCertificate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	hash := ps.ByName("opHash")
+	if hash == "" {
+		http.Error(w, "No operation hash specified", http.StatusBadRequest)
+		return
+	}
+	// Only retirements on the registry contracts are certified
+	if s.certificateKey == nil || len(s.registryContracts) == 0 {
+		http.Error(w, "Certificates are not available", http.StatusServiceUnavailable)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" && format != "json" {
+		http.Error(w, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	certificate, err := x4c.GenerateCertificate(r.Context(), s.tezosClient, s.registryContracts, hash)
+	if err != nil {
+		if errors.Is(err, x4c.ErrNotCertifiable) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to generate certificate for %s: %v", hash, err)
+		http.Error(w, "Failed to look up operation", http.StatusFailedDependency)
+		return
+	}
+	err = certificate.Sign(*s.certificateKey)
+	if err != nil {
+		log.Printf("Failed to sign certificate for %s: %v", hash, err)
+		http.Error(w, "Failed to sign certificate", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = certificate.WriteHTML(w)
+	case "json":
+		err = json.NewEncoder(w).Encode(certificate)
+	default:
+		w.Header().Set("Content-Type", "application/pdf")
+		err = certificate.WritePDF(w)
+	}
+	if err != nil {
+		log.Printf("Failed to write certificate response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
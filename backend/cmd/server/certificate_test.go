@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+func TestGetCertificate(t *testing.T) {
+	fa2 := "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"
+	// A contract that isn't in the registry but emits a retire event that looks like a
+	// custodian's
+	impostor := "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"
+	mockClient := tzclient.NewMockClient()
+	mockClient.Operations = map[string][]tzkt.Operation{
+		"ooRetire": {
+			{Identifier: 10, Level: 500, Hash: "ooRetire", Status: "applied", Target: &tzkt.OperationParty{Address: fa2}},
+		},
+		"ooImpostor": {
+			{Identifier: 11, Level: 501, Hash: "ooImpostor", Status: "applied", Target: &tzkt.OperationParty{Address: impostor}},
+		},
+	}
+	mockClient.Events = []tzkt.Event{
+		{
+			Identifier:    100,
+			Level:         500,
+			TransactionID: 10,
+			Contract:      tzkt.EventContractInfo{Address: &fa2},
+			Tag:           "retire",
+			Payload:       json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "tokenId": "1", "amount": "5", "retiring_data": "050100000007666c6967687473"}`),
+		},
+		{
+			Identifier:    101,
+			Level:         501,
+			TransactionID: 11,
+			Contract:      tzkt.EventContractInfo{Address: &impostor},
+			Tag:           "retire",
+			Payload:       json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "retiring_party_kyc": "050100000007636f6d70736369", "token": {"token_id": "1", "token_address": "` + fa2 + `"}, "amount": "5000", "retiring_data": "050100000007666c6967687473"}`),
+		},
+	}
+	mockClient.Storage = &x4c.FA2Storage{TokenMetadata: 5}
+	registry, err := tzclient.NewContractWithAddress("fa2", fa2)
+	if err != nil {
+		t.Fatalf("Failed to make contract: %v", err)
+	}
+
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("Failed to make key: %v", err)
+	}
+
+	testcases := []struct {
+		target         string
+		withKey        bool
+		withRegistry   bool
+		expectedStatus int
+		expectedType   string
+	}{
+		{
+			target:         "/retirements/ooRetire/certificate",
+			withKey:        true,
+			withRegistry:   true,
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/pdf",
+		},
+		{
+			target:         "/retirements/ooRetire/certificate?format=html",
+			withKey:        true,
+			withRegistry:   true,
+			expectedStatus: http.StatusOK,
+			expectedType:   "text/html; charset=utf-8",
+		},
+		{
+			target:         "/retirements/ooRetire/certificate?format=json",
+			withKey:        true,
+			withRegistry:   true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			target:         "/retirements/ooUnknown/certificate",
+			withKey:        true,
+			withRegistry:   true,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			target:         "/retirements/ooRetire/certificate?format=doc",
+			withKey:        true,
+			withRegistry:   true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			target:         "/retirements/ooImpostor/certificate",
+			withKey:        true,
+			withRegistry:   true,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			target:         "/retirements/ooRetire/certificate",
+			withKey:        false,
+			withRegistry:   true,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			target:         "/retirements/ooRetire/certificate",
+			withKey:        true,
+			withRegistry:   false,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		options := serverOptions{}
+		if testcase.withKey {
+			options.CertificateKey = &key
+		}
+		if testcase.withRegistry {
+			options.RegistryContracts = []tzclient.Contract{registry}
+		}
+		operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+		server := SetupMyHandlers(mockClient, operator, options)
+
+		r, err := http.NewRequest("GET", testcase.target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if testcase.expectedType != "" {
+			if content_type := resp.Header.Get("Content-Type"); content_type != testcase.expectedType {
+				t.Errorf("%d: Expected content type %s, got %s", idx, testcase.expectedType, content_type)
+			}
+			continue
+		}
+		var certificate x4c.Certificate
+		err = json.NewDecoder(resp.Body).Decode(&certificate)
+		if err != nil {
+			t.Errorf("%d: failed to decode response: %v", idx, err)
+			continue
+		}
+		err = certificate.Verify()
+		if err != nil {
+			t.Errorf("%d: Expected certificate to verify: %v", idx, err)
+		}
+		if len(certificate.Retirements) != 1 || certificate.Retirements[0].Reason != "flights" {
+			t.Errorf("%d: Unexpected retirements %v", idx, certificate.Retirements)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+// Works out the HTTP status for a contract rejecting a call. These are all client
+// errors, as the contract has decided the request is not valid.
+func contractErrorStatus(contract_error x4c.ContractError) int {
+	switch contract_error.Name {
+	case "PERMISSIONS_DENIED", "NOT_OWNER", "NOT_OPERATOR", "TX_DENIED":
+		return http.StatusForbidden
+	case "TOKEN_UNDEFINED", "ADDRESS_NOT_FOUND":
+		return http.StatusNotFound
+	case "INSUFFICIENT_BALANCE":
+		return http.StatusUnprocessableEntity
+	case "ID_ALREADY_IN_USE", "COLLISION":
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// Reports the failure of a contract call, using a client error status and readable
+// message if the contract rejected the call, and an internal error otherwise.
+func writeContractCallError(w http.ResponseWriter, err error) {
+	// If we don't recognise the error code then we can't say it was the client's fault
+	var contract_error x4c.ContractError
+	if errors.As(err, &contract_error) && (contract_error.Name != "") {
+		err_str := fmt.Sprintf("Contract rejected request: %s (%s)", contract_error.Description, contract_error.Name)
+		http.Error(w, err_str, contractErrorStatus(contract_error))
+		return
+	}
+	err_str := fmt.Sprintf("Failed call contract: %v", err)
+	http.Error(w, err_str, http.StatusInternalServerError)
+}
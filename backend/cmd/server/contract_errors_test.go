@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+func TestRetireContractErrors(t *testing.T) {
+	custodian := tezos.MustParseAddress("KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2 := tezos.MustParseAddress("KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	testcases := []struct {
+		callError      error
+		dryRun         bool
+		expectedStatus int
+		expectedText   string
+	}{
+		{
+			callError:      tzclient.RejectedError{Contract: custodian, With: micheline.NewInt64(x4c.CustodianInsufficientBalance)},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedText:   "INSUFFICIENT_BALANCE",
+		},
+		{
+			callError:      tzclient.RejectedError{Contract: custodian, With: micheline.NewInt64(x4c.CustodianPermissionsDenied)},
+			expectedStatus: http.StatusForbidden,
+			expectedText:   "PERMISSIONS_DENIED",
+		},
+		{
+			callError:      tzclient.RejectedError{Contract: fa2, With: micheline.NewInt64(x4c.FA2TokenUndefined)},
+			expectedStatus: http.StatusNotFound,
+			expectedText:   "TOKEN_UNDEFINED",
+		},
+		{
+			callError:      tzclient.RejectedError{Contract: custodian, With: micheline.NewInt64(x4c.CustodianInsufficientBalance)},
+			dryRun:         true,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedText:   "INSUFFICIENT_BALANCE",
+		},
+		{
+			callError:      tzclient.RejectedError{Contract: custodian, With: micheline.NewInt64(42)},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			callError:      fmt.Errorf("node unavailable"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.CallError = testcase.callError
+		server := newMockServer(client)
+
+		request := CreditRetireRequest{
+			Minter:  "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			KYC:     "compsci",
+			TokenID: "123",
+			Amount:  "123",
+			Reason:  "fun",
+		}
+		requestBody, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		url := "/contract/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm/retire"
+		if testcase.dryRun {
+			url += "?dryRun=true"
+		}
+		r, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer func() {
+			resp.Body.Close()
+		}()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d: %s", idx, testcase.expectedStatus, resp.StatusCode, string(body))
+		}
+		if !strings.Contains(string(body), testcase.expectedText) {
+			t.Errorf("%d: Expected body to contain %q, got %s", idx, testcase.expectedText, string(body))
+		}
+	}
+}
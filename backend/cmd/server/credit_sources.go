@@ -40,6 +40,13 @@ func (s *server) getCreditSources(w http.ResponseWriter, r *http.Request, ps htt
 		}
 	}
 
+	caller := principalFromContext(r.Context())
+	if !caller.canAccessContract(contract.Address.String()) {
+		s.audit.record(r, caller, "credit-sources", contract.Address.String(), "", auditDenied, "")
+		http.Error(w, "Not permitted to view this custodian", http.StatusForbidden)
+		return
+	}
+
 	var storage x4c.CustodianStorage
 	err = s.tezosClient.GetContractStorage(contract, r.Context(), &storage)
 	if err != nil {
@@ -48,7 +55,19 @@ func (s *server) getCreditSources(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
-	ledger, err := storage.GetLedger(r.Context(), s.tezosClient)
+	// Auditors can ask what was held at some point in the past
+	var ledger x4c.Ledger
+	if at := r.URL.Query().Get("at"); at != "" {
+		var moment x4c.Moment
+		moment, err = x4c.ParseMoment(at)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid at value: %v", err), http.StatusBadRequest)
+			return
+		}
+		ledger, err = storage.GetLedgerAt(r.Context(), s.tezosClient, moment)
+	} else {
+		ledger, err = storage.GetLedger(r.Context(), s.tezosClient)
+	}
 	if err != nil {
 		log.Printf("Failed to lookup contract ledger (%d) %s: %v", storage.Ledger, custodian_address, err)
 		http.Error(w, "Failed to get ledger storage", http.StatusInternalServerError)
@@ -68,6 +87,10 @@ func (s *server) getCreditSources(w http.ResponseWriter, r *http.Request, ps htt
 		if err != nil {
 			kyc = key.RawKYC
 		}
+		// Only show the caller the KYCs they're allowed to know about
+		if !caller.canAccessKYC(kyc) {
+			continue
+		}
 		indexerURL := s.tezosClient.GetIndexerWebURL()
 		item := CreditSourcesResponseItem{
 			TokenID:      token_id,
@@ -80,6 +103,9 @@ func (s *server) getCreditSources(w http.ResponseWriter, r *http.Request, ps htt
 		results = append(results, item)
 	}
 
+	s.audit.record(r, caller, "credit-sources", contract.Address.String(), "", auditAllowed,
+		fmt.Sprintf("returned %d sources", len(results)))
+
 	response := CreditSourcesResponse{
 		Data: results,
 	}
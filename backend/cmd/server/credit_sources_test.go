@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"net/http/httputil"
 	"testing"
+	"time"
 
 	"quantify.earth/x4c/pkg/tzclient"
 	"quantify.earth/x4c/pkg/tzkt"
@@ -116,3 +117,74 @@ func TestGetCreditSources(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCreditSourcesAt(t *testing.T) {
+	mockClient := tzclient.NewMockClient()
+	key := json.RawMessage(`{"token": {"token_id": 42, "token_address": "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB"}, "kyc": "0501000000096f74686572206f7267"}`)
+	mockClient.AddBigMap(1234, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`1`)}})
+	mockClient.AddHistoricalBigMap(1234, 100, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`500`)}})
+	mockClient.Blocks = []tzkt.Block{
+		{Level: 100, Timestamp: time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)},
+	}
+	mockClient.Storage = &x4c.CustodianStorage{Ledger: 1234}
+
+	testcases := []struct {
+		at             string
+		expectedStatus int
+		expectedAmount int64
+	}{
+		{
+			at:             "",
+			expectedStatus: http.StatusOK,
+			expectedAmount: 1,
+		},
+		{
+			at:             "150",
+			expectedStatus: http.StatusOK,
+			expectedAmount: 500,
+		},
+		{
+			at:             "2023-03-31",
+			expectedStatus: http.StatusOK,
+			expectedAmount: 500,
+		},
+		{
+			at:             "yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		server := newMockServer(mockClient)
+
+		target := "/credit/sources/KT1Lw1p7rDaZixeX1SpmdNAueWW3QihZ31C6"
+		if testcase.at != "" {
+			target += "?at=" + testcase.at
+		}
+		r, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var result CreditSourcesResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Errorf("%d: failed to decode response: %v", idx, err)
+			continue
+		}
+		if len(result.Data) != 1 || result.Data[0].Amount != testcase.expectedAmount {
+			t.Errorf("%d: Expected amount %d, got %v", idx, testcase.expectedAmount, result.Data)
+		}
+	}
+}
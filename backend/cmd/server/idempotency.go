@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyReplayHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+
+	// How long we remember a key for. Clients are expected to retry within minutes,
+	// so this is very generous.
+	idempotencyKeyLifetime = 24 * time.Hour
+)
+
+var (
+	errIdempotencyKeyReused     = errors.New("idempotency key has already been used for a different request")
+	errIdempotencyKeyInProgress = errors.New("a request with this idempotency key is already in progress")
+)
+
+// The response we sent the first time a key was used, so that we can send it again
+// to any retries. Successful responses are kept, as are errors from requests that may
+// still have taken effect, but other failures are forgotten so that the request can be
+// retried with the same key.
+type idempotencyRecord struct {
+	Key         string    `json:"key"`
+	RequestHash string    `json:"requestHash"`
+	StatusCode  int       `json:"statusCode"`
+	ContentType string    `json:"contentType,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Body        string    `json:"body"`
+	Created     time.Time `json:"created"`
+}
+
+// Like the job store, this is kept in memory and written out in full on each change
+// if it has a path.
+type idempotencyStore struct {
+	path string
+
+	lock        sync.Mutex
+	records     map[string]idempotencyRecord
+	in_progress map[string]string
+}
+
+func newMemoryIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{
+		records:     make(map[string]idempotencyRecord),
+		in_progress: make(map[string]string),
+	}
+}
+
+func loadIdempotencyStore(path string) (*idempotencyStore, error) {
+	store := newMemoryIdempotencyStore()
+	store.path = path
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("failed to open idempotency store: %w", err)
+	}
+	var records []idempotencyRecord
+	err = json.Unmarshal(content, &records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode idempotency store: %w", err)
+	}
+	for _, record := range records {
+		store.records[record.Key] = record
+	}
+	store.removeExpired()
+	return store, nil
+}
+
+// Must be called with the lock held.
+func (s *idempotencyStore) removeExpired() {
+	cutoff := time.Now().Add(-idempotencyKeyLifetime)
+	for key, record := range s.records {
+		if record.Created.Before(cutoff) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (s *idempotencyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	records := make([]idempotencyRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	data, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency store: %w", err)
+	}
+	err = writeFileAtomically(s.path, data)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency store: %w", err)
+	}
+	return nil
+}
+
+// Claims the key for a request. If the key has already been used for the same
+// request then the original response is returned, and if it was used for a
+// different request or is in use right now an error is returned. Otherwise the
+// caller must call finish once it has a response.
+func (s *idempotencyStore) begin(key string, request_hash string) (idempotencyRecord, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[key]; ok && time.Since(record.Created) < idempotencyKeyLifetime {
+		if record.RequestHash != request_hash {
+			return idempotencyRecord{}, false, errIdempotencyKeyReused
+		}
+		return record, true, nil
+	}
+	if in_progress_hash, ok := s.in_progress[key]; ok {
+		if in_progress_hash != request_hash {
+			return idempotencyRecord{}, false, errIdempotencyKeyReused
+		}
+		return idempotencyRecord{}, false, errIdempotencyKeyInProgress
+	}
+	s.in_progress[key] = request_hash
+	return idempotencyRecord{}, false, nil
+}
+
+// Releases the key, and if the request succeeded or must not be repeated remembers the
+// response.
+func (s *idempotencyStore) finish(record idempotencyRecord, remember bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.in_progress, record.Key)
+	if !remember && (record.StatusCode < 200 || record.StatusCode > 299) {
+		return nil
+	}
+	s.records[record.Key] = record
+	s.removeExpired()
+	return s.save()
+}
+
+// Keeps a copy of the response whilst passing it on to the client.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status_code int
+	body        bytes.Buffer
+	remember    bool
+}
+
+// Handlers call this before sending an error when they can't tell whether the request
+// took effect, such as when a retirement was queued but we don't know whether it
+// reached the chain. The error is then replayed to retries with the same key, rather
+// than the request being made a second time.
+func rememberResponse(w http.ResponseWriter) {
+	if recorder, ok := w.(*recordingResponseWriter); ok {
+		recorder.remember = true
+	}
+}
+
+func (w *recordingResponseWriter) WriteHeader(status_code int) {
+	if w.status_code == 0 {
+		w.status_code = status_code
+	}
+	w.ResponseWriter.WriteHeader(status_code)
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	if w.status_code == 0 {
+		w.status_code = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func hashRequest(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotent wraps a write route so that a client can safely retry a request by
+// sending the same Idempotency-Key header. Requests without the header are handled
+// as normal.
+func (s *server) idempotent(handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		raw_key := r.Header.Get(idempotencyKeyHeader)
+		if raw_key == "" {
+			handler(w, r, ps)
+			return
+		}
+		if len(raw_key) > maxIdempotencyKeyLength {
+			err_str := fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+			http.Error(w, err_str, http.StatusBadRequest)
+			return
+		}
+		// Keys are per caller, so one caller can't see another's response by
+		// guessing their key
+		key := principalFromContext(r.Context()).Name + "/" + raw_key
+
+		// We need the body to tell whether this is the same request, so read it
+		// all now and give the handler a copy
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1048576))
+		if err != nil {
+			http.Error(w, "Failed to read request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		request_hash := hashRequest(r, body)
+
+		record, found, err := s.idempotency.begin(key, request_hash)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to process %s: %v", idempotencyKeyHeader, err), http.StatusConflict)
+			return
+		}
+		if found {
+			if record.ContentType != "" {
+				w.Header().Set("Content-Type", record.ContentType)
+			}
+			if record.Location != "" {
+				w.Header().Set("Location", record.Location)
+			}
+			w.Header().Set(idempotencyReplayHeader, "true")
+			w.WriteHeader(record.StatusCode)
+			_, err = io.WriteString(w, record.Body)
+			if err != nil {
+				log.Printf("Failed to write replayed response: %v", err)
+			}
+			return
+		}
+
+		recorder := &recordingResponseWriter{ResponseWriter: w}
+		handler(recorder, r, ps)
+
+		err = s.idempotency.finish(idempotencyRecord{
+			Key:         key,
+			RequestHash: request_hash,
+			StatusCode:  recorder.status_code,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Location:    recorder.Header().Get("Location"),
+			Body:        recorder.body.String(),
+			Created:     time.Now().UTC(),
+		}, recorder.remember)
+		if err != nil {
+			// The response has already gone, so all we can do is log it
+			log.Printf("Failed to save idempotency key: %v", err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+func makeRetireRequest(t *testing.T, server server, key string, amount string) (*http.Response, string) {
+	request := CreditRetireRequest{
+		Minter:  "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+		KYC:     "compsci",
+		TokenID: "123",
+		Amount:  json.Number(amount),
+		Reason:  "fun",
+	}
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest("POST", "/contract/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm/retire", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		r.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, r)
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func TestIdempotentRetire(t *testing.T) {
+	testcases := []struct {
+		firstKey       string
+		firstAmount    string
+		secondKey      string
+		secondAmount   string
+		callError      error
+		expectedStatus int
+		expectReplay   bool
+		expectedJobs   int
+	}{
+		{
+			// Same key and body is a replay
+			firstKey:       "key1",
+			firstAmount:    "10",
+			secondKey:      "key1",
+			secondAmount:   "10",
+			expectedStatus: http.StatusAccepted,
+			expectReplay:   true,
+			expectedJobs:   1,
+		},
+		{
+			// Same key with a different body is rejected
+			firstKey:       "key1",
+			firstAmount:    "10",
+			secondKey:      "key1",
+			secondAmount:   "20",
+			expectedStatus: http.StatusConflict,
+			expectReplay:   false,
+			expectedJobs:   1,
+		},
+		{
+			firstKey:       "key1",
+			firstAmount:    "10",
+			secondKey:      "key2",
+			secondAmount:   "10",
+			expectedStatus: http.StatusAccepted,
+			expectReplay:   false,
+			expectedJobs:   2,
+		},
+		{
+			// Without a key there is no protection
+			firstAmount:    "10",
+			secondAmount:   "10",
+			expectedStatus: http.StatusAccepted,
+			expectReplay:   false,
+			expectedJobs:   2,
+		},
+		{
+			// Failures aren't remembered, so can be retried
+			firstKey:       "key1",
+			firstAmount:    "10",
+			secondKey:      "key1",
+			secondAmount:   "10",
+			callError:      fmt.Errorf("node unavailable"),
+			expectedStatus: http.StatusInternalServerError,
+			expectReplay:   false,
+			expectedJobs:   0,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.CallError = testcase.callError
+		server := newMockServer(client)
+
+		first, first_body := makeRetireRequest(t, server, testcase.firstKey, testcase.firstAmount)
+		second, second_body := makeRetireRequest(t, server, testcase.secondKey, testcase.secondAmount)
+
+		if second.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d: %s", idx, testcase.expectedStatus, second.StatusCode, second_body)
+		}
+		replayed := second.Header.Get(idempotencyReplayHeader) == "true"
+		if replayed != testcase.expectReplay {
+			t.Errorf("%d: Expected replay %v, got %v", idx, testcase.expectReplay, replayed)
+		}
+		if testcase.expectReplay {
+			if second_body != first_body {
+				t.Errorf("%d: Expected replayed body %s, got %s", idx, first_body, second_body)
+			}
+			if second.Header.Get("Location") != first.Header.Get("Location") {
+				t.Errorf("%d: Expected replayed location %s, got %s", idx, first.Header.Get("Location"), second.Header.Get("Location"))
+			}
+		}
+		jobs := server.jobs.store.pending(server.custodianOperator.Address.String())
+		if len(jobs) != testcase.expectedJobs {
+			t.Errorf("%d: Expected %d jobs, got %d", idx, testcase.expectedJobs, len(jobs))
+		}
+	}
+}
+
+func TestIdempotentLegacyRetire(t *testing.T) {
+	testcases := []struct {
+		failWith       error
+		expectedStatus int
+		expectReplay   bool
+		expectedCalls  int
+	}{
+		{
+			// Nothing was sent, so the request can be retried
+			failWith:       tzclient.RejectedError{},
+			expectedStatus: http.StatusInternalServerError,
+			expectReplay:   false,
+			expectedCalls:  2,
+		},
+		{
+			// The retirement may be on chain, so a retry gets the same error
+			// rather than retiring the credits again
+			failWith:       fmt.Errorf("connection reset"),
+			expectedStatus: http.StatusInternalServerError,
+			expectReplay:   true,
+			expectedCalls:  1,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client := &jobTestClient{
+			MockClient: tzclient.NewMockClient(),
+			FailCalls:  1,
+			FailWith:   testcase.failWith,
+		}
+		server := newMockServer(client)
+		ctx, cancel := context.WithCancel(context.Background())
+		go server.jobs.Run(ctx)
+
+		request := func() (*http.Response, string) {
+			body := `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 10, "reason": "fun"}`
+			r, err := http.NewRequest("POST", "/retire/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set(idempotencyKeyHeader, "key1")
+			w := httptest.NewRecorder()
+			server.mux.ServeHTTP(w, r)
+			resp := w.Result()
+			resp_body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return resp, string(resp_body)
+		}
+		first, first_body := request()
+		if first.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected first status %d, got %d: %s", idx, testcase.expectedStatus, first.StatusCode, first_body)
+		}
+		second, second_body := request()
+		cancel()
+
+		replayed := second.Header.Get(idempotencyReplayHeader) == "true"
+		if replayed != testcase.expectReplay {
+			t.Errorf("%d: Expected replay %v, got %v: %s", idx, testcase.expectReplay, replayed, second_body)
+		}
+		if testcase.expectReplay && second_body != first_body {
+			t.Errorf("%d: Expected replayed body %s, got %s", idx, first_body, second_body)
+		}
+		if client.Calls != testcase.expectedCalls {
+			t.Errorf("%d: Expected %d calls, got %d", idx, testcase.expectedCalls, client.Calls)
+		}
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.json")
+	store, err := loadIdempotencyStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	_, found, err := store.begin("key", "hash")
+	if err != nil || found {
+		t.Fatalf("Expected new key, got %v %v", found, err)
+	}
+	_, _, err = store.begin("key", "hash")
+	if !errors.Is(err, errIdempotencyKeyInProgress) {
+		t.Errorf("Expected in progress error, got %v", err)
+	}
+	_, _, err = store.begin("key", "other")
+	if !errors.Is(err, errIdempotencyKeyReused) {
+		t.Errorf("Expected reused error, got %v", err)
+	}
+
+	err = store.finish(idempotencyRecord{
+		Key:         "key",
+		RequestHash: "hash",
+		StatusCode:  http.StatusAccepted,
+		Body:        "body",
+		Created:     time.Now().UTC(),
+	}, false)
+	if err != nil {
+		t.Fatalf("Failed to finish: %v", err)
+	}
+	// An old record should be dropped when the store is loaded
+	err = store.finish(idempotencyRecord{
+		Key:         "old",
+		RequestHash: "hash",
+		StatusCode:  http.StatusAccepted,
+		Created:     time.Now().Add(-2 * idempotencyKeyLifetime),
+	}, false)
+	if err != nil {
+		t.Fatalf("Failed to finish: %v", err)
+	}
+
+	reloaded, err := loadIdempotencyStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	record, found, err := reloaded.begin("key", "hash")
+	if err != nil || !found {
+		t.Fatalf("Expected to find key after reload, got %v %v", found, err)
+	}
+	if record.Body != "body" || record.StatusCode != http.StatusAccepted {
+		t.Errorf("Unexpected record after reload: %v", record)
+	}
+	_, found, err = reloaded.begin("old", "hash")
+	if err != nil || found {
+		t.Errorf("Expected old key to have expired, got %v %v", found, err)
+	}
+}
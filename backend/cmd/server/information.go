@@ -82,7 +82,7 @@ func (s *server) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	events, err := s.tezosClient.GetContractEvents(r.Context(), contractAddress, tag)
+	events, err := s.tezosClient.GetContractEvents(r.Context(), contractAddress, tag, tzkt.QueryOptions{})
 	if err != nil {
 		log.Printf("Failed to lookup events tagged %s on %s: %v\n", tag, contractAddress, err)
 		http.Error(w, "Failed to get events", http.StatusInternalServerError)
@@ -10,9 +10,9 @@ import (
 	"quantify.earth/x4c/pkg/tzclient"
 )
 
-func newMockServer(client tzclient.MockClient) server {
+func newMockServer(client tzclient.TezosClient) server {
 	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
-	server := SetupMyHandlers(client, operator)
+	server := SetupMyHandlers(client, operator, serverOptions{})
 	return server
 }
 
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+const (
+	defaultJobConfirmations = 2
+	defaultRetireBatchSize  = 1
+)
+
+// How long to wait before trying again if we lose contact with the indexer whilst
+// waiting for an operation.
+var jobRetryInterval = 30 * time.Second
+
+// jobQueue owns all the operations for a single signer. Tezos only lets a wallet have
+// one operation in each block, and two operations built at the same time would use
+// the same counter, so the queue submits one operation at a time and waits for it to
+// be included before moving on. Retirements that queue up whilst waiting can be sent
+// together as a single retire call by setting batch_size above one.
+type jobQueue struct {
+	store         *jobStore
+	client        tzclient.TezosClient
+	signer        tzclient.Wallet
+	batch_size    int
+	confirmations int64
+
+	wake chan struct{}
+}
+
+func newJobQueue(store *jobStore, client tzclient.TezosClient, signer tzclient.Wallet, batch_size int, confirmations int64) *jobQueue {
+	if batch_size < 1 {
+		batch_size = 1
+	}
+	// We wait for each operation ourselves, so that jobs can be saved as injected
+	// whilst they are pending
+	return &jobQueue{
+		store:         store,
+		client:        tzclient.NewInjectingClient(client),
+		signer:        signer,
+		batch_size:    batch_size,
+		confirmations: confirmations,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func (q *jobQueue) enqueue(job RetireJob) (RetireJob, error) {
+	job.Signer = q.signer.Address.String()
+	job, err := q.store.add(job)
+	if err != nil {
+		return RetireJob{}, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+		// The worker already has a wake up pending
+	}
+	return job, nil
+}
+
+// Run processes jobs until the context is cancelled. Any jobs left over from before a
+// restart are picked up first.
+func (q *jobQueue) Run(ctx context.Context) {
+	for {
+		q.processPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+	}
+}
+
+// Works through the pending jobs in order until there are none left.
+func (q *jobQueue) processPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		pending := q.store.pending(q.signer.Address.String())
+		if len(pending) == 0 {
+			return
+		}
+
+		// If we were restarted whilst waiting for an operation, carry on waiting
+		// for it before sending anything else
+		first := pending[0]
+		if first.Status == JobInjected {
+			ids := make([]string, 0)
+			for _, job := range pending {
+				if job.Status == JobInjected && job.OperationHash == first.OperationHash {
+					ids = append(ids, job.ID)
+				}
+			}
+			q.confirm(ctx, ids, first.OperationHash)
+			continue
+		}
+
+		// A retire call goes to a single custodian, so only batch up jobs for the
+		// same one
+		batch := make([]RetireJob, 0, q.batch_size)
+		for _, job := range pending {
+			if job.Status == JobQueued && job.Contract == first.Contract {
+				batch = append(batch, job)
+				if len(batch) == q.batch_size {
+					break
+				}
+			}
+		}
+		q.submit(ctx, batch)
+	}
+}
+
+func (q *jobQueue) retire(ctx context.Context, batch []RetireJob) (string, error) {
+	contract, err := tzclient.NewContractWithAddress("contract", batch[0].Contract)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse contract address %s: %w", batch[0].Contract, err)
+	}
+	retire_list := make([]x4c.CustodianRetireInfo, 0, len(batch))
+	for _, job := range batch {
+		minter, err := tzclient.NewContractWithAddress("minter", job.Minter)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse minter address %s: %w", job.Minter, err)
+		}
+		// Jobs stored before retirements had metadata only have a reason
+		metadata := x4c.NoteRetirementMetadata(job.Reason)
+		if job.Metadata != nil {
+			metadata = *job.Metadata
+		}
+		retire_list = append(retire_list, x4c.CustodianRetireInfo{
+			TokenAddress: minter,
+			TokenID:      job.TokenID,
+			KYC:          job.KYC,
+			Amount:       job.Amount,
+			Metadata:     metadata,
+		})
+	}
+	return x4c.CustodianRetireBatch(ctx, q.client, contract, q.signer, retire_list)
+}
+
+func (q *jobQueue) submit(ctx context.Context, batch []RetireJob) {
+	ids := make([]string, 0, len(batch))
+	for _, job := range batch {
+		ids = append(ids, job.ID)
+	}
+
+	operation_hash, err := q.retire(ctx, batch)
+	if err != nil {
+		// If the node may have received the operation then it could still be included,
+		// so we wait for it as though it had been injected rather than risk retiring
+		// the credits twice
+		var broadcast_error tzclient.BroadcastError
+		if errors.As(err, &broadcast_error) {
+			log.Printf("Failed to broadcast operation %s for jobs %v, waiting to see if it is included: %v", broadcast_error.OperationHash, ids, err)
+			q.setStatus(ids, JobInjected, broadcast_error.OperationHash, "")
+			q.confirm(ctx, ids, broadcast_error.OperationHash)
+			return
+		}
+		// Otherwise only a rejection from the simulation tells us that nothing was
+		// injected, so anything else is left for someone to check against the chain
+		if !isRejection(err) {
+			log.Printf("Failed to retire jobs %v, outcome unknown: %v", ids, err)
+			q.setStatus(ids, JobUnknown, "", err.Error())
+			return
+		}
+		if len(batch) > 1 {
+			// A single bad retirement fails the whole operation, so try them one
+			// at a time so that the others still go through.
+			log.Printf("Failed to retire batch of %d jobs, retrying individually: %v", len(batch), err)
+			for _, job := range batch {
+				q.submit(ctx, []RetireJob{job})
+			}
+			return
+		}
+		log.Printf("Failed to retire job %s: %v", batch[0].ID, err)
+		q.setStatus(ids, JobFailed, "", err.Error())
+		return
+	}
+
+	q.setStatus(ids, JobInjected, operation_hash, "")
+	q.confirm(ctx, ids, operation_hash)
+}
+
+// Returns true if the error is the node refusing the operation when it was simulated,
+// in which case it was never injected.
+func isRejection(err error) bool {
+	var contract_error x4c.ContractError
+	return errors.As(err, &contract_error) || tzclient.IsLimitExceeded(err)
+}
+
+// Waits for the operation to be confirmed. If we can't reach the indexer we keep
+// trying, as the operation may well still be included, and it's better to leave a
+// job as injected than to tell someone it failed when it didn't.
+func (q *jobQueue) confirm(ctx context.Context, ids []string, operation_hash string) {
+	for {
+		status, err := q.client.WaitForConfirmation(ctx, operation_hash, q.confirmations)
+		if err == nil {
+			if status.IsApplied() {
+				q.setStatus(ids, JobConfirmed, operation_hash, "")
+			} else {
+				message := fmt.Sprintf("operation %s", status.Status)
+				if len(status.Errors) > 0 {
+					message += ": " + strings.Join(status.Errors, ", ")
+				}
+				q.setStatus(ids, JobFailed, operation_hash, message)
+			}
+			return
+		}
+		if errors.Is(err, tzclient.ErrOperationExpired) {
+			q.setStatus(ids, JobFailed, operation_hash, err.Error())
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Failed to confirm operation %s, will retry: %v", operation_hash, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobRetryInterval):
+		}
+	}
+}
+
+func (q *jobQueue) setStatus(ids []string, status JobStatus, operation_hash string, message string) {
+	err := q.store.update(ids, func(job *RetireJob) {
+		job.Status = status
+		job.OperationHash = operation_hash
+		job.Error = message
+	})
+	if err != nil {
+		// The in memory state is still updated, so we'll carry on and hope
+		// the next save works
+		log.Printf("Failed to save jobs %v as %s: %v", ids, status, err)
+	}
+}
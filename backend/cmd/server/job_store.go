@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobInjected  JobStatus = "injected"
+	JobConfirmed JobStatus = "confirmed"
+	JobFailed    JobStatus = "failed"
+	// Sending the operation failed in a way that means we can't tell whether it was
+	// injected, so the job isn't retried and needs checking against the chain
+	JobUnknown JobStatus = "unknown"
+)
+
+// RetireJob is a retirement request that has been accepted by the server but which
+// may not yet be on chain. Addresses are stored as strings so the job file is easy to
+// read when debugging.
+type RetireJob struct {
+	ID            string                  `json:"id"`
+	Status        JobStatus               `json:"status"`
+	Signer        string                  `json:"signer"`
+	Principal     string                  `json:"principal"`
+	Contract      string                  `json:"contract"`
+	Minter        string                  `json:"minter"`
+	TokenID       int64                   `json:"tokenID"`
+	KYC           string                  `json:"kyc"`
+	Amount        int64                   `json:"amount"`
+	Reason        string                  `json:"reason,omitempty"`
+	Metadata      *x4c.RetirementMetadata `json:"metadata,omitempty"`
+	OperationHash string                  `json:"operationHash,omitempty"`
+	Error         string                  `json:"error,omitempty"`
+	Created       time.Time               `json:"created"`
+	Updated       time.Time               `json:"updated"`
+}
+
+// The job store keeps every job in memory, and if it has a path writes the whole
+// lot out to disk after each change, so that jobs that have been accepted aren't
+// lost if the server restarts. We expect the number of retirements to be small
+// enough that this is fine.
+type jobStore struct {
+	path string
+
+	lock sync.Mutex
+	jobs map[string]RetireJob
+
+	// Closed and replaced whenever a job is updated, to wake anyone waiting on a job
+	changed chan struct{}
+}
+
+func newMemoryJobStore() *jobStore {
+	return &jobStore{
+		jobs:    make(map[string]RetireJob),
+		changed: make(chan struct{}),
+	}
+}
+
+func loadJobStore(path string) (*jobStore, error) {
+	store := newMemoryJobStore()
+	store.path = path
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("failed to open job store: %w", err)
+	}
+	var jobs []RetireJob
+	err = json.Unmarshal(content, &jobs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode job store: %w", err)
+	}
+	for _, job := range jobs {
+		store.jobs[job.ID] = job
+	}
+	return store, nil
+}
+
+func newJobID() (string, error) {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// Must be called with the lock held.
+func (s *jobStore) sortedJobs() []RetireJob {
+	jobs := make([]RetireJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Created.Equal(jobs[j].Created) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+// Must be called with the lock held.
+func (s *jobStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sortedJobs(), "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job store: %w", err)
+	}
+	err = writeFileAtomically(s.path, data)
+	if err != nil {
+		return fmt.Errorf("failed to save job store: %w", err)
+	}
+	return nil
+}
+
+// Adds a new job in the queued state, filling in its ID and timestamps.
+func (s *jobStore) add(job RetireJob) (RetireJob, error) {
+	id, err := newJobID()
+	if err != nil {
+		return RetireJob{}, err
+	}
+	now := time.Now().UTC()
+	job.ID = id
+	job.Status = JobQueued
+	job.Created = now
+	job.Updated = now
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.jobs[job.ID] = job
+	err = s.save()
+	if err != nil {
+		delete(s.jobs, job.ID)
+		return RetireJob{}, err
+	}
+	return job, nil
+}
+
+func (s *jobStore) get(id string) (RetireJob, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// Applies the change to all the listed jobs and saves them in one go.
+func (s *jobStore) update(ids []string, change func(job *RetireJob)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now().UTC()
+	for _, id := range ids {
+		job, ok := s.jobs[id]
+		if !ok {
+			return fmt.Errorf("job %s not found", id)
+		}
+		change(&job)
+		job.Updated = now
+		s.jobs[id] = job
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return s.save()
+}
+
+// Blocks until the job is finished, that is confirmed, failed, or unknown, or until
+// the context is done.
+func (s *jobStore) wait(ctx context.Context, id string) (RetireJob, error) {
+	for {
+		s.lock.Lock()
+		job, ok := s.jobs[id]
+		changed := s.changed
+		s.lock.Unlock()
+		if !ok {
+			return RetireJob{}, fmt.Errorf("job %s not found", id)
+		}
+		if job.Status != JobQueued && job.Status != JobInjected {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Returns the jobs for the signer that are not yet finished, oldest first.
+func (s *jobStore) pending(signer string) []RetireJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pending := make([]RetireJob, 0)
+	for _, job := range s.sortedJobs() {
+		if job.Signer != signer {
+			continue
+		}
+		if job.Status == JobQueued || job.Status == JobInjected {
+			pending = append(pending, job)
+		}
+	}
+	return pending
+}
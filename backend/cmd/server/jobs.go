@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type JobData struct {
+	ID                 string    `json:"id"`
+	Status             JobStatus `json:"status"`
+	OperationHash      string    `json:"operationHash,omitempty"`
+	OperationLookupURL string    `json:"operationLookupURL,omitempty"`
+	Error              string    `json:"error,omitempty"`
+	Created            time.Time `json:"created"`
+	Updated            time.Time `json:"updated"`
+}
+
+type GetJobResponse struct {
+	Data JobData `json:"data"`
+}
+
+func (s *server) newJobData(job RetireJob) JobData {
+	data := JobData{
+		ID:            job.ID,
+		Status:        job.Status,
+		OperationHash: job.OperationHash,
+		Error:         job.Error,
+		Created:       job.Created,
+		Updated:       job.Updated,
+	}
+	if job.OperationHash != "" {
+		data.OperationLookupURL = s.tezosClient.GetIndexerWebURL() + "/" + job.OperationHash
+	}
+	return data
+}
+
+func (s *server) getJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if id == "" {
+		http.Error(w, "No job ID specified", http.StatusBadRequest)
+		return
+	}
+
+	// Callers can't tell the difference between a job that doesn't exist and one
+	// that they don't have access to
+	job, ok := s.jobs.store.get(id)
+	if ok && !principalFromContext(r.Context()).allows(job.Contract, job.KYC) {
+		ok = false
+	}
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	response := GetJobResponse{
+		Data: s.newJobData(job),
+	}
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode get job response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+// A client that gives each injected operation a distinct hash, and can be told to fail
+// the first few calls or to report that the operation failed on chain. Calls fail with
+// the contract rejecting them unless FailWith is set.
+type jobTestClient struct {
+	tzclient.MockClient
+	Calls           int
+	FailCalls       int
+	FailWith        error
+	OperationStatus string
+}
+
+func (c *jobTestClient) InjectContractCall(ctx context.Context, signedBy tzclient.Wallet, target tzclient.Contract, parameters micheline.Parameters) (string, error) {
+	c.Calls += 1
+	if c.Calls <= c.FailCalls {
+		if c.FailWith != nil {
+			return "", c.FailWith
+		}
+		return "", tzclient.RejectedError{Contract: target.Address, With: micheline.NewInt64(x4c.CustodianInsufficientBalance)}
+	}
+	return fmt.Sprintf("operationHash%d", c.Calls), nil
+}
+
+func (c *jobTestClient) WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (tzclient.OperationStatus, error) {
+	status, err := c.MockClient.WaitForConfirmation(ctx, hash, confirmations)
+	if c.OperationStatus != "" {
+		status.Status = c.OperationStatus
+	}
+	return status, err
+}
+
+func newTestJob(kyc string) RetireJob {
+	return RetireJob{
+		Contract: "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm",
+		Minter:   "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+		TokenID:  123,
+		KYC:      kyc,
+		Amount:   10,
+		Reason:   "fun",
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+
+	testcases := []struct {
+		BatchSize       int
+		FailCalls       int
+		FailWith        error
+		OperationStatus string
+		Jobs            int
+		ExpectedCalls   int
+		ExpectedStatus  JobStatus
+		ExpectedHash    string
+	}{
+		{
+			BatchSize:      1,
+			Jobs:           3,
+			ExpectedCalls:  3,
+			ExpectedStatus: JobConfirmed,
+		},
+		{
+			BatchSize:      2,
+			Jobs:           3,
+			ExpectedCalls:  2,
+			ExpectedStatus: JobConfirmed,
+		},
+		{
+			// The batch is rejected, so each is retried on its own
+			BatchSize:      2,
+			FailCalls:      1,
+			Jobs:           2,
+			ExpectedCalls:  3,
+			ExpectedStatus: JobConfirmed,
+		},
+		{
+			// The node stopped responding after it was sent the operation, which was
+			// still included, so the jobs aren't sent again
+			BatchSize:      2,
+			FailCalls:      1,
+			FailWith:       tzclient.BroadcastError{OperationHash: "broadcastHash", Err: fmt.Errorf("connection reset")},
+			Jobs:           2,
+			ExpectedCalls:  1,
+			ExpectedStatus: JobConfirmed,
+			ExpectedHash:   "broadcastHash",
+		},
+		{
+			// We can't tell whether the operation was injected, so it isn't retried
+			BatchSize:      2,
+			FailCalls:      1,
+			FailWith:       fmt.Errorf("Test should fail"),
+			Jobs:           2,
+			ExpectedCalls:  1,
+			ExpectedStatus: JobUnknown,
+		},
+		{
+			BatchSize:      1,
+			FailCalls:      1,
+			Jobs:           1,
+			ExpectedCalls:  1,
+			ExpectedStatus: JobFailed,
+		},
+		{
+			BatchSize:       1,
+			OperationStatus: tzclient.OperationBacktracked,
+			Jobs:            1,
+			ExpectedCalls:   1,
+			ExpectedStatus:  JobFailed,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &jobTestClient{
+			MockClient:      tzclient.NewMockClient(),
+			FailCalls:       testcase.FailCalls,
+			FailWith:        testcase.FailWith,
+			OperationStatus: testcase.OperationStatus,
+		}
+		queue := newJobQueue(newMemoryJobStore(), client, operator, testcase.BatchSize, 1)
+
+		ids := make([]string, 0, testcase.Jobs)
+		for i := 0; i < testcase.Jobs; i++ {
+			job, err := queue.enqueue(newTestJob(fmt.Sprintf("kyc%d", i)))
+			if err != nil {
+				t.Fatalf("%d: Failed to enqueue job: %v", index, err)
+			}
+			if job.Status != JobQueued {
+				t.Errorf("%d: Expected new job to be queued, got %s", index, job.Status)
+			}
+			ids = append(ids, job.ID)
+		}
+
+		queue.processPending(context.Background())
+
+		if client.Calls != testcase.ExpectedCalls {
+			t.Errorf("%d: Expected %d calls, got %d", index, testcase.ExpectedCalls, client.Calls)
+		}
+		for _, id := range ids {
+			job, ok := queue.store.get(id)
+			if !ok {
+				t.Errorf("%d: Job %s missing", index, id)
+				continue
+			}
+			if job.Status != testcase.ExpectedStatus {
+				t.Errorf("%d: Expected job %s to be %s, got %v", index, id, testcase.ExpectedStatus, job)
+			}
+			if job.Status == JobConfirmed && job.OperationHash == "" {
+				t.Errorf("%d: Expected confirmed job to have operation hash", index)
+			}
+			if testcase.ExpectedHash != "" && job.OperationHash != testcase.ExpectedHash {
+				t.Errorf("%d: Expected job to have operation hash %s, got %v", index, testcase.ExpectedHash, job)
+			}
+			if (job.Status == JobFailed || job.Status == JobUnknown) && job.Error == "" {
+				t.Errorf("%d: Expected %s job to have an error", index, job.Status)
+			}
+		}
+		if len(queue.store.pending(operator.Address.String())) != 0 {
+			t.Errorf("%d: Expected no pending jobs", index)
+		}
+	}
+}
+
+func TestOnlyJobQueueWrites(t *testing.T) {
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	client := &jobTestClient{MockClient: tzclient.NewMockClient()}
+	server := SetupMyHandlers(client, operator, serverOptions{})
+
+	contract, _ := tzclient.NewContractWithAddress("contract", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	_, err := server.tezosClient.CallContract(context.Background(), operator, contract, micheline.Parameters{})
+	if err == nil {
+		t.Errorf("Expected handler client to refuse contract calls")
+	}
+	_, err = server.tezosClient.InjectContractCall(context.Background(), operator, contract, micheline.Parameters{})
+	if err == nil {
+		t.Errorf("Expected handler client to refuse injections")
+	}
+	if client.Calls != 0 {
+		t.Errorf("Expected no calls to reach the chain, got %d", client.Calls)
+	}
+
+	job, err := server.jobs.enqueue(newTestJob("kyc"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	server.jobs.processPending(context.Background())
+	if client.Calls != 1 {
+		t.Errorf("Expected the queue to make 1 call, got %d", client.Calls)
+	}
+	job, _ = server.jobs.store.get(job.ID)
+	if job.Status != JobConfirmed {
+		t.Errorf("Expected job to be confirmed, got %v", job)
+	}
+}
+
+func TestJobStorePersists(t *testing.T) {
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	store, err := loadJobStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create job store: %v", err)
+	}
+	client := &jobTestClient{MockClient: tzclient.NewMockClient()}
+	queue := newJobQueue(store, client, operator, 1, 1)
+	queued, err := queue.enqueue(newTestJob("queued"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	injected, err := queue.enqueue(newTestJob("injected"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	// Pretend we were stopped after injecting the operation but before it was confirmed
+	err = store.update([]string{injected.ID}, func(job *RetireJob) {
+		job.Status = JobInjected
+		job.OperationHash = "earlierHash"
+	})
+	if err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+
+	reloaded, err := loadJobStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload job store: %v", err)
+	}
+	pending := reloaded.pending(operator.Address.String())
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 pending jobs after reload, got %v", pending)
+	}
+
+	client = &jobTestClient{MockClient: tzclient.NewMockClient()}
+	queue = newJobQueue(reloaded, client, operator, 1, 1)
+	queue.processPending(context.Background())
+
+	// Only the queued job should have needed injecting
+	if client.Calls != 1 {
+		t.Errorf("Expected 1 call after reload, got %d", client.Calls)
+	}
+	job, _ := reloaded.get(injected.ID)
+	if job.Status != JobConfirmed || job.OperationHash != "earlierHash" {
+		t.Errorf("Expected injected job to be confirmed with original hash, got %v", job)
+	}
+	job, _ = reloaded.get(queued.ID)
+	if job.Status != JobConfirmed || job.OperationHash != "operationHash1" {
+		t.Errorf("Expected queued job to be confirmed, got %v", job)
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	client := tzclient.NewMockClient()
+	server := newMockServer(client)
+
+	job, err := server.jobs.enqueue(newTestJob("compsci"))
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	testcases := []struct {
+		ID             string
+		Process        bool
+		ExpectedCode   int
+		ExpectedStatus JobStatus
+	}{
+		{
+			ID:           "unknown",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			ID:             job.ID,
+			ExpectedCode:   http.StatusOK,
+			ExpectedStatus: JobQueued,
+		},
+		{
+			ID:             job.ID,
+			Process:        true,
+			ExpectedCode:   http.StatusOK,
+			ExpectedStatus: JobConfirmed,
+		},
+	}
+
+	for index, testcase := range testcases {
+		if testcase.Process {
+			server.jobs.processPending(context.Background())
+		}
+
+		r, err := http.NewRequest("GET", "/jobs/"+testcase.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer func() {
+			resp.Body.Close()
+		}()
+
+		if resp.StatusCode != testcase.ExpectedCode {
+			t.Errorf("%d: Expected status code %d, got %d", index, testcase.ExpectedCode, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		var result GetJobResponse
+		decoder := json.NewDecoder(resp.Body)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&result)
+		if err != nil {
+			t.Errorf("%d: Failed to decode response: %v", index, err)
+			continue
+		}
+		if result.Data.Status != testcase.ExpectedStatus {
+			t.Errorf("%d: Expected job status %s, got %v", index, testcase.ExpectedStatus, result.Data)
+		}
+		if testcase.ExpectedStatus == JobConfirmed {
+			if result.Data.OperationHash != "operationHash" {
+				t.Errorf("%d: Did not get expected operation hash: %v", index, result.Data)
+			}
+			if result.Data.OperationLookupURL != "https://index.web/operationHash" {
+				t.Errorf("%d: Did not get expected lookup URL: %v", index, result.Data)
+			}
+		}
+	}
+}
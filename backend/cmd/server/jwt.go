@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// We only need to check tokens issued by a known identity provider, so rather than pull
+// in a JWT library this supports just the two signing algorithms in common use, with the
+// public keys read from a local JWKS file.
+
+type jwtConfig struct {
+	JWKSFile string `json:"jwksFile"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// The audience can be either a single string or a list.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("audience must be a string or list of strings")
+	}
+	*a = list
+	return nil
+}
+
+// The x4c claims say which custodian contracts and KYC identities the bearer may act
+// for, in the same form as for API keys.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+	Contracts []string    `json:"x4c_contracts"`
+	KYCs      []string    `json:"x4c_kycs"`
+}
+
+type jwtVerifier struct {
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func decodeBase64URLInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBase64URLInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBase64URLInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decodeBase64URLInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBase64URLInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
+
+func loadJWTVerifier(config jwtConfig) (*jwtVerifier, error) {
+	content, err := os.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JWKS file: %w", err)
+	}
+	var key_set jsonWebKeySet
+	err = json.Unmarshal(content, &key_set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS file: %w", err)
+	}
+	return newJWTVerifier(key_set, config.Issuer, config.Audience)
+}
+
+func newJWTVerifier(key_set jsonWebKeySet, issuer string, audience string) (*jwtVerifier, error) {
+	if len(key_set.Keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no keys")
+	}
+	verifier := &jwtVerifier{
+		keys:     make(map[string]crypto.PublicKey),
+		issuer:   issuer,
+		audience: audience,
+		now:      time.Now,
+	}
+	for index, key := range key_set.Keys {
+		public_key, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d in JWKS: %w", index, err)
+		}
+		verifier.keys[key.KeyID] = public_key
+	}
+	return verifier, nil
+}
+
+func decodeJWTPart(part string, result interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (v *jwtVerifier) verifySignature(header jwtHeader, signed []byte, signature []byte) error {
+	public_key, ok := v.keys[header.KeyID]
+	if !ok {
+		return fmt.Errorf("unknown key ID %q", header.KeyID)
+	}
+	digest := sha256.Sum256(signed)
+
+	switch header.Algorithm {
+	case "RS256":
+		rsa_key, ok := public_key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an RSA key", header.KeyID)
+		}
+		return rsa.VerifyPKCS1v15(rsa_key, crypto.SHA256, digest[:], signature)
+	case "ES256":
+		ec_key, ok := public_key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an EC key", header.KeyID)
+		}
+		if len(signature) != 64 {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ec_key, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+}
+
+func (v *jwtVerifier) verify(token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed token signature: %w", err)
+	}
+	err = v.verifySignature(header, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	var claims jwtClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("malformed token claims: %w", err)
+	}
+
+	now := v.now().Unix()
+	if claims.ExpiresAt == 0 || now >= claims.ExpiresAt {
+		return jwtClaims{}, fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return jwtClaims{}, fmt.Errorf("token is not yet valid")
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return jwtClaims{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if v.audience != "" {
+		found := false
+		for _, audience := range claims.Audience {
+			if audience == v.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return jwtClaims{}, fmt.Errorf("token is not for this audience")
+		}
+	}
+	if claims.Subject == "" {
+		return jwtClaims{}, fmt.Errorf("token has no subject")
+	}
+	return claims, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeJWTPart(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signTestJWT(t *testing.T, key crypto.Signer, algorithm string, key_id string, claims map[string]interface{}) string {
+	signed := encodeJWTPart(t, map[string]string{"alg": algorithm, "kid": key_id, "typ": "JWT"}) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+type testJWTKeys struct {
+	RSA    *rsa.PrivateKey
+	EC     *ecdsa.PrivateKey
+	KeySet jsonWebKeySet
+}
+
+func newTestJWTKeys(t *testing.T) testJWTKeys {
+	rsa_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec_key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testJWTKeys{
+		RSA: rsa_key,
+		EC:  ec_key,
+		KeySet: jsonWebKeySet{
+			Keys: []jsonWebKey{
+				{
+					KeyType: "RSA",
+					KeyID:   "rsa",
+					N:       encodeBigInt(rsa_key.N),
+					E:       encodeBigInt(big.NewInt(int64(rsa_key.E))),
+				},
+				{
+					KeyType: "EC",
+					KeyID:   "ec",
+					Curve:   "P-256",
+					X:       encodeBigInt(ec_key.X),
+					Y:       encodeBigInt(ec_key.Y),
+				},
+			},
+		},
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	verifier, err := newJWTVerifier(keys.KeySet, "https://issuer", "x4c")
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	other_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid_claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":           "frontend",
+			"iss":           "https://issuer",
+			"aud":           []string{"x4c", "other"},
+			"exp":           now.Add(time.Hour).Unix(),
+			"x4c_contracts": []string{"KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"},
+			"x4c_kycs":      []string{"compsci"},
+		}
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := valid_claims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	testcases := []struct {
+		Token       string
+		ExpectError bool
+	}{
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", valid_claims()),
+			ExpectError: false,
+		},
+		{
+			Token:       signTestJWT(t, keys.EC, "ES256", "ec", valid_claims()),
+			ExpectError: false,
+		},
+		{
+			// A single audience is allowed to be a string
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("aud", "x4c")),
+			ExpectError: false,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("exp", now.Add(-time.Minute).Unix())),
+			ExpectError: true,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("exp", nil)),
+			ExpectError: true,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("nbf", now.Add(time.Hour).Unix())),
+			ExpectError: true,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("iss", "https://elsewhere")),
+			ExpectError: true,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("aud", "other")),
+			ExpectError: true,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "rsa", with("sub", nil)),
+			ExpectError: true,
+		},
+		{
+			// Signed by a key we don't know about
+			Token:       signTestJWT(t, other_key, "RS256", "rsa", valid_claims()),
+			ExpectError: true,
+		},
+		{
+			// Algorithm doesn't match the key
+			Token:       signTestJWT(t, keys.RSA, "ES256", "rsa", valid_claims()),
+			ExpectError: true,
+		},
+		{
+			Token:       signTestJWT(t, keys.RSA, "RS256", "unknown", valid_claims()),
+			ExpectError: true,
+		},
+		{
+			Token:       encodeJWTPart(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + encodeJWTPart(t, valid_claims()) + ".",
+			ExpectError: true,
+		},
+		{
+			Token:       "not.a.jwt",
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		claims, err := verifier.verify(testcase.Token)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %v", index, claims)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if claims.Subject != "frontend" {
+			t.Errorf("%d: Unexpected subject %s", index, claims.Subject)
+		}
+		if len(claims.KYCs) != 1 || claims.KYCs[0] != "compsci" {
+			t.Errorf("%d: Unexpected KYCs %v", index, claims.KYCs)
+		}
+	}
+
+	// Tampering with the claims should break the signature
+	token := signTestJWT(t, keys.EC, "ES256", "ec", valid_claims())
+	parts := strings.Split(token, ".")
+	parts[1] = encodeJWTPart(t, with("x4c_kycs", []string{authWildcard}))
+	_, err = verifier.verify(strings.Join(parts, "."))
+	if err == nil {
+		t.Errorf("Expected tampered token to fail")
+	}
+}
@@ -1,38 +1,101 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"blockwatch.cc/tzgo/tezos"
 	"github.com/julienschmidt/httprouter"
 
+	"quantify.earth/x4c/pkg/index"
 	"quantify.earth/x4c/pkg/tzclient"
 )
 
+// How often the local index, if there is one, is brought up to date. This is about
+// the Tezos block time, so reads are rarely more than a block behind.
+const indexSyncInterval = 15 * time.Second
+
+// If the index falls further behind than this, say because the indexer can't reach
+// tzkt, reads go straight to the chain until it catches up again.
+const defaultIndexMaxLag = 2 * time.Minute
+
 type server struct {
 	mux               *httprouter.Router
 	tezosClient       tzclient.TezosClient
 	custodianOperator tzclient.Wallet
+	jobs              *jobQueue
+	idempotency       *idempotencyStore
+	auth              *authenticator
+	audit             *auditLog
+	certificateKey    *tezos.PrivateKey
+	registryContracts []tzclient.Contract
+	retirements       *retirementCache
+}
+
+// Where the server keeps its state. Anything left unset is kept in memory only, which
+// is fine for testing but means it will be lost on restart.
+type serverOptions struct {
+	Jobs            *jobStore
+	RetireBatchSize int
+	Idempotency     *idempotencyStore
+
+	// If Auth is nil then all requests are allowed
+	Auth  *authenticator
+	Audit *auditLog
+
+	// If CertificateKey is nil then retirement certificates are not available
+	CertificateKey *tezos.PrivateKey
+
+	// The custodian and FA2 contracts whose retirements are listed in the public
+	// registry, which is unavailable if there are none
+	RegistryContracts []tzclient.Contract
 }
 
-func SetupMyHandlers(client tzclient.TezosClient, operator tzclient.Wallet) server {
+func SetupMyHandlers(client tzclient.TezosClient, operator tzclient.Wallet, options serverOptions) server {
+
+	if options.Jobs == nil {
+		options.Jobs = newMemoryJobStore()
+	}
+	if options.RetireBatchSize == 0 {
+		options.RetireBatchSize = defaultRetireBatchSize
+	}
+	if options.Idempotency == nil {
+		options.Idempotency = newMemoryIdempotencyStore()
+	}
 
 	router := httprouter.New()
 	server := server{
 		mux:               router,
-		tezosClient:       client,
+		tezosClient:       readOnlyClient{client},
 		custodianOperator: operator,
+		jobs:              newJobQueue(options.Jobs, client, operator, options.RetireBatchSize, defaultJobConfirmations),
+		idempotency:       options.Idempotency,
+		auth:              options.Auth,
+		audit:             options.Audit,
+		certificateKey:    options.CertificateKey,
+		registryContracts: options.RegistryContracts,
+		retirements:       &retirementCache{},
 	}
 
-	router.GET("/credit/sources/:custodianID", server.getCreditSources)
+	router.GET("/credit/sources/:custodianID", server.authenticated(server.getCreditSources))
+	router.GET("/custodian/:custodianID/kyc/:kyc/statement", server.authenticated(server.getStatement))
 	router.GET("/operation/:opHash", server.getOperation)
 	router.GET("/info/indexer-url", server.getIndexerURL)
 	router.GET("/contract/:contractHash/events/:tag", server.getEvents)
-	router.POST("/contract/:contractHash/retire", server.retire)
+	router.GET("/retirements", server.getRetirements)
+	router.GET("/retirement-totals", server.getRetirementTotals)
+	router.GET("/retirements/:opHash/certificate", server.getCertificate)
+	router.GET("/tokens/:fa2/:tokenId", server.getToken)
+	router.POST("/contract/:contractHash/retire", server.authenticated(server.idempotent(server.retire)))
+	router.GET("/jobs/:id", server.authenticated(server.getJob))
 
 	// legacy API endpoints for compatibility
-	router.POST("/retire/:contractHash", server.retire)
+	router.POST("/retire/:contractHash", server.authenticated(server.idempotent(server.legacyRetire)))
 
 	return server
 }
@@ -44,14 +107,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	if client.Network != "" {
+		log.Printf("Network profile: %v\n", client.Network)
+	}
 	log.Printf("Tezos RPC URL: %v\n", client.RPCURL)
-	log.Printf("Indexer RPC URL: %v\n", client.IndexerRPCURL)
+	if client.Reader == "node" {
+		log.Printf("Reading chain state from the node, scanning from level %v\n", client.FirstLevel)
+	} else {
+		log.Printf("Indexer RPC URL: %v\n", client.IndexerRPCURL)
+	}
 	log.Printf("Indexer Web URL: %v\n", client.GetIndexerWebURL())
 	log.Printf("Signatory URL: %v\n", client.SignatoryURL)
 
 	operator_name := os.Getenv("X4C_CUSTODIAN_OPERATOR")
 	if operator_name == "" {
-		log.Printf("No operator specified (use env var X4C_CUSTODIAN_OPERATOR)")
+		operator_name = client.DefaultSigner
+	}
+	if operator_name == "" {
+		log.Printf("No operator specified (use env var X4C_CUSTODIAN_OPERATOR or the profile's default_signer)")
 		os.Exit(1)
 	}
 	operator, ok := client.Wallets[operator_name]
@@ -66,6 +139,144 @@ func main() {
 	}
 	log.Printf("Operator address: %v\n", operator.Address.String())
 
-	server := SetupMyHandlers(client, operator)
+	job_store_path := os.Getenv("X4C_JOB_STORE")
+	if job_store_path == "" {
+		job_store_path = "x4c_jobs.json"
+	}
+	jobs, err := loadJobStore(job_store_path)
+	if err != nil {
+		log.Printf("Failed to load job store %v: %v", job_store_path, err)
+		os.Exit(1)
+	}
+	log.Printf("Job store: %v\n", job_store_path)
+
+	idempotency_store_path := os.Getenv("X4C_IDEMPOTENCY_STORE")
+	if idempotency_store_path == "" {
+		idempotency_store_path = "x4c_idempotency.json"
+	}
+	idempotency, err := loadIdempotencyStore(idempotency_store_path)
+	if err != nil {
+		log.Printf("Failed to load idempotency store %v: %v", idempotency_store_path, err)
+		os.Exit(1)
+	}
+	log.Printf("Idempotency store: %v\n", idempotency_store_path)
+
+	// Refuse to run without authentication unless explicitly told to, as otherwise
+	// anyone who can reach the server can retire credits
+	var auth *authenticator
+	auth_config_path := os.Getenv("X4C_AUTH_CONFIG")
+	if auth_config_path != "" {
+		auth, err = loadAuthenticator(auth_config_path)
+		if err != nil {
+			log.Printf("Failed to load auth config %v: %v", auth_config_path, err)
+			os.Exit(1)
+		}
+		log.Printf("Auth config: %v\n", auth_config_path)
+	} else if os.Getenv("X4C_AUTH_DISABLED") == "true" {
+		log.Printf("WARNING: authentication is disabled, anyone who can reach the server can retire credits")
+	} else {
+		log.Printf("No auth config specified (use env var X4C_AUTH_CONFIG, or set X4C_AUTH_DISABLED=true for local testing)")
+		os.Exit(1)
+	}
+
+	audit := newAuditLog(nil)
+	if audit_log_path := os.Getenv("X4C_AUDIT_LOG"); audit_log_path != "" {
+		audit_file, err := os.OpenFile(audit_log_path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Printf("Failed to open audit log %v: %v", audit_log_path, err)
+			os.Exit(1)
+		}
+		defer audit_file.Close()
+		audit = newAuditLog(audit_file)
+		log.Printf("Audit log: %v\n", audit_log_path)
+	}
+
+	batch_size := defaultRetireBatchSize
+	if raw_batch_size := os.Getenv("X4C_RETIRE_BATCH_SIZE"); raw_batch_size != "" {
+		batch_size, err = strconv.Atoi(raw_batch_size)
+		if err != nil || batch_size < 1 {
+			log.Printf("Invalid retire batch size %v", raw_batch_size)
+			os.Exit(1)
+		}
+	}
+
+	var certificate_key *tezos.PrivateKey
+	if certificate_key_path := os.Getenv("X4C_CERTIFICATE_KEY_FILE"); certificate_key_path != "" {
+		certificate_key, err = loadCertificateKey(certificate_key_path)
+		if err != nil {
+			log.Printf("Failed to load certificate key %v: %v", certificate_key_path, err)
+			os.Exit(1)
+		}
+		log.Printf("Certificate signer: %v\n", certificate_key.Public())
+	}
+
+	registry_contracts := make([]tzclient.Contract, 0)
+	for _, name := range strings.Split(os.Getenv("X4C_REGISTRY_CONTRACTS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		contract, err := client.ContractByName(name)
+		if err != nil {
+			contract, err = tzclient.NewContractWithAddress("registry", name)
+			if err != nil {
+				log.Printf("Invalid registry contract %v: %v", name, err)
+				os.Exit(1)
+			}
+		}
+		registry_contracts = append(registry_contracts, contract)
+	}
+	if len(registry_contracts) > 0 {
+		log.Printf("Registry contracts: %v\n", os.Getenv("X4C_REGISTRY_CONTRACTS"))
+	}
+
+	// If there is a local index then reads for the indexed contracts come from there,
+	// and we keep it up to date in the background
+	var tezos_client tzclient.TezosClient = client
+	var indexer *index.Indexer
+	if index_store_path := os.Getenv("X4C_INDEX_STORE"); index_store_path != "" {
+		store, err := index.LoadStore(index_store_path)
+		if err != nil {
+			log.Printf("Failed to load index store %v: %v", index_store_path, err)
+			os.Exit(1)
+		}
+		contracts := make([]string, 0)
+		for _, address := range strings.Split(os.Getenv("X4C_INDEX_CONTRACTS"), ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				contracts = append(contracts, address)
+			}
+		}
+		source, err := index.NewSource(client)
+		if err != nil {
+			log.Printf("Failed to make index source: %v", err)
+			os.Exit(1)
+		}
+		max_lag := defaultIndexMaxLag
+		if raw_max_lag := os.Getenv("X4C_INDEX_MAX_LAG"); raw_max_lag != "" {
+			max_lag, err = time.ParseDuration(raw_max_lag)
+			if err != nil || max_lag < 0 {
+				log.Printf("Invalid index max lag %v", raw_max_lag)
+				os.Exit(1)
+			}
+		}
+		indexer = index.NewIndexer(source, store, contracts)
+		index_client := index.NewClient(client, store)
+		index_client.MaxLag = max_lag
+		tezos_client = index_client
+		log.Printf("Index store: %v (contracts %v, max lag %v)\n", index_store_path, contracts, max_lag)
+	}
+
+	server := SetupMyHandlers(tezos_client, operator, serverOptions{
+		Jobs:              jobs,
+		RetireBatchSize:   batch_size,
+		Idempotency:       idempotency,
+		Auth:              auth,
+		Audit:             audit,
+		CertificateKey:    certificate_key,
+		RegistryContracts: registry_contracts,
+	})
+	go server.jobs.Run(context.Background())
+	if indexer != nil {
+		go indexer.Run(context.Background(), indexSyncInterval)
+	}
 	http.ListenAndServe(":8080", server.mux)
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"blockwatch.cc/tzgo/micheline"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+var errWriteOutsideQueue = errors.New("writes must be sent through the job queue for their signer")
+
+// readOnlyClient is the client handed to route handlers. Each signer's job queue has
+// the only client that can inject operations, as an operation injected from anywhere
+// else would race the queue for the signer's counter. Simulations are still allowed,
+// so handlers can check a call with a DryRunClient before queueing it.
+type readOnlyClient struct {
+	tzclient.TezosClient
+}
+
+func (c readOnlyClient) CallContract(ctx context.Context, signedBy tzclient.Wallet, target tzclient.Contract, parameters micheline.Parameters) (string, error) {
+	return "", errWriteOutsideQueue
+}
+
+func (c readOnlyClient) InjectContractCall(ctx context.Context, signedBy tzclient.Wallet, target tzclient.Contract, parameters micheline.Parameters) (string, error) {
+	return "", errWriteOutsideQueue
+}
+
+func (c readOnlyClient) Originate(ctx context.Context, signedBy tzclient.Wallet, code []byte, initial_storage micheline.Prim) (tzclient.Contract, error) {
+	return tzclient.Contract{}, errWriteOutsideQueue
+}
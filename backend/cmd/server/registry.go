@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+const (
+	defaultRegistryPageSize = 100
+	maxRegistryPageSize     = 1000
+
+	// The registry is public and backs the transparency page, so we let caches keep
+	// responses for about the time it takes for a new block to be made
+	registryCacheControl = "public, max-age=30"
+)
+
+type RegistryPagination struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"`
+}
+
+type GetRetirementsResponse struct {
+	Data       []x4c.RegisteredRetirement `json:"data"`
+	Pagination RegistryPagination         `json:"pagination"`
+}
+
+type GetRetirementTotalsResponse struct {
+	Data x4c.RetirementTotals `json:"data"`
+}
+
+// Listing the registry means paging through every retire event on the indexer, so
+// rather than do that on each request we keep the full list until the head moves on
+// and filter it in memory.
+type retirementCache struct {
+	lock        sync.Mutex
+	level       int32
+	retirements []x4c.RegisteredRetirement
+}
+
+// Returns every retirement on the contracts as of the current head. The lock is held
+// whilst fetching so that requests arriving together only fetch the list once.
+func (c *retirementCache) get(ctx context.Context, client tzclient.TezosClient, contracts []tzclient.Contract) ([]x4c.RegisteredRetirement, error) {
+	head, err := client.GetHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.retirements != nil && c.level == head.Level {
+		return c.retirements, nil
+	}
+	retirements, err := x4c.ListRetirements(ctx, client, contracts, x4c.RetirementFilter{})
+	if err != nil {
+		return nil, err
+	}
+	c.level = head.Level
+	c.retirements = retirements
+	return retirements, nil
+}
+
+// A date means the whole of that day in UTC, so as the end of a range it means the
+// start of the next day.
+func parseRegistryTime(value string, end bool) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or YYYY-MM-DD date", value)
+	}
+	if end {
+		day = day.Add(24 * time.Hour)
+	}
+	return day, nil
+}
+
+func parseRetirementFilter(query url.Values) (x4c.RetirementFilter, error) {
+	filter := x4c.RetirementFilter{
+		TokenAddress:  query.Get("tokenAddress"),
+		RetiringParty: query.Get("retiringParty"),
+		KYC:           query.Get("kyc"),
+	}
+	if value := query.Get("tokenID"); value != "" {
+		token_id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return x4c.RetirementFilter{}, fmt.Errorf("invalid tokenID: %w", err)
+		}
+		filter.TokenID = &token_id
+	}
+	if value := query.Get("from"); value != "" {
+		from, err := parseRegistryTime(value, false)
+		if err != nil {
+			return x4c.RetirementFilter{}, fmt.Errorf("invalid from: %w", err)
+		}
+		filter.From = from
+	}
+	if value := query.Get("to"); value != "" {
+		to, err := parseRegistryTime(value, true)
+		if err != nil {
+			return x4c.RetirementFilter{}, fmt.Errorf("invalid to: %w", err)
+		}
+		filter.To = to
+	}
+	if value := query.Get("minAmount"); value != "" {
+		min_amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return x4c.RetirementFilter{}, fmt.Errorf("invalid minAmount: %w", err)
+		}
+		filter.MinAmount = min_amount
+	}
+	return filter, nil
+}
+
+func parseRegistryPage(query url.Values) (int, int, error) {
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		var err error
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", value)
+		}
+	}
+	limit := defaultRegistryPageSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxRegistryPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxRegistryPageSize)
+		}
+	}
+	return offset, limit, nil
+}
+
+// Writes a response that can be cached by a CDN, with an ETag over the body so that
+// clients and caches can revalidate cheaply.
+func writeCacheableJSON(w http.ResponseWriter, r *http.Request, response interface{}) {
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(response)
+	if err != nil {
+		log.Printf("Failed to encode cacheable response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	digest := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", registryCacheControl)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body.Bytes())
+	if err != nil {
+		log.Printf("Failed to write cacheable response: %v", err)
+	}
+}
+
+func (s *server) listRetirements(r *http.Request) ([]x4c.RegisteredRetirement, int, error) {
+	filter, err := parseRetirementFilter(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	retirements, err := s.retirements.get(r.Context(), s.tezosClient, s.registryContracts)
+	if err != nil {
+		log.Printf("Failed to list retirements: %v", err)
+		return nil, http.StatusFailedDependency, fmt.Errorf("failed to look up retirements")
+	}
+	return x4c.FilterRetirements(retirements, filter), http.StatusOK, nil
+}
+
+// Returns a page of the retirements made on the registry contracts, newest first, that
+// match the filters given in the query parameters.
+func (s *server) getRetirements(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if len(s.registryContracts) == 0 {
+		http.Error(w, "The retirement registry is not available", http.StatusServiceUnavailable)
+		return
+	}
+	offset, limit, err := parseRegistryPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	retirements, status, err := s.listRetirements(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	page := make([]x4c.RegisteredRetirement, 0, limit)
+	if offset < len(retirements) {
+		end := offset + limit
+		if end > len(retirements) {
+			end = len(retirements)
+		}
+		page = append(page, retirements[offset:end]...)
+	}
+	writeCacheableJSON(w, r, GetRetirementsResponse{
+		Data: page,
+		Pagination: RegistryPagination{
+			Offset: offset,
+			Limit:  limit,
+			Total:  len(retirements),
+		},
+	})
+}
+
+// Returns the totals retired per token and per beneficiary, over the retirements that
+// match the same filters as getRetirements.
+func (s *server) getRetirementTotals(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if len(s.registryContracts) == 0 {
+		http.Error(w, "The retirement registry is not available", http.StatusServiceUnavailable)
+		return
+	}
+	retirements, status, err := s.listRetirements(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	totals, err := x4c.TotalRetirements(r.Context(), s.tezosClient, retirements)
+	if err != nil {
+		log.Printf("Failed to total retirements: %v", err)
+		http.Error(w, "Failed to look up token metadata", http.StatusFailedDependency)
+		return
+	}
+	writeCacheableJSON(w, r, GetRetirementTotalsResponse{Data: totals})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+func newRegistryTestServer(with_contracts bool) server {
+	fa2 := "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"
+	mockClient := tzclient.NewMockClient()
+	mockClient.Events = make([]tzkt.Event, 0)
+	for index := 0; index < 5; index++ {
+		mockClient.Events = append(mockClient.Events, tzkt.Event{
+			Identifier:    int64(100 + index),
+			Level:         int32(500 + index),
+			Timestamp:     time.Date(2023, 3, 1+index, 12, 0, 0, 0, time.UTC),
+			TransactionID: int64(10 + index),
+			Contract:      tzkt.EventContractInfo{Address: &fa2},
+			Tag:           "retire",
+			Payload:       json.RawMessage(fmt.Sprintf(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "tokenId": "1", "amount": "%d", "retiring_data": "050100000007666c6967687473"}`, index+1)),
+		})
+	}
+	mockClient.Storage = &x4c.FA2Storage{TokenMetadata: 5}
+
+	options := serverOptions{}
+	if with_contracts {
+		contract, _ := tzclient.NewContractWithAddress("fa2", fa2)
+		options.RegistryContracts = []tzclient.Contract{contract}
+	}
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	return SetupMyHandlers(mockClient, operator, options)
+}
+
+func TestGetRetirements(t *testing.T) {
+	testcases := []struct {
+		target         string
+		withContracts  bool
+		expectedStatus int
+		expectedEvents []int64
+		expectedTotal  int
+	}{
+		{
+			target:         "/retirements",
+			withContracts:  true,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []int64{104, 103, 102, 101, 100},
+			expectedTotal:  5,
+		},
+		{
+			target:         "/retirements?limit=2&offset=1",
+			withContracts:  true,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []int64{103, 102},
+			expectedTotal:  5,
+		},
+		{
+			target:         "/retirements?offset=10",
+			withContracts:  true,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []int64{},
+			expectedTotal:  5,
+		},
+		{
+			target:         "/retirements?from=2023-03-02&to=2023-03-03&minAmount=3",
+			withContracts:  true,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []int64{102},
+			expectedTotal:  1,
+		},
+		{
+			target:         "/retirements?tokenID=2",
+			withContracts:  true,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []int64{},
+			expectedTotal:  0,
+		},
+		{
+			target:         "/retirements?limit=0",
+			withContracts:  true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			target:         "/retirements?from=yesterday",
+			withContracts:  true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			target:         "/retirements",
+			withContracts:  false,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		server := newRegistryTestServer(testcase.withContracts)
+
+		r, err := http.NewRequest("GET", testcase.target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if resp.Header.Get("ETag") == "" || resp.Header.Get("Cache-Control") != registryCacheControl {
+			t.Errorf("%d: Expected caching headers, got %v", idx, resp.Header)
+		}
+
+		var result GetRetirementsResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Errorf("%d: Failed to decode response: %v", idx, err)
+			continue
+		}
+		if result.Pagination.Total != testcase.expectedTotal {
+			t.Errorf("%d: Expected total %d, got %d", idx, testcase.expectedTotal, result.Pagination.Total)
+		}
+		if len(result.Data) != len(testcase.expectedEvents) {
+			t.Errorf("%d: Expected %d retirements, got %v", idx, len(testcase.expectedEvents), result.Data)
+			continue
+		}
+		for row, retirement := range result.Data {
+			if retirement.EventID != testcase.expectedEvents[row] {
+				t.Errorf("%d: Expected event %d at row %d, got %d", idx, testcase.expectedEvents[row], row, retirement.EventID)
+			}
+		}
+	}
+}
+
+func TestGetRetirementsNotModified(t *testing.T) {
+	server := newRegistryTestServer(true)
+
+	r, _ := http.NewRequest("GET", "/retirements", nil)
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, r)
+	etag := w.Result().Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag")
+	}
+
+	r, _ = http.NewRequest("GET", "/retirements", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	server.mux.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusNotModified {
+		t.Errorf("Expected not modified, got %d", w.Result().StatusCode)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no body, got %v", w.Body.String())
+	}
+
+	r, _ = http.NewRequest("GET", "/retirements?limit=1", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	server.mux.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("Expected different page to be returned, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestGetRetirementTotals(t *testing.T) {
+	server := newRegistryTestServer(true)
+
+	r, _ := http.NewRequest("GET", "/retirement-totals?from=2023-03-02", nil)
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, r)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var result GetRetirementTotalsResponse
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(result.Data.ByToken) != 1 || result.Data.ByToken[0].Amount != 14 || result.Data.ByToken[0].Retirements != 4 {
+		t.Errorf("Unexpected token totals %v", result.Data.ByToken)
+	}
+	if len(result.Data.ByBeneficiary) != 1 || result.Data.ByBeneficiary[0].Amount != 14 {
+		t.Errorf("Unexpected beneficiary totals %v", result.Data.ByBeneficiary)
+	}
+}
+
+// Counts how often the registry goes to the indexer for events.
+type eventCountingClient struct {
+	tzclient.MockClient
+	eventCalls int
+}
+
+func (c *eventCountingClient) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	c.eventCalls += 1
+	return c.MockClient.GetContractEvents(ctx, contractAddress, tag, options)
+}
+
+func TestRetirementsCachedUntilNewBlock(t *testing.T) {
+	registry := newRegistryTestServer(true)
+	client := &eventCountingClient{MockClient: registry.tezosClient.(readOnlyClient).TezosClient.(tzclient.MockClient)}
+	client.Blocks = []tzkt.Block{{Level: 504, Timestamp: time.Date(2023, 3, 5, 12, 0, 0, 0, time.UTC)}}
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	server := SetupMyHandlers(client, operator, serverOptions{RegistryContracts: registry.registryContracts})
+
+	get := func(target string) GetRetirementsResponse {
+		r, _ := http.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+		var result GetRetirementsResponse
+		if w.Result().StatusCode == http.StatusOK {
+			_ = json.NewDecoder(w.Result().Body).Decode(&result)
+		} else {
+			t.Errorf("Got status %d for %s", w.Result().StatusCode, target)
+		}
+		return result
+	}
+
+	// Different pages and filters at the same head all come from one fetch
+	get("/retirements")
+	get("/retirements?limit=2&offset=2")
+	result := get("/retirements?from=2023-03-02&to=2023-03-02")
+	if len(result.Data) != 1 || result.Data[0].EventID != 101 {
+		t.Errorf("Expected only event 101 from the cached list, got %v", result.Data)
+	}
+	if client.eventCalls != 1 {
+		t.Errorf("Expected one fetch of events, got %d", client.eventCalls)
+	}
+
+	// A new block may have new retirements, so the list is fetched again
+	client.Events = append(client.Events, tzkt.Event{
+		Identifier:    105,
+		Level:         505,
+		Timestamp:     time.Date(2023, 3, 6, 12, 0, 0, 0, time.UTC),
+		TransactionID: 15,
+		Contract:      client.Events[0].Contract,
+		Tag:           "retire",
+		Payload:       json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "tokenId": "1", "amount": "6", "retiring_data": "050100000007666c6967687473"}`),
+	})
+	client.Blocks = append(client.Blocks, tzkt.Block{Level: 505, Timestamp: time.Date(2023, 3, 6, 12, 0, 0, 0, time.UTC)})
+	result = get("/retirements")
+	if client.eventCalls != 2 {
+		t.Errorf("Expected events to be fetched again after a new block, got %d fetches", client.eventCalls)
+	}
+	if result.Pagination.Total != 6 || result.Data[0].EventID != 105 {
+		t.Errorf("Expected new retirement at the top, got %v", result)
+	}
+}
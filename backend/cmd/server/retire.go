@@ -12,30 +12,182 @@ import (
 	"quantify.earth/x4c/pkg/x4c"
 )
 
+// Reason is kept for callers written before retirements had structured metadata, and
+// becomes the metadata note.
 type CreditRetireRequest struct {
-	Minter  string      `json:"minter"`
-	KYC     string      `json:"kyc"`
-	TokenID json.Number `json:"tokenID"`
-	Amount  json.Number `json:"amount"`
-	Reason  string      `json:"reason"`
+	Minter   string                  `json:"minter"`
+	KYC      string                  `json:"kyc"`
+	TokenID  json.Number             `json:"tokenID"`
+	Amount   json.Number             `json:"amount"`
+	Reason   string                  `json:"reason"`
+	Metadata *x4c.RetirementMetadata `json:"metadata"`
+}
+
+func (request CreditRetireRequest) retirementMetadata() (x4c.RetirementMetadata, error) {
+	if request.Metadata == nil {
+		return x4c.NoteRetirementMetadata(request.Reason), nil
+	}
+	metadata := *request.Metadata
+	if request.Reason != "" {
+		if metadata.Note != "" {
+			return x4c.RetirementMetadata{}, fmt.Errorf("reason and metadata note can not both be set")
+		}
+		metadata.Note = request.Reason
+	}
+	if metadata.Version == 0 {
+		metadata.Version = x4c.RetirementMetadataVersion
+	}
+	return metadata, metadata.Validate()
 }
 
 type CreditRetireData struct {
+	Message string  `json:"message"`
+	JobURL  string  `json:"jobURL"`
+	Job     JobData `json:"job"`
+}
+
+type CreditRetireResponse struct {
+	Data CreditRetireData `json:"data"`
+}
+
+// The response from the legacy retire route, which is only sent once the retirement
+// is on chain.
+type LegacyCreditRetireData struct {
 	Message            string `json:"message"`
 	OperationHash      string `json:"updateHash"`
 	OperationLookupURL string `json:"tzstatsUpdateHashUrl"`
 }
 
-type CreditRetireResponse struct {
-	Data CreditRetireData `json:"data"`
+type LegacyCreditRetireResponse struct {
+	Data LegacyCreditRetireData `json:"data"`
+}
+
+type SimulatedEventData struct {
+	Source  string          `json:"source"`
+	Tag     string          `json:"tag"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type SimulationData struct {
+	GasUsed        int64                `json:"gasUsed"`
+	StorageUsed    int64                `json:"storageUsed"`
+	StorageBurn    int64                `json:"storageBurn"`
+	AllocationBurn int64                `json:"allocationBurn"`
+	Fee            int64                `json:"fee"`
+	Events         []SimulatedEventData `json:"events"`
+}
+
+type CreditRetireDryRunData struct {
+	Message    string         `json:"message"`
+	Simulation SimulationData `json:"simulation"`
+}
+
+type CreditRetireDryRunResponse struct {
+	Data CreditRetireDryRunData `json:"data"`
+}
+
+func newSimulationData(result tzclient.SimulationResult) (SimulationData, error) {
+	data := SimulationData{
+		GasUsed:        result.GasUsed,
+		StorageUsed:    result.StorageUsed,
+		StorageBurn:    result.StorageBurn,
+		AllocationBurn: result.AllocationBurn,
+		Fee:            result.Fee,
+		Events:         make([]SimulatedEventData, 0, len(result.Events)),
+	}
+	for _, event := range result.Events {
+		payload, err := event.Payload.MarshalJSON()
+		if err != nil {
+			return SimulationData{}, fmt.Errorf("failed to encode event payload: %w", err)
+		}
+		data.Events = append(data.Events, SimulatedEventData{
+			Source:  event.Source.String(),
+			Tag:     event.Tag,
+			Payload: payload,
+		})
+	}
+	return data, nil
 }
 
 func (s *server) retire(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job, ok := s.queueRetire(w, r, ps)
+	if !ok {
+		return
+	}
+
+	result := CreditRetireResponse{
+		Data: CreditRetireData{
+			Message: "Retirement queued",
+			JobURL:  "/jobs/" + job.ID,
+			Job:     s.newJobData(job),
+		},
+	}
+	w.Header().Set("Location", result.Data.JobURL)
+	w.WriteHeader(http.StatusAccepted)
+	err := json.NewEncoder(w).Encode(result)
+	if err != nil {
+		log.Printf("Failed to encode get retire response: %v", err)
+		return
+	}
+}
+
+// The legacy route was written before retirements were queued, and its callers expect
+// to get the operation hash back once the retirement is on chain, so it waits for the
+// job to finish before responding.
+func (s *server) legacyRetire(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job, ok := s.queueRetire(w, r, ps)
+	if !ok {
+		return
+	}
+
+	id := job.ID
+	job, err := s.jobs.store.wait(r.Context(), id)
+	if err != nil {
+		// The job is still processed, the caller just won't hear about it here, so
+		// a retry must not queue it again
+		log.Printf("Stopped waiting for job %s: %v", id, err)
+		rememberResponse(w)
+		http.Error(w, fmt.Sprintf("Failed waiting for retirement job %s, which is still queued: %v", id, err), http.StatusInternalServerError)
+		return
+	}
+	if job.Status == JobUnknown {
+		rememberResponse(w)
+		err_str := fmt.Sprintf("Retirement job %s may or may not have reached the chain: %s", id, job.Error)
+		http.Error(w, err_str, http.StatusInternalServerError)
+		return
+	}
+	if job.Status != JobConfirmed {
+		err_str := fmt.Sprintf("Failed call contract: %s", job.Error)
+		if job.OperationHash != "" {
+			err_str = fmt.Sprintf("Failed call contract in operation %s: %s", job.OperationHash, job.Error)
+		}
+		http.Error(w, err_str, http.StatusInternalServerError)
+		return
+	}
+
+	result := LegacyCreditRetireResponse{
+		Data: LegacyCreditRetireData{
+			Message:            "Successfully retired credits",
+			OperationHash:      job.OperationHash,
+			OperationLookupURL: s.tezosClient.GetIndexerWebURL() + "/" + job.OperationHash,
+		},
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		log.Printf("Failed to encode get retire response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Checks and queues a retirement, returning false if it has already responded to the
+// request, either with an error or the results of a dry run.
+func (s *server) queueRetire(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (RetireJob, bool) {
 
 	contract_address := ps.ByName("contractHash")
 	if contract_address == "" {
 		http.Error(w, "No contract address specified", http.StatusBadRequest)
-		return
+		return RetireJob{}, false
 	}
 	contract, err := s.tezosClient.ContractByName(contract_address)
 	if err != nil {
@@ -43,7 +195,7 @@ func (s *server) retire(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		contract, err = tzclient.NewContractWithAddress("contract", contract_address)
 		if err != nil {
 			http.Error(w, "Failed parse contract address", http.StatusBadRequest)
-			return
+			return RetireJob{}, false
 		}
 	}
 
@@ -55,7 +207,7 @@ func (s *server) retire(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 	err = decoder.Decode(&request)
 	if err != nil {
 		http.Error(w, "Failed to decode request", http.StatusBadRequest)
-		return
+		return RetireJob{}, false
 	}
 
 	minter, err := s.tezosClient.ContractByName(request.Minter)
@@ -64,7 +216,7 @@ func (s *server) retire(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		if err != nil {
 			err_str := fmt.Sprintf("Failed to resolve minter: %v", err)
 			http.Error(w, err_str, http.StatusBadRequest)
-			return
+			return RetireJob{}, false
 		}
 	}
 
@@ -72,38 +224,107 @@ func (s *server) retire(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 	if err != nil {
 		err_str := fmt.Sprintf("Failed to resolve token ID: %v", err)
 		http.Error(w, err_str, http.StatusBadRequest)
-		return
+		return RetireJob{}, false
 	}
 
 	amount, err := request.Amount.Int64()
 	if err != nil {
 		err_str := fmt.Sprintf("Failed to resolve amount: %v", err)
 		http.Error(w, err_str, http.StatusBadRequest)
-		return
+		return RetireJob{}, false
 	}
 	if amount <= 0 {
 		err_str := fmt.Sprintf("Amount to retire is not valid: %v", amount)
 		http.Error(w, err_str, http.StatusBadRequest)
+		return RetireJob{}, false
+	}
+
+	metadata, err := request.retirementMetadata()
+	if err != nil {
+		err_str := fmt.Sprintf("Retirement metadata is not valid: %v", err)
+		http.Error(w, err_str, http.StatusBadRequest)
+		return RetireJob{}, false
+	}
+
+	caller := principalFromContext(r.Context())
+	if !caller.allows(contract.Address.String(), request.KYC) {
+		s.audit.record(r, caller, "retire", contract.Address.String(), request.KYC, auditDenied, "")
+		http.Error(w, "Not permitted to retire credits for this KYC", http.StatusForbidden)
+		return RetireJob{}, false
+	}
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		s.retireDryRun(w, r, contract, minter, token_id, request.KYC, amount, metadata)
+		return RetireJob{}, false
+	}
+
+	// Check the retirement would work before accepting it, so that the caller finds
+	// out straight away about things like insufficient balances. It can still fail
+	// later if other retirements for the same KYC are ahead of it in the queue.
+	check_client := tzclient.NewDryRunClient(s.tezosClient)
+	_, err = x4c.CustodianRetire(r.Context(), check_client, contract, s.custodianOperator, minter, token_id, request.KYC, amount, metadata)
+	if err != nil {
+		s.audit.record(r, caller, "retire", contract.Address.String(), request.KYC, auditFailed, err.Error())
+		writeContractCallError(w, err)
+		return RetireJob{}, false
+	}
+
+	job, err := s.jobs.enqueue(RetireJob{
+		Principal: caller.Name,
+		Contract:  contract.Address.String(),
+		Minter:    minter.Address.String(),
+		TokenID:   token_id,
+		KYC:       request.KYC,
+		Amount:    amount,
+		Metadata:  &metadata,
+	})
+	if err != nil {
+		log.Printf("Failed to queue retirement: %v", err)
+		http.Error(w, "Failed to queue retirement", http.StatusInternalServerError)
+		return RetireJob{}, false
+	}
+	s.audit.record(r, caller, "retire", contract.Address.String(), request.KYC, auditAllowed,
+		fmt.Sprintf("queued retirement of %d of token %d as job %s", amount, token_id, job.ID))
+	return job, true
+}
+
+func (s *server) retireDryRun(
+	w http.ResponseWriter,
+	r *http.Request,
+	contract tzclient.Contract,
+	minter tzclient.Contract,
+	token_id int64,
+	kyc string,
+	amount int64,
+	metadata x4c.RetirementMetadata,
+) {
+	client := tzclient.NewDryRunClient(s.tezosClient)
+	_, err := x4c.CustodianRetire(r.Context(), client, contract, s.custodianOperator, minter, token_id, kyc, amount, metadata)
+	if err != nil {
+		writeContractCallError(w, err)
+		return
+	}
+	if len(client.Simulations) != 1 {
+		http.Error(w, "Unexpected simulation results", http.StatusInternalServerError)
 		return
 	}
 
-	op_hash, err := x4c.CustodianRetire(r.Context(), s.tezosClient, contract, s.custodianOperator, minter, token_id, request.KYC, amount, request.Reason)
+	simulation, err := newSimulationData(client.Simulations[0])
 	if err != nil {
-		err_str := fmt.Sprintf("Failed call contract: %v", err)
-		http.Error(w, err_str, http.StatusInternalServerError)
+		log.Printf("Failed to encode simulation: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 
-	result := CreditRetireResponse{
-		Data: CreditRetireData{
-			Message:            "Successfully retired credits",
-			OperationHash:      op_hash,
-			OperationLookupURL: s.tezosClient.GetIndexerWebURL() + "/" + op_hash,
+	result := CreditRetireDryRunResponse{
+		Data: CreditRetireDryRunData{
+			Message:    "Dry run succeeded, credits were not retired",
+			Simulation: simulation,
 		},
 	}
 	err = json.NewEncoder(w).Encode(result)
 	if err != nil {
-		log.Printf("Failed to encode get retire response: %v", err)
+		log.Printf("Failed to encode retire dry run response: %v", err)
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
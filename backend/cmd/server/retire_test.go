@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"strings"
 	"testing"
 
 	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
 )
 
 func TestRetire(t *testing.T) {
@@ -76,7 +80,7 @@ func TestRetire(t *testing.T) {
 		}()
 
 		if testcase.expectSuccess {
-			if resp.StatusCode != http.StatusOK {
+			if resp.StatusCode != http.StatusAccepted {
 				respDump, _ := httputil.DumpResponse(resp, true)
 				t.Errorf("%d: Unexpected status code %d. Body was: %v", idx, resp.StatusCode, string(respDump))
 			}
@@ -88,11 +92,90 @@ func TestRetire(t *testing.T) {
 			if err != nil {
 				t.Errorf("%d: Failed to decode response: %v", idx, err)
 			} else {
-				if result.Data.Message != "Successfully retired credits" {
+				if result.Data.Message != "Retirement queued" {
+					t.Errorf("%d: Did not get expected message: %v", idx, result.Data)
+				}
+				if result.Data.Job.Status != JobQueued {
+					t.Errorf("%d: Expected job to be queued: %v", idx, result.Data)
+				}
+				if result.Data.JobURL != "/jobs/"+result.Data.Job.ID {
+					t.Errorf("%d: Did not get expected job URL: %v", idx, result.Data)
+				}
+				if resp.Header.Get("Location") != result.Data.JobURL {
+					t.Errorf("%d: Did not get expected location: %v", idx, resp.Header)
+				}
+			}
+		} else {
+			if resp.StatusCode == http.StatusOK {
+				respDump, _ := httputil.DumpResponse(resp, true)
+				t.Errorf("%d: Unexpected status code %d. Body was: %v", idx, resp.StatusCode, string(respDump))
+			}
+		}
+	}
+}
+
+func TestRetireDryRun(t *testing.T) {
+	testcases := []struct {
+		shouldError   bool
+		expectSuccess bool
+	}{
+		{
+			shouldError:   false,
+			expectSuccess: true,
+		},
+		{
+			shouldError:   true,
+			expectSuccess: false,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.ShouldError = testcase.shouldError
+		server := newMockServer(client)
+
+		request := CreditRetireRequest{
+			Minter:  "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			KYC:     "compsci",
+			TokenID: "123",
+			Amount:  "123",
+			Reason:  "fun",
+		}
+		requestBody, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := http.NewRequest("POST", "/contract/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm/retire?dryRun=true", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer func() {
+			resp.Body.Close()
+		}()
+
+		if testcase.expectSuccess {
+			if resp.StatusCode != http.StatusOK {
+				respDump, _ := httputil.DumpResponse(resp, true)
+				t.Errorf("%d: Unexpected status code %d. Body was: %v", idx, resp.StatusCode, string(respDump))
+			}
+
+			var result CreditRetireDryRunResponse
+			decoder := json.NewDecoder(resp.Body)
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&result)
+			if err != nil {
+				t.Errorf("%d: Failed to decode response: %v", idx, err)
+			} else {
+				if result.Data.Message != "Dry run succeeded, credits were not retired" {
 					t.Errorf("%d: Did not get expected message: %v", idx, result.Data)
 				}
-				if result.Data.OperationHash != "operationHash" {
-					t.Errorf("%d: Did not get expected operation hash: %v", idx, result.Data)
+				if result.Data.Simulation.Events == nil {
+					t.Errorf("%d: Expected empty event list, got nil", idx)
 				}
 			}
 		} else {
@@ -103,3 +186,227 @@ func TestRetire(t *testing.T) {
 		}
 	}
 }
+
+func TestRetireMetadata(t *testing.T) {
+	testcases := []struct {
+		body           string
+		expectedStatus int
+	}{
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "reason": "fun"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "metadata": {"beneficiary": "Acme", "reporting_period": {"start": "2023-01-01", "end": "2023-12-31"}}}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "reason": "fun", "metadata": {"purpose": "flights"}}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "reason": "fun", "metadata": {"note": "flights"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "metadata": {"reporting_period": {"start": "2023-12-31", "end": "2023-01-01"}}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "metadata": {"version": 7}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			body:           `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 1, "metadata": {"colour": "green"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		server := newMockServer(tzclient.NewMockClient())
+
+		r, err := http.NewRequest("POST", "/contract/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm/retire", bytes.NewBufferString(testcase.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.expectedStatus {
+			respDump, _ := httputil.DumpResponse(resp, true)
+			t.Errorf("%d: Expected status %d, got %d. Body was: %v", idx, testcase.expectedStatus, resp.StatusCode, string(respDump))
+		}
+	}
+}
+
+func TestRetireOnFakeChain(t *testing.T) {
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	custodian, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	ctx := context.Background()
+
+	// The custodian holds 100 credits, of which 40 are compsci's
+	client := tzclient.NewFakeClient()
+	client.AddFA2Contract(fa2, operator.Address)
+	client.AddCustodianContract(custodian, operator.Address)
+	_, err := x4c.FA2AddToken(ctx, client, fa2, operator, 123, "test", "url")
+	if err != nil {
+		t.Fatalf("Failed to add token: %v", err)
+	}
+	_, err = x4c.FA2Mint(ctx, client, fa2, operator, 123, custodian.Address, 100)
+	if err != nil {
+		t.Fatalf("Failed to mint: %v", err)
+	}
+	_, err = x4c.CustodianInternalMint(ctx, client, custodian, operator, fa2, 123)
+	if err != nil {
+		t.Fatalf("Failed to mint internally: %v", err)
+	}
+	_, err = x4c.CustodianInternalTransfer(ctx, client, custodian, operator, fa2, 123, 40, "self", "compsci")
+	if err != nil {
+		t.Fatalf("Failed to transfer: %v", err)
+	}
+	server := newMockServer(client)
+
+	serve := func(method string, url string, body string) *http.Response {
+		r, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+		return w.Result()
+	}
+	retire := func(amount int, dry_run bool) *http.Response {
+		url := "/contract/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm/retire"
+		if dry_run {
+			url += "?dryRun=true"
+		}
+		return serve("POST", url, fmt.Sprintf(`{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": %d, "reason": "fun"}`, amount))
+	}
+
+	resp := retire(15, false)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		respDump, _ := httputil.DumpResponse(resp, true)
+		t.Fatalf("Unexpected status code %d. Body was: %v", resp.StatusCode, string(respDump))
+	}
+	var queued CreditRetireResponse
+	err = json.NewDecoder(resp.Body).Decode(&queued)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	server.jobs.processPending(ctx)
+
+	resp = serve("GET", queued.Data.JobURL, "")
+	defer resp.Body.Close()
+	var job GetJobResponse
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	if err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if job.Data.Status != JobConfirmed {
+		t.Errorf("Expected job to be confirmed, got %v", job.Data)
+	}
+
+	resp = serve("GET", "/credit/sources/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm", "")
+	defer resp.Body.Close()
+	var sources CreditSourcesResponse
+	err = json.NewDecoder(resp.Body).Decode(&sources)
+	if err != nil {
+		t.Fatalf("Failed to decode credit sources: %v", err)
+	}
+	balances := make(map[string]int64)
+	for _, source := range sources.Data {
+		balances[source.KYC] = source.Amount
+	}
+	if balances["compsci"] != 25 || balances["self"] != 60 {
+		t.Errorf("Unexpected balances after retiring %v", sources.Data)
+	}
+
+	// compsci doesn't have enough left for this, which is found before it's queued
+	resp = retire(30, false)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(body), "INSUFFICIENT_BALANCE") {
+		t.Errorf("Expected insufficient balance, got %d: %s", resp.StatusCode, body)
+	}
+
+	// A dry run shows the events from both contracts, and retires nothing
+	resp = retire(5, true)
+	defer resp.Body.Close()
+	var dry_run CreditRetireDryRunResponse
+	err = json.NewDecoder(resp.Body).Decode(&dry_run)
+	if err != nil {
+		t.Fatalf("Failed to decode dry run: %v", err)
+	}
+	if len(dry_run.Data.Simulation.Events) != 2 || dry_run.Data.Simulation.Events[0].Source != fa2.Address.String() || dry_run.Data.Simulation.Events[1].Source != custodian.Address.String() {
+		t.Errorf("Unexpected dry run events %v", dry_run.Data.Simulation.Events)
+	}
+	events, err := x4c.GetCustodianRetireEvents(ctx, client, custodian)
+	if err != nil || len(events) != 1 || events[0].Amount != "15" {
+		t.Errorf("Expected just the one retirement, got %v, %v", events, err)
+	}
+}
+
+func TestLegacyRetire(t *testing.T) {
+	testcases := []struct {
+		OperationStatus string
+		ExpectedStatus  int
+	}{
+		{
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			OperationStatus: tzclient.OperationFailed,
+			ExpectedStatus:  http.StatusInternalServerError,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client := &jobTestClient{
+			MockClient:      tzclient.NewMockClient(),
+			OperationStatus: testcase.OperationStatus,
+		}
+		server := newMockServer(client)
+		ctx, cancel := context.WithCancel(context.Background())
+		go server.jobs.Run(ctx)
+
+		body := `{"minter": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "kyc": "compsci", "tokenID": 123, "amount": 10, "reason": "fun"}`
+		r, err := http.NewRequest("POST", "/retire/KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+		cancel()
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.ExpectedStatus {
+			respDump, _ := httputil.DumpResponse(resp, true)
+			t.Errorf("%d: Unexpected status code %d. Body was: %v", idx, resp.StatusCode, string(respDump))
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		// The response is the same as before retirements were queued
+		var result LegacyCreditRetireResponse
+		decoder := json.NewDecoder(resp.Body)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&result)
+		if err != nil {
+			t.Errorf("%d: Failed to decode response: %v", idx, err)
+			continue
+		}
+		if result.Data.Message != "Successfully retired credits" || result.Data.OperationHash != "operationHash1" {
+			t.Errorf("%d: Did not get expected response: %v", idx, result.Data)
+		}
+		if result.Data.OperationLookupURL != "https://index.web/operationHash1" {
+			t.Errorf("%d: Did not get expected lookup URL: %v", idx, result.Data)
+		}
+	}
+}
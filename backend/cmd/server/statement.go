@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+// Returns the statement for a KYC on a custodian. The period is given by the optional
+// from and to query parameters, which take the same values as the -from and -to flags
+// of x4cli, and the format query parameter picks json (the default), csv, or pdf.
+func (s *server) getStatement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	custodian_address := ps.ByName("custodianID")
+	kyc := ps.ByName("kyc")
+	if custodian_address == "" || kyc == "" {
+		http.Error(w, "No custodian ID or KYC specified", http.StatusBadRequest)
+		return
+	}
+	contract, err := s.tezosClient.ContractByName(custodian_address)
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("custodian", custodian_address)
+		if err != nil {
+			http.Error(w, "Custodian ID not recognised", http.StatusBadRequest)
+			return
+		}
+	}
+
+	caller := principalFromContext(r.Context())
+	if !caller.allows(contract.Address.String(), kyc) {
+		s.audit.record(r, caller, "statement", contract.Address.String(), kyc, auditDenied, "")
+		http.Error(w, "Not permitted to view this KYC", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "csv" && format != "json" && format != "pdf" {
+		http.Error(w, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	var from x4c.Moment
+	if value := query.Get("from"); value != "" {
+		from, err = x4c.ParseStartMoment(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid from value: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	to := x4c.AtTime(time.Now())
+	if value := query.Get("to"); value != "" {
+		to, err = x4c.ParseMoment(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid to value: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	statement, err := x4c.GenerateStatement(r.Context(), s.tezosClient, contract, kyc, from, to)
+	if err != nil {
+		log.Printf("Failed to generate statement for %s on %s: %v", kyc, custodian_address, err)
+		s.audit.record(r, caller, "statement", contract.Address.String(), kyc, auditFailed, err.Error())
+		http.Error(w, "Failed to generate statement", http.StatusFailedDependency)
+		return
+	}
+	s.audit.record(r, caller, "statement", contract.Address.String(), kyc, auditAllowed,
+		fmt.Sprintf("levels %d to %d as %s", statement.FromLevel, statement.ToLevel, format))
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		err = statement.WriteCSV(w)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		err = statement.WritePDF(w)
+	default:
+		err = json.NewEncoder(w).Encode(statement)
+	}
+	if err != nil {
+		log.Printf("Failed to write statement response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+func TestGetStatement(t *testing.T) {
+	mockClient := tzclient.NewMockClient()
+	key := json.RawMessage(`{"token": {"token_id": "42", "token_address": "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB"}, "kyc": "0501000000096f74686572206f7267"}`)
+	mockClient.AddHistoricalBigMap(1234, 100, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`"5"`)}})
+	mockClient.Events = []tzkt.Event{
+		{
+			Level:   100,
+			Tag:     "internal_transfer",
+			Payload: json.RawMessage(`{"source": "05010000000473656c66", "destination": "0501000000096f74686572206f7267", "token": {"token_id": "42", "token_address": "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB"}, "amount": "5"}`),
+		},
+	}
+	mockClient.Storage = &x4c.CustodianStorage{Ledger: 1234}
+
+	testcases := []struct {
+		query          string
+		expectedStatus int
+		expectedType   string
+	}{
+		{
+			query:          "?to=100",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			query:          "?to=100&format=csv",
+			expectedStatus: http.StatusOK,
+			expectedType:   "text/csv",
+		},
+		{
+			query:          "?to=100&format=pdf",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/pdf",
+		},
+		{
+			query:          "?to=100&format=xml",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			query:          "?from=never",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		server := newMockServer(mockClient)
+
+		r, err := http.NewRequest("GET", "/custodian/KT1Lw1p7rDaZixeX1SpmdNAueWW3QihZ31C6/kyc/other%20org/statement"+testcase.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if testcase.expectedType != "" {
+			if content_type := resp.Header.Get("Content-Type"); content_type != testcase.expectedType {
+				t.Errorf("%d: Expected content type %s, got %s", idx, testcase.expectedType, content_type)
+			}
+			continue
+		}
+		var result x4c.Statement
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Errorf("%d: failed to decode response: %v", idx, err)
+			continue
+		}
+		if result.KYC != "other org" || len(result.Tokens) != 1 || result.Tokens[0].Closing != 5 || len(result.Tokens[0].Entries) != 1 {
+			t.Errorf("%d: Unexpected statement: %v", idx, result)
+		}
+	}
+}
+
+func TestGetStatementNotPermitted(t *testing.T) {
+	mockClient := tzclient.NewMockClient()
+	mockClient.Storage = &x4c.CustodianStorage{Ledger: 1234}
+	var audit bytes.Buffer
+	server := newAuthTestServer(t, mockClient, newTestJWTKeys(t), &audit)
+
+	// The API key may only see the compsci KYC
+	r, err := http.NewRequest("GET", "/custodian/"+testCustodian+"/kyc/other%20org/statement?to=100", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(apiKeyHeader, testAPIKey)
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if !strings.Contains(audit.String(), `"outcome":"denied"`) {
+		t.Errorf("Expected denial to be audited, got %s", audit.String())
+	}
+}
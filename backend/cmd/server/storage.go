@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The server keeps its state in small JSON files. These are written to one side and
+// then moved into place so that a crash part way through a write doesn't leave us
+// with a corrupt file.
+func writeFileAtomically(path string, data []byte) error {
+	temp_file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = temp_file.Write(data)
+	if err == nil {
+		err = temp_file.Sync()
+	}
+	close_err := temp_file.Close()
+	if err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(temp_file.Name())
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	err = os.Rename(temp_file.Name(), path)
+	if err != nil {
+		os.Remove(temp_file.Name())
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
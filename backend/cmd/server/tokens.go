@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+// If some of the token's metadata couldn't be decoded then Metadata only has the
+// fields that could, and MetadataError says what was wrong.
+type TokenData struct {
+	Token         x4c.TokenID         `json:"token"`
+	Metadata      x4c.ProjectMetadata `json:"metadata"`
+	MetadataError string              `json:"metadataError,omitempty"`
+}
+
+type GetTokenResponse struct {
+	Data TokenData `json:"data"`
+}
+
+// Returns the project metadata for a token ID on an FA2 contract. Like the registry
+// this is public, and can be cached.
+func (s *server) getToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	fa2_address := ps.ByName("fa2")
+	contract, err := s.tezosClient.ContractByName(fa2_address)
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("fa2", fa2_address)
+		if err != nil {
+			http.Error(w, "Failed to parse FA2 contract address", http.StatusBadRequest)
+			return
+		}
+	}
+	token_id, err := strconv.ParseInt(ps.ByName("tokenId"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse token ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var storage x4c.FA2Storage
+	err = s.tezosClient.GetContractStorage(contract, r.Context(), &storage)
+	if err != nil {
+		log.Printf("Failed to get storage for %s: %v", contract.Address, err)
+		http.Error(w, "Failed to get contract storage", http.StatusFailedDependency)
+		return
+	}
+	token_metadata, err := storage.GetTokenMetadata(r.Context(), s.tezosClient)
+	if err != nil {
+		log.Printf("Failed to get token metadata for %s: %v", contract.Address, err)
+		http.Error(w, "Failed to get token metadata", http.StatusFailedDependency)
+		return
+	}
+	info, ok := token_metadata[token_id]
+	if !ok {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	data := TokenData{
+		Token: x4c.TokenID{
+			TokenID: info.TokenIdentifier,
+			Address: contract.Address.String(),
+		},
+		Metadata: info.Project,
+	}
+	if info.ProjectError != nil {
+		data.MetadataError = info.ProjectError.Error()
+	}
+	writeCacheableJSON(w, r, GetTokenResponse{Data: data})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+func TestGetToken(t *testing.T) {
+	mockClient := tzclient.NewMockClient()
+	mockClient.Storage = &x4c.FA2Storage{TokenMetadata: 5}
+	mockClient.AddBigMap(5, []tzkt.BigMapItem{
+		{
+			// "Forest project", vintage 2021, and a PACT factor of 0.85
+			Active: true,
+			Key:    json.RawMessage(`"1"`),
+			Value:  json.RawMessage(`{"token_id": "1", "token_info": {"name": "466f726573742070726f6a656374", "vintage": "32303231", "pactFactor": "302e3835"}}`),
+		},
+		{
+			// Added before there was a schema
+			Active: true,
+			Key:    json.RawMessage(`"2"`),
+			Value:  json.RawMessage(`{"token_id": "2", "token_info": {"title": "74657374", "url": "75726c"}}`),
+		},
+		{
+			// "Wetland", vintage 2020, but with decimals of "two" and creators that
+			// aren't JSON
+			Active: true,
+			Key:    json.RawMessage(`"4"`),
+			Value:  json.RawMessage(`{"token_id": "4", "token_info": {"name": "5765746c616e64", "vintage": "32303230", "decimals": "74776f", "creators": "616c696365"}}`),
+		},
+	})
+
+	testcases := []struct {
+		target         string
+		expectedStatus int
+		expected       x4c.ProjectMetadata
+		expectError    bool
+	}{
+		{
+			target:         "/tokens/KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR/1",
+			expectedStatus: http.StatusOK,
+			expected:       x4c.ProjectMetadata{Name: "Forest project", Vintage: 2021, PACTFactor: 0.85},
+		},
+		{
+			target:         "/tokens/KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR/2",
+			expectedStatus: http.StatusOK,
+			expected:       x4c.ProjectMetadata{Name: "test", ExternalURI: "url"},
+		},
+		{
+			target:         "/tokens/KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR/4",
+			expectedStatus: http.StatusOK,
+			expected:       x4c.ProjectMetadata{Name: "Wetland", Vintage: 2020},
+			expectError:    true,
+		},
+		{
+			target:         "/tokens/KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR/3",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			target:         "/tokens/KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR/one",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			target:         "/tokens/alice/1",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		server := newMockServer(mockClient)
+
+		r, err := http.NewRequest("GET", testcase.target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+		if resp.StatusCode != testcase.expectedStatus {
+			t.Errorf("%d: Expected status %d, got %d", idx, testcase.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var result GetTokenResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Errorf("%d: Failed to decode response: %v", idx, err)
+			continue
+		}
+		metadata := result.Data.Metadata
+		if metadata.Name != testcase.expected.Name || metadata.ExternalURI != testcase.expected.ExternalURI ||
+			metadata.Vintage != testcase.expected.Vintage || metadata.PACTFactor != testcase.expected.PACTFactor {
+			t.Errorf("%d: Expected %v, got %v", idx, testcase.expected, metadata)
+		}
+		if (result.Data.MetadataError != "") != testcase.expectError {
+			t.Errorf("%d: Unexpected metadata error %q", idx, result.Data.MetadataError)
+		}
+		if result.Data.Token.Address != "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR" {
+			t.Errorf("%d: Unexpected token %v", idx, result.Data.Token)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+// Simulates the operation that build makes, so that SubmitInBatches can work out how
+// many rows fit in an operation.
+func simulateBatch(client tzclient.TezosClient, build func(client tzclient.TezosClient) error) (tzclient.SimulationResult, error) {
+	simulator := tzclient.NewDryRunClient(client)
+	err := build(simulator)
+	if err != nil {
+		return tzclient.SimulationResult{}, err
+	}
+	if len(simulator.Simulations) != 1 {
+		return tzclient.SimulationResult{}, fmt.Errorf("expected one operation, got %d", len(simulator.Simulations))
+	}
+	return simulator.Simulations[0], nil
+}
+
+// Unless this is a dry run, waits for each operation in a batch before the next is
+// sent, for the number of confirmations given with -wait, or for one if that is less.
+func confirmBatches(ctx context.Context, client tzclient.TezosClient, submit func(start int, end int) (string, error)) func(start int, end int) (string, error) {
+	if dryRunClient != nil {
+		return submit
+	}
+	confirmations := int64(1)
+	if globalWriteOptions.Wait > confirmations {
+		confirmations = globalWriteOptions.Wait
+	}
+	return x4c.ConfirmEachBatch(ctx, client, confirmations, submit)
+}
+
+// Reports rows using 1-based numbering to match what people see in their spreadsheet.
+func printBatchResults(results []x4c.BatchResult, err error) int {
+	for index, result := range results {
+		if dryRunClient != nil {
+			fmt.Printf("Rows %d to %d simulated successfully, not injected:\n", result.Start+1, result.End)
+			printSimulation(dryRunClient.Simulations[index])
+		} else {
+			fmt.Printf("Rows %d to %d applied as %s\n", result.Start+1, result.End, result.OperationHash)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to submit batch: %v\n", err)
+		if len(results) > 0 {
+			fmt.Fprintf(os.Stderr, "Rows after %d were not submitted.\n", results[len(results)-1].End)
+		} else {
+			fmt.Fprintf(os.Stderr, "No rows were submitted.\n")
+		}
+		return 1
+	}
+	return 0
+}
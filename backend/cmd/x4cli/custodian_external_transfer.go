@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"blockwatch.cc/tzgo/tezos"
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type custodianExternalTransfer struct{}
+
+func NewCustodianExternalTransferCommand() (cli.Command, error) {
+	return custodianExternalTransfer{}, nil
+}
+
+func (c custodianExternalTransfer) Help() string {
+	return `usage: x4cli custodian external_transfer CONTRACT SIGNER FA2_CONTRACT TOKEN_ID AMOUNT KYC DESTINATION
+
+Transfers tokens held for an off-chain owner to an on-chain address, removing them from the custodian.`
+}
+
+func (c custodianExternalTransfer) Synopsis() string {
+	return "Transfers tokens held for an off-chain owner to an on-chain address."
+}
+
+func (c custodianExternalTransfer) Run(args []string) int {
+	if len(args) != 7 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - Custodian contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Signer name/address
+	signer, ok := client.Wallets[args[1]]
+	if !ok {
+		signer, err = tzclient.NewWalletWithAddress("signer", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signer address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - FA2 contract address
+	fa2, err := client.ContractByName(args[2])
+	if err != nil {
+		fa2, err = tzclient.NewContractWithAddress("contract", args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FA2 contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg3 - token ID
+	token_id, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token ID %v: %v\n", args[3], err)
+		return 1
+	}
+
+	// arg4 - amount
+	amount, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse amount %v: %v\n", args[4], err)
+		return 1
+	}
+
+	// arg5 - current kyc
+	kyc := args[5]
+
+	// arg6 - destination (could be wallet, contract, or raw address)
+	destination := tezos.Address{}
+	if wallet, ok := client.Wallets[args[6]]; ok {
+		destination = wallet.Address
+	} else if contract, err := client.ContractByName(args[6]); err == nil {
+		destination = contract.Address
+	} else {
+		destination, err = tezos.ParseAddress(args[6])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse destination %v: %v\n", args[6], err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+
+	transfer_list := []x4c.CustodianExternalTransferInfo{
+		{
+			TokenAddress: fa2,
+			Batches: []x4c.CustodianExternalTransferBatch{
+				{
+					FromKYC: kyc,
+					Txs: []x4c.CustodianExternalTransferDestination{
+						{
+							To:      destination,
+							TokenID: token_id,
+							Amount:  amount,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	operation_hash, err := x4c.CustodianExternalTransfer(ctx, writeClient(client), contract, signer, transfer_list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to transfer tokens: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
@@ -25,6 +25,9 @@ type CustodianSnapshot struct {
 	ExternalLedgerContents x4c.ExternalLedger    `json:"-"`
 	MetadataContents       x4c.CustodianMetadata `json:"-"`
 
+	// If the ledger is from the past rather than current, the level it is from
+	LedgerLevel int32 `json:"ledger_level,omitempty"`
+
 	// These are the versions of the above for JSON output
 	JSONSafeLedger         []map[string]interface{} `json:"ledger_bigmap"`
 	JSONSafeExternalLedger []map[string]interface{} `json:"external_ledger_bigmap"`
@@ -43,10 +46,14 @@ func NewCustodianInfoCommand() (cli.Command, error) {
 }
 
 func (c custodianInfoCommand) Help() string {
-	return `usage: x4cli custodian info [-json] CONTRACT
+	return `usage: x4cli custodian info [-json] [-at LEVEL|TIME|DATE] CONTRACT
 
 Shows information about the specified custodian contract. Defaults to human
-readable, but can also output JSON to snapshot the contract.`
+readable, but can also output JSON to snapshot the contract.
+
+With -at the ledger is shown as it was at the end of the given block level, RFC 3339
+time, or YYYY-MM-DD date (meaning the end of that day in UTC). Everything else is
+shown as it is now.`
 }
 
 func (c custodianInfoCommand) Synopsis() string {
@@ -56,8 +63,10 @@ func (c custodianInfoCommand) Synopsis() string {
 func (c custodianInfoCommand) Run(rawargs []string) int {
 
 	var outputJson bool
+	var at string
 	flags := flag.NewFlagSet("info", flag.ExitOnError)
 	flags.BoolVar(&outputJson, "json", false, "output JSON")
+	flags.StringVar(&at, "at", "", "show the ledger at a block level, time, or date")
 	flags.Parse(rawargs)
 	args := flags.Args()
 
@@ -66,7 +75,7 @@ func (c custodianInfoCommand) Run(rawargs []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -81,40 +90,61 @@ func (c custodianInfoCommand) Run(rawargs []string) int {
 		}
 	}
 
+	reader, err := readClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+
 	// Gather all the info, and then work out if we're displaying it for humans or as JSON
 	ctx := context.Background()
 	var storage x4c.CustodianStorage
-	err = client.GetContractStorage(contract, ctx, &storage)
+	err = reader.GetContractStorage(contract, ctx, &storage)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get contract storage: %v.\n", err)
 		return 1
 	}
-	ledger, err := storage.GetLedger(ctx, client)
+	var ledger_level int32
+	var ledger x4c.Ledger
+	if at == "" {
+		ledger, err = storage.GetLedger(ctx, reader)
+	} else {
+		var moment x4c.Moment
+		moment, err = x4c.ParseMoment(at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -at value: %v\n", err)
+			return 1
+		}
+		ledger_level, err = moment.ResolveLevel(ctx, reader)
+		if err == nil {
+			ledger, err = storage.GetLedgerAt(ctx, reader, x4c.AtLevel(ledger_level))
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read ledger: %v\n", err)
 		return 1
 	}
-	external_ledger, err := storage.GetExternalLedger(ctx, client)
+	external_ledger, err := storage.GetExternalLedger(ctx, reader)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read external ledger: %v\n", err)
 		return 1
 	}
-	metadata, err := storage.GetCustodianMetadata(ctx, client)
+	metadata, err := storage.GetCustodianMetadata(ctx, reader)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read metadata: %v\n", err)
 		return 1
 	}
-	mint_events, err := x4c.GetInternalMintEvents(ctx, client, contract)
+	mint_events, err := x4c.GetInternalMintEvents(ctx, reader, contract)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get internal mint events: %v\n", err)
 		return 1
 	}
-	transfer_events, err := x4c.GetInternalTransferEvents(ctx, client, contract)
+	transfer_events, err := x4c.GetInternalTransferEvents(ctx, reader, contract)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get internal transfer events: %v\n", err)
 		return 1
 	}
-	retirement_events, err := x4c.GetCustodianRetireEvents(ctx, client, contract)
+	retirement_events, err := x4c.GetCustodianRetireEvents(ctx, reader, contract)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get retirement events: %v\n", err)
 		return 1
@@ -122,6 +152,7 @@ func (c custodianInfoCommand) Run(rawargs []string) int {
 
 	info := CustodianSnapshot{
 		CustodianStorage:       storage,
+		LedgerLevel:            ledger_level,
 		LedgerContents:         ledger,
 		ExternalLedgerContents: external_ledger,
 		MetadataContents:       metadata,
@@ -147,7 +178,11 @@ func displayCustodianAsText(client tzclient.Client, info CustodianSnapshot) erro
 	custodianName := client.FindNameForAddress(info.Custodian)
 	fmt.Printf("Custodian: %v\n", custodianName)
 
-	fmt.Printf("\nLedger:\n")
+	if info.LedgerLevel != 0 {
+		fmt.Printf("\nLedger at level %d:\n", info.LedgerLevel)
+	} else {
+		fmt.Printf("\nLedger:\n")
+	}
 	{
 		t := tabby.New()
 		t.AddHeader("KYC", "Minter", "ID", "Amount")
@@ -225,7 +260,7 @@ func displayCustodianAsText(client tzclient.Client, info CustodianSnapshot) erro
 		t := tabby.New()
 		t.AddHeader("ID", "Time", "Token Address", "Token ID", "By", "KYC", "Amount", "Reason")
 		for _, event := range info.RetireEvents {
-			t.AddLine(event.Identifier, event.Timestamp, event.Token.Address, event.Token.TokenID, event.RetiringParty, event.RetiringPartyKyc, event.Amount, event.Reason)
+			t.AddLine(event.Identifier, event.Timestamp, event.Token.Address, event.Token.TokenID, event.RetiringParty, event.RetiringPartyKyc, event.Amount, event.Metadata.String())
 		}
 		t.Print()
 	}
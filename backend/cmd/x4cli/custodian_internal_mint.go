@@ -34,7 +34,7 @@ func (c custodianInternalMint) Run(args []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -79,13 +79,11 @@ func (c custodianInternalMint) Run(args []string) int {
 
 	ctx := context.Background()
 
-	operation_hash, err := x4c.CustodianInternalMint(ctx, client, contract, signer, fa2, token_id)
+	operation_hash, err := x4c.CustodianInternalMint(ctx, writeClient(client), contract, signer, fa2, token_id)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to update operators: %v", err)
 		return 1
 	}
 
-	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
-
-	return 0
+	return reportOperation(ctx, client, operation_hash)
 }
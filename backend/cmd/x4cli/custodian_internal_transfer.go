@@ -34,7 +34,7 @@ func (c custodianInternalTransfer) Run(args []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -92,13 +92,11 @@ func (c custodianInternalTransfer) Run(args []string) int {
 
 	ctx := context.Background()
 
-	operation_hash, err := x4c.CustodianInternalTransfer(ctx, client, contract, signer, fa2, token_id, amount, current_kyc, new_kyc)
+	operation_hash, err := x4c.CustodianInternalTransfer(ctx, writeClient(client), contract, signer, fa2, token_id, amount, current_kyc, new_kyc)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to update operators: %v", err)
 		return 1
 	}
 
-	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
-
-	return 0
+	return reportOperation(ctx, client, operation_hash)
 }
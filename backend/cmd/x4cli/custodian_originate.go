@@ -34,7 +34,7 @@ func (c custodianOriginateCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -77,7 +77,7 @@ func (c custodianOriginateCommand) Run(args []string) int {
 
 	ctx := context.Background()
 
-	contract, err := x4c.CustodianOriginate(ctx, client, contractBytes, signer, owner)
+	contract, err := x4c.CustodianOriginate(ctx, writeClient(client), contractBytes, signer, owner)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to originate contract: %v", err)
 		return 1
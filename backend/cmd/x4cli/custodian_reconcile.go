@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type custodianReconcileCommand struct{}
+
+func NewCustodianReconcileCommand() (cli.Command, error) {
+	return custodianReconcileCommand{}, nil
+}
+
+func (c custodianReconcileCommand) Help() string {
+	return `usage: x4cli custodian reconcile [-json] [-ignore-unminted] CONTRACT [FA2_CONTRACT...]
+
+Checks that the custodian's internal ledger adds up to its external ledger for each
+token, and that the external ledger matches what the custodian owns on the FA2
+contracts. The FA2 contracts named in the custodian's ledgers are always checked; any
+others given are checked too, to find credits sent on contracts never minted from.
+
+Exits with status 2 if any discrepancies are found, and 1 if the check could not be
+made, so it can be used for monitoring. Credits held on an FA2 contract that are
+waiting for internal_mint are reported as unminted, and with -ignore-unminted they do
+not affect the exit status.`
+}
+
+func (c custodianReconcileCommand) Synopsis() string {
+	return "Checks a custodian contract's ledgers agree with each other and the FA2 contracts."
+}
+
+func (c custodianReconcileCommand) Run(rawargs []string) int {
+
+	var outputJson, ignoreUnminted bool
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	flags.BoolVar(&outputJson, "json", false, "output JSON")
+	flags.BoolVar(&ignoreUnminted, "ignore-unminted", false, "don't fail on credits awaiting internal_mint")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Expected a contract name or address\n")
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load client: %v.\n", err)
+		return 1
+	}
+
+	contracts := make([]tzclient.Contract, len(args))
+	for index, arg := range args {
+		contracts[index], err = client.ContractByName(arg)
+		if err != nil {
+			contracts[index], err = tzclient.NewContractWithAddress(arg, arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Contract address '%s' is not valid: %v\n", arg, err)
+				return 1
+			}
+		}
+	}
+
+	reader, err := readClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+
+	report, err := x4c.Reconcile(context.Background(), reader, contracts[0], contracts[1:]...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reconcile: %v\n", err)
+		return 1
+	}
+
+	if outputJson {
+		data, err := json.Marshal(report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to marshal report: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	} else {
+		displayReconciliationAsText(client, report)
+	}
+
+	if len(report.Problems(!ignoreUnminted)) > 0 {
+		return 2
+	}
+	return 0
+}
+
+func displayReconciliationAsText(client tzclient.Client, report x4c.ReconciliationReport) {
+	fmt.Printf("Custodian: %v\n", client.FindNameForAddress(report.Custodian))
+
+	fmt.Printf("\nBalances:\n")
+	{
+		t := tabby.New()
+		t.AddHeader("Minter", "ID", "Internal", "External", "FA2")
+		for _, token := range report.Tokens {
+			minter := client.FindNameForAddress(token.Token.Address)
+			t.AddLine(minter, token.Token.TokenID, token.Internal, token.External, token.FA2)
+		}
+		t.Print()
+	}
+
+	if len(report.Discrepancies) == 0 {
+		fmt.Printf("\nNo discrepancies found.\n")
+		return
+	}
+	fmt.Printf("\nDiscrepancies:\n")
+	{
+		t := tabby.New()
+		t.AddHeader("Minter", "ID", "Kind", "Detail")
+		for _, discrepancy := range report.Discrepancies {
+			minter := client.FindNameForAddress(discrepancy.Token.Address)
+			t.AddLine(minter, discrepancy.Token.TokenID, discrepancy.Kind, discrepancy.Detail)
+		}
+		t.Print()
+	}
+}
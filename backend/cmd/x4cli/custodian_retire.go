@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -19,22 +20,48 @@ func NewCustodianRetireCommand() (cli.Command, error) {
 }
 
 func (c custodianRetireCommand) Help() string {
-	return `usage: x4cli custodian retire CONTRACT SIGNER TOKEN_ADDRESS OWNER TOKEN_ID AMOUNT REASON
+	return `usage: x4cli custodian retire [METADATA OPTIONS] CONTRACT SIGNER TOKEN_ADDRESS OWNER TOKEN_ID AMOUNT REASON
+       x4cli custodian retire -file RETIREMENTS [-batch-size N] CONTRACT SIGNER
 
-Retires a set of tokens for a given off chain owner. Will update the source FA2 contract.`
+Retires a set of tokens for a given off chain owner. Will update the source FA2 contract.
+
+The reason is stored as the note in the retirement metadata, which can also record who
+the retirement is for with -beneficiary, why with -purpose, the reporting period with
+-period-start and -period-end (as YYYY-MM-DD dates), and the compliance scheme with
+-scheme.
+
+With -file, retirements are read from a CSV or JSON file with token_address, token_id,
+kyc, amount, and optional reason, beneficiary, purpose, compliance_scheme,
+reporting_period_start, and reporting_period_end columns. All rows are checked against
+the custodian ledger before anything is submitted, and are packed into as few
+operations as will fit on chain.`
 }
 
 func (c custodianRetireCommand) Synopsis() string {
 	return "Retires a set of tokens for a given off chain owner."
 }
 
-func (c custodianRetireCommand) Run(args []string) int {
+func (c custodianRetireCommand) Run(rawargs []string) int {
+
+	var batch_file string
+	var batch_size int
+	flags := flag.NewFlagSet("retire", flag.ExitOnError)
+	flags.StringVar(&batch_file, "file", "", "CSV or JSON file of retirements")
+	flags.IntVar(&batch_size, "batch-size", 0, "maximum retirements per operation, or 0 for as many as fit")
+	metadata_flags := addRetirementMetadataFlags(flags)
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if batch_file != "" {
+		return c.runBatch(args, batch_file, batch_size)
+	}
+
 	if len(args) != 7 {
 		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -88,17 +115,112 @@ func (c custodianRetireCommand) Run(args []string) int {
 	}
 
 	// arg6 - reason
-	reason := args[6]
+	metadata, err := metadata_flags.metadata(args[6])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid retirement metadata: %v\n", err)
+		return 1
+	}
 
 	ctx := context.Background()
 
-	operation_hash, err := x4c.CustodianRetire(ctx, client, contract, signer, fa2, token_id, kyc, amount, reason)
+	operation_hash, err := x4c.CustodianRetire(ctx, writeClient(client), contract, signer, fa2, token_id, kyc, amount, metadata)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to retire tokens: %v\n", err)
 		return 1
 	}
 
-	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
+	return reportOperation(ctx, client, operation_hash)
+}
+
+func (c custodianRetireCommand) runBatch(args []string, batch_file string, batch_size int) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	retire_list, err := x4c.LoadRetireFile(batch_file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load retirements: %v\n", err)
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - Custodian contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Signer name/address
+	signer, ok := client.Wallets[args[1]]
+	if !ok {
+		signer, err = tzclient.NewWalletWithAddress("signer", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signer address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+
+	err = x4c.ValidateRetireBatch(ctx, client, contract, retire_list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to validate retirements: %v\n", err)
+		return 1
+	}
+
+	write_client := writeClient(client)
+	simulate := func(start int, end int) (tzclient.SimulationResult, error) {
+		return simulateBatch(client, func(simulator tzclient.TezosClient) error {
+			_, err := x4c.CustodianRetireBatch(ctx, simulator, contract, signer, retire_list[start:end])
+			return err
+		})
+	}
+	submit := confirmBatches(ctx, client, func(start int, end int) (string, error) {
+		return x4c.CustodianRetireBatch(ctx, write_client, contract, signer, retire_list[start:end])
+	})
+	results, err := x4c.SubmitInBatches(len(retire_list), batch_size, simulate, submit)
+	return printBatchResults(results, err)
+}
+
+type retirementMetadataFlags struct {
+	beneficiary  string
+	purpose      string
+	scheme       string
+	period_start string
+	period_end   string
+}
 
-	return 0
+func addRetirementMetadataFlags(flags *flag.FlagSet) *retirementMetadataFlags {
+	metadata_flags := &retirementMetadataFlags{}
+	flags.StringVar(&metadata_flags.beneficiary, "beneficiary", "", "who the retirement is on behalf of")
+	flags.StringVar(&metadata_flags.purpose, "purpose", "", "what the retirement is for")
+	flags.StringVar(&metadata_flags.scheme, "scheme", "", "compliance scheme the retirement counts towards")
+	flags.StringVar(&metadata_flags.period_start, "period-start", "", "start of the reporting period, as YYYY-MM-DD")
+	flags.StringVar(&metadata_flags.period_end, "period-end", "", "end of the reporting period, as YYYY-MM-DD")
+	return metadata_flags
+}
+
+// Builds the retirement metadata from the flags, with the reason argument as the note.
+func (f *retirementMetadataFlags) metadata(reason string) (x4c.RetirementMetadata, error) {
+	metadata := x4c.NoteRetirementMetadata(reason)
+	metadata.Beneficiary = f.beneficiary
+	metadata.Purpose = f.purpose
+	metadata.ComplianceScheme = f.scheme
+	if f.period_start != "" || f.period_end != "" {
+		metadata.ReportingPeriod = &x4c.ReportingPeriod{
+			Start: f.period_start,
+			End:   f.period_end,
+		}
+	}
+	return metadata, metadata.Validate()
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type custodianStatementCommand struct{}
+
+func NewCustodianStatementCommand() (cli.Command, error) {
+	return custodianStatementCommand{}, nil
+}
+
+func (c custodianStatementCommand) Help() string {
+	return `usage: x4cli custodian statement [-from LEVEL|TIME|DATE] [-to LEVEL|TIME|DATE] [-format csv|json|pdf] CONTRACT KYC
+
+Writes a statement for the KYC on the custodian contract to stdout, giving for each
+token the opening balance, the mints, transfers, and retirements in the period, and
+the closing balance. Changes to the balance that no event explains, such as external
+transfers, are shown as "other".
+
+-from is the start of the period, where a date means the start of that day in UTC, and
+defaults to the origination of the contract. -to is the end of the period, where a date
+means the end of that day in UTC, and defaults to now. The default format is csv.`
+}
+
+func (c custodianStatementCommand) Synopsis() string {
+	return "Writes a statement of credits for a KYC on a custodian contract."
+}
+
+func (c custodianStatementCommand) Run(rawargs []string) int {
+
+	var from_value, to_value, format string
+	flags := flag.NewFlagSet("statement", flag.ExitOnError)
+	flags.StringVar(&from_value, "from", "", "start of the period as a block level, time, or date")
+	flags.StringVar(&to_value, "to", "", "end of the period as a block level, time, or date")
+	flags.StringVar(&format, "format", "csv", "output format: csv, json, or pdf")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Expected a contract name or address and a KYC\n")
+		return 1
+	}
+	if format != "csv" && format != "json" && format != "pdf" {
+		fmt.Fprintf(os.Stderr, "Unknown format '%s'\n", format)
+		return 1
+	}
+
+	var from x4c.Moment
+	var err error
+	if from_value != "" {
+		from, err = x4c.ParseStartMoment(from_value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -from value: %v\n", err)
+			return 1
+		}
+	}
+	to := x4c.AtTime(time.Now())
+	if to_value != "" {
+		to, err = x4c.ParseMoment(to_value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -to value: %v\n", err)
+			return 1
+		}
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load client: %v.\n", err)
+		return 1
+	}
+
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress(args[0], args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address '%s' is not valid: %v\n", args[0], err)
+			return 1
+		}
+	}
+
+	reader, err := readClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+
+	statement, err := x4c.GenerateStatement(context.Background(), reader, contract, args[1], from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate statement: %v\n", err)
+		return 1
+	}
+
+	switch format {
+	case "json":
+		var data []byte
+		data, err = json.Marshal(statement)
+		if err == nil {
+			fmt.Println(string(data))
+		}
+	case "pdf":
+		err = statement.WritePDF(os.Stdout)
+	default:
+		err = statement.WriteCSV(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write statement: %v\n", err)
+		return 1
+	}
+	return 0
+}
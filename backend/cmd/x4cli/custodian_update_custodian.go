@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"blockwatch.cc/tzgo/tezos"
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type custodianUpdateCustodian struct{}
+
+func NewCustodianUpdateCustodianCommand() (cli.Command, error) {
+	return custodianUpdateCustodian{}, nil
+}
+
+func (c custodianUpdateCustodian) Help() string {
+	return `usage: x4cli custodian update_custodian CONTRACT SIGNER NEW_CUSTODIAN
+
+Hands ownership of the custodian contract to a new address. The signer must be the current custodian.`
+}
+
+func (c custodianUpdateCustodian) Synopsis() string {
+	return "Hands ownership of the custodian contract to a new address."
+}
+
+func (c custodianUpdateCustodian) Run(args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - Custodian contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Signer name/address
+	signer, ok := client.Wallets[args[1]]
+	if !ok {
+		signer, err = tzclient.NewWalletWithAddress("signer", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signer address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - new custodian (could be wallet, contract, or raw address)
+	new_custodian := tezos.Address{}
+	if wallet, ok := client.Wallets[args[2]]; ok {
+		new_custodian = wallet.Address
+	} else if contract, err := client.ContractByName(args[2]); err == nil {
+		new_custodian = contract.Address
+	} else {
+		new_custodian, err = tezos.ParseAddress(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse new custodian %v: %v\n", args[2], err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+
+	operation_hash, err := x4c.CustodianUpdateCustodian(ctx, writeClient(client), contract, signer, new_custodian)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update custodian: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
@@ -44,7 +44,7 @@ func (c custodianUpdateOperator) Run(args []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -70,13 +70,11 @@ func (c custodianUpdateOperator) Run(args []string) int {
 		}
 	}
 
-	// arg2 - operator address
-	operator, ok := client.Wallets[args[2]]
-	if !ok {
-		operator, err = tzclient.NewWalletWithAddress("operator", args[2])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Operator address is not valid: %v\n", args[2])
-		}
+	// arg2 - operator (could be wallet, contract, or raw address)
+	operator, err := resolveAddress(client, args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Operator address is not valid: %v\n", err)
+		return 1
 	}
 
 	// arg3 - token ID
@@ -98,18 +96,16 @@ func (c custodianUpdateOperator) Run(args []string) int {
 	operator_list := make([]x4c.CustodianOperatorUpdateInfo, 1)
 	operator_list[0] = x4c.CustodianOperatorUpdateInfo{
 		Owner:      owner,
-		Operator:   operator.Address,
+		Operator:   operator,
 		TokenID:    token_id,
 		UpdateType: c.OperationType,
 	}
 
-	operation_hash, err := x4c.CustodianUpdateOperators(ctx, client, contract, signer, operator_list)
+	operation_hash, err := x4c.CustodianUpdateOperators(ctx, writeClient(client), contract, signer, operator_list)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to update operators: %v\n", err)
 		return 1
 	}
 
-	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
-
-	return 0
+	return reportOperation(ctx, client, operation_hash)
 }
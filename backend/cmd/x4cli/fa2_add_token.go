@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -19,20 +20,46 @@ func NewAddTokenCommand() (cli.Command, error) {
 }
 
 func (c addTokenCommand) Help() string {
-	return "Add a new token."
+	return `usage: x4cli fa2 add_token CONTRACT ORACLE TOKEN_ID TITLE URL
+       x4cli fa2 add_token -metadata PROJECT_FILE CONTRACT ORACLE TOKEN_ID
+
+Adds a new token ID for a project. With -metadata, the project is described by a JSON
+file with the fields of x4c.ProjectMetadata: the TZIP-21 name, symbol, decimals,
+description, creators, tags, externalUri, displayUri, thumbnailUri, and attributes,
+along with projectType, coordinates (latitude and longitude), geoJson, vintage,
+registryId, permanenceYears, and pactFactor. Only name is required.`
 }
 
 func (c addTokenCommand) Synopsis() string {
 	return "Add a new token."
 }
 
-func (c addTokenCommand) Run(args []string) int {
-	if len(args) != 5 {
-		fmt.Fprintf(os.Stderr, "Expected: contract oracle token_id token_owner amount\n")
+func (c addTokenCommand) Run(rawargs []string) int {
+
+	var metadata_file string
+	flags := flag.NewFlagSet("add_token", flag.ExitOnError)
+	flags.StringVar(&metadata_file, "metadata", "", "JSON file describing the project")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	var project x4c.ProjectMetadata
+	if metadata_file != "" {
+		if len(args) != 3 {
+			fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+			return 1
+		}
+		var err error
+		project, err = x4c.LoadProjectMetadata(metadata_file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load project metadata: %v\n", err)
+			return 1
+		}
+	} else if len(args) != 5 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -65,21 +92,24 @@ func (c addTokenCommand) Run(args []string) int {
 		return 1
 	}
 
-	// arg3 - token title
-	title := args[3]
+	ctx := context.Background()
 
-	// arg4 - token url
-	url := args[4]
+	var operation_hash string
+	if metadata_file != "" {
+		operation_hash, err = x4c.FA2AddProject(ctx, writeClient(client), contract, oracle, token_id, project)
+	} else {
+		// arg3 - token title
+		title := args[3]
 
-	ctx := context.Background()
+		// arg4 - token url
+		url := args[4]
 
-	operation_hash, err := x4c.FA2AddToken(ctx, client, contract, oracle, token_id, title, url)
+		operation_hash, err = x4c.FA2AddToken(ctx, writeClient(client), contract, oracle, token_id, title, url)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to add token: %v\n", err)
 		return 1
 	}
 
-	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
-
-	return 0
+	return reportOperation(ctx, client, operation_hash)
 }
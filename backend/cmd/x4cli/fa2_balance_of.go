@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2BalanceOfCommand struct{}
+
+func NewFA2BalanceOfCommand() (cli.Command, error) {
+	return fa2BalanceOfCommand{}, nil
+}
+
+func (c fa2BalanceOfCommand) Help() string {
+	return `usage: x4cli fa2 balance_of CONTRACT SIGNER CALLBACK[%ENTRYPOINT] OWNER TOKEN_ID
+
+Calls the standard FA2 balance_of entrypoint, which sends the balance to the callback
+contract. To just see balances use "x4cli fa2 info" instead.`
+}
+
+func (c fa2BalanceOfCommand) Synopsis() string {
+	return "Sends an owner's balance to a callback contract."
+}
+
+func (c fa2BalanceOfCommand) Run(args []string) int {
+	if len(args) != 5 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Signer name/address
+	signer, ok := client.Wallets[args[1]]
+	if !ok {
+		signer, err = tzclient.NewWalletWithAddress("signer", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signer address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - callback contract name/address with optional entrypoint
+	callback_name, callback_entrypoint, _ := strings.Cut(args[2], "%")
+	callback, err := client.ContractByName(callback_name)
+	if err != nil {
+		callback, err = tzclient.NewContractWithAddress("callback", callback_name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Callback contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg3 - token owner (could be wallet, contract, or raw address)
+	owner, err := resolveAddress(client, args[3])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token owner %v: %v\n", args[3], err)
+		return 1
+	}
+
+	// arg4 - token ID
+	token_id, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token ID %v: %v\n", args[4], err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	request_list := []x4c.FA2BalanceRequest{
+		{
+			Owner:   owner,
+			TokenID: token_id,
+		},
+	}
+
+	operation_hash, err := x4c.FA2BalanceOf(ctx, writeClient(client), contract, signer, request_list, callback, callback_entrypoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to request balance: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
@@ -25,6 +25,9 @@ type FA2Snapshot struct {
 	MetadataContents      x4c.FA2Metadata         `json:"-"`
 	TokenMetadataContents x4c.FA2TokenMetadataMap `json:"-"`
 
+	// If the ledger is from the past rather than current, the level it is from
+	LedgerLevel int32 `json:"ledger_level,omitempty"`
+
 	// These are the versions of the above for JSON output
 	JSONSafeLedger        []map[string]interface{} `json:"ledger_bigmap"`
 	JSONSafeMetadata      []map[string]interface{} `json:"metadata_bigmap"`
@@ -41,10 +44,14 @@ func NewFA2InfoCommand() (cli.Command, error) {
 }
 
 func (c fa2InfoCommand) Help() string {
-	return `usage: x4cli fa2 info [-json] CONTRACT
+	return `usage: x4cli fa2 info [-json] [-at LEVEL|TIME|DATE] CONTRACT
 
 Shows information about the specified custodian contract. Defaults to human
-readable, but can also output JSON to snapshot the contract.`
+readable, but can also output JSON to snapshot the contract.
+
+With -at the ledger is shown as it was at the end of the given block level, RFC 3339
+time, or YYYY-MM-DD date (meaning the end of that day in UTC). Everything else is
+shown as it is now.`
 }
 
 func (c fa2InfoCommand) Synopsis() string {
@@ -54,8 +61,10 @@ func (c fa2InfoCommand) Synopsis() string {
 func (c fa2InfoCommand) Run(rawargs []string) int {
 
 	var outputJson bool
+	var at string
 	flags := flag.NewFlagSet("info", flag.ExitOnError)
 	flags.BoolVar(&outputJson, "json", false, "output JSON")
+	flags.StringVar(&at, "at", "", "show the ledger at a block level, time, or date")
 	flags.Parse(rawargs)
 	args := flags.Args()
 
@@ -64,7 +73,7 @@ func (c fa2InfoCommand) Run(rawargs []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -79,30 +88,51 @@ func (c fa2InfoCommand) Run(rawargs []string) int {
 		}
 	}
 
+	reader, err := readClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+
 	// Gather all the info, and then work out if we're displaying it for humans or as JSON
 	ctx := context.Background()
 	var storage x4c.FA2Storage
-	err = client.GetContractStorage(contract, ctx, &storage)
+	err = reader.GetContractStorage(contract, ctx, &storage)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get contract storage: %v.\n", err)
 		return 1
 	}
-	ledger, err := storage.GetLedger(ctx, client)
+	var ledger_level int32
+	var ledger x4c.FA2Ledger
+	if at == "" {
+		ledger, err = storage.GetLedger(ctx, reader)
+	} else {
+		var moment x4c.Moment
+		moment, err = x4c.ParseMoment(at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -at value: %v\n", err)
+			return 1
+		}
+		ledger_level, err = moment.ResolveLevel(ctx, reader)
+		if err == nil {
+			ledger, err = storage.GetLedgerAt(ctx, reader, x4c.AtLevel(ledger_level))
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read ledger: %v", err)
 		return 1
 	}
-	metadata, err := storage.GetFA2Metadata(ctx, client)
+	metadata, err := storage.GetFA2Metadata(ctx, reader)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read FA2 metadata: %v", err)
 		return 1
 	}
-	token_metadata, err := storage.GetTokenMetadata(ctx, client)
+	token_metadata, err := storage.GetTokenMetadata(ctx, reader)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to read token metadata: %v", err)
 		return 1
 	}
-	retire_events, err := x4c.GetFA2RetireEvents(ctx, client, contract)
+	retire_events, err := x4c.GetFA2RetireEvents(ctx, reader, contract)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get retirement events: %v\n", err)
 		return 1
@@ -110,6 +140,7 @@ func (c fa2InfoCommand) Run(rawargs []string) int {
 
 	info := FA2Snapshot{
 		FA2Storage:            storage,
+		LedgerLevel:           ledger_level,
 		LedgerContents:        ledger,
 		MetadataContents:      metadata,
 		TokenMetadataContents: token_metadata,
@@ -133,7 +164,11 @@ func displayFA2AsText(client tzclient.Client, info FA2Snapshot) error {
 	oracleName := client.FindNameForAddress(info.Oracle)
 	fmt.Printf("Oracle: %v\n", oracleName)
 
-	fmt.Printf("\nLedger:\n")
+	if info.LedgerLevel != 0 {
+		fmt.Printf("\nLedger at level %d:\n", info.LedgerLevel)
+	} else {
+		fmt.Printf("\nLedger:\n")
+	}
 	{
 		t := tabby.New()
 		t.AddHeader("ID", "Owner", "Amount")
@@ -172,7 +207,7 @@ func displayFA2AsText(client tzclient.Client, info FA2Snapshot) error {
 		t := tabby.New()
 		t.AddHeader("ID", "Time", "Token ID", "By", "Amount", "Reason")
 		for _, event := range info.RetireEvents {
-			t.AddLine(event.Identifier, event.Timestamp, event.TokenID, event.RetiringParty, event.Amount, event.Reason)
+			t.AddLine(event.Identifier, event.Timestamp, event.TokenID, event.RetiringParty, event.Amount, event.Metadata.String())
 		}
 		t.Print()
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -20,20 +21,40 @@ func NewFA2MintCommand() (cli.Command, error) {
 }
 
 func (c mintCommand) Help() string {
-	return "Mint more of an existing token. Must have already been added to the contract."
+	return `usage: x4cli fa2 mint CONTRACT ORACLE TOKEN_ID TOKEN_OWNER AMOUNT
+       x4cli fa2 mint -file HOLDERS [-batch-size N] CONTRACT ORACLE
+
+Mint more of an existing token. Must have already been added to the contract.
+
+With -file, mints are read from a CSV or JSON file with owner, token_id, and amount
+columns. All rows are checked before anything is submitted, and are packed into as
+few operations as will fit on chain.`
 }
 
 func (c mintCommand) Synopsis() string {
 	return "Mint more of an existing token."
 }
 
-func (c mintCommand) Run(args []string) int {
+func (c mintCommand) Run(rawargs []string) int {
+
+	var batch_file string
+	var batch_size int
+	flags := flag.NewFlagSet("mint", flag.ExitOnError)
+	flags.StringVar(&batch_file, "file", "", "CSV or JSON file of mints")
+	flags.IntVar(&batch_size, "batch-size", 0, "maximum mints per operation, or 0 for as many as fit")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if batch_file != "" {
+		return c.runBatch(args, batch_file, batch_size)
+	}
+
 	if len(args) != 5 {
 		fmt.Fprintf(os.Stderr, "Expected: contract oracle token_id token_owner amount\n")
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -89,13 +110,71 @@ func (c mintCommand) Run(args []string) int {
 
 	ctx := context.Background()
 
-	operation_hash, err := x4c.FA2Mint(ctx, client, contract, oracle, token_id, owner, amount)
+	operation_hash, err := x4c.FA2Mint(ctx, writeClient(client), contract, oracle, token_id, owner, amount)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to mint tokens: %v\n", err)
 		return 1
 	}
 
-	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
+	return reportOperation(ctx, client, operation_hash)
+}
+
+func (c mintCommand) runBatch(args []string, batch_file string, batch_size int) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Expected: contract oracle\n")
+		return 1
+	}
+
+	mint_list, err := x4c.LoadMintFile(batch_file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load mints: %v\n", err)
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Oracle name/address
+	oracle, ok := client.Wallets[args[1]]
+	if !ok {
+		oracle, err = tzclient.NewWalletWithAddress("oracle", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Oracle address is not valid: %v", err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
 
-	return 0
+	err = x4c.ValidateMintBatch(ctx, client, contract, mint_list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to validate mints: %v\n", err)
+		return 1
+	}
+
+	write_client := writeClient(client)
+	simulate := func(start int, end int) (tzclient.SimulationResult, error) {
+		return simulateBatch(client, func(simulator tzclient.TezosClient) error {
+			_, err := x4c.FA2MintBatch(ctx, simulator, contract, oracle, mint_list[start:end])
+			return err
+		})
+	}
+	submit := confirmBatches(ctx, client, func(start int, end int) (string, error) {
+		return x4c.FA2MintBatch(ctx, write_client, contract, oracle, mint_list[start:end])
+	})
+	results, err := x4c.SubmitInBatches(len(mint_list), batch_size, simulate, submit)
+	return printBatchResults(results, err)
 }
@@ -34,7 +34,7 @@ func (c fa2OriginateCommand) Run(args []string) int {
 		return 1
 	}
 
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
@@ -77,7 +77,7 @@ func (c fa2OriginateCommand) Run(args []string) int {
 
 	ctx := context.Background()
 
-	contract, err := x4c.FA2Originate(ctx, client, contractBytes, signer, oracle)
+	contract, err := x4c.FA2Originate(ctx, writeClient(client), contractBytes, signer, oracle)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to originate contract: %v\n", err)
 		return 1
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2PublishMetadataCommand struct{}
+
+func NewFA2PublishMetadataCommand() (cli.Command, error) {
+	return fa2PublishMetadataCommand{}, nil
+}
+
+func (c fa2PublishMetadataCommand) Help() string {
+	return `usage: x4cli fa2 publish_metadata [-name NAME] [-description TEXT] [-version VERSION]
+       [-homepage URL] [-license NAME] [-authors AUTHOR,...] [-url URL | -print] CONTRACT ORACLE
+
+Builds the contract's TZIP-16 metadata, giving the interfaces it implements, its error
+codes, and the view_balance_of and view_get_metadata views, and publishes it with
+update_contract_metadata, replacing any metadata already there.
+
+By default the metadata is stored in the contract itself. To host it elsewhere instead,
+use -print to write the document to stdout without publishing it, put it at a URL, and
+then publish with -url, which fetches the document and pins the contract's metadata to
+its SHA-256 hash.`
+}
+
+func (c fa2PublishMetadataCommand) Synopsis() string {
+	return "Publishes TZIP-16 metadata for an fa2 contract."
+}
+
+func (c fa2PublishMetadataCommand) Run(rawargs []string) int {
+
+	var name, description, version, homepage, license, authors, location string
+	var print bool
+	flags := flag.NewFlagSet("publish_metadata", flag.ExitOnError)
+	flags.StringVar(&name, "name", "4C carbon credits", "contract name")
+	flags.StringVar(&description, "description", "", "contract description")
+	flags.StringVar(&version, "version", "", "contract version")
+	flags.StringVar(&homepage, "homepage", "", "homepage URL")
+	flags.StringVar(&license, "license", "", "license name")
+	flags.StringVar(&authors, "authors", "", "comma separated list of authors")
+	flags.StringVar(&location, "url", "", "publish the document hosted at this URL")
+	flags.BoolVar(&print, "print", false, "print the document rather than publishing it")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	metadata := x4c.NewFA2ContractMetadata(name, description, version)
+	metadata.Homepage = homepage
+	if license != "" {
+		metadata.License = &x4c.ContractMetadataLicense{Name: license}
+	}
+	if authors != "" {
+		metadata.Authors = strings.Split(authors, ",")
+	}
+	err := metadata.Validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if print {
+		data, err := json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode metadata: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Oracle name/address
+	oracle, ok := client.Wallets[args[1]]
+	if !ok {
+		oracle, err = tzclient.NewWalletWithAddress("oracle", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Oracle address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+
+	var bigmap map[string][]byte
+	if location == "" {
+		bigmap, err = x4c.InlineContractMetadata(metadata)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	} else {
+		// Pin whatever is actually hosted, having checked it is valid, rather than
+		// what we just built, so that the hash matches what readers will fetch
+		http_client := &http.Client{Timeout: 30 * time.Second}
+		document, err := x4c.FetchMetadataDocument(ctx, http_client, location)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		var hosted x4c.ContractMetadata
+		err = json.Unmarshal(document, &hosted)
+		if err == nil {
+			err = hosted.Validate()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Metadata at %s is not valid: %v\n", location, err)
+			return 1
+		}
+		bigmap = x4c.PinnedContractMetadata(location, document)
+	}
+
+	operation_hash, err := x4c.FA2UpdateContractMetadata(ctx, writeClient(client), contract, oracle, bigmap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update contract metadata: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
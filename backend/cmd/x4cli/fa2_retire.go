@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2RetireCommand struct{}
+
+func NewFA2RetireCommand() (cli.Command, error) {
+	return fa2RetireCommand{}, nil
+}
+
+func (c fa2RetireCommand) Help() string {
+	return `usage: x4cli fa2 retire [METADATA OPTIONS] CONTRACT SIGNER OWNER TOKEN_ID AMOUNT REASON
+
+Retires a set of tokens held directly by an on-chain owner. The signer must be the owner of the tokens or an operator for them.
+
+The reason is stored as the note in the retirement metadata. The metadata options are
+-beneficiary, -purpose, -period-start, -period-end, and -scheme, as for custodian retire.`
+}
+
+func (c fa2RetireCommand) Synopsis() string {
+	return "Retires a set of tokens for an on-chain owner."
+}
+
+func (c fa2RetireCommand) Run(rawargs []string) int {
+
+	flags := flag.NewFlagSet("retire", flag.ExitOnError)
+	metadata_flags := addRetirementMetadataFlags(flags)
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if len(args) != 6 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Signer name/address
+	signer, ok := client.Wallets[args[1]]
+	if !ok {
+		signer, err = tzclient.NewWalletWithAddress("signer", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signer address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - token owner (could be wallet, contract, or raw address)
+	owner, err := resolveAddress(client, args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token owner %v: %v\n", args[2], err)
+		return 1
+	}
+
+	// arg3 - token ID
+	token_id, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token ID %v: %v\n", args[3], err)
+		return 1
+	}
+
+	// arg4 - amount to retire
+	amount, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse amount %v: %v\n", args[4], err)
+		return 1
+	}
+
+	// arg5 - reason
+	metadata, err := metadata_flags.metadata(args[5])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid retirement metadata: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	retire_list := []x4c.FA2RetireInfo{
+		{
+			RetiringParty: owner,
+			TokenID:       token_id,
+			Amount:        amount,
+			Metadata:      metadata,
+		},
+	}
+
+	operation_hash, err := x4c.FA2Retire(ctx, writeClient(client), contract, signer, retire_list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to retire tokens: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"blockwatch.cc/tzgo/tezos"
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2TransferCommand struct{}
+
+func NewFA2TransferCommand() (cli.Command, error) {
+	return fa2TransferCommand{}, nil
+}
+
+func (c fa2TransferCommand) Help() string {
+	return `usage: x4cli fa2 transfer CONTRACT SIGNER FROM TO TOKEN_ID AMOUNT
+
+Transfers tokens between two on-chain addresses. The signer must be the owner of the tokens or an operator for them.`
+}
+
+func (c fa2TransferCommand) Synopsis() string {
+	return "Transfers tokens between two on-chain addresses."
+}
+
+func (c fa2TransferCommand) Run(args []string) int {
+	if len(args) != 6 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Signer name/address
+	signer, ok := client.Wallets[args[1]]
+	if !ok {
+		signer, err = tzclient.NewWalletWithAddress("signer", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signer address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - token owner (could be wallet, contract, or raw address)
+	from, err := resolveAddress(client, args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token owner %v: %v\n", args[2], err)
+		return 1
+	}
+
+	// arg3 - destination (could be wallet, contract, or raw address)
+	to, err := resolveAddress(client, args[3])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse destination %v: %v\n", args[3], err)
+		return 1
+	}
+
+	// arg4 - token ID
+	token_id, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token ID %v: %v\n", args[4], err)
+		return 1
+	}
+
+	// arg5 - amount
+	amount, err := strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse amount %v: %v\n", args[5], err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	transfer_list := []x4c.FA2TransferInfo{
+		{
+			From: from,
+			Txs: []x4c.FA2TransferDestination{
+				{
+					To:      to,
+					TokenID: token_id,
+					Amount:  amount,
+				},
+			},
+		},
+	}
+
+	operation_hash, err := x4c.FA2Transfer(ctx, writeClient(client), contract, signer, transfer_list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to transfer tokens: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
+
+// Lets the user specify an address as a wallet name, contract name, or raw address.
+func resolveAddress(client tzclient.Client, name string) (tezos.Address, error) {
+	if wallet, ok := client.Wallets[name]; ok {
+		return wallet.Address, nil
+	}
+	if contract, err := client.ContractByName(name); err == nil {
+		return contract.Address, nil
+	}
+	return tezos.ParseAddress(name)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2UpdateContractMetadataCommand struct{}
+
+func NewFA2UpdateContractMetadataCommand() (cli.Command, error) {
+	return fa2UpdateContractMetadataCommand{}, nil
+}
+
+func (c fa2UpdateContractMetadataCommand) Help() string {
+	return `usage: x4cli fa2 update_contract_metadata CONTRACT ORACLE KEY VALUE [KEY VALUE...]
+
+Replaces the contract's TZIP-16 metadata big map with the specified key value pairs.
+Any existing keys not specified will be removed.`
+}
+
+func (c fa2UpdateContractMetadataCommand) Synopsis() string {
+	return "Replaces the contract metadata."
+}
+
+func (c fa2UpdateContractMetadataCommand) Run(args []string) int {
+	if (len(args) < 4) || (len(args)%2 != 0) {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Oracle name/address
+	oracle, ok := client.Wallets[args[1]]
+	if !ok {
+		oracle, err = tzclient.NewWalletWithAddress("oracle", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Oracle address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// remaining args - key value pairs
+	metadata := make(map[string][]byte)
+	for index := 2; index < len(args); index += 2 {
+		if _, ok := metadata[args[index]]; ok {
+			fmt.Fprintf(os.Stderr, "Metadata key %s specified more than once\n", args[index])
+			return 1
+		}
+		metadata[args[index]] = []byte(args[index+1])
+	}
+
+	ctx := context.Background()
+
+	operation_hash, err := x4c.FA2UpdateContractMetadata(ctx, writeClient(client), contract, oracle, metadata)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update contract metadata: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
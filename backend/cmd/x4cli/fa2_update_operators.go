@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2UpdateOperator struct {
+	OperationType int
+}
+
+func NewFA2AddOperatorCommand() (cli.Command, error) {
+	return fa2UpdateOperator{
+		OperationType: x4c.AddOperator,
+	}, nil
+}
+
+func NewFA2RemoveOperatorCommand() (cli.Command, error) {
+	return fa2UpdateOperator{
+		OperationType: x4c.RemoveOperator,
+	}, nil
+}
+
+func (c fa2UpdateOperator) Help() string {
+	if c.OperationType == x4c.RemoveOperator {
+		return `usage: x4cli fa2 remove_operator CONTRACT OWNER OPERATOR TOKEN_ID
+
+Removes an operator for the owner's tokens on the FA2 contract. Must be signed by the owner.`
+	}
+	return `usage: x4cli fa2 add_operator CONTRACT OWNER OPERATOR TOKEN_ID
+
+Adds an operator for the owner's tokens on the FA2 contract. Must be signed by the owner.`
+}
+
+func (c fa2UpdateOperator) Synopsis() string {
+	if c.OperationType == x4c.RemoveOperator {
+		return "Removes an operator from the FA2 contract."
+	}
+	return "Adds an operator to the FA2 contract."
+}
+
+func (c fa2UpdateOperator) Run(args []string) int {
+	if len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Owner name/address, who must also sign
+	owner, ok := client.Wallets[args[1]]
+	if !ok {
+		owner, err = tzclient.NewWalletWithAddress("owner", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Owner address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - operator (could be wallet, contract, or raw address)
+	operator, err := resolveAddress(client, args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Operator address is not valid: %v\n", err)
+		return 1
+	}
+
+	// arg3 - token ID
+	token_id, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse token ID %v: %v\n", args[3], err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	update_list := []x4c.FA2OperatorUpdateInfo{
+		{
+			Owner:      owner.Address,
+			Operator:   operator,
+			TokenID:    token_id,
+			UpdateType: c.OperationType,
+		},
+	}
+
+	operation_hash, err := x4c.FA2UpdateOperators(ctx, writeClient(client), contract, owner, update_list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update operators: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
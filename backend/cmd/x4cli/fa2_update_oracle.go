@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"blockwatch.cc/tzgo/tezos"
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type fa2UpdateOracle struct{}
+
+func NewFA2UpdateOracleCommand() (cli.Command, error) {
+	return fa2UpdateOracle{}, nil
+}
+
+func (c fa2UpdateOracle) Help() string {
+	return `usage: x4cli fa2 update_oracle CONTRACT ORACLE NEW_ORACLE
+
+Hands ownership of the FA2 contract to a new oracle. The signer must be the current oracle.`
+}
+
+func (c fa2UpdateOracle) Synopsis() string {
+	return "Hands ownership of the FA2 contract to a new oracle."
+}
+
+func (c fa2UpdateOracle) Run(args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	// arg0 - FA2 contract name/address
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress("contract", args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg1 - Oracle name/address
+	oracle, ok := client.Wallets[args[1]]
+	if !ok {
+		oracle, err = tzclient.NewWalletWithAddress("oracle", args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Oracle address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	// arg2 - new oracle (could be wallet, contract, or raw address)
+	new_oracle := tezos.Address{}
+	if wallet, ok := client.Wallets[args[2]]; ok {
+		new_oracle = wallet.Address
+	} else if contract, err := client.ContractByName(args[2]); err == nil {
+		new_oracle = contract.Address
+	} else {
+		new_oracle, err = tezos.ParseAddress(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse new oracle %v: %v\n", args[2], err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+
+	operation_hash, err := x4c.FA2UpdateOracle(ctx, writeClient(client), contract, oracle, new_oracle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update oracle: %v\n", err)
+		return 1
+	}
+
+	return reportOperation(ctx, client, operation_hash)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/index"
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// Commands that read contract state should pass their client through this, so that if
+// there is a local index the contracts in it are read from there rather than tzkt.
+func readClient(client tzclient.TezosClient) (tzclient.TezosClient, error) {
+	path := os.Getenv("X4C_INDEX_STORE")
+	if path == "" {
+		return client, nil
+	}
+	store, err := index.LoadStore(path)
+	if err != nil {
+		return nil, err
+	}
+	index_client := index.NewClient(client, store)
+	if raw_max_lag := os.Getenv("X4C_INDEX_MAX_LAG"); raw_max_lag != "" {
+		max_lag, err := time.ParseDuration(raw_max_lag)
+		if err != nil || max_lag < 0 {
+			return nil, fmt.Errorf("invalid X4C_INDEX_MAX_LAG %q", raw_max_lag)
+		}
+		index_client.MaxLag = max_lag
+	}
+	return index_client, nil
+}
+
+type indexSyncCommand struct{}
+
+func NewIndexSyncCommand() (cli.Command, error) {
+	return indexSyncCommand{}, nil
+}
+
+func (c indexSyncCommand) Help() string {
+	return `usage: x4cli index sync [-store PATH] CONTRACT [CONTRACT...]
+
+Brings the local index up to date with the storage, big maps, and events of each of
+the listed contracts, fetching only what has changed since the last sync. The index is
+kept in the file given by -store, which defaults to the X4C_INDEX_STORE environmental
+variable. Other commands read from the index when X4C_INDEX_STORE is set.`
+}
+
+func (c indexSyncCommand) Synopsis() string {
+	return "Updates the local index of contract state and events."
+}
+
+func (c indexSyncCommand) Run(rawargs []string) int {
+
+	var store_path string
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	flags.StringVar(&store_path, "store", os.Getenv("X4C_INDEX_STORE"), "file to keep the index in")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+	if store_path == "" {
+		fmt.Fprintf(os.Stderr, "No index store specified (use -store or env var X4C_INDEX_STORE)\n")
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	addresses := make([]string, len(args))
+	for index, name := range args {
+		contract, err := client.ContractByName(name)
+		if err != nil {
+			contract, err = tzclient.NewContractWithAddress(name, name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Contract address '%s' is not valid: %v\n", name, err)
+				return 1
+			}
+		}
+		addresses[index] = contract.Address.String()
+	}
+
+	store, err := index.LoadStore(store_path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+	source, err := index.NewSource(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to make index source: %v\n", err)
+		return 1
+	}
+
+	err = index.NewIndexer(source, store, addresses).Sync(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to sync index: %v\n", err)
+		return 1
+	}
+
+	for _, address := range addresses {
+		fmt.Printf("%s indexed to level %d\n", client.FindNameForAddress(address), store.LastLevel(address))
+	}
+	return 0
+}
@@ -5,8 +5,6 @@ import (
 	"os"
 
 	"github.com/mitchellh/cli"
-
-	"quantify.earth/x4c/pkg/tzclient"
 )
 
 type infoCommand struct{}
@@ -25,7 +23,7 @@ func (c infoCommand) Synopsis() string {
 
 func (c infoCommand) Run(args []string) int {
 	// at some point we could take the location as an optional arg...
-	client, err := tzclient.LoadDefaultClient()
+	client, err := loadClient()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
 		return 1
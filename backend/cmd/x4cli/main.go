@@ -9,26 +9,73 @@ import (
 	"github.com/mitchellh/cli"
 )
 
+// Global options go before the command, so that they can't be mistaken for one of the
+// command's own arguments. They end at the first argument that isn't one, or at "--".
+func extractGlobalOptions(args []string) ([]string, error) {
+	for index := 0; index < len(args); {
+		if args[index] == "--" {
+			return args[index+1:], nil
+		}
+		used, err := parseNetworkOption(args[index:])
+		if err == nil && used == 0 {
+			used, err = parseWriteOption(args[index:])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if used == 0 {
+			return args[index:], nil
+		}
+		index += used
+	}
+	return []string{}, nil
+}
+
 func main() {
 	log.SetLevel(log.LevelError)
 
 	c := cli.NewCLI("x4cli", "0.0.1")
-	c.Args = os.Args[1:]
+	args, err := extractGlobalOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	c.Args = args
 	c.Commands = map[string]cli.CommandFactory{
 		"info": NewInfoCommand,
 
-		"fa2 info":      NewFA2InfoCommand,
-		"fa2 originate": NewFA2OriginateCommand,
-		"fa2 add_token": NewAddTokenCommand,
-		"fa2 mint":      NewFA2MintCommand,
+		"op wait": NewOpWaitCommand,
+
+		"index sync": NewIndexSyncCommand,
+
+		"retire certificate": NewRetireCertificateCommand,
+
+		"fa2 info":                     NewFA2InfoCommand,
+		"fa2 originate":                NewFA2OriginateCommand,
+		"fa2 add_token":                NewAddTokenCommand,
+		"fa2 mint":                     NewFA2MintCommand,
+		"fa2 update_oracle":            NewFA2UpdateOracleCommand,
+		"fa2 transfer":                 NewFA2TransferCommand,
+		"fa2 retire":                   NewFA2RetireCommand,
+		"fa2 add_operator":             NewFA2AddOperatorCommand,
+		"fa2 remove_operator":          NewFA2RemoveOperatorCommand,
+		"fa2 balance_of":               NewFA2BalanceOfCommand,
+		"fa2 update_contract_metadata": NewFA2UpdateContractMetadataCommand,
+		"fa2 publish_metadata":         NewFA2PublishMetadataCommand,
+		"fa2 verify_metadata":          NewVerifyMetadataCommand,
 
 		"custodian info":              NewCustodianInfoCommand,
+		"custodian statement":         NewCustodianStatementCommand,
+		"custodian reconcile":         NewCustodianReconcileCommand,
 		"custodian originate":         NewCustodianOriginateCommand,
 		"custodian internal_mint":     NewCustodianInternalMintCommand,
 		"custodian internal_transfer": NewCustodianInternalTransferCommand,
+		"custodian external_transfer": NewCustodianExternalTransferCommand,
 		"custodian add_operator":      NewCustodianAddOperatorCommand,
 		"custodian remove_operator":   NewCustodianRemoveOperatorCommand,
 		"custodian retire":            NewCustodianRetireCommand,
+		"custodian update_custodian":  NewCustodianUpdateCustodianCommand,
+		"custodian verify_metadata":   NewVerifyMetadataCommand,
 	}
 
 	exit_status, err := c.Run()
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// The network profile chosen with the global -network option.
+var globalNetwork string
+
+// Returns how many of the arguments were taken by a -network option at their start,
+// which is none if they don't start with one.
+func parseNetworkOption(args []string) (int, error) {
+	arg := args[0]
+	switch {
+	case arg == "-network" || arg == "--network":
+		if len(args) < 2 {
+			return 0, fmt.Errorf("%s requires a network name", arg)
+		}
+		globalNetwork = args[1]
+		return 2, nil
+	case strings.HasPrefix(arg, "-network=") || strings.HasPrefix(arg, "--network="):
+		_, globalNetwork, _ = strings.Cut(arg, "=")
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Commands should load their client through this so that -network is honoured.
+func loadClient() (tzclient.Client, error) {
+	return tzclient.LoadDefaultClientForNetwork(globalNetwork)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/cli"
+)
+
+type opWaitCommand struct{}
+
+func NewOpWaitCommand() (cli.Command, error) {
+	return opWaitCommand{}, nil
+}
+
+func (c opWaitCommand) Help() string {
+	return `usage: x4cli op wait [-confirmations N] HASH
+
+Waits for an operation to be included on chain and to have the requested number of
+confirmations, where the block containing the operation counts as the first, and then
+shows whether it was applied along with the gas and fees it used. Exits with a non-zero
+status if the operation failed or was never included.`
+}
+
+func (c opWaitCommand) Synopsis() string {
+	return "Waits for an operation to be confirmed and shows its status."
+}
+
+func (c opWaitCommand) Run(rawargs []string) int {
+
+	var confirmations int64
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	flags.Int64Var(&confirmations, "confirmations", 1, "number of confirmations to wait for")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Incorrect number of arguments.\n\n%s", c.Help())
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	return waitForOperation(ctx, client, args[0], confirmations)
+}
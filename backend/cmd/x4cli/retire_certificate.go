@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type retireCertificateCommand struct{}
+
+func NewRetireCertificateCommand() (cli.Command, error) {
+	return retireCertificateCommand{}, nil
+}
+
+func (c retireCertificateCommand) Help() string {
+	return `usage: x4cli retire certificate [-signer WALLET] [-format pdf|html|json] HASH
+
+Checks that the operation with the given hash was applied and writes a certificate
+for the retirements it made on known contracts to stdout, signed with the secret key of the given wallet,
+or of the network profile's default signer if none is given. The certificate includes
+the retiring party, the KYC for custodian retirements, the token's title and URL from
+the FA2 contract, the amount, the reason, and where to find the operation on the
+indexer. The default format is pdf.`
+}
+
+func (c retireCertificateCommand) Synopsis() string {
+	return "Writes a signed certificate for the retirements in an operation."
+}
+
+func (c retireCertificateCommand) Run(rawargs []string) int {
+
+	var signer_name, format string
+	flags := flag.NewFlagSet("certificate", flag.ExitOnError)
+	flags.StringVar(&signer_name, "signer", "", "wallet to sign the certificate with")
+	flags.StringVar(&format, "format", "pdf", "output format: pdf, html, or json")
+	flags.Parse(rawargs)
+	args := flags.Args()
+
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Expected an operation hash\n")
+		return 1
+	}
+	if format != "pdf" && format != "html" && format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format '%s'\n", format)
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load client: %v.\n", err)
+		return 1
+	}
+
+	if signer_name == "" {
+		signer_name = client.DefaultSigner
+	}
+	if signer_name == "" {
+		fmt.Fprintf(os.Stderr, "Expected a wallet to sign with\n")
+		return 1
+	}
+
+	signer, ok := client.Wallets[signer_name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Wallet '%s' not found\n", signer_name)
+		return 1
+	}
+	if !signer.HasSecretKey() {
+		fmt.Fprintf(os.Stderr, "Wallet '%s' has no secret key to sign with\n", signer_name)
+		return 1
+	}
+
+	reader, err := readClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+
+	// Only retirements on contracts we know by name are certified, as anyone can make a
+	// contract that emits retire events
+	contracts := make([]tzclient.Contract, 0, len(client.Contracts))
+	for _, contract := range client.Contracts {
+		contracts = append(contracts, contract)
+	}
+	certificate, err := x4c.GenerateCertificate(context.Background(), reader, contracts, args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate certificate: %v\n", err)
+		return 1
+	}
+	key, err := signer.SecretKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to unlock signing key: %v\n", err)
+		return 1
+	}
+	err = certificate.Sign(*key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to sign certificate: %v\n", err)
+		return 1
+	}
+
+	switch format {
+	case "json":
+		var data []byte
+		data, err = json.Marshal(certificate)
+		if err == nil {
+			fmt.Println(string(data))
+		}
+	case "html":
+		err = certificate.WriteHTML(os.Stdout)
+	default:
+		err = certificate.WritePDF(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write certificate: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/x4c"
+)
+
+type verifyMetadataCommand struct{}
+
+func NewVerifyMetadataCommand() (cli.Command, error) {
+	return verifyMetadataCommand{}, nil
+}
+
+func (c verifyMetadataCommand) Help() string {
+	return `usage: x4cli fa2 verify_metadata CONTRACT
+       x4cli custodian verify_metadata CONTRACT
+
+Fetches the contract's TZIP-16 metadata, following tezos-storage, http(s), and sha256
+URIs and checking any pinned hash, and validates it against the TZIP-16 schema. Exits
+with status 2 if the metadata is found but is not valid.`
+}
+
+func (c verifyMetadataCommand) Synopsis() string {
+	return "Checks a contract's TZIP-16 metadata."
+}
+
+func (c verifyMetadataCommand) Run(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Expected a contract name or address\n")
+		return 1
+	}
+
+	client, err := loadClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find info: %v.\n", err)
+		return 1
+	}
+
+	contract, err := client.ContractByName(args[0])
+	if err != nil {
+		contract, err = tzclient.NewContractWithAddress(args[0], args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Contract address is not valid: %v\n", err)
+			return 1
+		}
+	}
+
+	reader, err := readClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load index: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	http_client := &http.Client{Timeout: 30 * time.Second}
+	metadata, err := x4c.VerifyContractMetadata(ctx, reader, http_client, contract)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		var validation_err x4c.ContractMetadataValidationError
+		if errors.As(err, &validation_err) {
+			return 2
+		}
+		return 1
+	}
+
+	fmt.Printf("Metadata is valid.\n")
+	fmt.Printf("Name: %s\n", metadata.Name)
+	if metadata.Version != "" {
+		fmt.Printf("Version: %s\n", metadata.Version)
+	}
+	fmt.Printf("Interfaces: %v\n", metadata.Interfaces)
+	fmt.Printf("Views: %d, errors: %d\n", len(metadata.Views), len(metadata.Errors))
+	return 0
+}
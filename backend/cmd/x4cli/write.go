@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// Options that apply to every command that writes to the chain. Like -network these
+// are global options, so go before the command.
+type writeOptions struct {
+	DryRun bool
+
+	// If -wait was given then contract calls return as soon as the node has accepted
+	// the operation, and we then wait for Wait confirmations, where zero means not to
+	// wait at all. Otherwise the client waits for its default number of confirmations
+	// before returning.
+	WaitSet bool
+	Wait    int64
+}
+
+var globalWriteOptions writeOptions
+
+// Set when writeClient has wrapped the client for a dry run, so that the results
+// can be reported.
+var dryRunClient *tzclient.DryRunClient
+
+// Returns how many of the arguments were taken by a write option at their start,
+// which is none if they don't start with one.
+func parseWriteOption(args []string) (int, error) {
+	arg := args[0]
+	switch {
+	case arg == "-dry-run" || arg == "--dry-run":
+		globalWriteOptions.DryRun = true
+		return 1, nil
+	case arg == "-wait" || arg == "--wait":
+		if len(args) < 2 {
+			return 0, fmt.Errorf("%s requires a number of confirmations", arg)
+		}
+		return 2, parseWaitOption(args[1])
+	case strings.HasPrefix(arg, "-wait=") || strings.HasPrefix(arg, "--wait="):
+		_, value, _ := strings.Cut(arg, "=")
+		return 1, parseWaitOption(value)
+	}
+	return 0, nil
+}
+
+func parseWaitOption(value string) error {
+	confirmations, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || confirmations < 0 {
+		return fmt.Errorf("invalid number of confirmations to wait for: %q", value)
+	}
+	globalWriteOptions.WaitSet = true
+	globalWriteOptions.Wait = confirmations
+	return nil
+}
+
+// Commands that change chain state should pass their client through this so that
+// the global write options are honoured.
+func writeClient(client tzclient.TezosClient) tzclient.TezosClient {
+	if globalWriteOptions.DryRun {
+		if dryRunClient == nil {
+			dryRunClient = tzclient.NewDryRunClient(client)
+		}
+		return dryRunClient
+	}
+	if globalWriteOptions.WaitSet {
+		return tzclient.NewInjectingClient(client)
+	}
+	return client
+}
+
+func printSimulation(result tzclient.SimulationResult) {
+	fmt.Printf("\tGas used:        %d\n", result.GasUsed)
+	fmt.Printf("\tStorage used:    %d bytes\n", result.StorageUsed)
+	fmt.Printf("\tStorage burn:    %d mutez\n", result.StorageBurn)
+	fmt.Printf("\tAllocation burn: %d mutez\n", result.AllocationBurn)
+	fmt.Printf("\tEstimated fee:   %d mutez\n", result.Fee)
+	for _, event := range result.Events {
+		fmt.Printf("\tEvent %s from %s: %s\n", event.Tag, event.Source, event.Payload.Dump())
+	}
+}
+
+func printOperationStatus(status tzclient.OperationStatus) {
+	fmt.Printf("\tStatus:          %s\n", status.Status)
+	fmt.Printf("\tBlock:           %s (level %d)\n", status.Block, status.Level)
+	fmt.Printf("\tConfirmations:   %d\n", status.Confirmations)
+	fmt.Printf("\tGas used:        %d\n", status.GasUsed)
+	fmt.Printf("\tStorage used:    %d bytes\n", status.StorageUsed)
+	fmt.Printf("\tBaker fee:       %d mutez\n", status.BakerFee)
+	fmt.Printf("\tStorage fee:     %d mutez\n", status.StorageFee)
+	fmt.Printf("\tAllocation fee:  %d mutez\n", status.AllocationFee)
+	for _, operation_error := range status.Errors {
+		fmt.Printf("\tError:           %s\n", operation_error)
+	}
+}
+
+// Waits for the operation and prints the outcome, returning a non-zero exit status if
+// the operation didn't make it on chain.
+func waitForOperation(ctx context.Context, client tzclient.TezosClient, operation_hash string, confirmations int64) int {
+	fmt.Printf("Waiting for %d confirmations of %s...\n", confirmations, operation_hash)
+	status, err := client.WaitForConfirmation(ctx, operation_hash, confirmations)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to wait for operation: %v\n", err)
+		return 1
+	}
+	printOperationStatus(status)
+	if !status.IsApplied() {
+		fmt.Fprintf(os.Stderr, "Operation %s was %s.\n", operation_hash, status.Status)
+		return 1
+	}
+	return 0
+}
+
+func reportOperation(ctx context.Context, client tzclient.TezosClient, operation_hash string) int {
+	if dryRunClient != nil {
+		fmt.Printf("Dry run succeeded, operation was not injected.\n")
+		for _, result := range dryRunClient.Simulations {
+			printSimulation(result)
+		}
+		return 0
+	}
+
+	if !globalWriteOptions.WaitSet {
+		fmt.Printf("Operation %s was applied\n", operation_hash)
+		return 0
+	}
+	fmt.Printf("Submitted operation successfully as %s\n", operation_hash)
+	if globalWriteOptions.Wait > 0 {
+		return waitForOperation(ctx, client, operation_hash, globalWriteOptions.Wait)
+	}
+	return 0
+}
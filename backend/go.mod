@@ -8,6 +8,9 @@ require (
 	github.com/echa/log v1.2.2
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mitchellh/cli v1.1.4
+	golang.org/x/crypto v0.0.0-20221012134737-56aed061732a
+	golang.org/x/term v0.0.0-20220722155259-a9ba230a4035
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -32,6 +35,5 @@ require (
 	github.com/posener/complete v1.2.3 // indirect
 	github.com/shopspring/decimal v1.3.1 // indirect
 	github.com/spf13/cast v1.5.0 // indirect
-	golang.org/x/crypto v0.0.0-20221012134737-56aed061732a // indirect
 	golang.org/x/sys v0.0.0-20221010170243-090e33056c14 // indirect
 )
@@ -0,0 +1,70 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// Client wraps another client so that storage, big maps, and events for indexed
+// contracts are read from the local store rather than from tzkt. Anything that hasn't
+// been indexed, and all writes, go to the wrapped client as normal.
+type Client struct {
+	tzclient.TezosClient
+	store *Store
+
+	// If set, contracts that haven't been synced for longer than this are read from
+	// the wrapped client, so that if the indexer stops we don't carry on serving
+	// ever older state.
+	MaxLag time.Duration
+}
+
+func NewClient(client tzclient.TezosClient, store *Store) *Client {
+	return &Client{
+		TezosClient: client,
+		store:       store,
+	}
+}
+
+// Returns true if the contract was synced recently enough to read from the store.
+func (c *Client) isCurrent(address string) bool {
+	if c.MaxLag == 0 {
+		return true
+	}
+	synced := c.store.Synced(address)
+	return !synced.IsZero() && time.Since(synced) <= c.MaxLag
+}
+
+func (c *Client) GetContractStorage(target tzclient.Contract, ctx context.Context, storage interface{}) error {
+	raw, ok := c.store.Storage(target.Address.String())
+	if !ok || !c.isCurrent(target.Address.String()) {
+		return c.TezosClient.GetContractStorage(target, ctx, storage)
+	}
+	err := json.Unmarshal(raw, storage)
+	if err != nil {
+		return fmt.Errorf("failed to decode indexed storage: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error) {
+	if address, ok := c.store.bigMapContract(identifier); ok && c.isCurrent(address) {
+		if items, ok := c.store.BigMap(identifier, options); ok {
+			return items, nil
+		}
+	}
+	return c.TezosClient.GetBigMapContents(ctx, identifier, options)
+}
+
+func (c *Client) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	if c.isCurrent(contractAddress) {
+		if events, ok := c.store.Events(contractAddress, tag, options); ok {
+			return events, nil
+		}
+	}
+	return c.TezosClient.GetContractEvents(ctx, contractAddress, tag, options)
+}
@@ -0,0 +1,562 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+const testContract = "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"
+
+// A chain with a single contract, which tests change by setting the head and adding
+// keys and events at a level. Hashes for each level can be overridden to fake a reorg.
+type fakeSource struct {
+	Head         tzkt.Head
+	BlockHashes  map[int32]string
+	Keys         []tzkt.BigMapItem
+	Events       []tzkt.Event
+	ShouldFail   bool
+	KeyRequests  []int32
+	FullRequests int
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		BlockHashes: make(map[int32]string),
+	}
+}
+
+func (s *fakeSource) setHead(level int32) {
+	hash := fmt.Sprintf("block%d", level)
+	s.Head = tzkt.Head{Level: level, Hash: hash}
+	s.BlockHashes[level] = hash
+}
+
+func (s *fakeSource) GetHead(ctx context.Context) (tzkt.Head, error) {
+	if s.ShouldFail {
+		return tzkt.Head{}, fmt.Errorf("Test should fail")
+	}
+	return s.Head, nil
+}
+
+func (s *fakeSource) GetBlock(ctx context.Context, level int32) (tzkt.Block, error) {
+	return tzkt.Block{Level: level, Hash: s.BlockHashes[level]}, nil
+}
+
+func (s *fakeSource) GetContractStorage(ctx context.Context, contractAddress string, storage interface{}) error {
+	return json.Unmarshal([]byte(`{"custodian": "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5", "ledger": 12}`), storage)
+}
+
+func (s *fakeSource) GetContractBigMaps(ctx context.Context, contractAddress string) ([]tzkt.ContractBigMap, error) {
+	return []tzkt.ContractBigMap{{Pointer: 12, Path: "ledger", Active: true}}, nil
+}
+
+func (s *fakeSource) GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error) {
+	s.KeyRequests = append(s.KeyRequests, options.MinLevel)
+	if options.MinLevel <= 1 {
+		s.FullRequests += 1
+	}
+	results := make([]tzkt.BigMapItem, 0)
+	for _, key := range s.Keys {
+		if options.MatchesBigMapItem(key) {
+			results = append(results, key)
+		}
+	}
+	return results, nil
+}
+
+func (s *fakeSource) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	results := make([]tzkt.Event, 0)
+	for _, event := range s.Events {
+		if options.MatchesEvent(event) {
+			results = append(results, event)
+		}
+	}
+	return results, nil
+}
+
+func (s *fakeSource) setKey(id int64, hash string, value int64, level int32) {
+	item := tzkt.BigMapItem{
+		Identifier: id,
+		Active:     true,
+		Hash:       hash,
+		Key:        json.RawMessage(fmt.Sprintf(`"%s"`, hash)),
+		Value:      json.RawMessage(fmt.Sprintf(`"%d"`, value)),
+		LastLevel:  int64(level),
+	}
+	for index, key := range s.Keys {
+		if key.Hash == hash {
+			s.Keys[index] = item
+			return
+		}
+	}
+	s.Keys = append(s.Keys, item)
+}
+
+func (s *fakeSource) addEvent(id int64, tag string, level int32) {
+	s.Events = append(s.Events, tzkt.Event{
+		Identifier: id,
+		Level:      level,
+		Tag:        tag,
+		Payload:    json.RawMessage(`{}`),
+	})
+}
+
+func TestIncrementalSync(t *testing.T) {
+	source := newFakeSource()
+	store := NewMemoryStore()
+	indexer := NewIndexer(source, store, []string{testContract})
+	ctx := context.Background()
+
+	source.setKey(1, "a", 10, 5)
+	source.addEvent(1, "internal_mint", 5)
+	source.setHead(6)
+	err := indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if store.LastLevel(testContract) != 6 {
+		t.Errorf("Expected last level 6, got %d", store.LastLevel(testContract))
+	}
+
+	source.setKey(1, "a", 7, 8)
+	source.setKey(2, "b", 3, 8)
+	source.addEvent(2, "retire", 8)
+	source.addEvent(3, "internal_mint", 8)
+	source.setHead(9)
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(source.KeyRequests) != 2 || source.KeyRequests[1] != 7 {
+		t.Errorf("Expected second sync to only ask for changes since 6, got %v", source.KeyRequests)
+	}
+	items, ok := store.BigMap(12, tzkt.QueryOptions{})
+	if !ok {
+		t.Fatalf("Expected big map to be indexed")
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %v", items)
+	}
+	if string(items[0].Value) != `"7"` {
+		t.Errorf("Expected updated value, got %s", items[0].Value)
+	}
+	mints, ok := store.Events(testContract, "internal_mint", tzkt.QueryOptions{})
+	if !ok || len(mints) != 2 {
+		t.Errorf("Expected 2 mint events, got %v", mints)
+	}
+	retires, ok := store.Events(testContract, "retire", tzkt.QueryOptions{})
+	if !ok || len(retires) != 1 {
+		t.Errorf("Expected 1 retire event, got %v", retires)
+	}
+}
+
+func TestSyncStopsAtHead(t *testing.T) {
+	source := newFakeSource()
+	store := NewMemoryStore()
+	indexer := NewIndexer(source, store, []string{testContract})
+	ctx := context.Background()
+
+	// The key and event are both after the head we sync to, so neither is taken until
+	// the head has caught up with them
+	source.setKey(1, "a", 10, 5)
+	source.setKey(2, "b", 20, 7)
+	source.addEvent(1, "retire", 7)
+	source.setHead(6)
+	err := indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	items, _ := store.BigMap(12, tzkt.QueryOptions{})
+	if len(items) != 1 || items[0].Hash != "a" {
+		t.Errorf("Expected only the key from before the head, got %v", items)
+	}
+	events, _ := store.Events(testContract, "", tzkt.QueryOptions{})
+	if len(events) != 0 {
+		t.Errorf("Expected no events, got %v", events)
+	}
+
+	source.setHead(7)
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	items, _ = store.BigMap(12, tzkt.QueryOptions{})
+	if len(items) != 2 {
+		t.Errorf("Expected both keys, got %v", items)
+	}
+	events, _ = store.Events(testContract, "", tzkt.QueryOptions{})
+	if len(events) != 1 {
+		t.Errorf("Expected the event, got %v", events)
+	}
+}
+
+func TestSyncAfterReorg(t *testing.T) {
+	source := newFakeSource()
+	store := NewMemoryStore()
+	indexer := NewIndexer(source, store, []string{testContract})
+	ctx := context.Background()
+
+	source.setKey(1, "a", 10, 5)
+	source.addEvent(1, "retire", 5)
+	source.setHead(5)
+	err := indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Block 5 is orphaned, and the retire never happened
+	source.Events = nil
+	source.Keys = nil
+	source.setKey(1, "a", 20, 4)
+	source.BlockHashes[5] = "otherblock5"
+	source.setHead(6)
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if source.FullRequests != 2 {
+		t.Errorf("Expected contract to be reindexed from scratch, got requests %v", source.KeyRequests)
+	}
+	events, _ := store.Events(testContract, "retire", tzkt.QueryOptions{})
+	if len(events) != 0 {
+		t.Errorf("Expected orphaned event to be dropped, got %v", events)
+	}
+	items, _ := store.BigMap(12, tzkt.QueryOptions{})
+	if len(items) != 1 || string(items[0].Value) != `"20"` {
+		t.Errorf("Expected value from new chain, got %v", items)
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	store, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load empty store: %v", err)
+	}
+
+	source := newFakeSource()
+	source.setKey(1, "a", 10, 5)
+	source.addEvent(1, "retire", 5)
+	source.setHead(5)
+	err = NewIndexer(source, store, []string{testContract}).Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reloaded, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	if reloaded.LastLevel(testContract) != 5 {
+		t.Errorf("Expected last level 5, got %d", reloaded.LastLevel(testContract))
+	}
+	items, ok := reloaded.BigMap(12, tzkt.QueryOptions{})
+	if !ok || len(items) != 1 {
+		t.Errorf("Expected big map to be reloaded, got %v", items)
+	}
+}
+
+func TestStoreAppendsSyncs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	store, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load empty store: %v", err)
+	}
+	source := newFakeSource()
+	indexer := NewIndexer(source, store, []string{testContract})
+	ctx := context.Background()
+
+	source.setKey(1, "a", 10, 5)
+	source.addEvent(1, "retire", 5)
+	source.setHead(5)
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first, _ := os.ReadFile(path)
+
+	// Later syncs are added to the end of the file rather than rewriting it
+	source.setKey(1, "a", 7, 6)
+	source.setKey(2, "b", 3, 6)
+	source.addEvent(2, "retire", 6)
+	source.setHead(6)
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(second, first) || len(second) == len(first) {
+		t.Errorf("Expected second sync to be appended to the store")
+	}
+
+	// Including after a reorg, which the reload has to replay correctly
+	source.Events = nil
+	source.Keys = nil
+	source.setKey(1, "a", 20, 6)
+	source.BlockHashes[6] = "otherblock6"
+	source.setHead(7)
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reloaded, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	if reloaded.LastLevel(testContract) != 7 {
+		t.Errorf("Expected last level 7, got %d", reloaded.LastLevel(testContract))
+	}
+	items, _ := reloaded.BigMap(12, tzkt.QueryOptions{})
+	if len(items) != 1 || string(items[0].Value) != `"20"` {
+		t.Errorf("Expected value from new chain, got %v", items)
+	}
+	events, _ := reloaded.Events(testContract, "", tzkt.QueryOptions{})
+	if len(events) != 0 {
+		t.Errorf("Expected orphaned events to be dropped, got %v", events)
+	}
+
+	// A sync we stopped part way through writing is dropped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"address": "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm", "lastLevel": 8, "big`)
+	file.Close()
+	reloaded, err = LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload store with incomplete record: %v", err)
+	}
+	if reloaded.LastLevel(testContract) != 7 {
+		t.Errorf("Expected last level 7, got %d", reloaded.LastLevel(testContract))
+	}
+	reloaded, err = LoadStore(path)
+	if err != nil || reloaded.LastLevel(testContract) != 7 {
+		t.Errorf("Expected incomplete record to have been removed, got %d: %v", reloaded.LastLevel(testContract), err)
+	}
+}
+
+func TestStoreCompacts(t *testing.T) {
+	original := minCompactionSize
+	minCompactionSize = 0
+	t.Cleanup(func() {
+		minCompactionSize = original
+	})
+
+	path := filepath.Join(t.TempDir(), "index.json")
+	store, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load empty store: %v", err)
+	}
+	source := newFakeSource()
+	indexer := NewIndexer(source, store, []string{testContract})
+	for level := int32(5); level < 10; level++ {
+		source.setKey(int64(level), fmt.Sprintf("key%d", level), int64(level), level)
+		source.addEvent(int64(level), "retire", level)
+		source.setHead(level)
+		err = indexer.Sync(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Once the appended records outgrow the state the file is rewritten with a
+	// record per contract
+	content, _ := os.ReadFile(path)
+	lines := bytes.Count(content, []byte("\n"))
+	if lines >= 5 || !bytes.HasPrefix(content, []byte(`{"address":"KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm","reset":true`)) {
+		t.Errorf("Expected store to have been compacted, got %d records: %s", lines, content)
+	}
+	reloaded, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload store: %v", err)
+	}
+	items, _ := reloaded.BigMap(12, tzkt.QueryOptions{})
+	events, _ := reloaded.Events(testContract, "", tzkt.QueryOptions{})
+	if reloaded.LastLevel(testContract) != 9 || len(items) != 5 || len(events) != 5 {
+		t.Errorf("Unexpected store after compaction at level %d: %v and %v", reloaded.LastLevel(testContract), items, events)
+	}
+}
+
+func TestLoadStoreFromSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	err := os.WriteFile(path, []byte(`[{
+		"address": "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm",
+		"lastLevel": 5,
+		"lastHash": "block5",
+		"storage": {"ledger": 12},
+		"bigmaps": {"12": {"a": {"id": 1, "active": true, "hash": "a", "key": "a", "value": "10", "lastLevel": 5}}},
+		"events": [{"id": 1, "level": 5, "tag": "retire", "payload": {}}]
+	}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, description := range []string{"original", "converted"} {
+		store, err := LoadStore(path)
+		if err != nil {
+			t.Fatalf("Failed to load %s store: %v", description, err)
+		}
+		if store.LastLevel(testContract) != 5 {
+			t.Errorf("Expected last level 5 from %s store, got %d", description, store.LastLevel(testContract))
+		}
+		items, _ := store.BigMap(12, tzkt.QueryOptions{})
+		events, _ := store.Events(testContract, "retire", tzkt.QueryOptions{})
+		if len(items) != 1 || len(events) != 1 {
+			t.Errorf("Expected contents of %s store, got %v and %v", description, items, events)
+		}
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	source := newFakeSource()
+	source.ShouldFail = true
+	store := NewMemoryStore()
+	err := NewIndexer(source, store, []string{testContract}).Sync(context.Background())
+	if err == nil {
+		t.Errorf("Expected error")
+	}
+	if _, ok := store.Storage(testContract); ok {
+		t.Errorf("Expected nothing to be indexed")
+	}
+}
+
+func TestNewSourceFollowsReader(t *testing.T) {
+	testcases := []struct {
+		Reader      string
+		ExpectNode  bool
+		ExpectError bool
+	}{
+		{Reader: "", ExpectNode: false},
+		{Reader: "tzkt", ExpectNode: false},
+		{Reader: "node", ExpectNode: true},
+		{Reader: "carrier-pigeon", ExpectError: true},
+	}
+	for idx, testcase := range testcases {
+		client := tzclient.Client{
+			RPCURL:        "http://localhost:8732",
+			IndexerRPCURL: "http://localhost:5000",
+			Reader:        testcase.Reader,
+			FirstLevel:    10,
+		}
+		source, err := NewSource(client)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error", idx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", idx, err)
+			continue
+		}
+		reader, is_node := source.(*tzclient.NodeReader)
+		if is_node != testcase.ExpectNode {
+			t.Errorf("%d: Expected node source %v, got %T", idx, testcase.ExpectNode, source)
+		}
+		if is_node && reader.FirstLevel != 10 {
+			t.Errorf("%d: Expected node source to start at level 10, got %d", idx, reader.FirstLevel)
+		}
+	}
+}
+
+func TestClientReadsFromIndex(t *testing.T) {
+	source := newFakeSource()
+	source.setKey(1, "a", 10, 5)
+	source.addEvent(1, "retire", 5)
+	source.setHead(5)
+	store := NewMemoryStore()
+	err := NewIndexer(source, store, []string{testContract}).Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The mock has nothing in it, so anything we get must have come from the index
+	mock := tzclient.NewMockClient()
+	client := NewClient(mock, store)
+	ctx := context.Background()
+
+	contract, err := tzclient.NewContractWithAddress("test", testContract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var storage struct {
+		Ledger int64 `json:"ledger"`
+	}
+	err = client.GetContractStorage(contract, ctx, &storage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if storage.Ledger != 12 {
+		t.Errorf("Expected ledger 12, got %d", storage.Ledger)
+	}
+
+	items, err := client.GetBigMapContents(ctx, 12, tzkt.QueryOptions{})
+	if err != nil || len(items) != 1 {
+		t.Errorf("Expected 1 item, got %v: %v", items, err)
+	}
+	items, err = client.GetBigMapContents(ctx, 99, tzkt.QueryOptions{})
+	if err != nil || len(items) != 0 {
+		t.Errorf("Expected unindexed big map to come from the wrapped client, got %v: %v", items, err)
+	}
+
+	events, err := client.GetContractEvents(ctx, testContract, "retire", tzkt.QueryOptions{})
+	if err != nil || len(events) != 1 {
+		t.Errorf("Expected 1 event, got %v: %v", events, err)
+	}
+}
+
+func TestClientFallsBackWhenStale(t *testing.T) {
+	source := newFakeSource()
+	source.setKey(1, "a", 10, 5)
+	source.addEvent(1, "retire", 5)
+	source.setHead(5)
+	store := NewMemoryStore()
+	indexer := NewIndexer(source, store, []string{testContract})
+	err := indexer.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mock := tzclient.NewMockClient()
+	client := NewClient(mock, store)
+	client.MaxLag = time.Minute
+	ctx := context.Background()
+
+	// Just synced, so the index is used
+	items, _ := client.GetBigMapContents(ctx, 12, tzkt.QueryOptions{})
+	events, _ := client.GetContractEvents(ctx, testContract, "retire", tzkt.QueryOptions{})
+	if len(items) != 1 || len(events) != 1 {
+		t.Errorf("Expected reads from the index, got %v and %v", items, events)
+	}
+
+	// The indexer stopped an hour ago, so we go to the chain, which here is the empty
+	// mock
+	store.markSynced(testContract, time.Now().Add(-time.Hour))
+	items, _ = client.GetBigMapContents(ctx, 12, tzkt.QueryOptions{})
+	events, _ = client.GetContractEvents(ctx, testContract, "retire", tzkt.QueryOptions{})
+	if len(items) != 0 || len(events) != 0 {
+		t.Errorf("Expected stale index to be skipped, got %v and %v", items, events)
+	}
+
+	// A sync that finds nothing new still counts as being up to date
+	err = indexer.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	items, _ = client.GetBigMapContents(ctx, 12, tzkt.QueryOptions{})
+	if len(items) != 1 {
+		t.Errorf("Expected reads from the index after syncing, got %v", items)
+	}
+}
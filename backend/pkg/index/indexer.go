@@ -0,0 +1,180 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// The parts of tzkt the indexer needs, so that we can test without a real indexer and
+// sync from a node reader on networks without one.
+type Source interface {
+	GetHead(ctx context.Context) (tzkt.Head, error)
+	GetBlock(ctx context.Context, level int32) (tzkt.Block, error)
+	GetContractStorage(ctx context.Context, contractAddress string, storage interface{}) error
+	GetContractBigMaps(ctx context.Context, contractAddress string) ([]tzkt.ContractBigMap, error)
+	GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error)
+	GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error)
+}
+
+// NewSource makes a source that reads from wherever the client reads chain state, so
+// that a network without tzkt is indexed from its node.
+func NewSource(client tzclient.Client) (Source, error) {
+	switch client.Reader {
+	case "", "tzkt":
+		indexer, err := tzkt.NewClient(client.IndexerRPCURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make indexer: %w", err)
+		}
+		return &indexer, nil
+	case "node":
+		reader, err := tzclient.NewNodeReader(client.RPCURL, client.FirstLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make node reader: %w", err)
+		}
+		return reader, nil
+	default:
+		return nil, fmt.Errorf("unknown reader %s, expected tzkt or node", client.Reader)
+	}
+}
+
+// Indexer brings the store up to date with the chain for a set of contracts. Each sync
+// only asks tzkt for what has changed since the last level it processed, so once a
+// contract has been indexed keeping up with it is cheap.
+type Indexer struct {
+	source    Source
+	store     *Store
+	contracts []string
+}
+
+func NewIndexer(source Source, store *Store, contracts []string) *Indexer {
+	return &Indexer{
+		source:    source,
+		store:     store,
+		contracts: contracts,
+	}
+}
+
+// Sync indexes every contract up to the current head.
+func (i *Indexer) Sync(ctx context.Context) error {
+	// Taken before we ask for the head, so that we never claim to be more up to date
+	// than we are
+	checked := time.Now().UTC()
+	head, err := i.source.GetHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get chain head: %w", err)
+	}
+	for _, address := range i.contracts {
+		err = i.syncContract(ctx, address, head, checked)
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// Run syncs every interval until the context is cancelled. Failures are logged and
+// retried on the next interval, as readers can carry on using what was last indexed.
+func (i *Indexer) Run(ctx context.Context, interval time.Duration) {
+	for {
+		err := i.Sync(ctx)
+		if err != nil {
+			log.Printf("Index sync failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// If the block we last processed is no longer on the main chain then there has been a
+// reorganisation, and anything we took from the orphaned blocks may be wrong. We don't
+// keep enough history to unpick individual big map changes, so we start the contract
+// again from scratch, which is rare enough not to matter.
+func (i *Indexer) checkForReorg(ctx context.Context, last_level int32, last_hash string) (bool, error) {
+	if last_level == 0 {
+		return false, nil
+	}
+	block, err := i.source.GetBlock(ctx, last_level)
+	if err != nil {
+		return false, fmt.Errorf("failed to get block %d: %w", last_level, err)
+	}
+	return block.Hash != last_hash, nil
+}
+
+func (i *Indexer) syncContract(ctx context.Context, address string, head tzkt.Head, checked time.Time) error {
+	last_level, last_hash, pointers := i.store.position(address)
+	if last_level >= head.Level {
+		i.store.markSynced(address, checked)
+		return nil
+	}
+
+	record := syncRecord{
+		Address:   address,
+		LastLevel: head.Level,
+		LastHash:  head.Hash,
+		Synced:    checked,
+		BigMaps:   make(map[int64][]tzkt.BigMapItem),
+	}
+
+	reorg, err := i.checkForReorg(ctx, last_level, last_hash)
+	if err != nil {
+		return err
+	}
+	if reorg {
+		log.Printf("Block %d (%s) for %s is no longer on chain, reindexing", last_level, last_hash, address)
+		record.Reset = true
+		last_level = 0
+		pointers = make(map[int64]bool)
+	}
+
+	var storage json.RawMessage
+	err = i.source.GetContractStorage(ctx, address, &storage)
+	if err != nil {
+		return fmt.Errorf("failed to get storage: %w", err)
+	}
+	record.Storage = storage
+
+	// Big maps can be replaced as well as updated, so we check the list each time. Like
+	// the events, changes are only taken up to the head, as anything after it will be
+	// picked up by the next sync and taking it now would leave the big maps ahead of
+	// the events.
+	bigmaps, err := i.source.GetContractBigMaps(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to get big maps: %w", err)
+	}
+	for _, bigmap := range bigmaps {
+		since := last_level
+		if !pointers[bigmap.Pointer] {
+			since = 0
+		}
+		items, err := i.source.GetBigMapContents(ctx, bigmap.Pointer, tzkt.QueryOptions{
+			MinLevel: since + 1,
+			MaxLevel: head.Level,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get changes to big map %d: %w", bigmap.Pointer, err)
+		}
+		if len(items) > 0 || !pointers[bigmap.Pointer] {
+			record.BigMaps[bigmap.Pointer] = items
+		}
+	}
+
+	events, err := i.source.GetContractEvents(ctx, address, "", tzkt.QueryOptions{
+		MinLevel: last_level + 1,
+		MaxLevel: head.Level,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get events: %w", err)
+	}
+	record.Events = events
+
+	return i.store.record(record)
+}
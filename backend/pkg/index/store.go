@@ -0,0 +1,467 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// The state we hold for each contract we index. Addresses and big map keys are kept
+// in the same form tzkt gives them to us, so that readers can decode them exactly as
+// they would a response from the indexer.
+type contractState struct {
+	Address   string          `json:"address"`
+	LastLevel int32           `json:"lastLevel"`
+	LastHash  string          `json:"lastHash"`
+	Storage   json.RawMessage `json:"storage,omitempty"`
+
+	// When we last checked the contract was up to date with the chain
+	Synced time.Time `json:"synced"`
+
+	// Big map contents by pointer and then key hash
+	BigMaps map[int64]map[string]tzkt.BigMapItem `json:"bigmaps"`
+
+	// All events emitted by the contract, oldest first
+	Events []tzkt.Event `json:"events"`
+}
+
+func newContractState(address string) *contractState {
+	return &contractState{
+		Address: address,
+		BigMaps: make(map[int64]map[string]tzkt.BigMapItem),
+		Events:  make([]tzkt.Event, 0),
+	}
+}
+
+// What one sync learnt about a contract, which is how changes are written to the store
+// file. Only the big map keys and events that changed are included, unless Reset is
+// set, in which case the record holds the whole contract and replaces whatever we had.
+type syncRecord struct {
+	Address   string          `json:"address"`
+	Reset     bool            `json:"reset,omitempty"`
+	LastLevel int32           `json:"lastLevel"`
+	LastHash  string          `json:"lastHash"`
+	Synced    time.Time       `json:"synced"`
+	Storage   json.RawMessage `json:"storage,omitempty"`
+
+	// Changed keys by big map pointer. A big map with no changes is still listed if it
+	// is new, so that we know it has been indexed.
+	BigMaps map[int64][]tzkt.BigMapItem `json:"bigmaps,omitempty"`
+
+	Events []tzkt.Event `json:"events,omitempty"`
+}
+
+func (c *contractState) apply(record syncRecord) {
+	c.LastLevel = record.LastLevel
+	c.LastHash = record.LastHash
+	c.Synced = record.Synced
+	if record.Storage != nil {
+		c.Storage = record.Storage
+	}
+	for pointer, items := range record.BigMaps {
+		bigmap, ok := c.BigMaps[pointer]
+		if !ok {
+			bigmap = make(map[string]tzkt.BigMapItem, len(items))
+			c.BigMaps[pointer] = bigmap
+		}
+		for _, item := range items {
+			bigmap[item.Hash] = item
+		}
+	}
+	c.Events = append(c.Events, record.Events...)
+}
+
+// The record that recreates the contract as it is now.
+func (c *contractState) resetRecord() syncRecord {
+	record := syncRecord{
+		Address:   c.Address,
+		Reset:     true,
+		LastLevel: c.LastLevel,
+		LastHash:  c.LastHash,
+		Synced:    c.Synced,
+		Storage:   c.Storage,
+		BigMaps:   make(map[int64][]tzkt.BigMapItem, len(c.BigMaps)),
+		Events:    c.Events,
+	}
+	for pointer, bigmap := range c.BigMaps {
+		items := make([]tzkt.BigMapItem, 0, len(bigmap))
+		for _, item := range bigmap {
+			items = append(items, item)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Identifier < items[j].Identifier
+		})
+		record.BigMaps[pointer] = items
+	}
+	return record
+}
+
+// Store holds everything the indexer has ingested. It is kept in memory and, if it has
+// a path, each sync is appended to the file as a JSON record of what changed, so that
+// keeping up with the chain doesn't mean rewriting everything we've indexed. When the
+// records appended outgrow the state they describe, the file is rewritten with one
+// record per contract.
+type Store struct {
+	path string
+
+	lock      sync.RWMutex
+	contracts map[string]*contractState
+
+	// The size of the file when it was last rewritten, and how much has been appended
+	// to it since
+	compacted_size int64
+	appended_size  int64
+}
+
+// The file isn't rewritten until it has had at least this much appended, so that a
+// small index isn't rewritten on every sync. This is a variable so that tests can
+// compact small stores.
+var minCompactionSize int64 = 1 << 20
+
+func NewMemoryStore() *Store {
+	return &Store{
+		contracts: make(map[string]*contractState),
+	}
+}
+
+func LoadStore(path string) (*Store, error) {
+	store := NewMemoryStore()
+	store.path = path
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("failed to open index store: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	first, err := peekFirstByte(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index store: %w", err)
+	}
+	if first == '[' {
+		// Stores written before changes were appended hold every contract as a
+		// single JSON list, which we convert as we load it
+		var contracts []*contractState
+		err = json.NewDecoder(reader).Decode(&contracts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode index store: %w", err)
+		}
+		for _, contract := range contracts {
+			if contract.BigMaps == nil {
+				contract.BigMaps = make(map[int64]map[string]tzkt.BigMapItem)
+			}
+			store.contracts[contract.Address] = contract
+		}
+		err = store.compact()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var record syncRecord
+		err = decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// We stopped part way through appending the last sync, which can just
+			// be dropped as the next sync will fetch it again
+			log.Printf("Ignoring incomplete last record in index store %s", path)
+			err = store.compact()
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode index store: %w", err)
+		}
+		store.applyRecord(record)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index store: %w", err)
+	}
+	store.compacted_size = info.Size()
+	return store, nil
+}
+
+// Returns the first byte that isn't white space, or zero if there is none.
+func peekFirstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (s *Store) applyRecord(record syncRecord) {
+	contract, ok := s.contracts[record.Address]
+	if !ok || record.Reset {
+		contract = newContractState(record.Address)
+		s.contracts[record.Address] = contract
+	}
+	contract.apply(record)
+}
+
+// Appends the record to the file, and then applies it to what we hold in memory, so
+// that a failed write leaves both as they were. Must be called with the lock held.
+func (s *Store) appendRecord(record syncRecord) error {
+	if s.path != "" {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode index record: %w", err)
+		}
+		data = append(data, '\n')
+		err = appendToFile(s.path, data)
+		if err != nil {
+			return fmt.Errorf("failed to save index store: %w", err)
+		}
+		s.appended_size += int64(len(data))
+	}
+	s.applyRecord(record)
+
+	if s.path != "" && s.appended_size > minCompactionSize && s.appended_size > s.compacted_size {
+		err := s.compact()
+		if err != nil {
+			// Nothing is lost, the file is just bigger than it needs to be
+			log.Printf("Failed to compact index store: %v", err)
+		}
+	}
+	return nil
+}
+
+func appendToFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	close_err := file.Close()
+	if err == nil {
+		err = close_err
+	}
+	return err
+}
+
+// Rewrites the file with a single record for each contract. Must be called with the
+// lock held.
+func (s *Store) compact() error {
+	if s.path == "" {
+		return nil
+	}
+	addresses := make([]string, 0, len(s.contracts))
+	for address := range s.contracts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	var data bytes.Buffer
+	for _, address := range addresses {
+		line, err := json.Marshal(s.contracts[address].resetRecord())
+		if err != nil {
+			return fmt.Errorf("failed to encode index store: %w", err)
+		}
+		data.Write(line)
+		data.WriteByte('\n')
+	}
+	err := writeFileAtomically(s.path, data.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to save index store: %w", err)
+	}
+	s.compacted_size = int64(data.Len())
+	s.appended_size = 0
+	return nil
+}
+
+// Written to one side and then moved into place so that a crash part way through a
+// write doesn't leave us with a corrupt store.
+func writeFileAtomically(path string, data []byte) error {
+	temp_file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = temp_file.Write(data)
+	if err == nil {
+		err = temp_file.Sync()
+	}
+	close_err := temp_file.Close()
+	if err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(temp_file.Name())
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	err = os.Rename(temp_file.Name(), path)
+	if err != nil {
+		os.Remove(temp_file.Name())
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// Contracts returns the addresses of all the contracts in the store.
+func (s *Store) Contracts() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	addresses := make([]string, 0, len(s.contracts))
+	for address := range s.contracts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// LastLevel returns the level up to which the contract has been indexed, or zero if
+// it has not been indexed yet.
+func (s *Store) LastLevel(address string) int32 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if contract, ok := s.contracts[address]; ok {
+		return contract.LastLevel
+	}
+	return 0
+}
+
+// Synced returns when the contract was last checked to be up to date with the chain,
+// or the zero time if it never has been.
+func (s *Store) Synced(address string) time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if contract, ok := s.contracts[address]; ok {
+		return contract.Synced
+	}
+	return time.Time{}
+}
+
+// Records that a sync found nothing new for the contract. This isn't worth saving, as
+// it only matters to readers using this store.
+func (s *Store) markSynced(address string, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if contract, ok := s.contracts[address]; ok {
+		contract.Synced = at
+	}
+}
+
+// Returns the address of the indexed contract that the big map belongs to.
+func (s *Store) bigMapContract(identifier int64) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for address, contract := range s.contracts {
+		if _, ok := contract.BigMaps[identifier]; ok && contract.LastLevel != 0 {
+			return address, true
+		}
+	}
+	return "", false
+}
+
+// The contract's storage as tzkt returned it, if the contract has been indexed.
+func (s *Store) Storage(address string) (json.RawMessage, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	contract, ok := s.contracts[address]
+	if !ok || contract.LastLevel == 0 {
+		return nil, false
+	}
+	return contract.Storage, true
+}
+
+// BigMap returns the keys in the big map that match the options, in the same form as
+// TzKTClient.GetBigMapContents, if the big map belongs to an indexed contract.
+func (s *Store) BigMap(identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, contract := range s.contracts {
+		if contract.LastLevel == 0 {
+			continue
+		}
+		bigmap, ok := contract.BigMaps[identifier]
+		if !ok {
+			continue
+		}
+		items := make([]tzkt.BigMapItem, 0, len(bigmap))
+		for _, item := range bigmap {
+			if options.MatchesBigMapItem(item) {
+				items = append(items, item)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Identifier < items[j].Identifier
+		})
+		return tzkt.ApplyOrderAndLimit(options, items), true
+	}
+	return nil, false
+}
+
+// Events returns the contract's events with the given tag that match the options, if
+// the contract has been indexed. If the tag is empty then events with any tag are
+// returned.
+func (s *Store) Events(address string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	contract, ok := s.contracts[address]
+	if !ok || contract.LastLevel == 0 {
+		return nil, false
+	}
+	events := make([]tzkt.Event, 0)
+	for _, event := range contract.Events {
+		if (tag == "" || event.Tag == tag) && options.MatchesEvent(event) {
+			events = append(events, event)
+		}
+	}
+	return tzkt.ApplyOrderAndLimit(options, events), true
+}
+
+// Returns the level and block hash the contract was last synced to, and the pointers
+// of the big maps we have for it, which is all the indexer needs to work out what has
+// changed since.
+func (s *Store) position(address string) (int32, string, map[int64]bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	pointers := make(map[int64]bool)
+	contract, ok := s.contracts[address]
+	if !ok {
+		return 0, "", pointers
+	}
+	for pointer := range contract.BigMaps {
+		pointers[pointer] = true
+	}
+	return contract.LastLevel, contract.LastHash, pointers
+}
+
+// Adds what a sync found to the store.
+func (s *Store) record(record syncRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.appendRecord(record)
+}
@@ -0,0 +1,151 @@
+package tzclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// The status values the indexer reports for an operation
+const (
+	OperationApplied     = "applied"
+	OperationFailed      = "failed"
+	OperationBacktracked = "backtracked"
+	OperationSkipped     = "skipped"
+)
+
+// ErrOperationExpired is returned when waiting for an operation that was never included
+// in a block, and now never will be.
+var ErrOperationExpired = errors.New("operation was not included before it expired")
+
+// How often we ask the indexer whether an operation has been included yet. Tezos
+// blocks are currently around fifteen seconds apart, so there's no point asking
+// much more often than this.
+var confirmationPollInterval = 5 * time.Second
+
+// OperationStatus is the outcome of an operation once it has been included in a block.
+// If the operation was a batch then the gas and fees are the totals for all of its
+// contents, including any internal operations. Fees are in mutez.
+type OperationStatus struct {
+	Hash          string
+	Status        string
+	Level         int64
+	Block         string
+	Confirmations int64
+	GasUsed       int64
+	StorageUsed   int64
+	BakerFee      int64
+	StorageFee    int64
+	AllocationFee int64
+	Errors        []string
+}
+
+func (s OperationStatus) IsApplied() bool {
+	return s.Status == OperationApplied
+}
+
+// TotalFee is everything the signer paid for the operation.
+func (s OperationStatus) TotalFee() int64 {
+	return s.BakerFee + s.StorageFee + s.AllocationFee
+}
+
+// The parts of the indexer that waiting for an operation needs, so that we can test
+// without a real indexer.
+type confirmationSource interface {
+	GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error)
+	GetHead(ctx context.Context) (tzkt.Head, error)
+}
+
+// Works out the overall status of an operation from its parts. If any part of an
+// operation fails then the whole thing is failed, with the other parts either
+// backtracked or skipped.
+func newOperationStatus(hash string, operations []tzkt.Operation) OperationStatus {
+	status := OperationStatus{
+		Hash:   hash,
+		Status: OperationApplied,
+		Level:  int64(operations[0].Level),
+		Block:  operations[0].Block,
+		Errors: make([]string, 0),
+	}
+	rank := map[string]int{
+		OperationApplied:     0,
+		OperationSkipped:     1,
+		OperationBacktracked: 2,
+		OperationFailed:      3,
+	}
+	for _, operation := range operations {
+		if rank[operation.Status] > rank[status.Status] {
+			status.Status = operation.Status
+		}
+		status.GasUsed += operation.GasUsed
+		status.StorageUsed += operation.StorageUsed
+		status.BakerFee += operation.BakerFee
+		status.StorageFee += operation.StorageFee
+		status.AllocationFee += operation.AllocationFee
+		for _, operation_error := range operation.Errors {
+			status.Errors = append(status.Errors, operation_error.Type)
+		}
+	}
+	return status
+}
+
+func waitForConfirmation(
+	ctx context.Context,
+	indexer confirmationSource,
+	hash string,
+	confirmations int64,
+) (OperationStatus, error) {
+	if confirmations < 1 {
+		confirmations = 1
+	}
+
+	// If the operation hasn't been included by the time it would have expired then it
+	// has been dropped by the mempool and never will be.
+	var expires_at int64
+	for {
+		head, err := indexer.GetHead(ctx)
+		if err != nil {
+			return OperationStatus{}, fmt.Errorf("failed to get chain head: %w", err)
+		}
+		if expires_at == 0 {
+			expires_at = int64(head.Level) + tezos.DefaultParams.MaxOperationsTTL
+		}
+
+		operations, err := indexer.GetOperationInformation(ctx, hash)
+		if err != nil {
+			return OperationStatus{}, fmt.Errorf("failed to get operation %s: %w", hash, err)
+		}
+		if len(operations) > 0 {
+			status := newOperationStatus(hash, operations)
+			status.Confirmations = int64(head.Level) - status.Level + 1
+			if status.Confirmations >= confirmations {
+				return status, nil
+			}
+		} else if int64(head.Level) > expires_at {
+			return OperationStatus{}, fmt.Errorf("operation %s: %w", hash, ErrOperationExpired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return OperationStatus{}, fmt.Errorf("stopped waiting for operation %s: %w", hash, ctx.Err())
+		case <-time.After(confirmationPollInterval):
+		}
+	}
+}
+
+// WaitForConfirmation blocks until the operation has been included in a block and
+// that block has the requested number of confirmations, where the block containing
+// the operation counts as the first. The operation may have failed on chain, so
+// callers should check the status.
+func (c Client) WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (OperationStatus, error) {
+	reader, err := c.newReader()
+	if err != nil {
+		return OperationStatus{}, err
+	}
+	return waitForConfirmation(ctx, reader, hash, confirmations)
+}
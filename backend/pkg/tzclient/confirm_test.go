@@ -0,0 +1,205 @@
+package tzclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// Each call to GetHead moves the chain on by a block, and the operation appears in
+// the indexer at IncludedAt.
+type fakeConfirmationSource struct {
+	Level      int32
+	IncludedAt int32
+	Operations []tzkt.Operation
+	ShouldFail bool
+}
+
+func (s *fakeConfirmationSource) GetHead(ctx context.Context) (tzkt.Head, error) {
+	if s.ShouldFail {
+		return tzkt.Head{}, fmt.Errorf("Test should fail")
+	}
+	s.Level += 1
+	return tzkt.Head{Level: s.Level}, nil
+}
+
+func (s *fakeConfirmationSource) GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error) {
+	if s.IncludedAt == 0 || s.Level < s.IncludedAt {
+		return make([]tzkt.Operation, 0), nil
+	}
+	return s.Operations, nil
+}
+
+func TestNewOperationStatus(t *testing.T) {
+	testcases := []struct {
+		Operations     []tzkt.Operation
+		ExpectedStatus string
+		ExpectedGas    int64
+		ExpectedFee    int64
+		ExpectedErrors int
+	}{
+		{
+			Operations: []tzkt.Operation{
+				{Level: 10, Status: "applied", GasUsed: 1000, BakerFee: 500, StorageFee: 250},
+			},
+			ExpectedStatus: OperationApplied,
+			ExpectedGas:    1000,
+			ExpectedFee:    750,
+			ExpectedErrors: 0,
+		},
+		{
+			// An internal operation, which is reported separately by the indexer
+			Operations: []tzkt.Operation{
+				{Level: 10, Status: "applied", GasUsed: 1000, BakerFee: 500},
+				{Level: 10, Status: "applied", GasUsed: 300, AllocationFee: 100},
+			},
+			ExpectedStatus: OperationApplied,
+			ExpectedGas:    1300,
+			ExpectedFee:    600,
+			ExpectedErrors: 0,
+		},
+		{
+			Operations: []tzkt.Operation{
+				{Level: 10, Status: "backtracked", GasUsed: 1000, BakerFee: 500},
+				{Level: 10, Status: "failed", GasUsed: 300, Errors: []tzkt.OperationError{
+					{Type: "proto.015-PtLimaPt.michelson_v1.runtime_error"},
+					{Type: "proto.015-PtLimaPt.michelson_v1.script_rejected"},
+				}},
+				{Level: 10, Status: "skipped"},
+			},
+			ExpectedStatus: OperationFailed,
+			ExpectedGas:    1300,
+			ExpectedFee:    500,
+			ExpectedErrors: 2,
+		},
+	}
+
+	for index, testcase := range testcases {
+		status := newOperationStatus("hash", testcase.Operations)
+		if status.Status != testcase.ExpectedStatus {
+			t.Errorf("%d: Expected status %s, got %s", index, testcase.ExpectedStatus, status.Status)
+		}
+		if status.IsApplied() != (testcase.ExpectedStatus == OperationApplied) {
+			t.Errorf("%d: Unexpected IsApplied for %s", index, status.Status)
+		}
+		if status.GasUsed != testcase.ExpectedGas {
+			t.Errorf("%d: Expected gas %d, got %d", index, testcase.ExpectedGas, status.GasUsed)
+		}
+		if status.TotalFee() != testcase.ExpectedFee {
+			t.Errorf("%d: Expected fee %d, got %d", index, testcase.ExpectedFee, status.TotalFee())
+		}
+		if len(status.Errors) != testcase.ExpectedErrors {
+			t.Errorf("%d: Expected %d errors, got %v", index, testcase.ExpectedErrors, status.Errors)
+		}
+		if status.Level != 10 {
+			t.Errorf("%d: Expected level 10, got %d", index, status.Level)
+		}
+	}
+}
+
+func TestWaitForConfirmation(t *testing.T) {
+	confirmationPollInterval = time.Millisecond
+
+	operations := []tzkt.Operation{
+		{Level: 103, Block: "block", Status: "applied", GasUsed: 1000, BakerFee: 500},
+	}
+
+	testcases := []struct {
+		Source                *fakeConfirmationSource
+		Confirmations         int64
+		ExpectError           bool
+		ExpectedConfirmations int64
+		ExpectedLevel         int64
+	}{
+		{
+			Source:                &fakeConfirmationSource{Level: 100, IncludedAt: 103, Operations: operations},
+			Confirmations:         1,
+			ExpectError:           false,
+			ExpectedConfirmations: 1,
+			ExpectedLevel:         103,
+		},
+		{
+			Source:                &fakeConfirmationSource{Level: 100, IncludedAt: 103, Operations: operations},
+			Confirmations:         3,
+			ExpectError:           false,
+			ExpectedConfirmations: 3,
+			ExpectedLevel:         103,
+		},
+		{
+			// Zero is treated as waiting for inclusion
+			Source:                &fakeConfirmationSource{Level: 100, IncludedAt: 103, Operations: operations},
+			Confirmations:         0,
+			ExpectError:           false,
+			ExpectedConfirmations: 1,
+			ExpectedLevel:         103,
+		},
+		{
+			// Already well confirmed when we started
+			Source:                &fakeConfirmationSource{Level: 200, IncludedAt: 103, Operations: operations},
+			Confirmations:         2,
+			ExpectError:           false,
+			ExpectedConfirmations: 99,
+			ExpectedLevel:         103,
+		},
+		{
+			// Never included, so should expire
+			Source:        &fakeConfirmationSource{Level: 100},
+			Confirmations: 1,
+			ExpectError:   true,
+		},
+		{
+			Source:        &fakeConfirmationSource{ShouldFail: true},
+			Confirmations: 1,
+			ExpectError:   true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		ctx := context.Background()
+		status, err := waitForConfirmation(ctx, testcase.Source, "hash", testcase.Confirmations)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %v", index, status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if status.Confirmations != testcase.ExpectedConfirmations {
+			t.Errorf("%d: Expected %d confirmations, got %d", index, testcase.ExpectedConfirmations, status.Confirmations)
+		}
+		if status.Level != testcase.ExpectedLevel {
+			t.Errorf("%d: Expected level %d, got %d", index, testcase.ExpectedLevel, status.Level)
+		}
+		if status.Hash != "hash" {
+			t.Errorf("%d: Expected hash to be kept, got %s", index, status.Hash)
+		}
+	}
+}
+
+func TestWaitForConfirmationExpired(t *testing.T) {
+	confirmationPollInterval = time.Millisecond
+
+	ctx := context.Background()
+	_, err := waitForConfirmation(ctx, &fakeConfirmationSource{Level: 100}, "hash", 1)
+	if !errors.Is(err, ErrOperationExpired) {
+		t.Errorf("Expected expired error, got %v", err)
+	}
+}
+
+func TestWaitForConfirmationCancelled(t *testing.T) {
+	confirmationPollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := waitForConfirmation(ctx, &fakeConfirmationSource{Level: 100}, "hash", 1)
+	if err == nil {
+		t.Errorf("Expected error when context is cancelled")
+	}
+}
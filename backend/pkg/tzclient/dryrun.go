@@ -0,0 +1,41 @@
+package tzclient
+
+import (
+	"context"
+	"fmt"
+
+	"blockwatch.cc/tzgo/micheline"
+)
+
+// DryRunClient wraps another client so that contract calls are simulated rather than
+// injected, which lets code built on CallContract be tried out without changing any
+// chain state. The results of each simulation are kept in order in Simulations.
+type DryRunClient struct {
+	TezosClient
+	Simulations []SimulationResult
+}
+
+func NewDryRunClient(client TezosClient) *DryRunClient {
+	return &DryRunClient{
+		TezosClient: client,
+		Simulations: make([]SimulationResult, 0),
+	}
+}
+
+// As nothing is injected there is no operation hash, so this returns an empty string.
+func (c *DryRunClient) CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	result, err := c.TezosClient.Simulate(ctx, signedBy, target, parameters)
+	if err != nil {
+		return "", err
+	}
+	c.Simulations = append(c.Simulations, result)
+	return "", nil
+}
+
+func (c *DryRunClient) InjectContractCall(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	return c.CallContract(ctx, signedBy, target, parameters)
+}
+
+func (c *DryRunClient) Originate(ctx context.Context, signedBy Wallet, code []byte, initial_storage micheline.Prim) (Contract, error) {
+	return Contract{}, fmt.Errorf("dry run is not supported for origination")
+}
@@ -0,0 +1,54 @@
+package tzclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"blockwatch.cc/tzgo/rpc"
+)
+
+// The node reports these (with a protocol specific prefix) when an operation
+// would need more gas or storage than a single operation or block allows.
+var limitErrorIDs = []string{
+	"gas_exhausted.operation",
+	"gas_exhausted.block",
+	"gas_limit_too_high",
+	"storage_exhausted.operation",
+	"storage_limit_too_high",
+	"oversized_operation",
+}
+
+// IsLimitExceeded returns true if the error indicates that the operation was too
+// large to be included on chain, and so splitting it into smaller operations may
+// help.
+func IsLimitExceeded(err error) bool {
+	var tezos_error rpc.Error
+	if !errors.As(err, &tezos_error) {
+		return false
+	}
+	id := tezos_error.ErrorID()
+	for _, limit_id := range limitErrorIDs {
+		if strings.HasSuffix(id, limit_id) {
+			return true
+		}
+	}
+	return false
+}
+
+// BroadcastError is returned when sending a signed operation to the node fails. Unlike
+// other errors from CallContract the node may still have received the operation, in
+// which case it can yet be included, so callers shouldn't assume it failed but should
+// look for OperationHash on chain.
+type BroadcastError struct {
+	OperationHash string
+	Err           error
+}
+
+func (e BroadcastError) Error() string {
+	return fmt.Sprintf("failed to broadcast operation %s: %v", e.OperationHash, e.Err)
+}
+
+func (e BroadcastError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,47 @@
+package tzclient
+
+import (
+	"fmt"
+	"testing"
+
+	"blockwatch.cc/tzgo/rpc"
+)
+
+func TestIsLimitExceeded(t *testing.T) {
+	testcases := []struct {
+		Err    error
+		Expect bool
+	}{
+		{
+			Err:    rpc.GenericError{ID: "proto.015-PtLimaPt.gas_exhausted.operation", Kind: "temporary"},
+			Expect: true,
+		},
+		{
+			Err:    rpc.GenericError{ID: "proto.015-PtLimaPt.storage_exhausted.operation", Kind: "temporary"},
+			Expect: true,
+		},
+		{
+			Err:    fmt.Errorf("wrapped: %w", rpc.GenericError{ID: "proto.015-PtLimaPt.gas_limit_too_high", Kind: "permanent"}),
+			Expect: true,
+		},
+		{
+			Err:    rpc.GenericError{ID: "proto.015-PtLimaPt.michelson_v1.script_rejected", Kind: "temporary"},
+			Expect: false,
+		},
+		{
+			Err:    fmt.Errorf("gas_exhausted.operation"),
+			Expect: false,
+		},
+		{
+			Err:    nil,
+			Expect: false,
+		},
+	}
+
+	for index, testcase := range testcases {
+		result := IsLimitExceeded(testcase.Err)
+		if result != testcase.Expect {
+			t.Errorf("%d: Expected %v, got %v for %v", index, testcase.Expect, result, testcase.Err)
+		}
+	}
+}
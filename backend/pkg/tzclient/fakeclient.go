@@ -0,0 +1,645 @@
+package tzclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// FakeClient is an in-memory chain for tests that need contract calls to change
+// state, which MockClient can't do. It knows how the custodian and FA2 contracts in
+// src/ behave, so the parameters built by pkg/x4c are decoded and applied to the
+// ledgers and operators, events are emitted, and calls fail with the contracts' own
+// error codes. Storage, big maps and events are returned in the same shape as TzKT
+// would give them.
+//
+// Each successful call is baked into its own block, and like on chain a call that
+// fails anywhere, including in a contract called internally, changes nothing.
+type FakeClient struct {
+	// The time of the first block, with each block after a minute later
+	Genesis time.Time
+
+	lock  sync.Mutex
+	names map[string]Contract
+	state *fakeState
+}
+
+const fakeBlockTime = time.Minute
+
+// The chain state, which is copied for each call so that failed calls and
+// simulations can be thrown away.
+type fakeState struct {
+	level        int32
+	next_id      int64
+	next_big_map int64
+	contracts    map[string]*fakeContract
+	big_maps     map[int64]*fakeBigMap
+	blocks       []tzkt.Block
+	events       []tzkt.Event
+	operations   map[string][]tzkt.Operation
+}
+
+type fakeContractKind int
+
+const (
+	fakeFA2 fakeContractKind = iota + 1
+	fakeCustodian
+)
+
+// Operators are kept as strings, with custodian owners being the hex of their packed
+// KYC, as that is how they appear in the storage.
+type fakeOperator struct {
+	Owner    string
+	Operator string
+	TokenID  int64
+}
+
+// Admin is the oracle for FA2 contracts and the custodian for custodian contracts,
+// and only FA2 contracts have token metadata and only custodians an external ledger.
+type fakeContract struct {
+	Kind           fakeContractKind
+	Admin          tezos.Address
+	Ledger         int64
+	Metadata       int64
+	TokenMetadata  int64
+	ExternalLedger int64
+	Operators      []fakeOperator
+}
+
+type fakeBigMapUpdate struct {
+	Level int32
+	Hash  string
+	// Nil if the key was removed
+	Value json.RawMessage
+}
+
+// Items holds every key that has been in the map, in the order they were first
+// added, and values the current value of the active ones, by key hash.
+type fakeBigMap struct {
+	KeyType   micheline.Type
+	ValueType micheline.Type
+	items     []tzkt.BigMapItem
+	index     map[string]int
+	values    map[string]micheline.Prim
+	history   []fakeBigMapUpdate
+}
+
+func NewFakeClient() *FakeClient {
+	genesis := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	state := &fakeState{
+		level:      1,
+		contracts:  make(map[string]*fakeContract),
+		big_maps:   make(map[int64]*fakeBigMap),
+		blocks:     []tzkt.Block{{Level: 1, Hash: fakeBlockHash(1), Timestamp: genesis}},
+		events:     make([]tzkt.Event, 0),
+		operations: make(map[string][]tzkt.Operation),
+	}
+	return &FakeClient{
+		Genesis: genesis,
+		names:   make(map[string]Contract),
+		state:   state,
+	}
+}
+
+func fakeBlockHash(level int32) string {
+	hash := make([]byte, 32)
+	binary.BigEndian.PutUint32(hash[28:], uint32(level))
+	return tezos.NewBlockHash(hash).String()
+}
+
+func fakeOperationHash(identifier int64) string {
+	hash := make([]byte, 32)
+	binary.BigEndian.PutUint64(hash[24:], uint64(identifier))
+	return tezos.NewOpHash(hash).String()
+}
+
+func (s *fakeState) clone() *fakeState {
+	result := &fakeState{
+		level:        s.level,
+		next_id:      s.next_id,
+		next_big_map: s.next_big_map,
+		contracts:    make(map[string]*fakeContract, len(s.contracts)),
+		big_maps:     make(map[int64]*fakeBigMap, len(s.big_maps)),
+		blocks:       append([]tzkt.Block(nil), s.blocks...),
+		events:       append([]tzkt.Event(nil), s.events...),
+		operations:   make(map[string][]tzkt.Operation, len(s.operations)),
+	}
+	for address, contract := range s.contracts {
+		copied := *contract
+		copied.Operators = append([]fakeOperator(nil), contract.Operators...)
+		result.contracts[address] = &copied
+	}
+	for identifier, big_map := range s.big_maps {
+		result.big_maps[identifier] = big_map.clone()
+	}
+	for hash, operations := range s.operations {
+		result.operations[hash] = operations
+	}
+	return result
+}
+
+func (s *fakeState) newBigMap(key_type micheline.Type, value_type micheline.Type) int64 {
+	identifier := s.next_big_map
+	s.next_big_map += 1
+	s.big_maps[identifier] = &fakeBigMap{
+		KeyType:   key_type,
+		ValueType: value_type,
+		items:     make([]tzkt.BigMapItem, 0),
+		index:     make(map[string]int),
+		values:    make(map[string]micheline.Prim),
+		history:   make([]fakeBigMapUpdate, 0),
+	}
+	return identifier
+}
+
+func (s *fakeState) bigMap(identifier int64) (*fakeBigMap, error) {
+	big_map, ok := s.big_maps[identifier]
+	if !ok {
+		return nil, fmt.Errorf("no big map %d on the fake chain", identifier)
+	}
+	return big_map, nil
+}
+
+func (s *fakeState) get(identifier int64, key micheline.Prim) (micheline.Prim, bool, error) {
+	big_map, err := s.bigMap(identifier)
+	if err != nil {
+		return micheline.Prim{}, false, err
+	}
+	hash, err := big_map.hash(key)
+	if err != nil {
+		return micheline.Prim{}, false, err
+	}
+	value, ok := big_map.values[hash]
+	return value, ok, nil
+}
+
+// Sets the key to value, or removes it if value is nil.
+func (s *fakeState) update(identifier int64, key micheline.Prim, value *micheline.Prim) error {
+	big_map, err := s.bigMap(identifier)
+	if err != nil {
+		return err
+	}
+	hash, err := big_map.hash(key)
+	if err != nil {
+		return err
+	}
+	if _, active := big_map.values[hash]; !active && value == nil {
+		// Removing a key that isn't there doesn't change the map
+		return nil
+	}
+	position, ok := big_map.index[hash]
+	if !ok {
+		key_json, err := micheline.NewValue(big_map.KeyType, key).MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("failed to encode big map key: %w", err)
+		}
+		s.next_id += 1
+		big_map.items = append(big_map.items, tzkt.BigMapItem{
+			Identifier: s.next_id,
+			Hash:       hash,
+			Key:        key_json,
+			FirstLevel: int64(s.level),
+		})
+		position = len(big_map.items) - 1
+		big_map.index[hash] = position
+	}
+
+	var value_json json.RawMessage
+	if value != nil {
+		value_json, err = micheline.NewValue(big_map.ValueType, *value).MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("failed to encode big map value: %w", err)
+		}
+		big_map.values[hash] = *value
+		big_map.items[position].Value = value_json
+	} else {
+		// TzKT keeps the last value of removed keys
+		delete(big_map.values, hash)
+	}
+	big_map.items[position].Active = value != nil
+	big_map.items[position].LastLevel = int64(s.level)
+	big_map.items[position].Updates += 1
+	big_map.history = append(big_map.history, fakeBigMapUpdate{
+		Level: s.level,
+		Hash:  hash,
+		Value: value_json,
+	})
+	return nil
+}
+
+func (m *fakeBigMap) clone() *fakeBigMap {
+	result := &fakeBigMap{
+		KeyType:   m.KeyType,
+		ValueType: m.ValueType,
+		items:     append([]tzkt.BigMapItem(nil), m.items...),
+		index:     make(map[string]int, len(m.index)),
+		values:    make(map[string]micheline.Prim, len(m.values)),
+		history:   append([]fakeBigMapUpdate(nil), m.history...),
+	}
+	for hash, position := range m.index {
+		result.index[hash] = position
+	}
+	for hash, value := range m.values {
+		result.values[hash] = value
+	}
+	return result
+}
+
+func (m *fakeBigMap) hash(key micheline.Prim) (string, error) {
+	big_map_key, err := micheline.NewKey(m.KeyType, key)
+	if err != nil {
+		return "", fmt.Errorf("invalid big map key: %w", err)
+	}
+	return big_map_key.Hash().String(), nil
+}
+
+// AddFA2Contract puts an empty FA2 contract on the chain, as if it had just been
+// originated with the given oracle.
+func (c *FakeClient) AddFA2Contract(contract Contract, oracle tezos.Address) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.state.contracts[contract.Address.String()] = &fakeContract{
+		Kind:          fakeFA2,
+		Admin:         oracle,
+		Ledger:        c.state.newBigMap(fakeFA2OwnerType, fakeNatType),
+		Metadata:      c.state.newBigMap(fakeStringType, fakeBytesType),
+		TokenMetadata: c.state.newBigMap(fakeNatType, fakeTokenMetadataType),
+		Operators:     make([]fakeOperator, 0),
+	}
+	c.addName(contract)
+}
+
+// AddCustodianContract puts an empty custodian contract on the chain, as if it had
+// just been originated with the given custodian.
+func (c *FakeClient) AddCustodianContract(contract Contract, custodian tezos.Address) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.state.contracts[contract.Address.String()] = &fakeContract{
+		Kind:           fakeCustodian,
+		Admin:          custodian,
+		Ledger:         c.state.newBigMap(fakeCustodianOwnerType, fakeNatType),
+		ExternalLedger: c.state.newBigMap(fakeTokenType, fakeNatType),
+		Metadata:       c.state.newBigMap(fakeStringType, fakeBytesType),
+		Operators:      make([]fakeOperator, 0),
+	}
+	c.addName(contract)
+}
+
+func (c *FakeClient) addName(contract Contract) {
+	if contract.Name != "" {
+		c.names[contract.Name] = contract
+	}
+}
+
+func (c *FakeClient) ContractByName(name string) (Contract, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if contract, ok := c.names[name]; ok {
+		return contract, nil
+	}
+	for _, contract := range c.names {
+		if contract.Address.String() == name {
+			return contract, nil
+		}
+	}
+	return Contract{}, fmt.Errorf("contract not found")
+}
+
+func (c *FakeClient) GetIndexerWebURL() string {
+	return "https://index.web"
+}
+
+type fakeOperatorJSON struct {
+	TokenOwner    string `json:"token_owner"`
+	TokenOperator string `json:"token_operator"`
+	TokenID       string `json:"token_id"`
+}
+
+// The storage is encoded as TzKT does, with big maps as their IDs and the operator
+// set as a list of records in order.
+func (c *fakeContract) storageJSON() ([]byte, error) {
+	sorted := append([]fakeOperator(nil), c.Operators...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Owner != sorted[j].Owner {
+			return sorted[i].Owner < sorted[j].Owner
+		}
+		if sorted[i].Operator != sorted[j].Operator {
+			return sorted[i].Operator < sorted[j].Operator
+		}
+		return sorted[i].TokenID < sorted[j].TokenID
+	})
+	operators := make([]fakeOperatorJSON, 0, len(sorted))
+	for _, operator := range sorted {
+		operators = append(operators, fakeOperatorJSON{
+			TokenOwner:    operator.Owner,
+			TokenOperator: operator.Operator,
+			TokenID:       fmt.Sprintf("%d", operator.TokenID),
+		})
+	}
+
+	storage := map[string]interface{}{
+		"ledger":    c.Ledger,
+		"metadata":  c.Metadata,
+		"operators": operators,
+	}
+	switch c.Kind {
+	case fakeFA2:
+		storage["oracle"] = c.Admin.String()
+		storage["token_metadata"] = c.TokenMetadata
+	case fakeCustodian:
+		storage["custodian"] = c.Admin.String()
+		storage["external_ledger"] = c.ExternalLedger
+	}
+	return json.Marshal(storage)
+}
+
+func (c *FakeClient) GetContractStorage(target Contract, ctx context.Context, storage interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	contract, ok := c.state.contracts[target.Address.String()]
+	if !ok {
+		return fmt.Errorf("no contract %s on the fake chain", target.Address)
+	}
+	raw, err := contract.storageJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode storage: %w", err)
+	}
+	err = json.Unmarshal(raw, storage)
+	if err != nil {
+		return fmt.Errorf("failed to decode storage: %w", err)
+	}
+	return nil
+}
+
+func (c *FakeClient) GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	big_map, ok := c.state.big_maps[identifier]
+	if !ok {
+		// As with TzKT, an unknown big map is just empty
+		return make([]tzkt.BigMapItem, 0), nil
+	}
+	filtered := make([]tzkt.BigMapItem, 0, len(big_map.items))
+	for _, item := range big_map.items {
+		if options.MatchesBigMapItem(item) {
+			filtered = append(filtered, item)
+		}
+	}
+	return tzkt.ApplyOrderAndLimit(options, filtered), nil
+}
+
+// Replays the updates to the big map up to the level to find the keys that were
+// active then.
+func (c *FakeClient) GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	big_map, ok := c.state.big_maps[identifier]
+	if !ok {
+		return make([]tzkt.BigMapItem, 0), nil
+	}
+	latest := make(map[string]fakeBigMapUpdate)
+	updates := make(map[string]int64)
+	for _, update := range big_map.history {
+		if update.Level > level {
+			break
+		}
+		latest[update.Hash] = update
+		updates[update.Hash] += 1
+	}
+	items := make([]tzkt.BigMapItem, 0)
+	for _, item := range big_map.items {
+		update, ok := latest[item.Hash]
+		if !ok || update.Value == nil {
+			continue
+		}
+		item.Active = true
+		item.Value = update.Value
+		item.LastLevel = int64(update.Level)
+		item.Updates = updates[item.Hash]
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (c *FakeClient) GetLevelAtTime(ctx context.Context, at time.Time) (int32, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var level int32
+	for _, block := range c.state.blocks {
+		if !block.Timestamp.After(at) {
+			level = block.Level
+		}
+	}
+	if level == 0 {
+		return 0, fmt.Errorf("no blocks at or before %v", at)
+	}
+	return level, nil
+}
+
+func (c *FakeClient) GetHead(ctx context.Context) (tzkt.Head, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	block := c.state.blocks[len(c.state.blocks)-1]
+	return tzkt.Head{Level: block.Level, Hash: block.Hash, Timestamp: block.Timestamp}, nil
+}
+
+func (c *FakeClient) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	events := make([]tzkt.Event, 0)
+	for _, event := range c.state.events {
+		if *event.Contract.Address != contractAddress {
+			continue
+		}
+		if (tag == "" || event.Tag == tag) && options.MatchesEvent(event) {
+			events = append(events, event)
+		}
+	}
+	return tzkt.ApplyOrderAndLimit(options, events), nil
+}
+
+// Returns the transactions in the operation, including the internal ones, as TzKT
+// does.
+func (c *FakeClient) GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]tzkt.Operation{}, c.state.operations[hash]...), nil
+}
+
+func (c *FakeClient) GetTransactionByID(ctx context.Context, identifier int64) (tzkt.Operation, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, operations := range c.state.operations {
+		for _, operation := range operations {
+			if operation.Identifier == identifier {
+				return operation, nil
+			}
+		}
+	}
+	return tzkt.Operation{}, fmt.Errorf("no transaction with id %d", identifier)
+}
+
+// A call being run on a copy of the chain state, which collects the transactions
+// and events as they happen.
+type fakeRun struct {
+	state        *fakeState
+	source       tezos.Address
+	hash         string
+	transactions []tzkt.Operation
+	events       []SimulatedEvent
+}
+
+// Runs the call in a new block on a copy of the chain, which is returned for the
+// caller to keep if it wants.
+func (c *FakeClient) run(signedBy Wallet, target Contract, parameters micheline.Parameters) (*fakeRun, error) {
+	state := c.state.clone()
+	state.level += 1
+	state.blocks = append(state.blocks, tzkt.Block{
+		Level:     state.level,
+		Hash:      fakeBlockHash(state.level),
+		Timestamp: c.Genesis.Add(time.Duration(state.level-1) * fakeBlockTime),
+	})
+	run := &fakeRun{
+		state:        state,
+		source:       signedBy.Address,
+		hash:         fakeOperationHash(int64(state.level)),
+		transactions: make([]tzkt.Operation, 0),
+		events:       make([]SimulatedEvent, 0),
+	}
+	err := run.call(signedBy.Address, target.Address, parameters)
+	if err != nil {
+		return nil, err
+	}
+	state.operations[run.hash] = run.transactions
+	return run, nil
+}
+
+// Calls the contract and then, depth first as the protocol does, the operations it
+// emits.
+func (r *fakeRun) call(sender tezos.Address, target tezos.Address, parameters micheline.Parameters) error {
+	contract, ok := r.state.contracts[target.String()]
+	if !ok {
+		return fmt.Errorf("no contract %s on the fake chain", target)
+	}
+	block := r.state.blocks[len(r.state.blocks)-1]
+	r.state.next_id += 1
+	transaction_id := r.state.next_id
+	r.transactions = append(r.transactions, tzkt.Operation{
+		Type:       "transaction",
+		Identifier: transaction_id,
+		Level:      block.Level,
+		Timestamp:  block.Timestamp,
+		Block:      block.Hash,
+		Hash:       r.hash,
+		Sender:     &tzkt.OperationParty{Address: sender.String()},
+		Target:     &tzkt.OperationParty{Address: target.String()},
+		Status:     OperationApplied,
+	})
+
+	var operations []fakeOperation
+	var err error
+	switch contract.Kind {
+	case fakeFA2:
+		operations, err = r.callFA2(contract, sender, target, parameters)
+	case fakeCustodian:
+		operations, err = r.callCustodian(contract, sender, target, parameters)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, operation := range operations {
+		if operation.Event != nil {
+			err = r.emit(target, transaction_id, block, *operation.Event)
+		} else {
+			err = r.call(target, operation.Target, operation.Parameters)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fakeRun) emit(source tezos.Address, transaction_id int64, block tzkt.Block, event SimulatedEvent) error {
+	payload, err := micheline.NewValue(micheline.NewType(event.Type), event.Payload).MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Tag, err)
+	}
+	address := source.String()
+	r.state.next_id += 1
+	r.state.events = append(r.state.events, tzkt.Event{
+		Identifier:    r.state.next_id,
+		Level:         block.Level,
+		Timestamp:     block.Timestamp,
+		Contract:      tzkt.EventContractInfo{Address: &address},
+		Tag:           event.Tag,
+		Payload:       payload,
+		TransactionID: transaction_id,
+	})
+	event.Source = source
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (c *FakeClient) CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	run, err := c.run(signedBy, target, parameters)
+	if err != nil {
+		return "", err
+	}
+	c.state = run.state
+	return run.hash, nil
+}
+
+// The fake chain has no mempool, so operations are included as soon as they are sent.
+func (c *FakeClient) InjectContractCall(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	return c.CallContract(ctx, signedBy, target, parameters)
+}
+
+// Every operation the fake chain has is already final, so this just reports what
+// happened to it.
+func (c *FakeClient) WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (OperationStatus, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	operations, ok := c.state.operations[hash]
+	if !ok {
+		return OperationStatus{}, fmt.Errorf("operation %s not found", hash)
+	}
+	return OperationStatus{
+		Hash:          hash,
+		Status:        OperationApplied,
+		Level:         int64(operations[0].Level),
+		Block:         operations[0].Block,
+		Confirmations: confirmations,
+		Errors:        make([]string, 0),
+	}, nil
+}
+
+// Runs the call and throws away the result, so there is no gas or fee estimate, just
+// the events.
+func (c *FakeClient) Simulate(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (SimulationResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	run, err := c.run(signedBy, target, parameters)
+	if err != nil {
+		return SimulationResult{}, err
+	}
+	return SimulationResult{
+		Events: run.events,
+	}, nil
+}
+
+func (c *FakeClient) Originate(ctx context.Context, signedBy Wallet, code []byte, initial_storage micheline.Prim) (Contract, error) {
+	return Contract{}, fmt.Errorf("the fake chain can not originate contracts, use AddFA2Contract or AddCustodianContract")
+}
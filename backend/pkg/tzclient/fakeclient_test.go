@@ -0,0 +1,142 @@
+package tzclient
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"blockwatch.cc/tzgo/micheline"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func fakeMint(owner string, token_id int64, amount int64) micheline.Prim {
+	return micheline.NewPair(
+		micheline.NewPair(micheline.NewString(owner), micheline.NewNat(big.NewInt(amount))),
+		micheline.NewNat(big.NewInt(token_id)),
+	)
+}
+
+func TestFakeClient(t *testing.T) {
+	oracle, _ := NewWalletWithAddress("oracle", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	fa2, _ := NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	owner := "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"
+	ctx := context.Background()
+
+	client := NewFakeClient()
+	client.AddFA2Contract(fa2, oracle.Address)
+	found, err := client.ContractByName("fa2")
+	if err != nil || !found.Address.Equal(fa2.Address) {
+		t.Errorf("Expected to find contract by name, got %v, %v", found, err)
+	}
+
+	_, err = client.CallContract(ctx, oracle, fa2, micheline.Parameters{
+		Entrypoint: "add_token_id",
+		Value:      micheline.NewSeq(micheline.NewPair(micheline.NewNat(big.NewInt(1)), micheline.NewSeq())),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add token: %v", err)
+	}
+	hash, err := client.CallContract(ctx, oracle, fa2, micheline.Parameters{
+		Entrypoint: "mint",
+		Value:      micheline.NewSeq(fakeMint(owner, 1, 10)),
+	})
+	if err != nil {
+		t.Fatalf("Failed to mint: %v", err)
+	}
+	status, err := client.WaitForConfirmation(ctx, hash, 2)
+	if err != nil || !status.IsApplied() || status.Level != 3 {
+		t.Errorf("Unexpected status %v, %v", status, err)
+	}
+	operations, err := client.GetOperationInformation(ctx, hash)
+	if err != nil || len(operations) != 1 || operations[0].Target.Address != fa2.Address.String() || operations[0].Sender.Address != oracle.Address.String() {
+		t.Errorf("Unexpected operations %v, %v", operations, err)
+	}
+
+	testcases := []struct {
+		Parameters   micheline.Parameters
+		ExpectedCode int64
+	}{
+		{
+			// The first mint is fine, but the second fails, so neither happens
+			Parameters: micheline.Parameters{
+				Entrypoint: "mint",
+				Value:      micheline.NewSeq(fakeMint(owner, 1, 5), fakeMint(owner, 2, 5)),
+			},
+			ExpectedCode: fakeFA2TokenUndefined,
+		},
+		{
+			Parameters: micheline.Parameters{
+				Entrypoint: "retire",
+				Value: micheline.NewSeq(micheline.NewPair(
+					micheline.NewPair(micheline.NewNat(big.NewInt(1)), micheline.NewBytes(nil)),
+					micheline.NewPair(micheline.NewString(owner), micheline.NewNat(big.NewInt(1))),
+				)),
+			},
+			ExpectedCode: fakeFA2PermissionsDenied,
+		},
+		{
+			Parameters: micheline.Parameters{
+				Entrypoint: "mint",
+				Value:      micheline.NewSeq(micheline.NewString("not a mint")),
+			},
+			ExpectedCode: -1,
+		},
+		{
+			Parameters: micheline.Parameters{
+				Entrypoint: "burn",
+				Value:      micheline.NewSeq(),
+			},
+			ExpectedCode: -1,
+		},
+	}
+	for index, testcase := range testcases {
+		_, err := client.CallContract(ctx, oracle, fa2, testcase.Parameters)
+		var rejection RejectedError
+		if testcase.ExpectedCode < 0 {
+			if err == nil || errors.As(err, &rejection) {
+				t.Errorf("%d: Expected invalid parameters error, got %v", index, err)
+			}
+		} else if !errors.As(err, &rejection) || rejection.With.Int.Int64() != testcase.ExpectedCode || !rejection.Contract.Equal(fa2.Address) {
+			t.Errorf("%d: Expected rejection with %d, got %v", index, testcase.ExpectedCode, err)
+		}
+	}
+
+	// Simulating gives the events without changing anything
+	wallet, _ := NewWalletWithAddress("owner", owner)
+	result, err := client.Simulate(ctx, wallet, fa2, micheline.Parameters{
+		Entrypoint: "retire",
+		Value: micheline.NewSeq(micheline.NewPair(
+			micheline.NewPair(micheline.NewNat(big.NewInt(10)), micheline.NewBytes([]byte{1})),
+			micheline.NewPair(micheline.NewString(owner), micheline.NewNat(big.NewInt(1))),
+		)),
+	})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if len(result.Events) != 1 || result.Events[0].Tag != "retire" || !result.Events[0].Source.Equal(fa2.Address) {
+		t.Errorf("Unexpected events %v", result.Events)
+	}
+	events, err := client.GetContractEvents(ctx, fa2.Address.String(), "", tzkt.QueryOptions{})
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no events after simulation, got %v, %v", events, err)
+	}
+
+	items, err := client.GetBigMapContents(ctx, 0, tzkt.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get ledger: %v", err)
+	}
+	if len(items) != 1 || string(items[0].Key) != `{"token_id":"1","token_owner":"`+owner+`"}` || string(items[0].Value) != `"10"` || items[0].FirstLevel != 3 {
+		t.Errorf("Unexpected ledger %v", items)
+	}
+	items, err = client.GetBigMapContentsAt(ctx, 0, 2)
+	if err != nil || len(items) != 0 {
+		t.Errorf("Expected empty ledger before mint, got %v, %v", items, err)
+	}
+	level, err := client.GetLevelAtTime(ctx, client.Genesis.Add(90*time.Second))
+	if err != nil || level != 2 {
+		t.Errorf("Expected level 2, got %d, %v", level, err)
+	}
+}
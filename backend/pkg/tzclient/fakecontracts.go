@@ -0,0 +1,899 @@
+package tzclient
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Error codes from src/fa2.mligo and src/custodian.mligo. pkg/x4c has the full lists
+// and their names, these are just the ones the fake contracts fail with.
+const (
+	fakeFA2TokenUndefined      int64 = 0
+	fakeFA2InsufficientBalance int64 = 1
+	fakeFA2PermissionsDenied   int64 = 10
+	fakeFA2IDAlreadyInUse      int64 = 11
+	fakeFA2Collision           int64 = 12
+
+	fakeCustodianPermissionsDenied   int64 = 0
+	fakeCustodianAddressNotFound     int64 = 1
+	fakeCustodianInsufficientBalance int64 = 2
+	fakeCustodianCallViewFailed      int64 = 3
+)
+
+// The Michelson types of the big maps and events, as LIGO compiles them, which is what
+// gives the values the field names TzKT would use.
+var (
+	fakeNatType    = micheline.NewType(micheline.NewPrim(micheline.T_NAT))
+	fakeStringType = micheline.NewType(micheline.NewPrim(micheline.T_STRING))
+	fakeBytesType  = micheline.NewType(micheline.NewPrim(micheline.T_BYTES))
+
+	fakeFA2OwnerType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "address", "annots": ["%token_owner"]},
+		{"prim": "nat", "annots": ["%token_id"]}]}`)
+	fakeTokenMetadataType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "nat", "annots": ["%token_id"]},
+		{"prim": "map", "args": [{"prim": "string"}, {"prim": "bytes"}], "annots": ["%token_info"]}]}`)
+	fakeFA2RetireType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "pair", "args": [{"prim": "nat", "annots": ["%amount"]}, {"prim": "bytes", "annots": ["%retiring_data"]}]},
+		{"prim": "pair", "args": [{"prim": "address", "annots": ["%retiring_party"]}, {"prim": "nat", "annots": ["%token_id"]}]}]}`)
+
+	fakeTokenType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "address", "annots": ["%token_address"]},
+		{"prim": "nat", "annots": ["%token_id"]}]}`)
+	fakeCustodianOwnerType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "bytes", "annots": ["%kyc"]},
+		{"prim": "pair", "args": [{"prim": "address", "annots": ["%token_address"]}, {"prim": "nat", "annots": ["%token_id"]}], "annots": ["%token"]}]}`)
+	fakeInternalTransferType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "pair", "args": [{"prim": "nat", "annots": ["%amount"]}, {"prim": "bytes", "annots": ["%destination"]}]},
+		{"prim": "pair", "args": [{"prim": "bytes", "annots": ["%source"]},
+			{"prim": "pair", "args": [{"prim": "address", "annots": ["%token_address"]}, {"prim": "nat", "annots": ["%token_id"]}], "annots": ["%token"]}]}]}`)
+	fakeInternalMintType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "int", "annots": ["%amount"]},
+		{"prim": "pair", "args": [{"prim": "nat", "annots": ["%new_total"]},
+			{"prim": "pair", "args": [{"prim": "address", "annots": ["%token_address"]}, {"prim": "nat", "annots": ["%token_id"]}], "annots": ["%token"]}]}]}`)
+	fakeCustodianRetireType = mustParseFakeType(`{"prim": "pair", "args": [
+		{"prim": "pair", "args": [{"prim": "nat", "annots": ["%amount"]}, {"prim": "bytes", "annots": ["%retiring_data"]}]},
+		{"prim": "pair", "args": [{"prim": "address", "annots": ["%retiring_party"]},
+			{"prim": "pair", "args": [{"prim": "bytes", "annots": ["%retiring_party_kyc"]},
+				{"prim": "pair", "args": [{"prim": "address", "annots": ["%token_address"]}, {"prim": "nat", "annots": ["%token_id"]}], "annots": ["%token"]}]}]}]}`)
+)
+
+func mustParseFakeType(raw string) micheline.Type {
+	var prim micheline.Prim
+	err := prim.UnmarshalJSON([]byte(raw))
+	if err != nil {
+		panic(fmt.Sprintf("invalid fake contract type: %v", err))
+	}
+	return micheline.NewType(prim)
+}
+
+// An operation emitted by a contract, which is either a call to another contract or,
+// if Event is set, an event.
+type fakeOperation struct {
+	Target     tezos.Address
+	Parameters micheline.Parameters
+	Event      *SimulatedEvent
+}
+
+// A contract being called, and who by.
+type fakeCall struct {
+	run      *fakeRun
+	contract *fakeContract
+	sender   tezos.Address
+	self     tezos.Address
+}
+
+func (c fakeCall) reject(code int64) error {
+	return RejectedError{Contract: c.self, With: micheline.NewInt64(code)}
+}
+
+func (c fakeCall) isOperator(operator fakeOperator) bool {
+	for _, existing := range c.contract.Operators {
+		if existing == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func (c fakeCall) updateOperator(operator fakeOperator, add bool) {
+	operators := make([]fakeOperator, 0, len(c.contract.Operators)+1)
+	for _, existing := range c.contract.Operators {
+		if existing != operator {
+			operators = append(operators, existing)
+		}
+	}
+	if add {
+		operators = append(operators, operator)
+	}
+	c.contract.Operators = operators
+}
+
+func (c fakeCall) balance(identifier int64, key micheline.Prim) (int64, error) {
+	value, ok, err := c.run.state.get(identifier, key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return value.Int.Int64(), nil
+}
+
+// Mirrors update_balance in the contracts, which fail with the given code if the
+// balance would go negative, and remove balances that reach zero.
+func (c fakeCall) updateBalance(identifier int64, key micheline.Prim, diff int64, code int64) error {
+	balance, err := c.balance(identifier, key)
+	if err != nil {
+		return err
+	}
+	if balance+diff < 0 {
+		return c.reject(code)
+	}
+	if balance+diff == 0 {
+		return c.run.state.update(identifier, key, nil)
+	}
+	value := micheline.NewNat(big.NewInt(balance + diff))
+	return c.run.state.update(identifier, key, &value)
+}
+
+func fakeFA2OwnerKey(owner tezos.Address, token_id int64) micheline.Prim {
+	return micheline.NewPair(micheline.NewString(owner.String()), micheline.NewNat(big.NewInt(token_id)))
+}
+
+func fakeToken(token_address tezos.Address, token_id int64) micheline.Prim {
+	return micheline.NewPair(micheline.NewString(token_address.String()), micheline.NewNat(big.NewInt(token_id)))
+}
+
+func fakeCustodianOwnerKey(kyc []byte, token_address tezos.Address, token_id int64) micheline.Prim {
+	return micheline.NewPair(micheline.NewBytes(kyc), fakeToken(token_address, token_id))
+}
+
+func fakeEvent(tag string, event_type micheline.Type, payload micheline.Prim) fakeOperation {
+	return fakeOperation{
+		Event: &SimulatedEvent{
+			Tag:     tag,
+			Type:    event_type.Prim,
+			Payload: payload,
+		},
+	}
+}
+
+// Picks apart parameters, remembering the first thing that didn't match, so that an
+// entrypoint can decode everything before checking for an error once.
+type fakeDecoder struct {
+	err error
+}
+
+func (d *fakeDecoder) fail(expected string, value micheline.Prim) {
+	if d.err == nil {
+		d.err = fmt.Errorf("expected %s, got %s", expected, value.Dump())
+	}
+}
+
+// Also accepts right combs written as a single pair, e.g. Pair a b c.
+func (d *fakeDecoder) pair(value micheline.Prim) (micheline.Prim, micheline.Prim) {
+	if value.Type == micheline.PrimSequence || value.OpCode != micheline.D_PAIR || len(value.Args) < 2 {
+		d.fail("pair", value)
+		return micheline.Prim{}, micheline.Prim{}
+	}
+	if len(value.Args) == 2 {
+		return value.Args[0], value.Args[1]
+	}
+	return value.Args[0], micheline.NewCode(micheline.D_PAIR, value.Args[1:]...)
+}
+
+func (d *fakeDecoder) list(value micheline.Prim) []micheline.Prim {
+	if value.Type != micheline.PrimSequence {
+		d.fail("list", value)
+		return nil
+	}
+	return value.Args
+}
+
+func (d *fakeDecoder) nat(value micheline.Prim) int64 {
+	if value.Type != micheline.PrimInt || value.Int == nil || value.Int.Sign() < 0 || !value.Int.IsInt64() {
+		d.fail("nat", value)
+		return 0
+	}
+	return value.Int.Int64()
+}
+
+func (d *fakeDecoder) bytes(value micheline.Prim) []byte {
+	if value.Type != micheline.PrimBytes {
+		d.fail("bytes", value)
+		return nil
+	}
+	return value.Bytes
+}
+
+func (d *fakeDecoder) address(value micheline.Prim) tezos.Address {
+	if value.Type != micheline.PrimString {
+		d.fail("address", value)
+		return tezos.Address{}
+	}
+	address, err := tezos.ParseAddress(value.String)
+	if err != nil {
+		d.fail("address", value)
+	}
+	return address
+}
+
+// A contract, ignoring any entrypoint.
+func (d *fakeDecoder) contract(value micheline.Prim) tezos.Address {
+	if value.Type == micheline.PrimString {
+		address, _, _ := strings.Cut(value.String, "%")
+		value = micheline.NewString(address)
+	}
+	return d.address(value)
+}
+
+// Returns the map rebuilt from its parts, so that it's in the form we'd build
+// ourselves.
+func (d *fakeDecoder) bytesMap(value micheline.Prim) micheline.Prim {
+	elements := make([]micheline.Prim, 0)
+	for _, element := range d.list(value) {
+		if element.OpCode != micheline.D_ELT || len(element.Args) != 2 || element.Args[0].Type != micheline.PrimString {
+			d.fail("map element", element)
+			continue
+		}
+		elements = append(elements, micheline.NewMapElem(
+			micheline.NewString(element.Args[0].String),
+			micheline.NewBytes(d.bytes(element.Args[1])),
+		))
+	}
+	return micheline.NewSeq(elements...)
+}
+
+type fakeTransferTo struct {
+	To      tezos.Address
+	TokenID int64
+	Amount  int64
+}
+
+// (pair (address %to_) (pair (nat %token_id) (nat %amount)))
+func (d *fakeDecoder) transferTo(value micheline.Prim) fakeTransferTo {
+	to, rest := d.pair(value)
+	token_id, amount := d.pair(rest)
+	return fakeTransferTo{
+		To:      d.address(to),
+		TokenID: d.nat(token_id),
+		Amount:  d.nat(amount),
+	}
+}
+
+func (d *fakeDecoder) transfersTo(value micheline.Prim) []fakeTransferTo {
+	result := make([]fakeTransferTo, 0)
+	for _, tx := range d.list(value) {
+		result = append(result, d.transferTo(tx))
+	}
+	return result
+}
+
+func (t fakeTransferTo) prim() micheline.Prim {
+	return micheline.NewPair(
+		micheline.NewString(t.To.String()),
+		micheline.NewPair(micheline.NewNat(big.NewInt(t.TokenID)), micheline.NewNat(big.NewInt(t.Amount))),
+	)
+}
+
+// The FA2 and custodian operator updates only differ in the type of the owner, so
+// Owner is the address for FA2 and the KYC for the custodian.
+type fakeOperatorUpdate struct {
+	Add      bool
+	Owner    micheline.Prim
+	Operator tezos.Address
+	TokenID  int64
+}
+
+// (or (pair %add_operator owner (pair address nat)) (pair %remove_operator ...))
+func (d *fakeDecoder) operatorUpdates(value micheline.Prim) []fakeOperatorUpdate {
+	result := make([]fakeOperatorUpdate, 0)
+	for _, update := range d.list(value) {
+		if (update.OpCode != micheline.D_LEFT && update.OpCode != micheline.D_RIGHT) || len(update.Args) != 1 {
+			d.fail("operator update", update)
+			continue
+		}
+		owner, rest := d.pair(update.Args[0])
+		operator, token_id := d.pair(rest)
+		result = append(result, fakeOperatorUpdate{
+			Add:      update.OpCode == micheline.D_LEFT,
+			Owner:    owner,
+			Operator: d.address(operator),
+			TokenID:  d.nat(token_id),
+		})
+	}
+	return result
+}
+
+func (r *fakeRun) callFA2(contract *fakeContract, sender tezos.Address, self tezos.Address, parameters micheline.Parameters) ([]fakeOperation, error) {
+	call := fakeCall{run: r, contract: contract, sender: sender, self: self}
+	var operations []fakeOperation
+	var err error
+	switch parameters.Entrypoint {
+	case "transfer":
+		err = call.fa2Transfer(parameters.Value)
+	case "balance_of":
+		err = call.fa2BalanceOf(parameters.Value)
+	case "update_operators":
+		err = call.fa2UpdateOperators(parameters.Value)
+	case "mint":
+		err = call.fa2Mint(parameters.Value)
+	case "retire":
+		operations, err = call.fa2Retire(parameters.Value)
+	case "add_token_id":
+		err = call.fa2AddTokenID(parameters.Value)
+	case "update_contract_metadata":
+		err = call.fa2UpdateContractMetadata(parameters.Value)
+	case "update_oracle":
+		err = call.fa2UpdateOracle(parameters.Value)
+	default:
+		err = fmt.Errorf("FA2 contract %s has no entrypoint %s", self, parameters.Entrypoint)
+	}
+	return operations, err
+}
+
+func (c fakeCall) fa2Transfer(value micheline.Prim) error {
+	type transfer struct {
+		From tezos.Address
+		Txs  []fakeTransferTo
+	}
+	var d fakeDecoder
+	transfers := make([]transfer, 0)
+	for _, item := range d.list(value) {
+		from, txs := d.pair(item)
+		transfers = append(transfers, transfer{From: d.address(from), Txs: d.transfersTo(txs)})
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to decode transfer parameters: %w", d.err)
+	}
+
+	for _, transfer := range transfers {
+		for _, tx := range transfer.Txs {
+			operator := fakeOperator{Owner: transfer.From.String(), Operator: c.sender.String(), TokenID: tx.TokenID}
+			if !c.sender.Equal(transfer.From) && !c.isOperator(operator) {
+				return c.reject(fakeFA2PermissionsDenied)
+			}
+			// The contract credits the destination before debiting the source
+			err := c.updateBalance(c.contract.Ledger, fakeFA2OwnerKey(tx.To, tx.TokenID), tx.Amount, fakeFA2InsufficientBalance)
+			if err != nil {
+				return err
+			}
+			err = c.updateBalance(c.contract.Ledger, fakeFA2OwnerKey(transfer.From, tx.TokenID), -tx.Amount, fakeFA2InsufficientBalance)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The fake only knows the x4c contracts, so the balances aren't sent anywhere, but
+// the request is still checked.
+func (c fakeCall) fa2BalanceOf(value micheline.Prim) error {
+	var d fakeDecoder
+	requests, callback := d.pair(value)
+	for _, request := range d.list(requests) {
+		owner, token_id := d.pair(request)
+		d.address(owner)
+		d.nat(token_id)
+	}
+	d.contract(callback)
+	if d.err != nil {
+		return fmt.Errorf("failed to decode balance_of parameters: %w", d.err)
+	}
+	return nil
+}
+
+func (c fakeCall) fa2UpdateOperators(value micheline.Prim) error {
+	var d fakeDecoder
+	updates := d.operatorUpdates(value)
+	owners := make([]tezos.Address, 0, len(updates))
+	for _, update := range updates {
+		owners = append(owners, d.address(update.Owner))
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to decode update_operators parameters: %w", d.err)
+	}
+
+	for index, update := range updates {
+		owner := owners[index]
+		if !c.sender.Equal(owner) {
+			return c.reject(fakeFA2PermissionsDenied)
+		}
+		if update.Add && update.Operator.Equal(owner) {
+			return c.reject(fakeFA2Collision)
+		}
+		c.updateOperator(fakeOperator{Owner: owner.String(), Operator: update.Operator.String(), TokenID: update.TokenID}, update.Add)
+	}
+	return nil
+}
+
+func (c fakeCall) fa2Mint(value micheline.Prim) error {
+	type mint struct {
+		Owner   tezos.Address
+		TokenID int64
+		Amount  int64
+	}
+	var d fakeDecoder
+	mints := make([]mint, 0)
+	for _, item := range d.list(value) {
+		owner_and_amount, token_id := d.pair(item)
+		owner, amount := d.pair(owner_and_amount)
+		mints = append(mints, mint{Owner: d.address(owner), TokenID: d.nat(token_id), Amount: d.nat(amount)})
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to decode mint parameters: %w", d.err)
+	}
+
+	if !c.sender.Equal(c.contract.Admin) {
+		return c.reject(fakeFA2PermissionsDenied)
+	}
+	for _, mint := range mints {
+		_, ok, err := c.run.state.get(c.contract.TokenMetadata, micheline.NewNat(big.NewInt(mint.TokenID)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return c.reject(fakeFA2TokenUndefined)
+		}
+		err = c.updateBalance(c.contract.Ledger, fakeFA2OwnerKey(mint.Owner, mint.TokenID), mint.Amount, fakeFA2InsufficientBalance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c fakeCall) fa2Retire(value micheline.Prim) ([]fakeOperation, error) {
+	type retirement struct {
+		Amount        int64
+		RetiringData  []byte
+		RetiringParty tezos.Address
+		TokenID       int64
+	}
+	var d fakeDecoder
+	retirements := make([]retirement, 0)
+	for _, item := range d.list(value) {
+		amount_and_data, party_and_token := d.pair(item)
+		amount, data := d.pair(amount_and_data)
+		party, token_id := d.pair(party_and_token)
+		retirements = append(retirements, retirement{
+			Amount:        d.nat(amount),
+			RetiringData:  d.bytes(data),
+			RetiringParty: d.address(party),
+			TokenID:       d.nat(token_id),
+		})
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode retire parameters: %w", d.err)
+	}
+
+	operations := make([]fakeOperation, 0, len(retirements))
+	for _, retirement := range retirements {
+		operator := fakeOperator{Owner: retirement.RetiringParty.String(), Operator: c.sender.String(), TokenID: retirement.TokenID}
+		if !c.sender.Equal(retirement.RetiringParty) && !c.isOperator(operator) {
+			return nil, c.reject(fakeFA2PermissionsDenied)
+		}
+		err := c.updateBalance(c.contract.Ledger, fakeFA2OwnerKey(retirement.RetiringParty, retirement.TokenID), -retirement.Amount, fakeFA2InsufficientBalance)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, fakeEvent("retire", fakeFA2RetireType, micheline.NewPair(
+			micheline.NewPair(micheline.NewNat(big.NewInt(retirement.Amount)), micheline.NewBytes(retirement.RetiringData)),
+			micheline.NewPair(micheline.NewString(retirement.RetiringParty.String()), micheline.NewNat(big.NewInt(retirement.TokenID))),
+		)))
+	}
+	return operations, nil
+}
+
+func (c fakeCall) fa2AddTokenID(value micheline.Prim) error {
+	type token struct {
+		TokenID int64
+		Info    micheline.Prim
+	}
+	var d fakeDecoder
+	tokens := make([]token, 0)
+	for _, item := range d.list(value) {
+		token_id, info := d.pair(item)
+		tokens = append(tokens, token{TokenID: d.nat(token_id), Info: d.bytesMap(info)})
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to decode add_token_id parameters: %w", d.err)
+	}
+
+	if !c.sender.Equal(c.contract.Admin) {
+		return c.reject(fakeFA2PermissionsDenied)
+	}
+	for _, token := range tokens {
+		key := micheline.NewNat(big.NewInt(token.TokenID))
+		_, ok, err := c.run.state.get(c.contract.TokenMetadata, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return c.reject(fakeFA2IDAlreadyInUse)
+		}
+		metadata := micheline.NewPair(key, token.Info)
+		err = c.run.state.update(c.contract.TokenMetadata, key, &metadata)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A big map literal is a new big map, so the contract ends up with a new metadata
+// big map rather than an updated one.
+func (c fakeCall) fa2UpdateContractMetadata(value micheline.Prim) error {
+	var d fakeDecoder
+	metadata := d.bytesMap(value)
+	if d.err != nil {
+		return fmt.Errorf("failed to decode update_contract_metadata parameters: %w", d.err)
+	}
+
+	if !c.sender.Equal(c.contract.Admin) {
+		return c.reject(fakeFA2PermissionsDenied)
+	}
+	identifier := c.run.state.newBigMap(fakeStringType, fakeBytesType)
+	for _, element := range metadata.Args {
+		entry := element.Args[1]
+		err := c.run.state.update(identifier, element.Args[0], &entry)
+		if err != nil {
+			return err
+		}
+	}
+	c.contract.Metadata = identifier
+	return nil
+}
+
+func (c fakeCall) fa2UpdateOracle(value micheline.Prim) error {
+	var d fakeDecoder
+	oracle := d.address(value)
+	if d.err != nil {
+		return fmt.Errorf("failed to decode update_oracle parameters: %w", d.err)
+	}
+	if !c.sender.Equal(c.contract.Admin) {
+		return c.reject(fakeFA2PermissionsDenied)
+	}
+	c.contract.Admin = oracle
+	return nil
+}
+
+func (r *fakeRun) callCustodian(contract *fakeContract, sender tezos.Address, self tezos.Address, parameters micheline.Parameters) ([]fakeOperation, error) {
+	call := fakeCall{run: r, contract: contract, sender: sender, self: self}
+	var operations []fakeOperation
+	var err error
+	switch parameters.Entrypoint {
+	case "internal_transfer":
+		operations, err = call.custodianInternalTransfer(parameters.Value)
+	case "internal_mint":
+		operations, err = call.custodianInternalMint(parameters.Value)
+	case "external_transfer":
+		operations, err = call.custodianExternalTransfer(parameters.Value)
+	case "update_internal_operators":
+		err = call.custodianUpdateOperators(parameters.Value)
+	case "retire":
+		operations, err = call.custodianRetire(parameters.Value)
+	case "update_custodian":
+		err = call.custodianUpdateCustodian(parameters.Value)
+	default:
+		err = fmt.Errorf("custodian contract %s has no entrypoint %s", self, parameters.Entrypoint)
+	}
+	return operations, err
+}
+
+// The custodian can do anything, and operators can act for a KYC on a token.
+func (c fakeCall) custodianAllows(kyc []byte, token_id int64) bool {
+	operator := fakeOperator{Owner: hex.EncodeToString(kyc), Operator: c.sender.String(), TokenID: token_id}
+	return c.sender.Equal(c.contract.Admin) || c.isOperator(operator)
+}
+
+// Finds the FA2 contract the custodian wants to call, which must be one we know.
+func (c fakeCall) fa2Contract(token_address tezos.Address) (*fakeContract, bool) {
+	contract, ok := c.run.state.contracts[token_address.String()]
+	return contract, ok && contract.Kind == fakeFA2
+}
+
+func (c fakeCall) custodianInternalTransfer(value micheline.Prim) ([]fakeOperation, error) {
+	type destination struct {
+		To      []byte
+		TokenID int64
+		Amount  int64
+	}
+	type transfer struct {
+		From         []byte
+		TokenAddress tezos.Address
+		Txs          []destination
+	}
+	var d fakeDecoder
+	transfers := make([]transfer, 0)
+	for _, item := range d.list(value) {
+		from, rest := d.pair(item)
+		token_address, txs := d.pair(rest)
+		decoded := transfer{From: d.bytes(from), TokenAddress: d.address(token_address), Txs: make([]destination, 0)}
+		for _, tx := range d.list(txs) {
+			to, rest := d.pair(tx)
+			token_id, amount := d.pair(rest)
+			decoded.Txs = append(decoded.Txs, destination{To: d.bytes(to), TokenID: d.nat(token_id), Amount: d.nat(amount)})
+		}
+		transfers = append(transfers, decoded)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode internal_transfer parameters: %w", d.err)
+	}
+
+	operations := make([]fakeOperation, 0)
+	for _, transfer := range transfers {
+		for _, tx := range transfer.Txs {
+			if !c.custodianAllows(transfer.From, tx.TokenID) {
+				return nil, c.reject(fakeCustodianPermissionsDenied)
+			}
+			err := c.updateBalance(c.contract.Ledger, fakeCustodianOwnerKey(tx.To, transfer.TokenAddress, tx.TokenID), tx.Amount, fakeCustodianInsufficientBalance)
+			if err != nil {
+				return nil, err
+			}
+			err = c.updateBalance(c.contract.Ledger, fakeCustodianOwnerKey(transfer.From, transfer.TokenAddress, tx.TokenID), -tx.Amount, fakeCustodianInsufficientBalance)
+			if err != nil {
+				return nil, err
+			}
+			operations = append(operations, fakeEvent("internal_transfer", fakeInternalTransferType, micheline.NewPair(
+				micheline.NewPair(micheline.NewNat(big.NewInt(tx.Amount)), micheline.NewBytes(tx.To)),
+				micheline.NewPair(micheline.NewBytes(transfer.From), fakeToken(transfer.TokenAddress, tx.TokenID)),
+			)))
+		}
+	}
+	return operations, nil
+}
+
+// Credits the custodian's own "self" KYC with whatever it holds on the FA2 contracts
+// that it doesn't have in its external ledger yet.
+func (c fakeCall) custodianInternalMint(value micheline.Prim) ([]fakeOperation, error) {
+	type mint struct {
+		TokenAddress tezos.Address
+		TokenID      int64
+		Amount       int64
+		NewTotal     int64
+	}
+	var d fakeDecoder
+	mints := make([]mint, 0)
+	for _, item := range d.list(value) {
+		token_address, token_id := d.pair(item)
+		mints = append(mints, mint{TokenAddress: d.address(token_address), TokenID: d.nat(token_id)})
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode internal_mint parameters: %w", d.err)
+	}
+
+	if !c.sender.Equal(c.contract.Admin) {
+		return nil, c.reject(fakeCustodianPermissionsDenied)
+	}
+	// As in the contract, all the balances are looked up before any are changed
+	for index, mint := range mints {
+		fa2, ok := c.fa2Contract(mint.TokenAddress)
+		if !ok {
+			return nil, c.reject(fakeCustodianCallViewFailed)
+		}
+		external_balance, err := c.balance(fa2.Ledger, fakeFA2OwnerKey(c.self, mint.TokenID))
+		if err != nil {
+			return nil, err
+		}
+		internal_balance, err := c.balance(c.contract.ExternalLedger, fakeToken(mint.TokenAddress, mint.TokenID))
+		if err != nil {
+			return nil, err
+		}
+		if external_balance < internal_balance {
+			return nil, c.reject(fakeCustodianInsufficientBalance)
+		}
+		mints[index].Amount = external_balance - internal_balance
+		mints[index].NewTotal = external_balance
+	}
+
+	self_kyc := micheline.NewString("self").Pack()
+	operations := make([]fakeOperation, 0)
+	for _, mint := range mints {
+		err := c.updateBalance(c.contract.Ledger, fakeCustodianOwnerKey(self_kyc, mint.TokenAddress, mint.TokenID), mint.Amount, fakeCustodianInsufficientBalance)
+		if err != nil {
+			return nil, err
+		}
+		err = c.updateBalance(c.contract.ExternalLedger, fakeToken(mint.TokenAddress, mint.TokenID), mint.Amount, fakeCustodianInsufficientBalance)
+		if err != nil {
+			return nil, err
+		}
+		if mint.Amount != 0 {
+			operations = append(operations, fakeEvent("internal_mint", fakeInternalMintType, micheline.NewPair(
+				micheline.NewInt64(mint.Amount),
+				micheline.NewPair(micheline.NewNat(big.NewInt(mint.NewTotal)), fakeToken(mint.TokenAddress, mint.TokenID)),
+			)))
+		}
+	}
+	return operations, nil
+}
+
+func (c fakeCall) custodianExternalTransfer(value micheline.Prim) ([]fakeOperation, error) {
+	type batch struct {
+		From []byte
+		Txs  []fakeTransferTo
+	}
+	type transfer struct {
+		TokenAddress tezos.Address
+		Batches      []batch
+	}
+	var d fakeDecoder
+	transfers := make([]transfer, 0)
+	for _, item := range d.list(value) {
+		token_address, batches := d.pair(item)
+		decoded := transfer{TokenAddress: d.address(token_address), Batches: make([]batch, 0)}
+		for _, batch_item := range d.list(batches) {
+			from, txs := d.pair(batch_item)
+			decoded.Batches = append(decoded.Batches, batch{From: d.bytes(from), Txs: d.transfersTo(txs)})
+		}
+		transfers = append(transfers, decoded)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode external_transfer parameters: %w", d.err)
+	}
+
+	if !c.sender.Equal(c.contract.Admin) {
+		return nil, c.reject(fakeCustodianPermissionsDenied)
+	}
+	for _, transfer := range transfers {
+		for _, batch := range transfer.Batches {
+			for _, tx := range batch.Txs {
+				err := c.updateBalance(c.contract.Ledger, fakeCustodianOwnerKey(batch.From, transfer.TokenAddress, tx.TokenID), -tx.Amount, fakeCustodianInsufficientBalance)
+				if err != nil {
+					return nil, err
+				}
+				err = c.updateBalance(c.contract.ExternalLedger, fakeToken(transfer.TokenAddress, tx.TokenID), -tx.Amount, fakeCustodianInsufficientBalance)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// Then each FA2 contract is asked to move the tokens out of the custodian's account
+	operations := make([]fakeOperation, 0, len(transfers))
+	for _, transfer := range transfers {
+		if _, ok := c.fa2Contract(transfer.TokenAddress); !ok {
+			return nil, c.reject(fakeCustodianAddressNotFound)
+		}
+		batches := make([]micheline.Prim, 0, len(transfer.Batches))
+		for _, batch := range transfer.Batches {
+			txs := make([]micheline.Prim, 0, len(batch.Txs))
+			for _, tx := range batch.Txs {
+				txs = append(txs, tx.prim())
+			}
+			batches = append(batches, micheline.NewPair(micheline.NewString(c.self.String()), micheline.NewSeq(txs...)))
+		}
+		operations = append(operations, fakeOperation{
+			Target: transfer.TokenAddress,
+			Parameters: micheline.Parameters{
+				Entrypoint: "transfer",
+				Value:      micheline.NewSeq(batches...),
+			},
+		})
+	}
+	return operations, nil
+}
+
+func (c fakeCall) custodianUpdateOperators(value micheline.Prim) error {
+	var d fakeDecoder
+	updates := d.operatorUpdates(value)
+	owners := make([][]byte, 0, len(updates))
+	for _, update := range updates {
+		owners = append(owners, d.bytes(update.Owner))
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to decode update_internal_operators parameters: %w", d.err)
+	}
+
+	if !c.sender.Equal(c.contract.Admin) {
+		return c.reject(fakeCustodianPermissionsDenied)
+	}
+	for index, update := range updates {
+		c.updateOperator(fakeOperator{Owner: hex.EncodeToString(owners[index]), Operator: update.Operator.String(), TokenID: update.TokenID}, update.Add)
+	}
+	return nil
+}
+
+func (c fakeCall) custodianRetire(value micheline.Prim) ([]fakeOperation, error) {
+	type retirement struct {
+		Amount       int64
+		RetiringData []byte
+		KYC          []byte
+		TokenID      int64
+	}
+	type retirements struct {
+		TokenAddress tezos.Address
+		Txs          []retirement
+	}
+	var d fakeDecoder
+	groups := make([]retirements, 0)
+	for _, item := range d.list(value) {
+		token_address, txs := d.pair(item)
+		group := retirements{TokenAddress: d.address(token_address), Txs: make([]retirement, 0)}
+		for _, tx := range d.list(txs) {
+			amount_and_data, kyc_and_token := d.pair(tx)
+			amount, data := d.pair(amount_and_data)
+			kyc, token_id := d.pair(kyc_and_token)
+			group.Txs = append(group.Txs, retirement{
+				Amount:       d.nat(amount),
+				RetiringData: d.bytes(data),
+				KYC:          d.bytes(kyc),
+				TokenID:      d.nat(token_id),
+			})
+		}
+		groups = append(groups, group)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode retire parameters: %w", d.err)
+	}
+
+	for _, group := range groups {
+		for _, tx := range group.Txs {
+			if !c.custodianAllows(tx.KYC, tx.TokenID) {
+				return nil, c.reject(fakeCustodianPermissionsDenied)
+			}
+			err := c.updateBalance(c.contract.Ledger, fakeCustodianOwnerKey(tx.KYC, group.TokenAddress, tx.TokenID), -tx.Amount, fakeCustodianInsufficientBalance)
+			if err != nil {
+				return nil, err
+			}
+			err = c.updateBalance(c.contract.ExternalLedger, fakeToken(group.TokenAddress, tx.TokenID), -tx.Amount, fakeCustodianInsufficientBalance)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The FA2 contracts retire the tokens from the custodian's account, and then the
+	// custodian emits an event for each retirement
+	operations := make([]fakeOperation, 0)
+	for _, group := range groups {
+		if _, ok := c.fa2Contract(group.TokenAddress); !ok {
+			return nil, c.reject(fakeCustodianAddressNotFound)
+		}
+		txs := make([]micheline.Prim, 0, len(group.Txs))
+		for _, tx := range group.Txs {
+			txs = append(txs, micheline.NewPair(
+				micheline.NewPair(micheline.NewNat(big.NewInt(tx.Amount)), micheline.NewBytes(tx.RetiringData)),
+				micheline.NewPair(micheline.NewString(c.self.String()), micheline.NewNat(big.NewInt(tx.TokenID))),
+			))
+		}
+		operations = append(operations, fakeOperation{
+			Target: group.TokenAddress,
+			Parameters: micheline.Parameters{
+				Entrypoint: "retire",
+				Value:      micheline.NewSeq(txs...),
+			},
+		})
+	}
+	for _, group := range groups {
+		for _, tx := range group.Txs {
+			// The retiring party is whoever signed the operation
+			operations = append(operations, fakeEvent("retire", fakeCustodianRetireType, micheline.NewPair(
+				micheline.NewPair(micheline.NewNat(big.NewInt(tx.Amount)), micheline.NewBytes(tx.RetiringData)),
+				micheline.NewPair(
+					micheline.NewString(c.run.source.String()),
+					micheline.NewPair(micheline.NewBytes(tx.KYC), fakeToken(group.TokenAddress, tx.TokenID)),
+				),
+			)))
+		}
+	}
+	return operations, nil
+}
+
+func (c fakeCall) custodianUpdateCustodian(value micheline.Prim) error {
+	var d fakeDecoder
+	custodian := d.address(value)
+	if d.err != nil {
+		return fmt.Errorf("failed to decode update_custodian parameters: %w", d.err)
+	}
+	if !c.sender.Equal(c.contract.Admin) {
+		return c.reject(fakeCustodianPermissionsDenied)
+	}
+	c.contract.Admin = custodian
+	return nil
+}
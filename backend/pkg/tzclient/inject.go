@@ -0,0 +1,25 @@
+package tzclient
+
+import (
+	"context"
+
+	"blockwatch.cc/tzgo/micheline"
+)
+
+// InjectingClient wraps another client so that contract calls return as soon as the
+// node has accepted the operation, rather than once it has been confirmed. It is for
+// code that waits for its operations itself, such as the server's job queue, which
+// must then wait for each before sending the next from the same wallet.
+type InjectingClient struct {
+	TezosClient
+}
+
+func NewInjectingClient(client TezosClient) *InjectingClient {
+	return &InjectingClient{
+		TezosClient: client,
+	}
+}
+
+func (c *InjectingClient) CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	return c.TezosClient.InjectContractCall(ctx, signedBy, target, parameters)
+}
@@ -0,0 +1,224 @@
+package tzclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"blockwatch.cc/tzgo/tezos"
+	"golang.org/x/term"
+)
+
+// PassphraseFunc returns the passphrase for the named wallet's encrypted secret key.
+type PassphraseFunc func(name string) ([]byte, error)
+
+// KeyStore is somewhere that wallets, with or without secret keys, are loaded from.
+type KeyStore interface {
+	LoadWallets() (map[string]Wallet, error)
+}
+
+// Parses a secret key as tezos-client stores it, with an unencrypted: or encrypted:
+// prefix, or as a bare key. An encrypted key can only be left locked until it is used
+// if we know the wallet's address already, otherwise it is decrypted now to find it.
+func walletFromSecretKey(name string, value string, address *tezos.Address, passphrase PassphraseFunc) (Wallet, error) {
+	scheme, key, found := strings.Cut(value, ":")
+	if !found {
+		key = value
+		if tezos.IsEncryptedKey(value) {
+			scheme = "encrypted"
+		} else {
+			scheme = "unencrypted"
+		}
+	}
+	switch scheme {
+	case "unencrypted":
+		return NewWalletWithPrivateKey(name, key)
+	case "encrypted":
+		if address != nil {
+			return NewWalletWithEncryptedPrivateKey(name, *address, key, passphrase)
+		}
+		wallet := Wallet{Name: name, locked: &lockedKey{encrypted: key, passphrase: passphrase}}
+		private_key, err := wallet.locked.unlock(name)
+		if err != nil {
+			return Wallet{}, err
+		}
+		wallet.Address = private_key.Address()
+		return wallet, nil
+	default:
+		return Wallet{}, fmt.Errorf("secret key for %s is stored as %s, only unencrypted and encrypted keys are supported", name, scheme)
+	}
+}
+
+func readTezosClientValues(path string) ([]tezosClientValue, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	var values []tezosClientValue
+	err = json.Unmarshal(content, &values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// TezosClientKeyStore loads the wallets in a tezos-client (or octez-client) directory,
+// both those with secret keys and those it only knows the address of.
+type TezosClientKeyStore struct {
+	Path       string
+	Passphrase PassphraseFunc
+}
+
+func (s TezosClientKeyStore) LoadWallets() (map[string]Wallet, error) {
+	// tezos-client has redundent information stored - both the address/hash and public key
+	// can be derived from the secret key, but for encrypted keys we need the address from
+	// the hashes to avoid decrypting them here.
+	hashes, err := readTezosClientValues(filepath.Join(s.Path, "public_key_hashs"))
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[string]tezos.Address, len(hashes))
+	for _, hash := range hashes {
+		wallet, err := NewWalletWithAddress(hash.Name, hash.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse hash for %s: %w", hash.Name, err)
+		}
+		addresses[hash.Name] = wallet.Address
+	}
+
+	secret_keys, err := readTezosClientValues(filepath.Join(s.Path, "secret_keys"))
+	if err != nil {
+		return nil, err
+	}
+	wallets := make(map[string]Wallet, len(hashes))
+	for _, key := range secret_keys {
+		var address *tezos.Address
+		if known, ok := addresses[key.Name]; ok {
+			address = &known
+		}
+		wallet, err := walletFromSecretKey(key.Name, key.Value, address, s.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key for %s: %w", key.Name, err)
+		}
+		wallets[key.Name] = wallet
+	}
+	for name, address := range addresses {
+		if _, ok := wallets[name]; !ok {
+			wallets[name] = Wallet{Name: name, Address: address}
+		}
+	}
+	return wallets, nil
+}
+
+// KeyFileStore loads wallets from a single JSON file in the same format as
+// tezos-client's secret_keys file.
+type KeyFileStore struct {
+	Path       string
+	Passphrase PassphraseFunc
+}
+
+func (s KeyFileStore) LoadWallets() (map[string]Wallet, error) {
+	secret_keys, err := readTezosClientValues(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	wallets := make(map[string]Wallet, len(secret_keys))
+	for _, key := range secret_keys {
+		wallet, err := walletFromSecretKey(key.Name, key.Value, nil, s.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key for %s: %w", key.Name, err)
+		}
+		wallets[key.Name] = wallet
+	}
+	return wallets, nil
+}
+
+// EnvKeyStore loads wallets from environment variables named Prefix followed by the
+// wallet name in upper case, either holding the secret key, or, with a _FILE suffix,
+// the path of a file holding it, such as a mounted Kubernetes secret. Wallet names are
+// the lower case version of the variable name.
+type EnvKeyStore struct {
+	Prefix     string
+	Passphrase PassphraseFunc
+
+	// For testing, defaults to os.Environ
+	environ func() []string
+}
+
+func (s EnvKeyStore) LoadWallets() (map[string]Wallet, error) {
+	environ := s.environ
+	if environ == nil {
+		environ = os.Environ
+	}
+	// Sorted so that a name given both directly and as a file is always resolved the
+	// same way, with the file winning
+	variables := environ()
+	sort.Strings(variables)
+
+	wallets := make(map[string]Wallet)
+	for _, variable := range variables {
+		key, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, s.Prefix) || value == "" {
+			continue
+		}
+		name := strings.TrimPrefix(key, s.Prefix)
+		if strings.HasSuffix(name, "_FILE") {
+			name = strings.TrimSuffix(name, "_FILE")
+			content, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", key, err)
+			}
+			value = strings.TrimSpace(string(content))
+		}
+		if name == "" {
+			continue
+		}
+		name = strings.ToLower(name)
+		wallet, err := walletFromSecretKey(name, value, nil, s.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key in %s: %w", key, err)
+		}
+		wallets[name] = wallet
+	}
+	return wallets, nil
+}
+
+// DefaultPassphrase gets passphrases from X4C_KEY_PASSPHRASE, or the file named by
+// X4C_KEY_PASSPHRASE_FILE, and otherwise asks for them on the terminal if there is
+// one.
+func DefaultPassphrase(name string) ([]byte, error) {
+	if passphrase := os.Getenv("X4C_KEY_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	if path := os.Getenv("X4C_KEY_PASSPHRASE_FILE"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		return []byte(strings.TrimRight(string(content), "\r\n")), nil
+	}
+	return terminalPassphrase(name)
+}
+
+func terminalPassphrase(name string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("key for %s is encrypted, set X4C_KEY_PASSPHRASE or X4C_KEY_PASSPHRASE_FILE or run interactively", name)
+	}
+	defer tty.Close()
+	if !term.IsTerminal(int(tty.Fd())) {
+		return nil, fmt.Errorf("key for %s is encrypted, set X4C_KEY_PASSPHRASE or X4C_KEY_PASSPHRASE_FILE or run interactively", name)
+	}
+
+	fmt.Fprintf(tty, "Enter password for encrypted key \"%s\": ", name)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintf(tty, "\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,292 @@
+package tzclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+)
+
+func generateTestKey(t *testing.T, passphrase string) (tezos.PrivateKey, string) {
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	encrypted, err := key.Encrypt(func() ([]byte, error) { return []byte(passphrase), nil })
+	if err != nil {
+		t.Fatalf("Failed to encrypt key: %v", err)
+	}
+	return key, encrypted
+}
+
+type passphraseCounter struct {
+	passphrase string
+	calls      int
+}
+
+func (p *passphraseCounter) get(name string) ([]byte, error) {
+	p.calls += 1
+	return []byte(p.passphrase), nil
+}
+
+func TestWalletFromSecretKey(t *testing.T) {
+	key, encrypted := generateTestKey(t, "hunter2")
+	address := key.Address()
+
+	testcases := []struct {
+		Value        string
+		Address      *tezos.Address
+		Passphrase   string
+		LoadCalls    int
+		ExpectError  bool
+		ExpectUnlock bool
+	}{
+		{
+			Value: "unencrypted:" + key.String(),
+		},
+		{
+			Value: key.String(),
+		},
+		{
+			// With the address known, the key is left locked until used
+			Value:      "encrypted:" + encrypted,
+			Address:    &address,
+			Passphrase: "hunter2",
+		},
+		{
+			Value:      encrypted,
+			Passphrase: "hunter2",
+			LoadCalls:  1,
+		},
+		{
+			Value:        "encrypted:" + encrypted,
+			Address:      &address,
+			Passphrase:   "wrong",
+			ExpectUnlock: true,
+		},
+		{
+			Value:       "encrypted:" + encrypted,
+			Passphrase:  "wrong",
+			ExpectError: true,
+		},
+		{
+			Value:       "ledger://some-ledger/ed25519/0h/0h",
+			ExpectError: true,
+		},
+		{
+			Value:       "unencrypted:invalid",
+			ExpectError: true,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		passphrase := passphraseCounter{passphrase: testcase.Passphrase}
+		wallet, err := walletFromSecretKey("test", testcase.Value, testcase.Address, passphrase.get)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error", idx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", idx, err)
+			continue
+		}
+		if passphrase.calls != testcase.LoadCalls {
+			t.Errorf("%d: Expected %d passphrase requests on load, got %d", idx, testcase.LoadCalls, passphrase.calls)
+		}
+		if !wallet.Address.Equal(address) {
+			t.Errorf("%d: Expected address %v, got %v", idx, address, wallet.Address)
+		}
+		if !wallet.HasSecretKey() {
+			t.Errorf("%d: Expected wallet to have a secret key", idx)
+		}
+
+		unlocked, err := wallet.SecretKey()
+		if testcase.ExpectUnlock {
+			if err == nil {
+				t.Errorf("%d: Expected unlock to fail", idx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected unlock error: %v", idx, err)
+			continue
+		}
+		if unlocked.String() != key.String() {
+			t.Errorf("%d: Unlocked the wrong key", idx)
+		}
+		// Asking again shouldn't ask for the passphrase again
+		calls := passphrase.calls
+		_, _ = wallet.SecretKey()
+		if passphrase.calls != calls {
+			t.Errorf("%d: Passphrase was requested again", idx)
+		}
+	}
+}
+
+func TestWalletSecretKeyMismatch(t *testing.T) {
+	_, encrypted := generateTestKey(t, "hunter2")
+	other, _ := generateTestKey(t, "hunter2")
+	wallet, err := NewWalletWithEncryptedPrivateKey("test", other.Address(), encrypted, func(string) ([]byte, error) {
+		return []byte("hunter2"), nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = wallet.SecretKey()
+	if err == nil {
+		t.Errorf("Expected error for key not matching address")
+	}
+}
+
+func writeTezosClientValues(t *testing.T, path string, values []tezosClientValue) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", path, err)
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestTezosClientKeyStore(t *testing.T) {
+	plain, _ := generateTestKey(t, "unused")
+	locked, encrypted := generateTestKey(t, "hunter2")
+	remote := "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"
+
+	dir := t.TempDir()
+	writeTezosClientValues(t, filepath.Join(dir, "secret_keys"), []tezosClientValue{
+		{Name: "plain", Value: "unencrypted:" + plain.String()},
+		{Name: "locked", Value: "encrypted:" + encrypted},
+	})
+	writeTezosClientValues(t, filepath.Join(dir, "public_key_hashs"), []tezosClientValue{
+		{Name: "plain", Value: plain.Address().String()},
+		{Name: "locked", Value: locked.Address().String()},
+		{Name: "remote", Value: remote},
+	})
+
+	passphrase := passphraseCounter{passphrase: "hunter2"}
+	wallets, err := TezosClientKeyStore{Path: dir, Passphrase: passphrase.get}.LoadWallets()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(wallets) != 3 {
+		t.Fatalf("Expected 3 wallets, got %v", wallets)
+	}
+	if passphrase.calls != 0 {
+		t.Errorf("Expected no passphrase requests on load, got %d", passphrase.calls)
+	}
+	if wallets["plain"].Key == nil {
+		t.Errorf("Expected plain wallet to have its key")
+	}
+	if wallets["remote"].HasSecretKey() || wallets["remote"].Address.String() != remote {
+		t.Errorf("Unexpected remote wallet %v", wallets["remote"])
+	}
+	key, err := wallets["locked"].SecretKey()
+	if err != nil {
+		t.Fatalf("Failed to unlock key: %v", err)
+	}
+	if key.String() != locked.String() {
+		t.Errorf("Unlocked the wrong key")
+	}
+}
+
+func TestKeyFileStore(t *testing.T) {
+	key, _ := generateTestKey(t, "unused")
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeTezosClientValues(t, path, []tezosClientValue{
+		{Name: "oracle", Value: "unencrypted:" + key.String()},
+	})
+
+	wallets, err := KeyFileStore{Path: path}.LoadWallets()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !wallets["oracle"].Address.Equal(key.Address()) {
+		t.Errorf("Unexpected wallets %v", wallets)
+	}
+
+	_, err = KeyFileStore{Path: path + ".missing"}.LoadWallets()
+	if err == nil {
+		t.Errorf("Expected error for missing key file")
+	}
+}
+
+func TestEnvKeyStore(t *testing.T) {
+	operator, _ := generateTestKey(t, "unused")
+	oracle, encrypted := generateTestKey(t, "hunter2")
+	overridden, _ := generateTestKey(t, "unused")
+
+	path := filepath.Join(t.TempDir(), "oracle")
+	err := os.WriteFile(path, []byte(encrypted+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	store := EnvKeyStore{
+		Prefix: "X4C_SECRET_KEY_",
+		Passphrase: func(string) ([]byte, error) {
+			return []byte("hunter2"), nil
+		},
+		environ: func() []string {
+			return []string{
+				"HOME=/root",
+				"X4C_SECRET_KEY_ORACLE_FILE=" + path,
+				"X4C_SECRET_KEY_OPERATOR=unencrypted:" + operator.String(),
+				"X4C_SECRET_KEY_ORACLE=" + overridden.String(),
+				"X4C_SECRET_KEY_EMPTY=",
+			}
+		},
+	}
+	wallets, err := store.LoadWallets()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(wallets) != 2 {
+		t.Fatalf("Expected 2 wallets, got %v", wallets)
+	}
+	if !wallets["operator"].Address.Equal(operator.Address()) {
+		t.Errorf("Unexpected operator wallet %v", wallets["operator"])
+	}
+	if !wallets["oracle"].Address.Equal(oracle.Address()) {
+		t.Errorf("Expected the oracle key from the file, got %v", wallets["oracle"])
+	}
+
+	store.environ = func() []string {
+		return []string{fmt.Sprintf("X4C_SECRET_KEY_BAD_FILE=%s.missing", path)}
+	}
+	_, err = store.LoadWallets()
+	if err == nil {
+		t.Errorf("Expected error for missing key file")
+	}
+}
+
+func TestLoadClientWithEnvironmentKeys(t *testing.T) {
+	key, _ := generateTestKey(t, "unused")
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "config"), []byte(`{"endpoint": "https://rpc.ghostnet.teztnets.xyz"}`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	writeTezosClientValues(t, filepath.Join(dir, "secret_keys"), []tezosClientValue{})
+	writeTezosClientValues(t, filepath.Join(dir, "public_key_hashs"), []tezosClientValue{
+		{Name: "operator", Value: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},
+	})
+	t.Setenv("X4C_SECRET_KEY_OPERATOR", key.String())
+	t.Setenv("X4C_CONFIG", filepath.Join(dir, "missing.yaml"))
+	stubChainID(t, "NetXnHfVqm9iesp")
+
+	client, err := LoadClient(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	operator := client.Wallets["operator"]
+	if !operator.Address.Equal(key.Address()) || operator.Key == nil {
+		t.Errorf("Expected environment key to replace tezos-client wallet, got %v", operator)
+	}
+}
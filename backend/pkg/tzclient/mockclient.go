@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"time"
 
 	"blockwatch.cc/tzgo/micheline"
 
@@ -17,11 +18,32 @@ type MockClient struct {
 	ShouldError bool
 	Storage     interface{}
 	Items       map[int64][]tzkt.BigMapItem
+
+	// Storage for specific contract addresses, for tests that read more than one
+	// contract. Contracts not in here get Storage.
+	ContractStorage map[string]interface{}
+
+	// Big map contents at past levels, and the blocks used to turn times into levels
+	HistoricalItems map[int64]map[int32][]tzkt.BigMapItem
+	Blocks          []tzkt.Block
+
+	// Events filtered by tag and the query options, and by contract if the event
+	// has one
+	Events []tzkt.Event
+
+	// Operations by hash
+	Operations map[string][]tzkt.Operation
+
+	// If set, contract calls and simulations fail with this error, which lets
+	// tests check how specific chain errors are handled
+	CallError error
 }
 
 func NewMockClient() MockClient {
 	return MockClient{
-		Items: make(map[int64][]tzkt.BigMapItem),
+		Items:           make(map[int64][]tzkt.BigMapItem),
+		ContractStorage: make(map[string]interface{}),
+		HistoricalItems: make(map[int64]map[int32][]tzkt.BigMapItem),
 	}
 }
 
@@ -29,6 +51,13 @@ func (c *MockClient) AddBigMap(identifier int64, items []tzkt.BigMapItem) {
 	c.Items[identifier] = items
 }
 
+func (c *MockClient) AddHistoricalBigMap(identifier int64, level int32, items []tzkt.BigMapItem) {
+	if _, ok := c.HistoricalItems[identifier]; !ok {
+		c.HistoricalItems[identifier] = make(map[int32][]tzkt.BigMapItem)
+	}
+	c.HistoricalItems[identifier][level] = items
+}
+
 func (c MockClient) ContractByName(name string) (Contract, error) {
 	return Contract{}, fmt.Errorf("contract not found")
 }
@@ -41,10 +70,14 @@ func (c MockClient) GetContractStorage(target Contract, ctx context.Context, sto
 	if c.ShouldError {
 		return fmt.Errorf("Test should fail")
 	}
-	if c.Storage != nil {
+	source := c.Storage
+	if contract_storage, ok := c.ContractStorage[target.Address.String()]; ok {
+		source = contract_storage
+	}
+	if source != nil {
 		t := reflect.TypeOf(storage)
-		if (t == reflect.TypeOf(c.Storage)) && (t.Kind() == reflect.Ptr) {
-			src := reflect.ValueOf(c.Storage).Elem()
+		if (t == reflect.TypeOf(source)) && (t.Kind() == reflect.Ptr) {
+			src := reflect.ValueOf(source).Elem()
 			dst := reflect.ValueOf(storage).Elem()
 			inner_type := reflect.TypeOf(src.Interface())
 			for i := 0; i < src.NumField(); i++ {
@@ -59,12 +92,18 @@ func (c MockClient) GetContractStorage(target Contract, ctx context.Context, sto
 	return nil
 }
 
-func (c MockClient) GetBigMapContents(ctx context.Context, identifier int64) ([]tzkt.BigMapItem, error) {
+func (c MockClient) GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error) {
 	if c.ShouldError {
 		return nil, fmt.Errorf("Test should fail")
 	}
 	if items, ok := c.Items[identifier]; ok {
-		return items, nil
+		filtered := make([]tzkt.BigMapItem, 0, len(items))
+		for _, item := range items {
+			if options.MatchesBigMapItem(item) {
+				filtered = append(filtered, item)
+			}
+		}
+		return tzkt.ApplyOrderAndLimit(options, filtered), nil
 	} else {
 		// The tzkt API returns empty list if you ask for an invalid ID
 		// Though you could also argue we should return other garbage here, as that's also
@@ -73,27 +112,127 @@ func (c MockClient) GetBigMapContents(ctx context.Context, identifier int64) ([]
 	}
 }
 
-func (c MockClient) GetContractEvents(ctx context.Context, contractAddress string, tag string) ([]tzkt.Event, error) {
+// Returns the contents from the most recent level at or before the one asked for.
+func (c MockClient) GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error) {
 	if c.ShouldError {
 		return nil, fmt.Errorf("Test should fail")
 	}
-	return nil, nil
+	var found int32
+	items := make([]tzkt.BigMapItem, 0)
+	for item_level, item_contents := range c.HistoricalItems[identifier] {
+		if item_level <= level && item_level >= found {
+			found = item_level
+			items = item_contents
+		}
+	}
+	return items, nil
+}
+
+func (c MockClient) GetLevelAtTime(ctx context.Context, at time.Time) (int32, error) {
+	if c.ShouldError {
+		return 0, fmt.Errorf("Test should fail")
+	}
+	var level int32
+	for _, block := range c.Blocks {
+		if !block.Timestamp.After(at) && block.Level > level {
+			level = block.Level
+		}
+	}
+	if level == 0 {
+		return 0, fmt.Errorf("no blocks at or before %v", at)
+	}
+	return level, nil
+}
+
+// The head is the newest of the Blocks, or level zero if there are none.
+func (c MockClient) GetHead(ctx context.Context) (tzkt.Head, error) {
+	if c.ShouldError {
+		return tzkt.Head{}, fmt.Errorf("Test should fail")
+	}
+	var head tzkt.Head
+	for _, block := range c.Blocks {
+		if block.Level > head.Level {
+			head = tzkt.Head{Level: block.Level, Hash: block.Hash, Timestamp: block.Timestamp}
+		}
+	}
+	return head, nil
+}
+
+func (c MockClient) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	if c.ShouldError {
+		return nil, fmt.Errorf("Test should fail")
+	}
+	events := make([]tzkt.Event, 0)
+	for _, event := range c.Events {
+		if event.Contract.Address != nil && *event.Contract.Address != contractAddress {
+			continue
+		}
+		if (tag == "" || event.Tag == tag) && options.MatchesEvent(event) {
+			events = append(events, event)
+		}
+	}
+	return tzkt.ApplyOrderAndLimit(options, events), nil
 }
 
 func (c MockClient) GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error) {
 	if c.ShouldError {
 		return nil, fmt.Errorf("Test should fail")
 	}
-	return nil, nil
+	return c.Operations[hash], nil
+}
+
+func (c MockClient) GetTransactionByID(ctx context.Context, identifier int64) (tzkt.Operation, error) {
+	if c.ShouldError {
+		return tzkt.Operation{}, fmt.Errorf("Test should fail")
+	}
+	for _, operations := range c.Operations {
+		for _, operation := range operations {
+			if operation.Identifier == identifier {
+				return operation, nil
+			}
+		}
+	}
+	return tzkt.Operation{}, fmt.Errorf("no transaction with id %d", identifier)
 }
 
 func (c MockClient) CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
 	if c.ShouldError {
 		return "", fmt.Errorf("Test should fail")
 	}
+	if c.CallError != nil {
+		return "", c.CallError
+	}
 	return "operationHash", nil
 }
 
+func (c MockClient) InjectContractCall(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	return c.CallContract(ctx, signedBy, target, parameters)
+}
+
+func (c MockClient) WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (OperationStatus, error) {
+	if c.ShouldError {
+		return OperationStatus{}, fmt.Errorf("Test should fail")
+	}
+	return OperationStatus{
+		Hash:          hash,
+		Status:        OperationApplied,
+		Confirmations: confirmations,
+		Errors:        make([]string, 0),
+	}, nil
+}
+
+func (c MockClient) Simulate(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (SimulationResult, error) {
+	if c.ShouldError {
+		return SimulationResult{}, fmt.Errorf("Test should fail")
+	}
+	if c.CallError != nil {
+		return SimulationResult{}, c.CallError
+	}
+	return SimulationResult{
+		Events: make([]SimulatedEvent, 0),
+	}, nil
+}
+
 func (c MockClient) Originate(ctx context.Context, signedBy Wallet, code []byte, initial_storage micheline.Prim) (Contract, error) {
 	if c.ShouldError {
 		return Contract{}, fmt.Errorf("Test should fail")
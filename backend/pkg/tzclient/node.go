@@ -0,0 +1,554 @@
+package tzclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/rpc"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// NodeReader reads chain state directly from a Tezos node rather than from TzKT, for
+// networks such as private sandboxes that have no indexer. Results are in the same
+// shape TzKT would give, so the same storage types decode them.
+//
+// The node has no index of operations or events, and only keeps big map values by
+// the hash of their key, so those are found by scanning block receipts. Scans start
+// at FirstLevel, which should be no later than the level at which the contracts of
+// interest were originated, as anything before it is not seen.
+type NodeReader struct {
+	FirstLevel int32
+
+	rpc *rpc.Client
+}
+
+func NewNodeReader(address string, first_level int32) (*NodeReader, error) {
+	client, err := rpc.NewClient(address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return &NodeReader{
+		FirstLevel: first_level,
+		rpc:        client,
+	}, nil
+}
+
+// A transaction, origination, or event from a block's receipts.
+type nodeOperation struct {
+	Identifier  int64
+	Hash        tezos.OpHash
+	Kind        tezos.OpType
+	Source      tezos.Address
+	Destination tezos.Address // the contract called or originated
+	Fee         int64
+	Result      rpc.OperationResult
+
+	// Only for events, along with the transaction that emitted it
+	Tag     string
+	Payload micheline.Value
+	Caller  int64
+}
+
+// The node doesn't give operations identifiers, so we make them from where they are
+// in the chain, which keeps them unique and in the same order as TzKT's. The internal
+// index is zero for the operation itself, and one more than the index of internal
+// results otherwise.
+func nodeOperationID(level int64, list int, index int, content int, internal int) int64 {
+	return level<<32 | int64(list)<<30 | int64(index)<<18 | int64(content)<<10 | int64(internal)
+}
+
+func parseNodeOperationID(identifier int64) (level int64, list int, index int, content int, internal int) {
+	return identifier >> 32, int(identifier>>30) & 0x3, int(identifier>>18) & 0xfff, int(identifier>>10) & 0xff, int(identifier) & 0x3ff
+}
+
+// Calls visit for each transaction, origination, and event in the block, in the order
+// they were applied.
+func walkBlock(block *rpc.Block, visit func(operation nodeOperation) error) error {
+	level := block.GetLevel()
+	for list_index, list := range block.Operations {
+		for op_index, op := range list {
+			for content_index, content := range op.Contents {
+				operation := nodeOperation{
+					Identifier: nodeOperationID(level, list_index, op_index, content_index, 0),
+					Hash:       op.Hash,
+					Kind:       content.Kind(),
+					Fee:        content.Limits().Fee,
+					Result:     content.Result(),
+				}
+				switch typed := content.(type) {
+				case *rpc.Transaction:
+					operation.Source = typed.Source
+					operation.Destination = typed.Destination
+				case *rpc.Origination:
+					operation.Source = typed.Source
+					if originated := operation.Result.OriginatedContracts; len(originated) > 0 {
+						operation.Destination = originated[0]
+					}
+				default:
+					continue
+				}
+				err := visit(operation)
+				if err != nil {
+					return err
+				}
+
+				// Events are emitted by whichever call to the contract was most recently
+				// made, as internal operations are applied depth first
+				callers := map[string]int64{operation.Destination.String(): operation.Identifier}
+				for internal_index, internal_result := range content.Meta().InternalResults {
+					internal := nodeOperation{
+						Identifier: nodeOperationID(level, list_index, op_index, content_index, internal_index+1),
+						Hash:       op.Hash,
+						Kind:       internal_result.Kind,
+						Source:     internal_result.Source,
+						Result:     internal_result.Result,
+					}
+					switch internal_result.Kind {
+					case tezos.OpTypeTransaction:
+						if internal_result.Destination != nil {
+							internal.Destination = *internal_result.Destination
+						}
+						callers[internal.Destination.String()] = internal.Identifier
+					case tezos.OpTypeOrigination:
+						if originated := internal.Result.OriginatedContracts; len(originated) > 0 {
+							internal.Destination = originated[0]
+						}
+					case tezos.OpTypeEvent:
+						internal.Tag = internal_result.Tag
+						internal.Payload = micheline.NewValue(micheline.NewType(internal_result.Type), internal_result.Payload)
+						internal.Caller = callers[internal.Source.String()]
+					default:
+						continue
+					}
+					err := visit(internal)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Calls visit with each block from from to to inclusive, starting no earlier than
+// FirstLevel.
+func (r *NodeReader) scanBlocks(ctx context.Context, from int32, to int32, visit func(block *rpc.Block) error) error {
+	if from < r.FirstLevel {
+		from = r.FirstLevel
+	}
+	if from < 1 {
+		from = 1
+	}
+	for level := from; level <= to; level++ {
+		block, err := r.rpc.GetBlockHeight(ctx, int64(level))
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %w", level, err)
+		}
+		err = visit(block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TzKT gives error types without the protocol they came from.
+func nodeErrorType(identifier string) string {
+	if strings.HasPrefix(identifier, "proto.") {
+		parts := strings.SplitN(identifier, ".", 3)
+		if len(parts) == 3 {
+			return parts[2]
+		}
+	}
+	return identifier
+}
+
+func newNodeTransaction(block *rpc.Block, operation nodeOperation) tzkt.Operation {
+	result := operation.Result
+	var allocation_fee int64
+	if result.Allocated {
+		allocation_fee = tezos.DefaultParams.OriginationSize * tezos.DefaultParams.CostPerByte
+	}
+	var errors []tzkt.OperationError
+	for _, result_error := range result.Errors {
+		errors = append(errors, tzkt.OperationError{Type: nodeErrorType(result_error.ID)})
+	}
+	return tzkt.Operation{
+		Type:          "transaction",
+		Identifier:    operation.Identifier,
+		Level:         int32(block.GetLevel()),
+		Timestamp:     block.GetTimestamp(),
+		Block:         block.Hash.String(),
+		Hash:          operation.Hash.String(),
+		Sender:        &tzkt.OperationParty{Address: operation.Source.String()},
+		Target:        &tzkt.OperationParty{Address: operation.Destination.String()},
+		Status:        result.Status.String(),
+		GasUsed:       result.Gas(),
+		StorageUsed:   result.PaidStorageSizeDiff,
+		BakerFee:      operation.Fee,
+		StorageFee:    result.PaidStorageSizeDiff * tezos.DefaultParams.CostPerByte,
+		AllocationFee: allocation_fee,
+		Errors:        errors,
+	}
+}
+
+func (r *NodeReader) GetHead(ctx context.Context) (tzkt.Head, error) {
+	var head tzkt.Head
+	err := r.rpc.Get(ctx, "chains/main/blocks/head/header", &head)
+	if err != nil {
+		return tzkt.Head{}, fmt.Errorf("failed to get head: %w", err)
+	}
+	return head, nil
+}
+
+func (r *NodeReader) GetBlock(ctx context.Context, level int32) (tzkt.Block, error) {
+	var block tzkt.Block
+	err := r.rpc.Get(ctx, fmt.Sprintf("chains/main/blocks/%d/header", level), &block)
+	if err != nil {
+		return tzkt.Block{}, fmt.Errorf("failed to get block %d: %w", level, err)
+	}
+	return block, nil
+}
+
+// GetContractStorage decodes the storage using the contract's own storage type, which
+// gives the same JSON as TzKT.
+func (r *NodeReader) GetContractStorage(ctx context.Context, contractAddress string, storage interface{}) error {
+	address, err := tezos.ParseAddress(contractAddress)
+	if err != nil {
+		return fmt.Errorf("invalid contract address %s: %w", contractAddress, err)
+	}
+	script, err := r.rpc.GetContractScript(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to get script: %w", err)
+	}
+	prim, err := r.rpc.GetContractStorage(ctx, address, rpc.Head)
+	if err != nil {
+		return fmt.Errorf("failed to get storage: %w", err)
+	}
+	data, err := micheline.NewValue(script.StorageType(), prim).MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to convert storage: %w", err)
+	}
+	err = json.Unmarshal(data, storage)
+	if err != nil {
+		return fmt.Errorf("failed to decode storage: %w", err)
+	}
+	return nil
+}
+
+// GetContractBigMaps finds the big maps in the contract's current storage, with their
+// paths given by the field annotations in the storage type.
+func (r *NodeReader) GetContractBigMaps(ctx context.Context, contractAddress string) ([]tzkt.ContractBigMap, error) {
+	address, err := tezos.ParseAddress(contractAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract address %s: %w", contractAddress, err)
+	}
+	script, err := r.rpc.GetContractScript(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get script: %w", err)
+	}
+	bigmaps := make([]tzkt.ContractBigMap, 0)
+	for path, pointer := range script.Bigmaps() {
+		bigmaps = append(bigmaps, tzkt.ContractBigMap{Pointer: pointer, Path: path, Active: true})
+	}
+	sort.Slice(bigmaps, func(i, j int) bool {
+		return bigmaps[i].Pointer < bigmaps[j].Pointer
+	})
+	return bigmaps, nil
+}
+
+// What the block receipts say about a big map key.
+type nodeBigMapKey struct {
+	Key        micheline.Prim
+	Hash       tezos.ExprHash
+	Value      micheline.Prim
+	FirstLevel int64
+	LastLevel  int64
+	Updates    int64
+}
+
+// Finds the keys that have ever been in the big map up to the given level, in the
+// order they were first added.
+func (r *NodeReader) scanBigMapKeys(ctx context.Context, identifier int64, level int32) ([]*nodeBigMapKey, error) {
+	keys := make([]*nodeBigMapKey, 0)
+	by_hash := make(map[string]*nodeBigMapKey)
+	err := r.scanBlocks(ctx, 0, level, func(block *rpc.Block) error {
+		return walkBlock(block, func(operation nodeOperation) error {
+			if !operation.Result.IsSuccess() {
+				return nil
+			}
+			for _, diff := range operation.Result.BigmapEvents() {
+				if diff.Action == micheline.DiffActionCopy && diff.DestId == identifier {
+					return fmt.Errorf("big map %d was copied from big map %d, which is not supported", identifier, diff.SourceId)
+				}
+				if diff.Id != identifier || (diff.Action != micheline.DiffActionUpdate && diff.Action != micheline.DiffActionRemove) {
+					continue
+				}
+				key, ok := by_hash[diff.KeyHash.String()]
+				if !ok {
+					key = &nodeBigMapKey{Key: diff.Key, Hash: diff.KeyHash, FirstLevel: block.GetLevel()}
+					by_hash[diff.KeyHash.String()] = key
+					keys = append(keys, key)
+				}
+				key.LastLevel = block.GetLevel()
+				key.Updates += 1
+				if diff.Action == micheline.DiffActionUpdate {
+					key.Value = diff.Value
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// The receipts tell us which keys there are, but the values come from looking each key
+// up by its hash at the given level, with those that aren't found being inactive.
+func (r *NodeReader) getBigMapItems(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error) {
+	keys, err := r.scanBigMapKeys(ctx, identifier, level)
+	if err != nil {
+		return nil, err
+	}
+	block := rpc.BlockLevel(level)
+	info, err := r.rpc.GetBigmapInfo(ctx, identifier, block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get big map %d: %w", identifier, err)
+	}
+	key_type := micheline.NewType(info.KeyType)
+	value_type := micheline.NewType(info.ValueType)
+
+	items := make([]tzkt.BigMapItem, 0, len(keys))
+	for index, key := range keys {
+		active := true
+		value, err := r.rpc.GetBigmapValue(ctx, identifier, key.Hash, block)
+		if rpc.ErrorStatus(err) == http.StatusNotFound {
+			active = false
+			value = key.Value
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get value for %s in big map %d: %w", key.Hash, identifier, err)
+		}
+		key_data, err := micheline.NewValue(key_type, key.Key).MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert key %s: %w", key.Hash, err)
+		}
+		value_data, err := micheline.NewValue(value_type, value).MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert value for %s: %w", key.Hash, err)
+		}
+		items = append(items, tzkt.BigMapItem{
+			Identifier: int64(index + 1),
+			Active:     active,
+			Hash:       key.Hash.String(),
+			Key:        key_data,
+			Value:      value_data,
+			FirstLevel: key.FirstLevel,
+			LastLevel:  key.LastLevel,
+			Updates:    key.Updates,
+		})
+	}
+	return items, nil
+}
+
+func (r *NodeReader) GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error) {
+	head, err := r.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// There's no need to scan past the last level asked for
+	level := head.Level
+	if options.MaxLevel != 0 && options.MaxLevel < level {
+		level = options.MaxLevel
+	}
+	items, err := r.getBigMapItems(ctx, identifier, level)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]tzkt.BigMapItem, 0, len(items))
+	for _, item := range items {
+		if options.MatchesBigMapItem(item) {
+			results = append(results, item)
+		}
+	}
+	return tzkt.ApplyOrderAndLimit(options, results), nil
+}
+
+// GetBigMapContentsAt returns the keys that were in the big map at the end of the
+// given level.
+func (r *NodeReader) GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error) {
+	items, err := r.getBigMapItems(ctx, identifier, level)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]tzkt.BigMapItem, 0, len(items))
+	for _, item := range items {
+		if item.Active {
+			results = append(results, item)
+		}
+	}
+	return results, nil
+}
+
+// GetContractEvents scans the blocks in the options' level range for events, so
+// giving a range is much quicker than scanning everything since FirstLevel.
+func (r *NodeReader) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	to := options.MaxLevel
+	if to == 0 {
+		head, err := r.GetHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		to = head.Level
+	}
+
+	events := make([]tzkt.Event, 0)
+	err := r.scanBlocks(ctx, options.MinLevel, to, func(block *rpc.Block) error {
+		return walkBlock(block, func(operation nodeOperation) error {
+			if operation.Kind != tezos.OpTypeEvent || !operation.Result.IsSuccess() {
+				return nil
+			}
+			if operation.Source.String() != contractAddress || (tag != "" && operation.Tag != tag) {
+				return nil
+			}
+			payload, err := operation.Payload.MarshalJSON()
+			if err != nil {
+				return fmt.Errorf("failed to convert payload of event %d: %w", operation.Identifier, err)
+			}
+			address := contractAddress
+			event := tzkt.Event{
+				Identifier:    operation.Identifier,
+				Level:         int32(block.GetLevel()),
+				Timestamp:     block.GetTimestamp(),
+				Contract:      tzkt.EventContractInfo{Address: &address},
+				Tag:           operation.Tag,
+				Payload:       payload,
+				TransactionID: operation.Caller,
+			}
+			if options.MatchesEvent(event) {
+				events = append(events, event)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tzkt.ApplyOrderAndLimit(options, events), nil
+}
+
+// GetOperationInformation searches back from the head for the operation, stopping at
+// FirstLevel. As with TzKT, no operations are returned if it isn't found.
+func (r *NodeReader) GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error) {
+	head, err := r.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	first := r.FirstLevel
+	if first < 1 {
+		first = 1
+	}
+	for level := head.Level; level >= first; level-- {
+		hashes, err := r.rpc.GetBlockOperationHashes(ctx, rpc.BlockLevel(level))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get operations in block %d: %w", level, err)
+		}
+		found := false
+		for _, list := range hashes {
+			for _, op_hash := range list {
+				if op_hash.String() == hash {
+					found = true
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+
+		block, err := r.rpc.GetBlockHeight(ctx, int64(level))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block %d: %w", level, err)
+		}
+		results := make([]tzkt.Operation, 0)
+		err = walkBlock(block, func(operation nodeOperation) error {
+			if operation.Hash.String() == hash && operation.Kind == tezos.OpTypeTransaction {
+				results = append(results, newNodeTransaction(block, operation))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+	return []tzkt.Operation{}, nil
+}
+
+// GetTransactionByID finds a transaction from the identifier made for it by this
+// reader, such as an event's TransactionID.
+func (r *NodeReader) GetTransactionByID(ctx context.Context, identifier int64) (tzkt.Operation, error) {
+	level, _, _, _, _ := parseNodeOperationID(identifier)
+	block, err := r.rpc.GetBlockHeight(ctx, level)
+	if err != nil {
+		return tzkt.Operation{}, fmt.Errorf("failed to get block %d: %w", level, err)
+	}
+	var result *tzkt.Operation
+	err = walkBlock(block, func(operation nodeOperation) error {
+		if operation.Identifier == identifier && operation.Kind == tezos.OpTypeTransaction {
+			transaction := newNodeTransaction(block, operation)
+			result = &transaction
+		}
+		return nil
+	})
+	if err != nil {
+		return tzkt.Operation{}, err
+	}
+	if result == nil {
+		return tzkt.Operation{}, fmt.Errorf("no transaction with id %d", identifier)
+	}
+	return *result, nil
+}
+
+// GetLevelAtTime returns the level of the last block made at or before the given time.
+func (r *NodeReader) GetLevelAtTime(ctx context.Context, at time.Time) (int32, error) {
+	head, err := r.GetHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !head.Timestamp.After(at) {
+		return head.Level, nil
+	}
+	low, err := r.GetBlock(ctx, 1)
+	if err != nil {
+		return 0, err
+	}
+	if low.Timestamp.After(at) {
+		return 0, fmt.Errorf("no blocks at or before %v", at)
+	}
+
+	// The block at low is at or before the time, and the one at high is after it
+	low_level, high_level := low.Level, head.Level
+	for high_level-low_level > 1 {
+		middle := low_level + (high_level-low_level)/2
+		block, err := r.GetBlock(ctx, middle)
+		if err != nil {
+			return 0, err
+		}
+		if block.Timestamp.After(at) {
+			high_level = middle
+		} else {
+			low_level = middle
+		}
+	}
+	return low_level, nil
+}
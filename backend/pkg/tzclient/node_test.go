@@ -0,0 +1,248 @@
+package tzclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func testKeyHash(t *testing.T, key string) string {
+	prim := micheline.NewString(key)
+	hash, err := micheline.NewKey(micheline.NewType(micheline.NewCode(micheline.T_STRING)), prim)
+	if err != nil {
+		t.Fatalf("Failed to hash key %s: %v", key, err)
+	}
+	return hash.Hash().String()
+}
+
+// Serves just enough of the node RPC for a contract originated at level 1 with a big
+// map, which is called at level 2 to add and remove keys and emit an event.
+func newTestNode(t *testing.T) (*httptest.Server, string, string) {
+	contract := "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"
+	owner := "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"
+	origination_hash := tezos.NewOpHash(make([]byte, 32)).String()
+	call_bytes := make([]byte, 32)
+	call_bytes[0] = 1
+	call_hash := tezos.NewOpHash(call_bytes).String()
+	hash_a := testKeyHash(t, "a")
+	hash_b := testKeyHash(t, "b")
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	block := func(level int, operations string) string {
+		return fmt.Sprintf(`{"hash": "%s", "header": {"level": %d, "timestamp": "%s"}, "operations": [[], [], [], [%s]]}`,
+			tezos.NewBlockHash([]byte{byte(level)}), level, start.Add(time.Duration(level)*time.Minute).Format(time.RFC3339), operations)
+	}
+	header := func(level int) string {
+		return fmt.Sprintf(`{"level": %d, "hash": "block%d", "timestamp": "%s"}`, level, level, start.Add(time.Duration(level)*time.Minute).Format(time.RFC3339))
+	}
+
+	responses := map[string]string{
+		"/chains/main/blocks/head/header": header(3),
+		"/chains/main/blocks/1/header":    header(1),
+		"/chains/main/blocks/2/header":    header(2),
+		"/chains/main/blocks/3/header":    header(3),
+		"/chains/main/blocks/1": block(1, fmt.Sprintf(`{"hash": "%s", "contents": [{
+			"kind": "origination", "source": "%s", "fee": "1000", "balance": "0",
+			"metadata": {"operation_result": {"status": "applied", "originated_contracts": ["%s"],
+				"lazy_storage_diff": [{"kind": "big_map", "id": "7", "diff": {"action": "alloc",
+					"key_type": {"prim": "string"}, "value_type": {"prim": "bytes"},
+					"updates": [{"key_hash": "%s", "key": {"string": "a"}, "value": {"bytes": "00"}}]}}]}}}]}`,
+			origination_hash, owner, contract, hash_a)),
+		"/chains/main/blocks/2": block(2, fmt.Sprintf(`{"hash": "%s", "contents": [{
+			"kind": "transaction", "source": "%s", "fee": "500", "amount": "0", "destination": "%s",
+			"metadata": {"operation_result": {"status": "applied", "consumed_milligas": "2000000", "paid_storage_size_diff": "4",
+				"lazy_storage_diff": [{"kind": "big_map", "id": "7", "diff": {"action": "update",
+					"updates": [{"key_hash": "%s", "key": {"string": "b"}, "value": {"bytes": "01"}},
+						{"key_hash": "%s", "key": {"string": "a"}}]}}]},
+			"internal_operation_results": [{"kind": "event", "source": "%s", "nonce": 0, "tag": "retire",
+				"type": {"prim": "nat"}, "payload": {"int": "5"}, "result": {"status": "applied"}}]}}]}`,
+			call_hash, owner, contract, hash_b, hash_a, contract)),
+		"/chains/main/blocks/3":                                   block(3, ""),
+		"/chains/main/blocks/1/operation_hashes":                  fmt.Sprintf(`[[], [], [], ["%s"]]`, origination_hash),
+		"/chains/main/blocks/2/operation_hashes":                  fmt.Sprintf(`[[], [], [], ["%s"]]`, call_hash),
+		"/chains/main/blocks/3/operation_hashes":                  `[[], [], [], []]`,
+		"/chains/main/blocks/1/context/raw/json/big_maps/index/7": `{"key_type": {"prim": "string"}, "value_type": {"prim": "bytes"}}`,
+		"/chains/main/blocks/3/context/raw/json/big_maps/index/7": `{"key_type": {"prim": "string"}, "value_type": {"prim": "bytes"}}`,
+		"/chains/main/blocks/1/context/big_maps/7/" + hash_a:      `{"bytes": "00"}`,
+		"/chains/main/blocks/3/context/big_maps/7/" + hash_b:      `{"bytes": "01"}`,
+		"/chains/main/blocks/head/context/contracts/" + contract + "/script": `{
+			"code": [{"prim": "parameter", "args": [{"prim": "unit"}]},
+				{"prim": "storage", "args": [{"prim": "pair", "args": [{"prim": "address", "annots": ["%oracle"]}, {"prim": "big_map", "args": [{"prim": "string"}, {"prim": "bytes"}], "annots": ["%metadata"]}]}]},
+				{"prim": "code", "args": [[]]}],
+			"storage": {"prim": "Pair", "args": [{"string": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"}, {"int": "7"}]}}`,
+		"/chains/main/blocks/head/context/contracts/" + contract + "/storage": `{"prim": "Pair", "args": [{"string": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"}, {"int": "7"}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// tzgo expects the compact JSON the node gives
+		var compact bytes.Buffer
+		err := json.Compact(&compact, []byte(response))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(compact.Bytes())
+	}))
+	return server, contract, call_hash
+}
+
+func TestNodeReader(t *testing.T) {
+	server, contract, call_hash := newTestNode(t)
+	defer server.Close()
+	reader, err := NewNodeReader(server.URL, 1)
+	if err != nil {
+		t.Fatalf("Failed to make reader: %v", err)
+	}
+	ctx := context.Background()
+
+	var storage struct {
+		Oracle   string `json:"oracle"`
+		Metadata int64  `json:"metadata"`
+	}
+	err = reader.GetContractStorage(ctx, contract, &storage)
+	if err != nil {
+		t.Fatalf("Failed to get storage: %v", err)
+	}
+	if storage.Oracle != "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq" || storage.Metadata != 7 {
+		t.Errorf("Unexpected storage %v", storage)
+	}
+
+	bigmaps, err := reader.GetContractBigMaps(ctx, contract)
+	if err != nil {
+		t.Fatalf("Failed to get big maps: %v", err)
+	}
+	if len(bigmaps) != 1 || bigmaps[0].Pointer != 7 || bigmaps[0].Path != "metadata" || !bigmaps[0].Active {
+		t.Errorf("Expected metadata big map, got %v", bigmaps)
+	}
+	block, err := reader.GetBlock(ctx, 2)
+	if err != nil || block.Level != 2 || block.Hash != "block2" {
+		t.Errorf("Unexpected block %v, %v", block, err)
+	}
+
+	items, err := reader.GetBigMapContents(ctx, 7, tzkt.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get big map: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 keys, got %v", items)
+	}
+	if string(items[0].Key) != `"a"` || items[0].Active || items[0].Updates != 2 || items[0].LastLevel != 2 {
+		t.Errorf("Expected a to have been removed, got %v", items[0])
+	}
+	if string(items[1].Key) != `"b"` || !items[1].Active || string(items[1].Value) != `"01"` {
+		t.Errorf("Unexpected item %v", items[1])
+	}
+	items, err = reader.GetBigMapContents(ctx, 7, tzkt.QueryOptions{ActiveOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to get big map: %v", err)
+	}
+	if len(items) != 1 {
+		t.Errorf("Expected 1 active key, got %v", items)
+	}
+	// Asking for changes up to a level gives the keys as they were then
+	items, err = reader.GetBigMapContents(ctx, 7, tzkt.QueryOptions{MaxLevel: 1})
+	if err != nil {
+		t.Fatalf("Failed to get big map: %v", err)
+	}
+	if len(items) != 1 || !items[0].Active || string(items[0].Value) != `"00"` {
+		t.Errorf("Expected just a as it was at level 1, got %v", items)
+	}
+	items, err = reader.GetBigMapContentsAt(ctx, 7, 1)
+	if err != nil {
+		t.Fatalf("Failed to get big map at level 1: %v", err)
+	}
+	if len(items) != 1 || string(items[0].Key) != `"a"` || string(items[0].Value) != `"00"` {
+		t.Errorf("Expected just a at level 1, got %v", items)
+	}
+
+	events, err := reader.GetContractEvents(ctx, contract, "retire", tzkt.QueryOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	if len(events) != 1 || string(events[0].Payload) != `"5"` || events[0].Level != 2 {
+		t.Fatalf("Unexpected events %v", events)
+	}
+	events, err = reader.GetContractEvents(ctx, contract, "mint", tzkt.QueryOptions{})
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no mint events, got %v, %v", events, err)
+	}
+
+	operations, err := reader.GetOperationInformation(ctx, call_hash)
+	if err != nil {
+		t.Fatalf("Failed to get operation: %v", err)
+	}
+	if len(operations) != 1 {
+		t.Fatalf("Expected 1 operation, got %v", operations)
+	}
+	operation := operations[0]
+	if operation.Status != "applied" || operation.Target.Address != contract || operation.GasUsed != 2000 || operation.BakerFee != 500 || operation.StorageFee != 1000 {
+		t.Errorf("Unexpected operation %v", operation)
+	}
+	reader_events, _ := reader.GetContractEvents(ctx, contract, "retire", tzkt.QueryOptions{})
+	if reader_events[0].TransactionID != operation.Identifier {
+		t.Errorf("Expected event to be from transaction %d, got %d", operation.Identifier, reader_events[0].TransactionID)
+	}
+	transaction, err := reader.GetTransactionByID(ctx, operation.Identifier)
+	if err != nil || transaction.Hash != call_hash {
+		t.Errorf("Unexpected transaction %v, %v", transaction, err)
+	}
+	operations, err = reader.GetOperationInformation(ctx, tezos.NewOpHash(make([]byte, 31)).String())
+	if err != nil || len(operations) != 0 {
+		t.Errorf("Expected no operations for unknown hash, got %v, %v", operations, err)
+	}
+
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		At          time.Time
+		Level       int32
+		ExpectError bool
+	}{
+		{At: start.Add(90 * time.Second), Level: 1},
+		{At: start.Add(2 * time.Minute), Level: 2},
+		{At: start.Add(time.Hour), Level: 3},
+		{At: start, ExpectError: true},
+	}
+	for idx, testcase := range testcases {
+		level, err := reader.GetLevelAtTime(ctx, testcase.At)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error", idx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", idx, err)
+		} else if level != testcase.Level {
+			t.Errorf("%d: Expected level %d, got %d", idx, testcase.Level, level)
+		}
+	}
+}
+
+func TestNodeOperationID(t *testing.T) {
+	identifier := nodeOperationID(3456789, 3, 4095, 255, 1023)
+	level, list, index, content, internal := parseNodeOperationID(identifier)
+	if level != 3456789 || list != 3 || index != 4095 || content != 255 || internal != 1023 {
+		t.Errorf("Unexpected parts %d %d %d %d %d", level, list, index, content, internal)
+	}
+	if nodeOperationID(2, 0, 0, 0, 0) <= nodeOperationID(1, 3, 4095, 255, 1023) {
+		t.Errorf("Expected identifiers to be ordered by level first")
+	}
+	if nodeErrorType("proto.015-PtLimaPt.michelson_v1.script_rejected") != "michelson_v1.script_rejected" {
+		t.Errorf("Expected protocol to be removed from error")
+	}
+}
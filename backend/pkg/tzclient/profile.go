@@ -0,0 +1,201 @@
+package tzclient
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NetworkProfile says where to find everything for one Tezos network. Any field can
+// be left empty, and each of the URLs can be overridden by its environment variable.
+type NetworkProfile struct {
+	RPC           string `yaml:"rpc"`
+	IndexerAPI    string `yaml:"indexer_api"`
+	IndexerWeb    string `yaml:"indexer_web"`
+	Signatory     string `yaml:"signatory"`
+	DefaultSigner string `yaml:"default_signer"`
+	// Where to read chain state from, "tzkt" (the default) or "node" for networks
+	// without an indexer, in which case the node's blocks are scanned from FirstLevel
+	Reader     string `yaml:"reader"`
+	FirstLevel int32  `yaml:"first_level"`
+	// The chain ID the RPC node must be on for us to sign operations for it, so that
+	// a misconfigured profile can't send operations to the wrong network
+	ChainID string `yaml:"chain_id"`
+	// Contract addresses by name, in addition to those known to tezos-client
+	Contracts map[string]string `yaml:"contracts"`
+}
+
+// Config is the x4c configuration file, which holds the network profiles.
+type Config struct {
+	// The profile to use when none is given
+	DefaultNetwork string                    `yaml:"default_network"`
+	Networks       map[string]NetworkProfile `yaml:"networks"`
+}
+
+// The public networks are always available, though the config file can replace them.
+var builtinProfiles = map[string]NetworkProfile{
+	"mainnet": {
+		IndexerAPI: "https://api.mainnet.tzkt.io/",
+		IndexerWeb: "https://mainnet.tzkt.io/",
+		ChainID:    "NetXdQprcVkpaWU",
+	},
+	"ghostnet": {
+		IndexerAPI: "https://api.ghostnet.tzkt.io/",
+		IndexerWeb: "https://ghostnet.tzkt.io/",
+		ChainID:    "NetXnHfVqm9iesp",
+	},
+}
+
+// DefaultConfigPath is the file named by X4C_CONFIG, or ~/.x4c/config.yaml.
+func DefaultConfigPath() string {
+	if path := os.Getenv("X4C_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".x4c", "config.yaml")
+}
+
+// LoadConfig reads the config file at path. It is fine for there to be no file, in
+// which case only the built in profiles are available.
+func LoadConfig(path string) (Config, error) {
+	config := Config{Networks: make(map[string]NetworkProfile)}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("failed to open config %s: %w", path, err)
+	}
+	if err == nil {
+		err = yaml.UnmarshalStrict(content, &config)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+		}
+		if config.Networks == nil {
+			config.Networks = make(map[string]NetworkProfile)
+		}
+	}
+	for name, profile := range builtinProfiles {
+		if _, ok := config.Networks[name]; !ok {
+			config.Networks[name] = profile
+		}
+	}
+	if config.DefaultNetwork != "" {
+		if _, ok := config.Networks[config.DefaultNetwork]; !ok {
+			return Config{}, fmt.Errorf("default network %s in config %s is not defined", config.DefaultNetwork, path)
+		}
+	}
+	return config, nil
+}
+
+// Profile returns the named profile, or the default one if name is empty. The
+// returned name is empty if no profile was asked for and there is no default.
+func (c Config) Profile(name string) (string, NetworkProfile, error) {
+	if name == "" {
+		name = c.DefaultNetwork
+	}
+	if name == "" {
+		return "", NetworkProfile{}, nil
+	}
+	profile, ok := c.Networks[name]
+	if !ok {
+		known := make([]string, 0, len(c.Networks))
+		for known_name := range c.Networks {
+			known = append(known, known_name)
+		}
+		sort.Strings(known)
+		return "", NetworkProfile{}, fmt.Errorf("unknown network %s, expected one of %s", name, strings.Join(known, ", "))
+	}
+	return name, profile, nil
+}
+
+// ProfileForEndpoint finds the profile for an RPC endpoint that was given without one,
+// as tezos-client's is. A profile with that RPC URL is used if there is one, and
+// otherwise the one for the chain the node says it is on, which chain_id is only
+// called to find out if needed. If neither matches we return an error rather than
+// guess, as reading from one network whilst writing to another is worse than failing.
+func (c Config) ProfileForEndpoint(endpoint string, chain_id func() (string, error)) (string, NetworkProfile, error) {
+	names := make([]string, 0, len(c.Networks))
+	for name := range c.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if rpc := c.Networks[name].RPC; rpc != "" && strings.TrimSuffix(rpc, "/") == strings.TrimSuffix(endpoint, "/") {
+			return name, c.Networks[name], nil
+		}
+	}
+
+	chain, err := chain_id()
+	if err != nil {
+		return "", NetworkProfile{}, fmt.Errorf("no network profile has rpc %s, and failed to ask the node which chain it is on: %w", endpoint, err)
+	}
+	matches := make([]string, 0)
+	for _, name := range names {
+		if c.Networks[name].ChainID == chain {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", NetworkProfile{}, fmt.Errorf("no network profile for %s, which is on chain %s, pick one with -network or default_network", endpoint, chain)
+	case 1:
+		return matches[0], c.Networks[matches[0]], nil
+	default:
+		return "", NetworkProfile{}, fmt.Errorf("%s is on chain %s, which profiles %s are all for, pick one with -network or default_network", endpoint, chain, strings.Join(matches, ", "))
+	}
+}
+
+// Fills in the client from a profile, with any environment variables that are set
+// taking precedence.
+func (c *Client) applyProfile(profile NetworkProfile) error {
+	settings := []struct {
+		field    *string
+		variable string
+		value    string
+	}{
+		{&c.RPCURL, "X4C_TEZOS_RPC_HOST", profile.RPC},
+		{&c.IndexerRPCURL, "X4C_TEZOS_INDEX_HOST", profile.IndexerAPI},
+		{&c.indexerWebURL, "X4C_TEZOS_INDEX_WEB", profile.IndexerWeb},
+		{&c.SignatoryURL, "X4C_SIGNATORY_HOST", profile.Signatory},
+		{&c.Reader, "X4C_TEZOS_READER", profile.Reader},
+	}
+	for _, setting := range settings {
+		if value := os.Getenv(setting.variable); value != "" {
+			*setting.field = value
+		} else {
+			*setting.field = setting.value
+		}
+	}
+	c.FirstLevel = profile.FirstLevel
+	if value := os.Getenv("X4C_TEZOS_FIRST_LEVEL"); value != "" {
+		level, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || level < 0 {
+			return fmt.Errorf("invalid X4C_TEZOS_FIRST_LEVEL %q", value)
+		}
+		c.FirstLevel = int32(level)
+	}
+	if c.Reader != "" && c.Reader != "tzkt" && c.Reader != "node" {
+		return fmt.Errorf("unknown reader %s, expected tzkt or node", c.Reader)
+	}
+	c.DefaultSigner = profile.DefaultSigner
+	c.ChainID = profile.ChainID
+
+	names := make([]string, 0, len(profile.Contracts))
+	for name := range profile.Contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		contract, err := NewContractWithAddress(name, profile.Contracts[name])
+		if err != nil {
+			return fmt.Errorf("failed to parse contract %s in profile: %w", name, err)
+		}
+		c.Contracts[name] = contract
+		c.profileContracts[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package tzclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	testcases := []struct {
+		Content        string
+		ExpectError    bool
+		DefaultNetwork string
+		GhostnetRPC    string
+		Networks       int
+	}{
+		{
+			Content:  "",
+			Networks: 2,
+		},
+		{
+			Content: `
+default_network: sandbox
+networks:
+  sandbox:
+    rpc: http://localhost:20000
+  ghostnet:
+    rpc: https://ghostnet.example.com
+`,
+			DefaultNetwork: "sandbox",
+			GhostnetRPC:    "https://ghostnet.example.com",
+			Networks:       3,
+		},
+		{
+			Content:     "default_network: missing\n",
+			ExpectError: true,
+		},
+		{
+			// Typos shouldn't be silently ignored
+			Content:     "networks:\n  sandbox:\n    rcp: http://localhost:20000\n",
+			ExpectError: true,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		config, err := LoadConfig(writeConfig(t, testcase.Content))
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error", idx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", idx, err)
+			continue
+		}
+		if config.DefaultNetwork != testcase.DefaultNetwork {
+			t.Errorf("%d: Expected default %s, got %s", idx, testcase.DefaultNetwork, config.DefaultNetwork)
+		}
+		if len(config.Networks) != testcase.Networks {
+			t.Errorf("%d: Expected %d networks, got %v", idx, testcase.Networks, config.Networks)
+		}
+		if config.Networks["ghostnet"].RPC != testcase.GhostnetRPC {
+			t.Errorf("%d: Expected ghostnet RPC %s, got %s", idx, testcase.GhostnetRPC, config.Networks["ghostnet"].RPC)
+		}
+	}
+
+	config, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Unexpected error for missing config: %v", err)
+	}
+	if _, ok := config.Networks["mainnet"]; !ok {
+		t.Errorf("Expected built in profiles without a config file")
+	}
+	_, _, err = config.Profile("nosuchnet")
+	if err == nil {
+		t.Errorf("Expected error for unknown network")
+	}
+}
+
+// Stands in for the node when picking a profile by its chain ID.
+func stubChainID(t *testing.T, chain_id string) {
+	original := nodeChainID
+	nodeChainID = func(endpoint string) (string, error) {
+		if chain_id == "" {
+			return "", fmt.Errorf("node not found")
+		}
+		return chain_id, nil
+	}
+	t.Cleanup(func() {
+		nodeChainID = original
+	})
+}
+
+func TestProfileForEndpoint(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+networks:
+  sandbox:
+    rpc: http://localhost:20000/
+    chain_id: NetXsandbox
+  ghostnet2:
+    rpc: http://localhost:30000/
+    chain_id: NetXnHfVqm9iesp
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testcases := []struct {
+		Endpoint    string
+		NodeChainID string
+		Network     string
+		ExpectError bool
+	}{
+		{
+			Endpoint: "http://localhost:20000",
+			Network:  "sandbox",
+		},
+		{
+			Endpoint:    "https://mainnet.api.tez.ie",
+			NodeChainID: "NetXdQprcVkpaWU",
+			Network:     "mainnet",
+		},
+		{
+			// The name of a network in the URL doesn't count for anything
+			Endpoint:    "https://rpc.ghostnet.example.com",
+			NodeChainID: "NetXdQprcVkpaWU",
+			Network:     "mainnet",
+		},
+		{
+			// A node on a chain we have no profile for
+			Endpoint:    "http://localhost:8732",
+			NodeChainID: "NetXotherchain",
+			ExpectError: true,
+		},
+		{
+			// A node we can't reach
+			Endpoint:    "http://localhost:8732",
+			ExpectError: true,
+		},
+		{
+			// More than one profile is for the node's chain
+			Endpoint:    "https://rpc.ghostnet.teztnets.xyz",
+			NodeChainID: "NetXnHfVqm9iesp",
+			ExpectError: true,
+		},
+	}
+	for idx, testcase := range testcases {
+		name, profile, err := config.ProfileForEndpoint(testcase.Endpoint, func() (string, error) {
+			if testcase.NodeChainID == "" {
+				return "", fmt.Errorf("node not found")
+			}
+			return testcase.NodeChainID, nil
+		})
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %s", idx, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", idx, err)
+			continue
+		}
+		if name != testcase.Network {
+			t.Errorf("%d: Expected %s, got %s", idx, testcase.Network, name)
+		}
+		if profile.ChainID != config.Networks[testcase.Network].ChainID {
+			t.Errorf("%d: Expected the profile's chain, got %q", idx, profile.ChainID)
+		}
+	}
+}
+
+func TestLoadClientForNetwork(t *testing.T) {
+	t.Setenv("X4C_CONFIG", writeConfig(t, `
+networks:
+  sandbox:
+    rpc: http://localhost:20000
+    indexer_api: http://localhost:5000
+    signatory: http://localhost:6732
+    default_signer: operator
+    chain_id: NetXsandbox
+    reader: node
+    first_level: 10
+    contracts:
+      fa2: KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR
+`))
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "config"), []byte(`{"endpoint": "https://rpc.ghostnet.teztnets.xyz"}`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	writeTezosClientValues(t, filepath.Join(dir, "secret_keys"), []tezosClientValue{})
+	writeTezosClientValues(t, filepath.Join(dir, "public_key_hashs"), []tezosClientValue{})
+	writeTezosClientValues(t, filepath.Join(dir, "contracts"), []tezosClientValue{
+		{Name: "fa2", Value: "KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9"},
+		{Name: "custodian", Value: "KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9"},
+	})
+	t.Setenv("X4C_SIGNATORY_HOST", "http://signatory:6732")
+
+	client, err := LoadClientForNetwork(dir, "sandbox")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.RPCURL != "http://localhost:20000" || client.IndexerRPCURL != "http://localhost:5000" {
+		t.Errorf("Expected profile URLs, got %v and %v", client.RPCURL, client.IndexerRPCURL)
+	}
+	if client.SignatoryURL != "http://signatory:6732" {
+		t.Errorf("Expected environment to override signatory, got %v", client.SignatoryURL)
+	}
+	if client.DefaultSigner != "operator" || client.ChainID != "NetXsandbox" {
+		t.Errorf("Unexpected signer %v or chain %v", client.DefaultSigner, client.ChainID)
+	}
+	if client.Reader != "node" || client.FirstLevel != 10 {
+		t.Errorf("Expected to read from the node from level 10, got %v from %d", client.Reader, client.FirstLevel)
+	}
+	if client.Contracts["fa2"].Address.String() != "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR" {
+		t.Errorf("Expected profile contract to win, got %v", client.Contracts["fa2"])
+	}
+	if _, ok := client.Contracts["custodian"]; !ok {
+		t.Errorf("Expected tezos-client contracts too")
+	}
+
+	// Saving a contract shouldn't write the profile's contracts to tezos-client
+	contract, _ := NewContractWithAddress("new", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	err = client.SaveContract(contract)
+	if err != nil {
+		t.Fatalf("Failed to save contract: %v", err)
+	}
+	saved, err := readTezosClientValues(filepath.Join(dir, "contracts"))
+	if err != nil {
+		t.Fatalf("Failed to read contracts: %v", err)
+	}
+	// tezos-client's own fa2, which the profile's hides, is kept
+	found_fa2 := false
+	for _, value := range saved {
+		if value.Name == "fa2" {
+			found_fa2 = true
+			if value.Value != "KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9" {
+				t.Errorf("Profile contract was saved to tezos-client: %v", value)
+			}
+		}
+	}
+	if !found_fa2 {
+		t.Errorf("Expected tezos-client's fa2 to be kept, got %v", saved)
+	}
+	if len(saved) != 3 {
+		t.Errorf("Expected 3 saved contracts, got %v", saved)
+	}
+
+	// Without a network we go by the chain tezos-client's endpoint is on
+	stubChainID(t, "NetXnHfVqm9iesp")
+	client, err = LoadClient(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.Network != "ghostnet" || client.IndexerRPCURL != "https://api.ghostnet.tzkt.io/" || client.Reader != "" {
+		t.Errorf("Expected ghostnet read from TzKT, got %v with indexer %v", client.Network, client.IndexerRPCURL)
+	}
+
+	_, err = LoadClientForNetwork(dir, "nosuchnet")
+	if err == nil {
+		t.Errorf("Expected error for unknown network")
+	}
+
+	// Rather than assume mainnet, we fail if the endpoint's chain has no profile
+	stubChainID(t, "NetXotherchain")
+	_, err = LoadClient(dir)
+	if err == nil {
+		t.Errorf("Expected error for endpoint without a profile")
+	}
+
+	// Unless the environment says where the node and indexer are
+	t.Setenv("X4C_TEZOS_RPC_HOST", "http://localhost:8732")
+	_, err = LoadClient(dir)
+	if err == nil {
+		t.Errorf("Expected error for endpoint without a profile or indexer")
+	}
+	t.Setenv("X4C_TEZOS_INDEX_HOST", "http://localhost:5000")
+	client, err = LoadClient(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.Network != "" || client.RPCURL != "http://localhost:8732" || client.IndexerRPCURL != "http://localhost:5000" {
+		t.Errorf("Expected no profile with the environment's URLs, got %v with %v and %v", client.Network, client.RPCURL, client.IndexerRPCURL)
+	}
+}
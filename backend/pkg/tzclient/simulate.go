@@ -0,0 +1,154 @@
+package tzclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"blockwatch.cc/tzgo/codec"
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/rpc"
+	"blockwatch.cc/tzgo/tezos"
+)
+
+type SimulatedEvent struct {
+	Source  tezos.Address
+	Tag     string
+	Type    micheline.Prim
+	Payload micheline.Prim
+}
+
+// SimulationResult is what the node estimates it will cost to run an operation. Fee is
+// the minimum fee we'd pay to have the operation included, and is in mutez, as are
+// the burn values.
+type SimulationResult struct {
+	GasUsed        int64
+	StorageUsed    int64
+	StorageBurn    int64
+	AllocationBurn int64
+	Fee            int64
+	Events         []SimulatedEvent
+}
+
+// RejectedError is returned when a contract FAILWITHs. The contract is the one that
+// actually failed, which may not be the one that was called if the failure was in an
+// internal operation.
+type RejectedError struct {
+	Contract tezos.Address
+	With     micheline.Prim
+}
+
+func (e RejectedError) Error() string {
+	return fmt.Sprintf("contract %s rejected operation with %s", e.Contract, e.With.Dump())
+}
+
+type runtimeErrorInfo struct {
+	ContractHandle string `json:"contract_handle"`
+}
+
+// Looks through all the results of the operation, including internal operations,
+// for the contract that failed and the value it failed with.
+func findRejection(receipt *rpc.Receipt) (RejectedError, bool) {
+	operation_errors := make([]rpc.OperationError, 0)
+	for _, content := range receipt.Op.Contents {
+		operation_errors = append(operation_errors, content.Result().Errors...)
+		for _, internal := range content.Meta().InternalResults {
+			operation_errors = append(operation_errors, internal.Result.Errors...)
+		}
+	}
+
+	// The node reports a runtime_error naming the contract followed by a
+	// script_rejected with the value passed to FAILWITH.
+	var contract tezos.Address
+	for _, operation_error := range operation_errors {
+		switch {
+		case strings.HasSuffix(operation_error.ID, "runtime_error"):
+			var info runtimeErrorInfo
+			err := json.Unmarshal(operation_error.Raw, &info)
+			if err == nil {
+				contract, _ = tezos.ParseAddress(info.ContractHandle)
+			}
+		case strings.HasSuffix(operation_error.ID, "script_rejected"):
+			return RejectedError{
+				Contract: contract,
+				With:     operation_error.With,
+			}, true
+		}
+	}
+	return RejectedError{}, false
+}
+
+// Fills in the counter and any reveal the operation needs, runs it against the current
+// chain head, and then sets the limits and fee based on the simulation. If a contract
+// rejects the operation a RejectedError is returned.
+func simulateOperation(ctx context.Context, rpcClient *rpc.Client, signedBy Wallet, op *codec.Op) (*rpc.Receipt, error) {
+	// We need the key to work out if the operation needs a reveal adding
+	key, err := rpcClient.Signer.GetKey(ctx, signedBy.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key for %v: %w", signedBy.Name, err)
+	}
+
+	err = rpcClient.Complete(ctx, op, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete operation: %w", err)
+	}
+
+	receipt, err := rpcClient.Simulate(ctx, op, nil)
+	if err != nil {
+		if receipt != nil {
+			if rejection, ok := findRejection(receipt); ok {
+				return nil, rejection
+			}
+		}
+		return nil, err
+	}
+
+	// This is the same fee calculation that Send does before injecting
+	op.WithLimits(receipt.MinLimits(), rpc.GasSafetyMargin)
+
+	return receipt, nil
+}
+
+// Simulate runs the contract call against the current chain head without injecting
+// it. If the contract rejects the call a RejectedError is returned.
+func (c Client) Simulate(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (SimulationResult, error) {
+
+	rpcClient, err := c.newSigningRPCClient(ctx, signedBy)
+	if err != nil {
+		return SimulationResult{}, err
+	}
+
+	op := codec.NewOp().WithSource(signedBy.Address)
+	op.WithCall(target.Address, parameters)
+
+	receipt, err := simulateOperation(ctx, rpcClient, signedBy, op)
+	if err != nil {
+		return SimulationResult{}, err
+	}
+
+	costs := receipt.TotalCosts()
+	result := SimulationResult{
+		GasUsed:        costs.GasUsed,
+		StorageUsed:    costs.StorageUsed,
+		StorageBurn:    costs.StorageBurn,
+		AllocationBurn: costs.AllocationBurn,
+		Fee:            op.Limits().Fee,
+		Events:         make([]SimulatedEvent, 0),
+	}
+	for _, content := range receipt.Op.Contents {
+		for _, internal := range content.Meta().InternalResults {
+			if internal.Kind != tezos.OpTypeEvent {
+				continue
+			}
+			result.Events = append(result.Events, SimulatedEvent{
+				Source:  internal.Source,
+				Tag:     internal.Tag,
+				Type:    internal.Type,
+				Payload: internal.Payload,
+			})
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package tzclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/rpc"
+)
+
+// A cut down version of what the node returns from run_operation when a custodian
+// calls an FA2 contract that then fails.
+const rejectedOperationJSON = `{
+	"contents": [{
+		"kind": "transaction",
+		"source": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+		"fee": "0",
+		"counter": "1",
+		"gas_limit": "1040000",
+		"storage_limit": "60000",
+		"amount": "0",
+		"destination": "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm",
+		"metadata": {
+			"balance_updates": [],
+			"operation_result": {
+				"status": "backtracked",
+				"consumed_milligas": "100000"
+			},
+			"internal_operation_results": [{
+				"kind": "transaction",
+				"source": "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm",
+				"nonce": 0,
+				"amount": "0",
+				"destination": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+				"result": {
+					"status": "failed",
+					"errors": [
+						{
+							"kind": "temporary",
+							"id": "proto.015-PtLimaPt.runtime_error",
+							"contract_handle": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+							"contract_code": "Deprecated"
+						},
+						{
+							"kind": "temporary",
+							"id": "proto.015-PtLimaPt.michelson_v1.script_rejected",
+							"location": 123,
+							"with": {"int": "1"}
+						}
+					]
+				}
+			}]
+		}
+	}]
+}`
+
+func TestFindRejection(t *testing.T) {
+	// tzgo expects the compact JSON the node sends
+	var compact bytes.Buffer
+	err := json.Compact(&compact, []byte(rejectedOperationJSON))
+	if err != nil {
+		t.Fatalf("Failed to compact test operation: %v", err)
+	}
+	var op rpc.Operation
+	err = json.Unmarshal(compact.Bytes(), &op)
+	if err != nil {
+		t.Fatalf("Failed to decode test operation: %v", err)
+	}
+	receipt := rpc.Receipt{Op: &op}
+
+	rejection, ok := findRejection(&receipt)
+	if !ok {
+		t.Fatalf("Expected to find rejection")
+	}
+	if rejection.Contract.String() != "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR" {
+		t.Errorf("Got unexpected contract %v", rejection.Contract)
+	}
+	if (rejection.With.Type != micheline.PrimInt) || (rejection.With.Int.Int64() != 1) {
+		t.Errorf("Got unexpected rejection value %v", rejection.With.Dump())
+	}
+}
+
+func TestDryRunClient(t *testing.T) {
+	signer, _ := NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := NewContractWithAddress("target", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	ctx := context.Background()
+
+	client := NewDryRunClient(NewMockClient())
+	hash, err := client.CallContract(ctx, signer, target, micheline.Parameters{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hash != "" {
+		t.Errorf("Expected no operation hash for dry run, got %s", hash)
+	}
+	if len(client.Simulations) != 1 {
+		t.Errorf("Expected one simulation, got %d", len(client.Simulations))
+	}
+
+	_, err = client.Originate(ctx, signer, nil, micheline.Prim{})
+	if err == nil {
+		t.Errorf("Expected origination to be refused in dry run")
+	}
+
+	failing := MockClient{ShouldError: true}
+	client = NewDryRunClient(failing)
+	_, err = client.CallContract(ctx, signer, target, micheline.Parameters{})
+	if err == nil {
+		t.Errorf("Expected simulation error to be returned")
+	}
+	if len(client.Simulations) != 0 {
+		t.Errorf("Expected no simulations, got %d", len(client.Simulations))
+	}
+}
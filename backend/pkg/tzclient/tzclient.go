@@ -18,6 +18,7 @@ import (
 	"blockwatch.cc/tzgo/rpc"
 	"blockwatch.cc/tzgo/signer"
 	"blockwatch.cc/tzgo/signer/remote"
+	"blockwatch.cc/tzgo/tezos"
 	"github.com/echa/log"
 
 	"quantify.earth/x4c/pkg/tzkt"
@@ -30,10 +31,17 @@ const maxRetries = 5
 // TezosClient is a generic interface that lets us mock out the backend for testing
 type TezosClient interface {
 	GetContractStorage(target Contract, ctx context.Context, storage interface{}) error
-	GetBigMapContents(ctx context.Context, identifier int64) ([]tzkt.BigMapItem, error)
+	GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error)
+	GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error)
+	GetLevelAtTime(ctx context.Context, at time.Time) (int32, error)
 	GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error)
-	GetContractEvents(ctx context.Context, contractAddress string, tag string) ([]tzkt.Event, error)
+	GetTransactionByID(ctx context.Context, identifier int64) (tzkt.Operation, error)
+	GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error)
+	GetHead(ctx context.Context) (tzkt.Head, error)
 	CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error)
+	InjectContractCall(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error)
+	WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (OperationStatus, error)
+	Simulate(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (SimulationResult, error)
 	Originate(ctx context.Context, signedBy Wallet, code []byte, initial_storage micheline.Prim) (Contract, error)
 
 	// Mostly to stop people accessing struct fields directly so we can mock out
@@ -49,12 +57,41 @@ type Client struct {
 	Wallets       map[string]Wallet
 	Contracts     map[string]Contract
 
+	// Where chain state is read from, either "tzkt" (the default) or "node", in which
+	// case scans of the node's blocks start at FirstLevel
+	Reader     string
+	FirstLevel int32
+
+	// From the network profile, if one was used
+	Network       string
+	DefaultSigner string
+	ChainID       string
+
 	path          string
 	indexerWebURL string
+	// Contracts that came from the profile rather than tezos-client, and so
+	// shouldn't be saved back to it
+	profileContracts map[string]bool
+	// tezos-client's contracts that have the same name as one in the profile, which
+	// aren't used but must be kept when the contracts file is rewritten
+	shadowedContracts []tezosClientValue
 }
 
 // internal types
 
+// chainReader is where the client reads chain state from, which is TzKT unless the
+// client is set to read from the node.
+type chainReader interface {
+	GetContractStorage(ctx context.Context, contractAddress string, storage interface{}) error
+	GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error)
+	GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error)
+	GetLevelAtTime(ctx context.Context, at time.Time) (int32, error)
+	GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error)
+	GetTransactionByID(ctx context.Context, identifier int64) (tzkt.Operation, error)
+	GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error)
+	GetHead(ctx context.Context) (tzkt.Head, error)
+}
+
 type tezosClientConfig struct {
 	Endpoint string `json:"endpoint"`
 }
@@ -88,122 +125,143 @@ func (t tezosClientPublicKey) Key() (string, error) {
 }
 
 // public code
+
+// NewClient makes a client configured by the network profile named in X4C_PROFILE, if
+// any, and the environment, without using tezos-client's files.
 func NewClient() (Client, error) {
 	client := Client{
-		path:      "",
-		Wallets:   make(map[string]Wallet),
-		Contracts: make(map[string]Contract),
+		path:             "",
+		Wallets:          make(map[string]Wallet),
+		Contracts:        make(map[string]Contract),
+		profileContracts: make(map[string]bool),
 	}
 
-	// first check env for hosts
-	client.RPCURL = os.Getenv("X4C_TEZOS_RPC_HOST")
-	if client.RPCURL == "" {
-		return Client{}, fmt.Errorf("X4C_TEZOS_RPC_HOST is not configured")
+	config, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return Client{}, err
+	}
+	name, profile, err := config.Profile(os.Getenv("X4C_PROFILE"))
+	if err != nil {
+		return Client{}, err
+	}
+	client.Network = name
+	err = client.applyProfile(profile)
+	if err != nil {
+		return Client{}, err
 	}
 
-	client.IndexerRPCURL = os.Getenv("X4C_TEZOS_INDEX_HOST")
 	if client.RPCURL == "" {
+		return Client{}, fmt.Errorf("X4C_TEZOS_RPC_HOST is not configured")
+	}
+	if client.IndexerRPCURL == "" && client.Reader != "node" {
 		return Client{}, fmt.Errorf("X4C_TEZOS_INDEX_HOST is not configured")
 	}
 
-	// These two are optional
-	client.indexerWebURL = os.Getenv("X4C_TEZOS_INDEX_WEB")
-	client.SignatoryURL = os.Getenv("X4C_SIGNATORY_HOST")
+	err = client.addEnvironmentKeyStores()
+	if err != nil {
+		return Client{}, err
+	}
 
 	return client, nil
 }
 
+// How long to wait for the node when asking it which chain it is on.
+const chainIDTimeout = 30 * time.Second
+
+// Asks the node which chain it is on, so that we can pick the profile for it. This is
+// a variable so that tests don't need a node.
+var nodeChainID = func(endpoint string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), chainIDTimeout)
+	defer cancel()
+	rpcClient, err := rpc.NewClient(endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create client: %w", err)
+	}
+	chain_id, err := rpcClient.GetChainId(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get chain ID from %s: %w", endpoint, err)
+	}
+	return chain_id.String(), nil
+}
+
+// LoadClient makes a client from the tezos-client directory at path, picking the
+// network profile from the config file's default or tezos-client's endpoint.
 func LoadClient(path string) (Client, error) {
+	return LoadClientForNetwork(path, "")
+}
+
+// LoadClientForNetwork makes a client from the tezos-client directory at path and
+// the named network profile, with environment variables overriding the profile.
+func LoadClientForNetwork(path string, network string) (Client, error) {
 	client := Client{
-		path:      path,
-		Wallets:   make(map[string]Wallet),
-		Contracts: make(map[string]Contract),
+		path:             path,
+		Wallets:          make(map[string]Wallet),
+		Contracts:        make(map[string]Contract),
+		profileContracts: make(map[string]bool),
 	}
 
-	// first check env for hosts
-	client.RPCURL = os.Getenv("X4C_TEZOS_RPC_HOST")
-	client.IndexerRPCURL = os.Getenv("X4C_TEZOS_INDEX_HOST")
-	client.indexerWebURL = os.Getenv("X4C_TEZOS_INDEX_WEB")
-	client.SignatoryURL = os.Getenv("X4C_SIGNATORY_HOST")
-
 	content, err := ioutil.ReadFile(filepath.Join(path, "config"))
 	if err != nil {
 		return Client{}, fmt.Errorf("failed to open tezos-client config: %w", err)
 	}
-	var config tezosClientConfig
-	err = json.Unmarshal(content, &config)
+	var tezos_client_config tezosClientConfig
+	err = json.Unmarshal(content, &tezos_client_config)
 	if err != nil {
 		return Client{}, fmt.Errorf("failed to decode tezos-client config: %w", err)
 	}
 
-	// If the env didn't specify things, try to infer where things are from the config file
-	if client.RPCURL == "" {
-		if config.Endpoint == "" {
-			return Client{}, fmt.Errorf("no rpc endpoint found in tezos-client config - try running 'tezos-client config update'")
-		}
-		client.RPCURL = config.Endpoint
-
-		if strings.Contains(client.RPCURL, "kathmandunet") {
-			client.IndexerRPCURL = "https://api.kathmandunet.tzkt.io/"
-			client.indexerWebURL = "https://kathmandunet.tzkt.io/"
-		} else if strings.Contains(client.RPCURL, "ghostnet") {
-			client.IndexerRPCURL = "https://api.ghostnet.tzkt.io/"
-			client.indexerWebURL = "https://ghostnet.tzkt.io/"
-		} else {
-			client.IndexerRPCURL = "https://api.mainnet.tzkt.io/"
-			client.indexerWebURL = "https://mainnet.tzkt.io/"
-		}
-	}
-
-	// tezos-client has redundent information stored - both the address/hash and public key
-	// can be derived from the secret key, so we just load secret keys first, and then any other keys
-	// we just load the address for (and not the public key, as that's not currently of use).
-
-	content, err = ioutil.ReadFile(filepath.Join(path, "secret_keys"))
+	config, err := LoadConfig(DefaultConfigPath())
 	if err != nil {
-		return Client{}, fmt.Errorf("failed to open tezos-client secret keys: %w", err)
+		return Client{}, err
 	}
-	var secret_keys []tezosClientValue
-	err = json.Unmarshal(content, &secret_keys)
+	name, profile, err := config.Profile(network)
 	if err != nil {
-		return Client{}, fmt.Errorf("failed to decode tezos-client secret keys: %w", err)
+		return Client{}, err
 	}
-	for _, key := range secret_keys {
-		// In the tezos client the keys have a prefix to indicate how they're stored. For now
-		// just deal with the unencrypted default
-		if !strings.HasPrefix(key.Value, "unencrypted:") {
-			return Client{}, fmt.Errorf("Key for %s has unexpected secret key format.", key.Name)
+	if name == "" {
+		// Without a profile we work out which network tezos-client is using
+		endpoint := os.Getenv("X4C_TEZOS_RPC_HOST")
+		if endpoint == "" {
+			endpoint = tezos_client_config.Endpoint
 		}
-		value := strings.TrimPrefix(key.Value, "unencrypted:")
-
-		wallet, err := NewWalletWithPrivateKey(key.Name, value)
+		if endpoint == "" {
+			return Client{}, fmt.Errorf("no rpc endpoint found in tezos-client config - try running 'tezos-client config update'")
+		}
+		name, profile, err = config.ProfileForEndpoint(endpoint, func() (string, error) {
+			return nodeChainID(endpoint)
+		})
 		if err != nil {
-			return Client{}, fmt.Errorf("failed to parse key for %s: %w", key.Name, err)
+			// A network we have no profile for can still be used if the environment
+			// says where everything is
+			if !environmentConfiguresNetwork() {
+				return Client{}, err
+			}
+			name, profile = "", NetworkProfile{}
 		}
-		client.Wallets[key.Name] = wallet
 	}
-
-	content, err = ioutil.ReadFile(filepath.Join(path, "public_key_hashs"))
+	client.Network = name
+	err = client.applyProfile(profile)
 	if err != nil {
-		return Client{}, fmt.Errorf("failed to open tezos-client hashes: %w", err)
+		return Client{}, err
+	}
+	if client.RPCURL == "" {
+		if tezos_client_config.Endpoint == "" {
+			return Client{}, fmt.Errorf("no rpc endpoint found in tezos-client config - try running 'tezos-client config update'")
+		}
+		client.RPCURL = tezos_client_config.Endpoint
 	}
-	var hashes []tezosClientValue
-	err = json.Unmarshal(content, &hashes)
+
+	err = client.AddKeyStore(TezosClientKeyStore{Path: path, Passphrase: DefaultPassphrase})
 	if err != nil {
-		return Client{}, fmt.Errorf("failed to decode tezos-client hashes: %w", err)
+		return Client{}, err
 	}
-	for _, hash := range hashes {
-		if _, ok := client.Wallets[hash.Name]; ok {
-			continue
-		}
-		wallet, err := NewWalletWithAddress(hash.Name, hash.Value)
-		if err != nil {
-			return Client{}, fmt.Errorf("failed to parse hash for %s: %w", hash.Name, err)
-		}
-		client.Wallets[hash.Name] = wallet
+	err = client.addEnvironmentKeyStores()
+	if err != nil {
+		return Client{}, err
 	}
 
-	// Contracts are similar format, but treated distinctly
+	// Contracts are similar format, but treated distinctly. Those named in the profile
+	// are specific to the network, so take precedence over tezos-client's.
 
 	content, err = ioutil.ReadFile(filepath.Join(path, "contracts"))
 	if err != nil {
@@ -217,6 +275,10 @@ func LoadClient(path string) (Client, error) {
 			return Client{}, fmt.Errorf("failed to decode tezos-client contracts: %w", err)
 		}
 		for _, contract_info := range contracts {
+			if client.profileContracts[contract_info.Name] {
+				client.shadowedContracts = append(client.shadowedContracts, contract_info)
+				continue
+			}
 			contract, err := NewContractWithAddress(contract_info.Name, contract_info.Value)
 			if err != nil {
 				return Client{}, fmt.Errorf("failed to parse contract %s: %w", contract_info.Name, err)
@@ -228,9 +290,48 @@ func LoadClient(path string) (Client, error) {
 	return client, nil
 }
 
+// Returns true if the environment gives both the node and, unless chain state is read
+// from the node, the indexer, in which case we don't need a profile.
+func environmentConfiguresNetwork() bool {
+	if os.Getenv("X4C_TEZOS_RPC_HOST") == "" {
+		return false
+	}
+	return os.Getenv("X4C_TEZOS_INDEX_HOST") != "" || os.Getenv("X4C_TEZOS_READER") == "node"
+}
+
+// AddKeyStore loads the wallets from a key store into the client, replacing any
+// already loaded with the same names.
+func (c *Client) AddKeyStore(store KeyStore) error {
+	wallets, err := store.LoadWallets()
+	if err != nil {
+		return fmt.Errorf("failed to load wallets: %w", err)
+	}
+	for name, wallet := range wallets {
+		c.Wallets[name] = wallet
+	}
+	return nil
+}
+
+// Keys given in the environment, whether as a keyfile or one key per variable, are
+// loaded after any tezos-client wallets, so that a deployment can override them.
+func (c *Client) addEnvironmentKeyStores() error {
+	if path := os.Getenv("X4C_KEY_FILE"); path != "" {
+		err := c.AddKeyStore(KeyFileStore{Path: path, Passphrase: DefaultPassphrase})
+		if err != nil {
+			return err
+		}
+	}
+	return c.AddKeyStore(EnvKeyStore{Prefix: "X4C_SECRET_KEY_", Passphrase: DefaultPassphrase})
+}
+
 func LoadDefaultClient() (Client, error) {
+	return LoadDefaultClientForNetwork("")
+}
+
+// LoadDefaultClientForNetwork is LoadDefaultClient with the named network profile.
+func LoadDefaultClientForNetwork(network string) (Client, error) {
 	default_path := filepath.Join(os.Getenv("HOME"), ".tezos-client")
-	return LoadClient(default_path)
+	return LoadClientForNetwork(default_path, network)
 }
 
 func (c *Client) FindNameForAddress(address string) string {
@@ -275,8 +376,12 @@ func (c *Client) SaveContract(contract Contract) error {
 
 	c.Contracts[contract.Name] = contract
 
-	new_file_contents := make([]tezosClientValue, 0, len(c.Contracts))
-	for _, info := range c.Contracts {
+	new_file_contents := make([]tezosClientValue, 0, len(c.Contracts)+len(c.shadowedContracts))
+	new_file_contents = append(new_file_contents, c.shadowedContracts...)
+	for name, info := range c.Contracts {
+		if c.profileContracts[name] {
+			continue
+		}
 		new_file_value := tezosClientValue{
 			Name:  info.Name,
 			Value: info.Address.String(),
@@ -297,45 +402,142 @@ func (c *Client) SaveContract(contract Contract) error {
 	return nil
 }
 
-func (c Client) CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+// Picks how operations for the provided wallet are signed, either with its local key
+// or via the remote signer. Any implicit account type can be signed for remotely, but
+// tzgo can't sign with BLS (tz4) keys itself.
+func (c Client) signerForWallet(signedBy Wallet) (signer.Signer, error) {
+	if !signedBy.Address.IsEOA() {
+		return nil, fmt.Errorf("%v is not an implicit account, so can't sign operations", signedBy.Name)
+	}
+
+	key, err := signedBy.SecretKey()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		if key.Type == tezos.KeyTypeBls12_381 {
+			return nil, fmt.Errorf("can't sign with the BLS key for %v locally, use a remote signer", signedBy.Name)
+		}
+		return signer.NewFromKey(*key), nil
+	}
+
+	// It's not a given that any hashes without keys are stored in signatory in
+	// general, but in the 4C app context I think we can assert this is, if not
+	// true. something we're happy to see errors for if we mess our tezos-client
+	// stores for :)
+	if c.SignatoryURL == "" {
+		return nil, fmt.Errorf("remote signer not configured for %v", signedBy.Name)
+	}
+	remoteSigner, err := remote.New(c.SignatoryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make remote signer for %v: %w", signedBy.Name, err)
+	}
+	return remoteSigner.WithAddress(signedBy.Address), nil
+}
+
+// Makes an RPC client that will sign operations as the provided wallet.
+func (c Client) newSigningRPCClient(ctx context.Context, signedBy Wallet) (*rpc.Client, error) {
+	walletSigner, err := c.signerForWallet(signedBy)
+	if err != nil {
+		return nil, err
+	}
 
 	rpcClient, err := rpc.NewClient(c.RPCURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create client: %w", err)
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	err = c.initRPCClient(ctx, rpcClient)
+	if err != nil {
+		return nil, err
+	}
+	rpcClient.Signer = walletSigner
+
+	return rpcClient, nil
+}
+
+// Connects to the node, and if the profile says which chain we expect it to be on,
+// checks that it is before we sign anything for it.
+func (c Client) initRPCClient(ctx context.Context, rpcClient *rpc.Client) error {
+	err := rpcClient.Init(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", c.RPCURL, err)
+	}
+	if c.ChainID != "" && rpcClient.ChainId.String() != c.ChainID {
+		return fmt.Errorf("node %s is on chain %s, but the %s profile expects %s", c.RPCURL, rpcClient.ChainId, c.Network, c.ChainID)
 	}
-	rpcClient.Init(ctx)
 	rpcClient.Listen()
+	return nil
+}
 
-	if signedBy.Key == nil {
-		// It's not a given that any hashes without keys are stored in signatory in
-		// general, but in the 4C app context I think we can assert this is, if not
-		// true. something we're happy to see errors for if we mess our tezos-client
-		// stores for :)
-		if c.SignatoryURL == "" {
-			return "", fmt.Errorf("remote signer not configured for %v", signedBy.Name)
-		}
-		remoteSigner, err := remote.New(c.SignatoryURL, nil)
-		if err != nil {
-			return "", fmt.Errorf("failed to make remote signer for %v: %w", signedBy.Name, err)
-		}
-		if signedBy.Address.String() == "" {
-			return "", fmt.Errorf("signer is missing address!")
-		}
-		rpcClient.Signer = remoteSigner.WithAddress(signedBy.Address)
-	} else {
-		rpcClient.Signer = signer.NewFromKey(*signedBy.Key)
+// Used instead of rpc.Client.Send so that we can return as soon as the node has
+// accepted the operation, and know the operation hash even if broadcasting fails.
+func injectOperation(ctx context.Context, rpcClient *rpc.Client, signedBy Wallet, op *codec.Op) (string, error) {
+	_, err := simulateOperation(ctx, rpcClient, signedBy, op)
+	if err != nil {
+		return "", err
+	}
+
+	if limits := op.Limits(); limits.Fee > rpc.DefaultOptions.MaxFee {
+		return "", fmt.Errorf("estimated fee %d is more than maximum of %d", limits.Fee, rpc.DefaultOptions.MaxFee)
+	}
+
+	signature, err := rpcClient.Signer.SignOperation(ctx, signedBy.Address, op)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign operation: %w", err)
+	}
+	op.WithSignature(signature)
+
+	hash, err := rpcClient.Broadcast(ctx, op)
+	if err != nil {
+		// The operation hash is the digest of the signed operation, so we know it
+		// even if we don't hear back from the node
+		digest := tezos.Digest(op.Bytes())
+		return "", BroadcastError{OperationHash: tezos.NewOpHash(digest[:]).String(), Err: err}
+	}
+	return hash.String(), nil
+}
+
+// CallContract injects the operation and waits for it to be confirmed, as
+// rpc.Client.Send does, so that anything done next can rely on it having happened. If
+// the operation was included but failed then its hash is returned with the error.
+func (c Client) CallContract(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+	operation_hash, err := c.InjectContractCall(ctx, signedBy, target, parameters)
+	if err != nil {
+		return "", err
+	}
+	status, err := c.WaitForConfirmation(ctx, operation_hash, rpc.DefaultOptions.Confirmations)
+	if err != nil {
+		return operation_hash, err
+	}
+	if !status.IsApplied() {
+		return operation_hash, fmt.Errorf("operation %s was %s: %s", operation_hash, status.Status, strings.Join(status.Errors, ", "))
+	}
+	return operation_hash, nil
+}
+
+// InjectContractCall returns the operation hash once the node has accepted the
+// operation, which is before it has been included in a block. Callers must wait for
+// it with WaitForConfirmation before sending another operation from the same wallet,
+// as until then the new operation would be given the same counter and be refused.
+func (c Client) InjectContractCall(ctx context.Context, signedBy Wallet, target Contract, parameters micheline.Parameters) (string, error) {
+
+	rpcClient, err := c.newSigningRPCClient(ctx, signedBy)
+	if err != nil {
+		return "", err
 	}
 
 	op := codec.NewOp().WithSource(signedBy.Address)
 	op.WithCall(target.Address, parameters)
 
-	// send operation with default options
-	var result *rpc.Receipt
+	var operation_hash string
 	for tries := 0; true; tries += 1 {
-		result, err = rpcClient.Send(ctx, op, nil)
+		operation_hash, err = injectOperation(ctx, rpcClient, signedBy, op)
 		if err != nil {
+			// If the node might have the operation then sending it again could see it
+			// included twice, so only retry if we didn't get as far as broadcasting it
 			var urlError *url.Error
-			if errors.As(err, &urlError) && (tries < maxRetries) {
+			var broadcastError BroadcastError
+			if errors.As(err, &urlError) && !errors.As(err, &broadcastError) && (tries < maxRetries) {
 				time.Sleep(500 * time.Millisecond)
 				continue
 			}
@@ -344,20 +546,31 @@ func (c Client) CallContract(ctx context.Context, signedBy Wallet, target Contra
 		break
 	}
 
-	// return just the operation hash
-	if (result == nil) || (result.Op == nil) {
-		return "", fmt.Errorf("malformed result: %v", result)
+	return operation_hash, nil
+}
+
+func (c Client) newReader() (chainReader, error) {
+	switch c.Reader {
+	case "", "tzkt":
+		indexer, err := tzkt.NewClient(c.IndexerRPCURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make indexer: %w", err)
+		}
+		return &indexer, nil
+	case "node":
+		return NewNodeReader(c.RPCURL, c.FirstLevel)
+	default:
+		return nil, fmt.Errorf("unknown reader %s, expected tzkt or node", c.Reader)
 	}
-	return result.Op.Hash.String(), nil
 }
 
-// These just call through to the indexer
+// These just call through to the indexer, or the node
 func (c Client) GetContractStorage(target Contract, ctx context.Context, storage interface{}) error {
-	indexer, err := tzkt.NewClient(c.IndexerRPCURL)
+	reader, err := c.newReader()
 	if err != nil {
-		return fmt.Errorf("failed to make indexer: %w", err)
+		return err
 	}
-	err = indexer.GetContractStorage(ctx, target.Address.String(), storage)
+	err = reader.GetContractStorage(ctx, target.Address.String(), storage)
 	if err != nil {
 		return fmt.Errorf("failed to fetch storage: %w", err)
 	}
@@ -365,28 +578,60 @@ func (c Client) GetContractStorage(target Contract, ctx context.Context, storage
 	return nil
 }
 
-func (c Client) GetBigMapContents(ctx context.Context, identifier int64) ([]tzkt.BigMapItem, error) {
-	indexer, err := tzkt.NewClient(c.IndexerRPCURL)
+func (c Client) GetBigMapContents(ctx context.Context, identifier int64, options tzkt.QueryOptions) ([]tzkt.BigMapItem, error) {
+	reader, err := c.newReader()
 	if err != nil {
-		return nil, fmt.Errorf("failed to make indexer: %w", err)
+		return nil, err
 	}
-	return indexer.GetBigMapContents(ctx, identifier)
+	return reader.GetBigMapContents(ctx, identifier, options)
+}
+
+func (c Client) GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]tzkt.BigMapItem, error) {
+	reader, err := c.newReader()
+	if err != nil {
+		return nil, err
+	}
+	return reader.GetBigMapContentsAt(ctx, identifier, level)
+}
+
+func (c Client) GetLevelAtTime(ctx context.Context, at time.Time) (int32, error) {
+	reader, err := c.newReader()
+	if err != nil {
+		return 0, err
+	}
+	return reader.GetLevelAtTime(ctx, at)
+}
+
+func (c Client) GetHead(ctx context.Context) (tzkt.Head, error) {
+	reader, err := c.newReader()
+	if err != nil {
+		return tzkt.Head{}, err
+	}
+	return reader.GetHead(ctx)
 }
 
 func (c Client) GetOperationInformation(ctx context.Context, hash string) ([]tzkt.Operation, error) {
-	indexer, err := tzkt.NewClient(c.IndexerRPCURL)
+	reader, err := c.newReader()
 	if err != nil {
-		return nil, fmt.Errorf("failed to make indexer: %w", err)
+		return nil, err
 	}
-	return indexer.GetOperationInformation(ctx, hash)
+	return reader.GetOperationInformation(ctx, hash)
 }
 
-func (c Client) GetContractEvents(ctx context.Context, contractAddress string, tag string) ([]tzkt.Event, error) {
-	indexer, err := tzkt.NewClient(c.IndexerRPCURL)
+func (c Client) GetTransactionByID(ctx context.Context, identifier int64) (tzkt.Operation, error) {
+	reader, err := c.newReader()
 	if err != nil {
-		return nil, fmt.Errorf("failed to make indexer: %w", err)
+		return tzkt.Operation{}, err
 	}
-	return indexer.GetContractEvents(ctx, contractAddress, tag)
+	return reader.GetTransactionByID(ctx, identifier)
+}
+
+func (c Client) GetContractEvents(ctx context.Context, contractAddress string, tag string, options tzkt.QueryOptions) ([]tzkt.Event, error) {
+	reader, err := c.newReader()
+	if err != nil {
+		return nil, err
+	}
+	return reader.GetContractEvents(ctx, contractAddress, tag, options)
 }
 
 func (c Client) Originate(ctx context.Context, signedBy Wallet, codedata []byte, initial_storage micheline.Prim) (Contract, error) {
@@ -398,13 +643,18 @@ func (c Client) Originate(ctx context.Context, signedBy Wallet, codedata []byte,
 	rpc.UseLogger(log.Log)
 	contract.UseLogger(log.Log)
 
-	if signedBy.Key == nil {
+	if !signedBy.HasSecretKey() {
 		return Contract{}, fmt.Errorf("signer wallet %s has no private key", signedBy.Name)
 	}
-	rpcClient.Signer = signer.NewFromKey(*signedBy.Key)
+	rpcClient.Signer, err = c.signerForWallet(signedBy)
+	if err != nil {
+		return Contract{}, err
+	}
 
-	rpcClient.Init(ctx)
-	rpcClient.Listen()
+	err = c.initRPCClient(ctx, rpcClient)
+	if err != nil {
+		return Contract{}, err
+	}
 
 	contract := contract.NewEmptyContract(rpcClient)
 	code := micheline.Code{}
@@ -427,7 +677,7 @@ func (c Client) Originate(ctx context.Context, signedBy Wallet, codedata []byte,
 				time.Sleep(500 * time.Millisecond)
 				continue
 			}
-			return Contract{}, fmt.Errorf("failed to deploy: %v", err)
+			return Contract{}, fmt.Errorf("failed to deploy: %w", err)
 		}
 		break
 	}
@@ -1,7 +1,16 @@
 package tzclient
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"blockwatch.cc/tzgo/codec"
+	"blockwatch.cc/tzgo/tezos"
+	"golang.org/x/crypto/blake2b"
 )
 
 func TestLoadInvalidPath(t *testing.T) {
@@ -10,3 +19,116 @@ func TestLoadInvalidPath(t *testing.T) {
 		t.Error("Expected an error value, got nil")
 	}
 }
+
+// A stand in for Signatory, holding keys for some addresses and refusing to sign for
+// any others.
+type testRemoteSigner struct {
+	keys      map[string]tezos.PrivateKey
+	requested []string
+}
+
+func (s *testRemoteSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, "/keys/")
+	s.requested = append(s.requested, address)
+	key, ok := s.keys[address]
+	if !ok {
+		http.Error(w, "unknown key", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]string{"public_key": key.Public().String()})
+	case http.MethodPost:
+		var message tezos.HexBytes
+		err := json.NewDecoder(r.Body).Decode(&message)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		digest := blake2b.Sum256(message)
+		signature, err := key.Sign(digest[:])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"signature": signature.String()})
+	}
+}
+
+func TestSignWithEachCurve(t *testing.T) {
+	remote_signer := &testRemoteSigner{keys: make(map[string]tezos.PrivateKey)}
+	server := httptest.NewServer(remote_signer)
+	defer server.Close()
+	client := Client{SignatoryURL: server.URL}
+	destination, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+
+	for _, key_type := range []tezos.KeyType{tezos.KeyTypeEd25519, tezos.KeyTypeSecp256k1, tezos.KeyTypeP256} {
+		key, err := tezos.GenerateKey(key_type)
+		if err != nil {
+			t.Fatalf("%v: Failed to generate key: %v", key_type, err)
+		}
+		remote_signer.keys[key.Address().String()] = key
+
+		local, err := NewWalletWithPrivateKey("local", key.String())
+		if err != nil {
+			t.Fatalf("%v: Failed to make wallet: %v", key_type, err)
+		}
+		remote, err := NewWalletWithAddress("remote", key.Address().String())
+		if err != nil {
+			t.Fatalf("%v: Failed to make wallet: %v", key_type, err)
+		}
+
+		for _, wallet := range []Wallet{local, remote} {
+			wallet_signer, err := client.signerForWallet(wallet)
+			if err != nil {
+				t.Errorf("%v %s: Failed to make signer: %v", key_type, wallet.Name, err)
+				continue
+			}
+			op := codec.NewOp().WithSource(wallet.Address).WithBranch(tezos.ZeroBlockHash).WithTransfer(destination, 1)
+			signature, err := wallet_signer.SignOperation(context.Background(), wallet.Address, op)
+			if err != nil {
+				t.Errorf("%v %s: Failed to sign: %v", key_type, wallet.Name, err)
+				continue
+			}
+			err = key.Public().Verify(op.Digest(), signature)
+			if err != nil {
+				t.Errorf("%v %s: Signature does not verify: %v", key_type, wallet.Name, err)
+			}
+		}
+	}
+	if len(remote_signer.requested) != 3 {
+		t.Errorf("Expected 3 requests to the remote signer, got %v", remote_signer.requested)
+	}
+
+	// tzgo can't make BLS signatures, so tz4 wallets have to sign remotely
+	bls_address := "tz496afrNbzJu2jtMFwkELNm5WPumbzCEh2S"
+	remote, err := NewWalletWithAddress("remote", bls_address)
+	if err != nil {
+		t.Fatalf("Failed to make tz4 wallet: %v", err)
+	}
+	wallet_signer, err := client.signerForWallet(remote)
+	if err != nil {
+		t.Fatalf("Failed to make tz4 signer: %v", err)
+	}
+	op := codec.NewOp().WithSource(remote.Address).WithBranch(tezos.ZeroBlockHash).WithTransfer(destination, 1)
+	_, _ = wallet_signer.SignOperation(context.Background(), remote.Address, op)
+	if remote_signer.requested[len(remote_signer.requested)-1] != bls_address {
+		t.Errorf("Expected tz4 signing to go to the remote signer, got %v", remote_signer.requested)
+	}
+	local := remote
+	local.Key = &tezos.PrivateKey{Type: tezos.KeyTypeBls12_381, Data: make([]byte, 32)}
+	_, err = client.signerForWallet(local)
+	if err == nil {
+		t.Errorf("Expected error signing with a local BLS key")
+	}
+
+	_, err = Client{}.signerForWallet(remote)
+	if err == nil {
+		t.Errorf("Expected error with no remote signer configured")
+	}
+	contract := Wallet{Name: "contract", Address: tezos.MustParseAddress("KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")}
+	_, err = client.signerForWallet(contract)
+	if err == nil {
+		t.Errorf("Expected error signing as a contract")
+	}
+}
@@ -2,6 +2,7 @@ package tzclient
 
 import (
 	"fmt"
+	"sync"
 
 	"blockwatch.cc/tzgo/tezos"
 )
@@ -14,6 +15,58 @@ type Wallet struct {
 	Name    string
 	Address tezos.Address
 	Key     *tezos.PrivateKey
+
+	// Set for wallets whose secret key is stored encrypted, which is only decrypted
+	// when it is first needed, so that loading a client doesn't ask for the passphrase
+	// of every key it has.
+	locked *lockedKey
+}
+
+type lockedKey struct {
+	encrypted  string
+	passphrase PassphraseFunc
+
+	once sync.Once
+	key  *tezos.PrivateKey
+	err  error
+}
+
+func (l *lockedKey) unlock(name string) (*tezos.PrivateKey, error) {
+	l.once.Do(func() {
+		key, err := tezos.ParseEncryptedPrivateKey(l.encrypted, func() ([]byte, error) {
+			if l.passphrase == nil {
+				return nil, fmt.Errorf("no passphrase available")
+			}
+			return l.passphrase(name)
+		})
+		if err != nil {
+			l.err = fmt.Errorf("failed to decrypt secret key for %s: %w", name, err)
+			return
+		}
+		l.key = &key
+	})
+	return l.key, l.err
+}
+
+// SecretKey returns the wallet's secret key, decrypting it if need be, or nil if the
+// wallet doesn't have one, in which case operations must be signed remotely.
+func (w Wallet) SecretKey() (*tezos.PrivateKey, error) {
+	if w.Key != nil || w.locked == nil {
+		return w.Key, nil
+	}
+	key, err := w.locked.unlock(w.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Address().Equal(w.Address) {
+		return nil, fmt.Errorf("secret key for %s does not match its address %s", w.Name, w.Address)
+	}
+	return key, nil
+}
+
+// HasSecretKey says whether the wallet has a secret key, encrypted or not.
+func (w Wallet) HasSecretKey() bool {
+	return w.Key != nil || w.locked != nil
 }
 
 func NewWalletWithAddress(name string, address string) (Wallet, error) {
@@ -21,9 +74,9 @@ func NewWalletWithAddress(name string, address string) (Wallet, error) {
 	if err != nil {
 		return Wallet{}, err
 	}
-	// we should support more types for this, rather than just tz1
-	if tezos_address.Type != tezos.AddressTypeEd25519 {
-		return Wallet{}, fmt.Errorf("invalid wallet address")
+	// Any implicit account can be a wallet, whichever curve its key is on
+	if !tezos_address.IsEOA() {
+		return Wallet{}, fmt.Errorf("invalid wallet address, expected a tz1, tz2, tz3, or tz4 address")
 	}
 	return Wallet{
 		Name:    name,
@@ -31,6 +84,22 @@ func NewWalletWithAddress(name string, address string) (Wallet, error) {
 	}, nil
 }
 
+// NewWalletWithEncryptedPrivateKey makes a wallet for an encrypted secret key, which
+// will be decrypted with a passphrase from the given function when it is first used.
+func NewWalletWithEncryptedPrivateKey(name string, address tezos.Address, encrypted_key string, passphrase PassphraseFunc) (Wallet, error) {
+	if !tezos.IsEncryptedKey(encrypted_key) {
+		return Wallet{}, fmt.Errorf("not an encrypted secret key")
+	}
+	return Wallet{
+		Name:    name,
+		Address: address,
+		locked: &lockedKey{
+			encrypted:  encrypted_key,
+			passphrase: passphrase,
+		},
+	}, nil
+}
+
 func NewWalletWithPrivateKey(name string, private_key string) (Wallet, error) {
 	tezos_private_key, err := tezos.ParsePrivateKey(private_key)
 	if err != nil {
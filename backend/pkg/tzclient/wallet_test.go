@@ -11,7 +11,10 @@ func TestCreateWalletByAddress(t *testing.T) {
 	}{
 		{"invalid", false},
 		{"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", true},
-		// Contract is a valid address, but not an ED25519 wallet address
+		{"tz28QZkJtASQaeeieppeZjx8iaFPUtPpBrZd", true},
+		{"tz3LRNhdn2ZwyH7255tuZhQWXw8uFiXNJRVw", true},
+		{"tz496afrNbzJu2jtMFwkELNm5WPumbzCEh2S", true},
+		// Contract is a valid address, but not an implicit account address
 		{"KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", false},
 	}
 	for index, testcase := range testcases {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 )
 
 type BigMapItem struct {
@@ -17,26 +18,68 @@ type BigMapItem struct {
 	Updates    int64           `json:"updates"`
 }
 
-func (c *TzKTClient) GetBigMapContents(ctx context.Context, identifier int64) ([]BigMapItem, error) {
-	path := fmt.Sprintf("/v1/bigmaps/%d/keys", identifier)
-
-	var results []BigMapItem
-	err := c.makeRequest(ctx, path, &results)
-
+// GetBigMapContents returns the keys in the big map that match the options, paging
+// through the results so that none are missed. Unless ActiveOnly is set this includes
+// keys that have been removed from the map.
+func (c *TzKTClient) GetBigMapContents(ctx context.Context, identifier int64, options QueryOptions) ([]BigMapItem, error) {
+	err := checkFieldRoots(options.Fields, bigMapFieldRoots)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
+	query := options.values("lastLevel", false)
 
-	// Until we get some json schema action in here, and given there is no "required" validation in golang's
-	// json library, so a quick sanitation check
-	for index, item := range results {
-		if item.Identifier == 0 {
-			return nil, fmt.Errorf("item %d had invalid identifier %d", index, item.Identifier)
+	results := make([]BigMapItem, 0)
+	for {
+		page_size := options.pageSize(len(results))
+		query.Set("limit", strconv.Itoa(page_size))
+		path := fmt.Sprintf("/v1/bigmaps/%d/keys?%s", identifier, query.Encode())
+		var page []BigMapItem
+		err := c.makeRequest(ctx, path, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make request: %w", err)
 		}
-		if item.Hash == "" {
-			return nil, fmt.Errorf("item %d had empty hash", index)
+
+		// Until we get some json schema action in here, and given there is no "required" validation in golang's
+		// json library, so a quick sanitation check
+		for index, item := range page {
+			if item.Identifier == 0 {
+				return nil, fmt.Errorf("item %d had invalid identifier %d", len(results)+index, item.Identifier)
+			}
+			if item.Hash == "" {
+				return nil, fmt.Errorf("item %d had empty hash", len(results)+index)
+			}
+		}
+
+		results = append(results, page...)
+		if len(page) < page_size || len(results) == options.Limit {
+			return results, nil
 		}
+		query.Set("offset.cr", strconv.FormatInt(page[len(page)-1].Identifier, 10))
 	}
+}
 
-	return results, nil
+// GetBigMapContentsAt returns the keys that were in the big map at the end of the
+// given level, paging through the results so that none are missed.
+func (c *TzKTClient) GetBigMapContentsAt(ctx context.Context, identifier int64, level int32) ([]BigMapItem, error) {
+	results := make([]BigMapItem, 0)
+	for {
+		path := fmt.Sprintf("/v1/bigmaps/%d/historical_keys/%d?sort.asc=id&offset=%d&limit=%d", identifier, level, len(results), maxPageSize)
+		var page []BigMapItem
+		err := c.makeRequest(ctx, path, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make request: %w", err)
+		}
+		for index, item := range page {
+			if item.Identifier == 0 {
+				return nil, fmt.Errorf("item %d had invalid identifier %d", len(results)+index, item.Identifier)
+			}
+			if item.Hash == "" {
+				return nil, fmt.Errorf("item %d had empty hash", len(results)+index)
+			}
+		}
+		results = append(results, page...)
+		if len(page) < maxPageSize {
+			return results, nil
+		}
+	}
 }
@@ -2,6 +2,7 @@ package tzkt
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -104,7 +105,7 @@ func TestGetBigMap(t *testing.T) {
 		}
 
 		ctx := context.Background()
-		resp, err := tzclient.GetBigMapContents(ctx, 1234)
+		resp, err := tzclient.GetBigMapContents(ctx, 1234, QueryOptions{})
 		if testcase.ExpectError {
 			if err == nil {
 				t.Errorf("Testcase %d expected error, got none", index)
@@ -119,3 +120,66 @@ func TestGetBigMap(t *testing.T) {
 		}
 	}
 }
+
+func TestGetBigMapPaging(t *testing.T) {
+	base_url, _ := url.Parse("http://test.com")
+	mockClient := &HTTPClientMock{}
+	tzclient := TzKTClient{
+		client:  mockClient,
+		BaseURL: base_url,
+	}
+
+	// The first page is full, so the client should ask for another after the last ID
+	requests := make([]url.Values, 0)
+	mockClient.DoFunc = func(r *http.Request) (*http.Response, error) {
+		query := r.URL.Query()
+		requests = append(requests, query)
+		count := 3
+		if query.Get("offset.cr") == "" {
+			count = maxPageSize
+		}
+		items := make([]string, count)
+		for index := range items {
+			items[index] = fmt.Sprintf(`{"id": %d, "active": true, "hash": "expr", "key": "k", "value": "1"}`, len(requests)*maxPageSize+index)
+		}
+		return &http.Response{
+			Body:       io.NopCloser(strings.NewReader("[" + strings.Join(items, ",") + "]")),
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+
+	options := QueryOptions{
+		MinLevel:   100,
+		ActiveOnly: true,
+		Fields:     map[string]string{"key.kyc": "05010000000473656c66"},
+	}
+	resp, err := tzclient.GetBigMapContents(context.Background(), 1234, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resp) != maxPageSize+3 {
+		t.Errorf("Expected %d items, got %d", maxPageSize+3, len(resp))
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	if requests[1].Get("offset.cr") != fmt.Sprintf("%d", 2*maxPageSize-1) {
+		t.Errorf("Expected second page to start after last ID, got %v", requests[1])
+	}
+	for name, expected := range map[string]string{
+		"lastLevel.ge": "100",
+		"active":       "true",
+		"key.kyc":      "05010000000473656c66",
+		"sort.asc":     "id",
+	} {
+		if requests[0].Get(name) != expected {
+			t.Errorf("Expected %s=%s, got %v", name, expected, requests[0])
+		}
+	}
+
+	// Big map keys have no payload to filter on
+	_, err = tzclient.GetBigMapContents(context.Background(), 1234, QueryOptions{Fields: map[string]string{"payload.kyc": "x"}})
+	if err == nil {
+		t.Errorf("Expected error for invalid field path")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -12,6 +13,13 @@ type EventContractInfo struct {
 	Alias   *string `json:"alias,omitempty"`
 }
 
+// ContractBigMap describes one of the big maps in a contract's storage.
+type ContractBigMap struct {
+	Pointer int64  `json:"ptr"`
+	Path    string `json:"path"`
+	Active  bool   `json:"active"`
+}
+
 type Event struct {
 	Identifier    int64             `json:"id"`
 	Level         int32             `json:"level"`
@@ -32,13 +40,44 @@ func (c *TzKTClient) GetContractStorage(ctx context.Context, contractAddress str
 	return nil
 }
 
-func (c *TzKTClient) GetContractEvents(ctx context.Context, contractAddress string, tag string) ([]Event, error) {
-	path := fmt.Sprintf("/v1/contracts/events?contract=%s&tag=%s", contractAddress, tag)
+// GetContractEvents returns the events from the contract with the tag that match the
+// options, paging through the results so that none are missed. If the tag is empty
+// then events with any tag are returned.
+func (c *TzKTClient) GetContractEvents(ctx context.Context, contractAddress string, tag string, options QueryOptions) ([]Event, error) {
+	err := checkFieldRoots(options.Fields, eventFieldRoots)
+	if err != nil {
+		return nil, err
+	}
+	query := options.values("level", true)
+	query.Set("contract", contractAddress)
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+
+	results := make([]Event, 0)
+	for {
+		page_size := options.pageSize(len(results))
+		query.Set("limit", strconv.Itoa(page_size))
+		path := fmt.Sprintf("/v1/contracts/events?%s", query.Encode())
+		var page []Event
+		err := c.makeRequest(ctx, path, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make event request: %w", err)
+		}
+		results = append(results, page...)
+		if len(page) < page_size || len(results) == options.Limit {
+			return results, nil
+		}
+		query.Set("offset.cr", strconv.FormatInt(page[len(page)-1].Identifier, 10))
+	}
+}
 
-	var results []Event
+func (c *TzKTClient) GetContractBigMaps(ctx context.Context, contractAddress string) ([]ContractBigMap, error) {
+	path := fmt.Sprintf("/v1/contracts/%s/bigmaps?limit=%d", contractAddress, maxPageSize)
+	var results []ContractBigMap
 	err := c.makeRequest(ctx, path, &results)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make event request: %w", err)
+		return nil, fmt.Errorf("failed to make big map request: %w", err)
 	}
 	return results, nil
 }
@@ -4,21 +4,55 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+type OperationError struct {
+	Type string `json:"type"`
+}
+
+type OperationParty struct {
+	Address string `json:"address"`
+	Alias   string `json:"alias,omitempty"`
+}
+
 type Operation struct {
-	Type       string          `json:"type"`
-	Identifier int64           `json:"id"`
-	Level      int32           `json:"level"`
-	Timestamp  time.Time       `json:"timestamp"`
-	Block      string          `json:"block"`
-	Hash       string          `json:"hash"`
-	Delegate   json.RawMessage `json:"delegate,omitempty"`
-	Parameter  json.RawMessage `json:"parameter,omitempty"`
-	Slots      int32           `json:"slots"`
-	Deposit    int64           `json:"deposit"`
-	Quote      json.RawMessage `json:"quote,omitempty"`
+	Type          string           `json:"type"`
+	Identifier    int64            `json:"id"`
+	Level         int32            `json:"level"`
+	Timestamp     time.Time        `json:"timestamp"`
+	Block         string           `json:"block"`
+	Hash          string           `json:"hash"`
+	Sender        *OperationParty  `json:"sender,omitempty"`
+	Target        *OperationParty  `json:"target,omitempty"`
+	Delegate      json.RawMessage  `json:"delegate,omitempty"`
+	Parameter     json.RawMessage  `json:"parameter,omitempty"`
+	Slots         int32            `json:"slots"`
+	Deposit       int64            `json:"deposit"`
+	Quote         json.RawMessage  `json:"quote,omitempty"`
+	Status        string           `json:"status"`
+	GasUsed       int64            `json:"gasUsed"`
+	StorageUsed   int64            `json:"storageUsed"`
+	BakerFee      int64            `json:"bakerFee"`
+	StorageFee    int64            `json:"storageFee"`
+	AllocationFee int64            `json:"allocationFee"`
+	Errors        []OperationError `json:"errors,omitempty"`
+}
+
+// Head is the most recent block the indexer has processed.
+type Head struct {
+	Level     int32     `json:"level"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Block is a summary of a block the indexer has processed.
+type Block struct {
+	Level     int32     `json:"level"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 func (c *TzKTClient) GetOperationInformation(ctx context.Context, hash string) ([]Operation, error) {
@@ -32,3 +66,55 @@ func (c *TzKTClient) GetOperationInformation(ctx context.Context, hash string) (
 	}
 	return results, nil
 }
+
+// GetTransactionByID finds a transaction from its tzkt identifier, which is what events
+// give as their transactionId.
+func (c *TzKTClient) GetTransactionByID(ctx context.Context, identifier int64) (Operation, error) {
+	query := url.Values{}
+	query.Set("id", strconv.FormatInt(identifier, 10))
+	var results []Operation
+	err := c.makeRequest(ctx, "/v1/operations/transactions?"+query.Encode(), &results)
+	if err != nil {
+		return Operation{}, fmt.Errorf("failed to make operation request: %w", err)
+	}
+	if len(results) == 0 {
+		return Operation{}, fmt.Errorf("no transaction with id %d", identifier)
+	}
+	return results[0], nil
+}
+
+func (c *TzKTClient) GetHead(ctx context.Context) (Head, error) {
+	var head Head
+	err := c.makeRequest(ctx, "/v1/head", &head)
+	if err != nil {
+		return Head{}, fmt.Errorf("failed to make head request: %w", err)
+	}
+	return head, nil
+}
+
+func (c *TzKTClient) GetBlock(ctx context.Context, level int32) (Block, error) {
+	path := fmt.Sprintf("/v1/blocks/%d", level)
+	var block Block
+	err := c.makeRequest(ctx, path, &block)
+	if err != nil {
+		return Block{}, fmt.Errorf("failed to make block request: %w", err)
+	}
+	return block, nil
+}
+
+// GetLevelAtTime returns the level of the last block made at or before the given time.
+func (c *TzKTClient) GetLevelAtTime(ctx context.Context, at time.Time) (int32, error) {
+	query := url.Values{}
+	query.Set("timestamp.le", at.UTC().Format(time.RFC3339))
+	query.Set("sort.desc", "level")
+	query.Set("limit", "1")
+	var blocks []Block
+	err := c.makeRequest(ctx, "/v1/blocks?"+query.Encode(), &blocks)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make block request: %w", err)
+	}
+	if len(blocks) == 0 {
+		return 0, fmt.Errorf("no blocks at or before %v", at)
+	}
+	return blocks[0].Level, nil
+}
@@ -0,0 +1,187 @@
+package tzkt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The most items TzKT will return in one request.
+const maxPageSize = 10000
+
+// QueryOptions narrows down the big map keys or events that are returned. The zero
+// value returns everything, oldest first.
+type QueryOptions struct {
+	// Only include items from this range of levels, inclusive, where zero means
+	// unbounded. For big map keys this is the level at which the key last changed.
+	MinLevel int32
+	MaxLevel int32
+
+	// Only include events emitted at or after After and before Before, where the zero
+	// time means unbounded. Big map keys have no timestamp, so these are ignored for them.
+	After  time.Time
+	Before time.Time
+
+	// Only include big map keys that are currently in the map.
+	ActiveOnly bool
+
+	// Exact matches on parts of a big map key or value, or of an event payload, by
+	// path, e.g. "key.kyc" or "payload.token.token_id".
+	Fields map[string]string
+
+	// Return the newest items first.
+	Descending bool
+
+	// The most items to return, where zero means all of them.
+	Limit int
+}
+
+// Fields must start with one of these, as that's all TzKT lets us filter on.
+var bigMapFieldRoots = []string{"key", "value"}
+var eventFieldRoots = []string{"payload"}
+
+func checkFieldRoots(fields map[string]string, roots []string) error {
+	for path := range fields {
+		root, _, _ := strings.Cut(path, ".")
+		found := false
+		for _, allowed := range roots {
+			if root == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("can not filter on %q, path must start with one of %v", path, roots)
+		}
+	}
+	return nil
+}
+
+// The query parameters for the options, where level_field is what TzKT calls the level
+// for the kind of item being fetched.
+func (o QueryOptions) values(level_field string, include_time bool) url.Values {
+	values := url.Values{}
+	if o.MinLevel != 0 {
+		values.Set(level_field+".ge", strconv.FormatInt(int64(o.MinLevel), 10))
+	}
+	if o.MaxLevel != 0 {
+		values.Set(level_field+".le", strconv.FormatInt(int64(o.MaxLevel), 10))
+	}
+	if include_time && !o.After.IsZero() {
+		values.Set("timestamp.ge", o.After.UTC().Format(time.RFC3339))
+	}
+	if include_time && !o.Before.IsZero() {
+		values.Set("timestamp.lt", o.Before.UTC().Format(time.RFC3339))
+	}
+	if o.ActiveOnly {
+		values.Set("active", "true")
+	}
+	for path, value := range o.Fields {
+		values.Set(path, value)
+	}
+	if o.Descending {
+		values.Set("sort.desc", "id")
+	} else {
+		values.Set("sort.asc", "id")
+	}
+	return values
+}
+
+// The size of the next page to ask for, given how many items we have so far.
+func (o QueryOptions) pageSize(count int) int {
+	if o.Limit > 0 && o.Limit-count < maxPageSize {
+		return o.Limit - count
+	}
+	return maxPageSize
+}
+
+// Finds the part of the JSON value at the path, which has already had its root
+// removed. TzKT compares on the text of the value, so strings are unquoted.
+func jsonPathValue(data json.RawMessage, path string) (string, bool) {
+	current := data
+	if path != "" {
+		for _, part := range strings.Split(path, ".") {
+			var object map[string]json.RawMessage
+			if json.Unmarshal(current, &object) != nil {
+				return "", false
+			}
+			next, ok := object[part]
+			if !ok {
+				return "", false
+			}
+			current = next
+		}
+	}
+	var text string
+	if json.Unmarshal(current, &text) == nil {
+		return text, true
+	}
+	return string(current), true
+}
+
+func fieldsMatch(fields map[string]string, roots map[string]json.RawMessage) bool {
+	for path, expected := range fields {
+		root, rest, _ := strings.Cut(path, ".")
+		data, ok := roots[root]
+		if !ok {
+			return false
+		}
+		actual, ok := jsonPathValue(data, rest)
+		if !ok || actual != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesBigMapItem applies the options to a big map key held locally, in the same way
+// TzKT would, for clients that don't fetch keys from TzKT directly.
+func (o QueryOptions) MatchesBigMapItem(item BigMapItem) bool {
+	if o.MinLevel != 0 && item.LastLevel < int64(o.MinLevel) {
+		return false
+	}
+	if o.MaxLevel != 0 && item.LastLevel > int64(o.MaxLevel) {
+		return false
+	}
+	if o.ActiveOnly && !item.Active {
+		return false
+	}
+	return fieldsMatch(o.Fields, map[string]json.RawMessage{"key": item.Key, "value": item.Value})
+}
+
+// MatchesEvent applies the options to an event held locally, in the same way TzKT
+// would, for clients that don't fetch events from TzKT directly.
+func (o QueryOptions) MatchesEvent(event Event) bool {
+	if o.MinLevel != 0 && event.Level < o.MinLevel {
+		return false
+	}
+	if o.MaxLevel != 0 && event.Level > o.MaxLevel {
+		return false
+	}
+	if !o.After.IsZero() && event.Timestamp.Before(o.After) {
+		return false
+	}
+	if !o.Before.IsZero() && !event.Timestamp.Before(o.Before) {
+		return false
+	}
+	return fieldsMatch(o.Fields, map[string]json.RawMessage{"payload": event.Payload})
+}
+
+// Orders and limits items that have already been through the Matches functions, which
+// must be given to it oldest first.
+func ApplyOrderAndLimit[T any](o QueryOptions, items []T) []T {
+	if o.Descending {
+		reversed := make([]T, len(items))
+		for index, item := range items {
+			reversed[len(items)-index-1] = item
+		}
+		items = reversed
+	}
+	if o.Limit > 0 && len(items) > o.Limit {
+		items = items[:o.Limit]
+	}
+	return items
+}
@@ -0,0 +1,78 @@
+package tzkt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMatchesBigMapItem(t *testing.T) {
+	item := BigMapItem{
+		Active:    true,
+		Key:       json.RawMessage(`{"kyc": "05010000000473656c66", "token": {"token_id": "123", "token_address": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"}}`),
+		Value:     json.RawMessage(`"500"`),
+		LastLevel: 100,
+	}
+
+	testcases := []struct {
+		Options  QueryOptions
+		Expected bool
+	}{
+		{QueryOptions{}, true},
+		{QueryOptions{MinLevel: 100, MaxLevel: 100}, true},
+		{QueryOptions{MinLevel: 101}, false},
+		{QueryOptions{MaxLevel: 99}, false},
+		{QueryOptions{Fields: map[string]string{"key.token.token_id": "123"}}, true},
+		{QueryOptions{Fields: map[string]string{"key.token.token_id": "124"}}, false},
+		{QueryOptions{Fields: map[string]string{"key.missing": "124"}}, false},
+		{QueryOptions{Fields: map[string]string{"value": "500"}}, true},
+	}
+	for index, testcase := range testcases {
+		if testcase.Options.MatchesBigMapItem(item) != testcase.Expected {
+			t.Errorf("%d: Expected %v", index, testcase.Expected)
+		}
+	}
+
+	item.Active = false
+	if (QueryOptions{ActiveOnly: true}).MatchesBigMapItem(item) {
+		t.Errorf("Expected inactive item to be excluded")
+	}
+}
+
+func TestMatchesEvent(t *testing.T) {
+	timestamp := time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)
+	event := Event{
+		Level:     100,
+		Timestamp: timestamp,
+		Payload:   json.RawMessage(`{"token": {"token_id": "123"}, "amount": "10"}`),
+	}
+
+	testcases := []struct {
+		Options  QueryOptions
+		Expected bool
+	}{
+		{QueryOptions{}, true},
+		{QueryOptions{After: timestamp}, true},
+		{QueryOptions{Before: timestamp}, false},
+		{QueryOptions{After: timestamp.Add(time.Second)}, false},
+		{QueryOptions{Before: timestamp.Add(time.Second)}, true},
+		{QueryOptions{Fields: map[string]string{"payload.token.token_id": "123"}}, true},
+		{QueryOptions{Fields: map[string]string{"payload.amount": "11"}}, false},
+	}
+	for index, testcase := range testcases {
+		if testcase.Options.MatchesEvent(event) != testcase.Expected {
+			t.Errorf("%d: Expected %v", index, testcase.Expected)
+		}
+	}
+}
+
+func TestApplyOrderAndLimit(t *testing.T) {
+	items := []int{1, 2, 3, 4}
+	result := ApplyOrderAndLimit(QueryOptions{Descending: true, Limit: 3}, items)
+	if len(result) != 3 || result[0] != 4 || result[2] != 2 {
+		t.Errorf("Expected [4 3 2], got %v", result)
+	}
+	if items[0] != 1 {
+		t.Errorf("Expected original to be unchanged, got %v", items)
+	}
+}
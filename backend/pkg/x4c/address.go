@@ -0,0 +1,20 @@
+package x4c
+
+import (
+	"fmt"
+
+	"blockwatch.cc/tzgo/tezos"
+)
+
+// Both contracts will accept any address as a new admin, so we check here that
+// it is something that could actually sign for or call the contract later, as
+// otherwise the contract would be left without an admin.
+func validateAdminAddress(address tezos.Address) error {
+	if !address.IsValid() {
+		return fmt.Errorf("address is not valid")
+	}
+	if !address.IsEOA() && !address.IsContract() {
+		return fmt.Errorf("address %s is not a tz1, tz2, tz3, tz4, or KT1 address", address)
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+package x4c
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// Before submitting a batch we simulate this many of its rows to find out what each
+// row costs, and then fill each operation with as many rows as that says will fit in
+// this share of the hard operation limits, leaving room for rows that cost more.
+const (
+	batchSampleSize  = 10
+	batchLimitMargin = 0.8
+)
+
+// A batch file row is just a set of named columns, as we support both CSV
+// files with a header row and JSON files containing a list of objects.
+type batchRecord map[string]string
+
+func (r batchRecord) field(name string) (string, error) {
+	value, ok := r[name]
+	if !ok {
+		return "", fmt.Errorf("missing field %s", name)
+	}
+	return value, nil
+}
+
+func (r batchRecord) int64Field(name string) (int64, error) {
+	value, err := r.field(name)
+	if err != nil {
+		return 0, err
+	}
+	result, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s %q: %w", name, value, err)
+	}
+	return result, nil
+}
+
+func (r batchRecord) addressField(name string) (tezos.Address, error) {
+	value, err := r.field(name)
+	if err != nil {
+		return tezos.Address{}, err
+	}
+	result, err := tezos.ParseAddress(value)
+	if err != nil {
+		return tezos.Address{}, fmt.Errorf("failed to parse %s %q: %w", name, value, err)
+	}
+	return result, nil
+}
+
+func readBatchRecords(path string) ([]batchRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open batch file: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSVRecords(f)
+	case ".json":
+		return readJSONRecords(f)
+	default:
+		return nil, fmt.Errorf("batch file %s must be either .csv or .json", path)
+	}
+}
+
+func readCSVRecords(r io.Reader) ([]batchRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV file has no header row")
+	}
+
+	header := rows[0]
+	records := make([]batchRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(batchRecord)
+		for index, name := range header {
+			record[strings.TrimSpace(name)] = strings.TrimSpace(row[index])
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func readJSONRecords(r io.Reader) ([]batchRecord, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var rows []map[string]interface{}
+	err := decoder.Decode(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	records := make([]batchRecord, 0, len(rows))
+	for index, row := range rows {
+		record := make(batchRecord)
+		for name, value := range row {
+			switch v := value.(type) {
+			case string:
+				record[name] = v
+			case json.Number:
+				record[name] = v.String()
+			default:
+				return nil, fmt.Errorf("row %d has unexpected value for %s: %v", index+1, name, value)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// LoadMintFile reads a list of mints from a CSV or JSON file. Each row needs an
+// owner address, a token_id, and an amount.
+func LoadMintFile(path string) ([]FA2MintInfo, error) {
+	records, err := readBatchRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("batch file %s has no rows", path)
+	}
+
+	mint_list := make([]FA2MintInfo, 0, len(records))
+	for index, record := range records {
+		owner, err := record.addressField("owner")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		token_id, err := record.int64Field("token_id")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		amount, err := record.int64Field("amount")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		mint_list = append(mint_list, FA2MintInfo{
+			Owner:   owner,
+			TokenID: token_id,
+			Amount:  amount,
+		})
+	}
+	return mint_list, nil
+}
+
+// Retirement metadata columns are all optional, with reason being the free text note.
+func (r batchRecord) retirementMetadata() (RetirementMetadata, error) {
+	metadata := RetirementMetadata{
+		Version:          RetirementMetadataVersion,
+		Beneficiary:      r["beneficiary"],
+		Purpose:          r["purpose"],
+		ComplianceScheme: r["compliance_scheme"],
+		Note:             r["reason"],
+	}
+	start := r["reporting_period_start"]
+	end := r["reporting_period_end"]
+	if start != "" || end != "" {
+		metadata.ReportingPeriod = &ReportingPeriod{Start: start, End: end}
+	}
+	err := metadata.Validate()
+	if err != nil {
+		return RetirementMetadata{}, fmt.Errorf("invalid retirement metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// LoadRetireFile reads a list of custodian retirements from a CSV or JSON file. Each
+// row needs a token_address, token_id, kyc, amount, and optionally a reason along with
+// beneficiary, purpose, reporting_period_start, reporting_period_end, and
+// compliance_scheme.
+func LoadRetireFile(path string) ([]CustodianRetireInfo, error) {
+	records, err := readBatchRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("batch file %s has no rows", path)
+	}
+
+	retire_list := make([]CustodianRetireInfo, 0, len(records))
+	for index, record := range records {
+		raw_token_address, err := record.field("token_address")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		token_address, err := tzclient.NewContractWithAddress("token", raw_token_address)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: failed to parse token_address %q: %w", index+1, raw_token_address, err)
+		}
+		token_id, err := record.int64Field("token_id")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		kyc, err := record.field("kyc")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		amount, err := record.int64Field("amount")
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		metadata, err := record.retirementMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		retire_list = append(retire_list, CustodianRetireInfo{
+			TokenAddress: token_address,
+			TokenID:      token_id,
+			KYC:          kyc,
+			Amount:       amount,
+			Metadata:     metadata,
+		})
+	}
+	return retire_list, nil
+}
+
+// BatchValidationError lists every row that failed validation, so the user can
+// fix the whole file in one go rather than one row at a time.
+type BatchValidationError struct {
+	Problems []string
+}
+
+func (e BatchValidationError) Error() string {
+	return fmt.Sprintf("%d problems found in batch:\n\t%s", len(e.Problems), strings.Join(e.Problems, "\n\t"))
+}
+
+// ValidateMintBatch checks all the mints are for tokens that the FA2 contract knows about.
+func ValidateMintBatch(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	mint_list []FA2MintInfo,
+) error {
+	var storage FA2Storage
+	err := client.GetContractStorage(target, ctx, &storage)
+	if err != nil {
+		return fmt.Errorf("failed to get contract storage: %w", err)
+	}
+	token_metadata, err := storage.GetTokenMetadata(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to get token metadata: %w", err)
+	}
+
+	problems := make([]string, 0)
+	for index, mint := range mint_list {
+		if !mint.Owner.IsValid() {
+			problems = append(problems, fmt.Sprintf("row %d: invalid owner address", index+1))
+		}
+		if _, ok := token_metadata[mint.TokenID]; !ok {
+			problems = append(problems, fmt.Sprintf("row %d: token %d is not defined on %s", index+1, mint.TokenID, target.Address))
+		}
+		if mint.Amount <= 0 {
+			problems = append(problems, fmt.Sprintf("row %d: amount %d must be positive", index+1, mint.Amount))
+		}
+	}
+	if len(problems) > 0 {
+		return BatchValidationError{Problems: problems}
+	}
+	return nil
+}
+
+type retireBalanceKey struct {
+	TokenAddress string
+	TokenID      int64
+	KYC          string
+}
+
+// ValidateRetireBatch checks that each KYC has enough tokens held in the custodian to
+// cover all of the retirements in the batch for them.
+func ValidateRetireBatch(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	retire_list []CustodianRetireInfo,
+) error {
+	var storage CustodianStorage
+	err := client.GetContractStorage(target, ctx, &storage)
+	if err != nil {
+		return fmt.Errorf("failed to get contract storage: %w", err)
+	}
+	ledger, err := storage.GetLedger(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to get ledger: %w", err)
+	}
+
+	balances := make(map[retireBalanceKey]int64)
+	for key, amount := range ledger {
+		kyc, err := key.DecodeKYC()
+		if err != nil {
+			return fmt.Errorf("failed to decode ledger KYC %s: %w", key.RawKYC, err)
+		}
+		token_id, err := key.Token.TokenID.Int64()
+		if err != nil {
+			return fmt.Errorf("failed to decode ledger token ID %v: %w", key.Token.TokenID, err)
+		}
+		balances[retireBalanceKey{
+			TokenAddress: key.Token.Address,
+			TokenID:      token_id,
+			KYC:          kyc,
+		}] = amount
+	}
+
+	problems := make([]string, 0)
+	for index, retirement := range retire_list {
+		if retirement.KYC == "" {
+			problems = append(problems, fmt.Sprintf("row %d: no KYC specified", index+1))
+			continue
+		}
+		if retirement.Amount <= 0 {
+			problems = append(problems, fmt.Sprintf("row %d: amount %d must be positive", index+1, retirement.Amount))
+			continue
+		}
+		key := retireBalanceKey{
+			TokenAddress: retirement.TokenAddress.Address.String(),
+			TokenID:      retirement.TokenID,
+			KYC:          retirement.KYC,
+		}
+		// Deduct as we go so that several rows for the same KYC are checked together
+		if balances[key] < retirement.Amount {
+			problems = append(problems, fmt.Sprintf("row %d: %s has insufficient balance of token %d on %s for %d",
+				index+1, retirement.KYC, retirement.TokenID, key.TokenAddress, retirement.Amount))
+			continue
+		}
+		balances[key] -= retirement.Amount
+	}
+	if len(problems) > 0 {
+		return BatchValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// BatchResult records which rows (as a half open range of indexes into the
+// original list) went into which operation.
+type BatchResult struct {
+	Start         int
+	End           int
+	OperationHash string
+}
+
+// Works out how many rows like those simulated would fit in one operation. Each row is
+// charged the average cost, which includes a share of the operation's fixed costs, so
+// this errs on the side of too few.
+func rowsWithinLimits(result tzclient.SimulationResult, rows int) int {
+	limits := []struct {
+		used  int64
+		limit int64
+	}{
+		{result.GasUsed, tezos.DefaultParams.HardGasLimitPerOperation},
+		{result.StorageUsed, tezos.DefaultParams.HardStorageLimitPerOperation},
+	}
+	fit := math.MaxInt
+	for _, limit := range limits {
+		if limit.used <= 0 {
+			continue
+		}
+		per_row := (limit.used + int64(rows) - 1) / int64(rows)
+		rows_within := int(int64(float64(limit.limit)*batchLimitMargin) / per_row)
+		if rows_within < fit {
+			fit = rows_within
+		}
+	}
+	if fit < 1 {
+		fit = 1
+	}
+	return fit
+}
+
+// SubmitInBatches splits count rows into as few operations as will fit within the gas
+// and storage limits, and calls submit for each. How many rows fit is worked out by
+// calling simulate for a sample of the rows, and if batch_size is positive no more
+// than that many rows go in one operation. Should the chain still say an operation is
+// too large, as later rows can cost more than the sample, it is halved and retried. On
+// failure the results for the chunks that were submitted are returned along with the
+// error, so the caller can tell which rows still need submitting.
+func SubmitInBatches(
+	count int,
+	batch_size int,
+	simulate func(start int, end int) (tzclient.SimulationResult, error),
+	submit func(start int, end int) (string, error),
+) ([]BatchResult, error) {
+	if batch_size < 0 {
+		return nil, fmt.Errorf("batch size must not be negative, not %d", batch_size)
+	}
+
+	results := make([]BatchResult, 0)
+	if count == 0 {
+		return results, nil
+	}
+
+	sample := batchSampleSize
+	if sample > count {
+		sample = count
+	}
+	if batch_size > 0 && sample > batch_size {
+		sample = batch_size
+	}
+	var simulation tzclient.SimulationResult
+	for {
+		var err error
+		simulation, err = simulate(0, sample)
+		if err == nil {
+			break
+		}
+		if tzclient.IsLimitExceeded(err) && (sample > 1) {
+			sample = sample / 2
+			continue
+		}
+		return results, fmt.Errorf("failed to simulate rows 1 to %d: %w", sample, err)
+	}
+	size := rowsWithinLimits(simulation, sample)
+	if batch_size > 0 && size > batch_size {
+		size = batch_size
+	}
+
+	start := 0
+	for start < count {
+		end := start + size
+		if end > count {
+			end = count
+		}
+		operation_hash, err := submit(start, end)
+		if err != nil {
+			if tzclient.IsLimitExceeded(err) && (end-start > 1) {
+				// Once a size has proved too large, stick with the smaller
+				// size for the rest of the rows
+				size = (end - start) / 2
+				continue
+			}
+			return results, fmt.Errorf("failed to submit rows %d to %d: %w", start+1, end, err)
+		}
+		results = append(results, BatchResult{
+			Start:         start,
+			End:           end,
+			OperationHash: operation_hash,
+		})
+		start = end
+	}
+	return results, nil
+}
+
+// ConfirmEachBatch wraps a submit function for SubmitInBatches so that each operation
+// has been included on chain before the next is built. All the operations come from
+// the same wallet, and one built whilst the last is still in the mempool would be
+// given the same counter and refused. An operation that was included but failed
+// stops the batch, as its rows were not applied.
+func ConfirmEachBatch(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	confirmations int64,
+	submit func(start int, end int) (string, error),
+) func(start int, end int) (string, error) {
+	return func(start int, end int) (string, error) {
+		operation_hash, err := submit(start, end)
+		if err != nil {
+			return "", err
+		}
+		status, err := client.WaitForConfirmation(ctx, operation_hash, confirmations)
+		if err != nil {
+			return "", fmt.Errorf("failed to confirm operation %s: %w", operation_hash, err)
+		}
+		if !status.IsApplied() {
+			return "", fmt.Errorf("operation %s was %s: %s", operation_hash, status.Status, strings.Join(status.Errors, ", "))
+		}
+		return operation_hash, nil
+	}
+}
@@ -0,0 +1,540 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/rpc"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func TestLoadMintFile(t *testing.T) {
+	testcases := []struct {
+		Filename    string
+		Contents    string
+		ExpectError bool
+		Expected    []FA2MintInfo
+	}{
+		{
+			Filename: "holders.csv",
+			Contents: "owner,token_id,amount\ntz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq,1,10\ntz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5, 2, 20\n",
+			Expected: []FA2MintInfo{
+				{Owner: tezos.MustParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"), TokenID: 1, Amount: 10},
+				{Owner: tezos.MustParseAddress("tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"), TokenID: 2, Amount: 20},
+			},
+		},
+		{
+			Filename: "reordered.csv",
+			Contents: "amount,owner,token_id\n10,tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq,1\n",
+			Expected: []FA2MintInfo{
+				{Owner: tezos.MustParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"), TokenID: 1, Amount: 10},
+			},
+		},
+		{
+			Filename: "holders.json",
+			Contents: `[{"owner": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "token_id": 1, "amount": "10"}]`,
+			Expected: []FA2MintInfo{
+				{Owner: tezos.MustParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"), TokenID: 1, Amount: 10},
+			},
+		},
+		{
+			Filename:    "missing.csv",
+			Contents:    "owner,amount\ntz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq,10\n",
+			ExpectError: true,
+		},
+		{
+			Filename:    "badaddress.json",
+			Contents:    `[{"owner": "bob", "token_id": 1, "amount": 10}]`,
+			ExpectError: true,
+		},
+		{
+			Filename:    "empty.csv",
+			Contents:    "owner,token_id,amount\n",
+			ExpectError: true,
+		},
+		{
+			Filename:    "holders.txt",
+			Contents:    "owner,token_id,amount\ntz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq,1,10\n",
+			ExpectError: true,
+		},
+	}
+
+	dir := t.TempDir()
+	for index, testcase := range testcases {
+		path := filepath.Join(dir, testcase.Filename)
+		err := os.WriteFile(path, []byte(testcase.Contents), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+
+		mint_list, err := LoadMintFile(path)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			continue
+		}
+		if len(mint_list) != len(testcase.Expected) {
+			t.Errorf("%d: Expected %d rows, got %d", index, len(testcase.Expected), len(mint_list))
+			continue
+		}
+		for row, mint := range mint_list {
+			expected := testcase.Expected[row]
+			if !mint.Owner.Equal(expected.Owner) || (mint.TokenID != expected.TokenID) || (mint.Amount != expected.Amount) {
+				t.Errorf("%d: Row %d was %v, expected %v", index, row, mint, expected)
+			}
+		}
+	}
+}
+
+func TestLoadRetireFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "retirements.json")
+	contents := `[
+		{"token_address": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "token_id": 1, "kyc": "compsci", "amount": 10, "reason": "flights", "beneficiary": "Acme", "reporting_period_start": "2023-01-01", "reporting_period_end": "2023-12-31"},
+		{"token_address": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR", "token_id": 2, "kyc": "other org", "amount": 5}
+	]`
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	retire_list, err := LoadRetireFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(retire_list) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(retire_list))
+	}
+	if retire_list[0].KYC != "compsci" || retire_list[0].Metadata.Note != "flights" || retire_list[0].Metadata.Beneficiary != "Acme" || retire_list[0].Amount != 10 {
+		t.Errorf("Unexpected first row: %v", retire_list[0])
+	}
+	if retire_list[1].Metadata.Note != "" || retire_list[1].Metadata.ReportingPeriod != nil || retire_list[1].TokenID != 2 {
+		t.Errorf("Unexpected second row: %v", retire_list[1])
+	}
+	if retire_list[1].TokenAddress.Address.String() != "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR" {
+		t.Errorf("Unexpected token address: %v", retire_list[1].TokenAddress)
+	}
+
+	bad_path := filepath.Join(dir, "bad.csv")
+	err = os.WriteFile(bad_path, []byte("token_address,token_id,kyc,amount\ntz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq,1,compsci,10\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	_, err = LoadRetireFile(bad_path)
+	if err == nil {
+		t.Errorf("Expected error for wallet address as token address")
+	}
+
+	err = os.WriteFile(bad_path, []byte("token_address,token_id,kyc,amount,reporting_period_start,reporting_period_end\nKT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR,1,compsci,10,2023-12-31,2023-01-01\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	_, err = LoadRetireFile(bad_path)
+	if err == nil {
+		t.Errorf("Expected error for reporting period that ends before it starts")
+	}
+}
+
+func TestValidateMintBatch(t *testing.T) {
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	alice := tezos.MustParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+
+	client := tzclient.NewMockClient()
+	client.Storage = &FA2Storage{
+		TokenMetadata: 12,
+	}
+	client.AddBigMap(12, []tzkt.BigMapItem{
+		{
+			Active: true,
+			Key:    json.RawMessage(`"1"`),
+			Value:  json.RawMessage(`{"token_id": "1", "token_info": {"title": "74657374"}}`),
+		},
+	})
+
+	testcases := []struct {
+		Mints          []FA2MintInfo
+		ExpectProblems int
+	}{
+		{
+			Mints: []FA2MintInfo{
+				{Owner: alice, TokenID: 1, Amount: 10},
+				{Owner: alice, TokenID: 1, Amount: 20},
+			},
+			ExpectProblems: 0,
+		},
+		{
+			Mints: []FA2MintInfo{
+				{Owner: alice, TokenID: 1, Amount: 10},
+				{Owner: alice, TokenID: 2, Amount: 20},
+				{Owner: alice, TokenID: 1, Amount: 0},
+			},
+			ExpectProblems: 2,
+		},
+	}
+
+	for index, testcase := range testcases {
+		err := ValidateMintBatch(context.Background(), client, target, testcase.Mints)
+		if testcase.ExpectProblems == 0 {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			}
+			continue
+		}
+		validation_error, ok := err.(BatchValidationError)
+		if !ok {
+			t.Errorf("%d: Expected validation error, got %v", index, err)
+			continue
+		}
+		if len(validation_error.Problems) != testcase.ExpectProblems {
+			t.Errorf("%d: Expected %d problems, got %v", index, testcase.ExpectProblems, validation_error.Problems)
+		}
+	}
+}
+
+func TestValidateRetireBatch(t *testing.T) {
+	target, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	client := tzclient.NewMockClient()
+	client.Storage = &CustodianStorage{
+		Ledger: 7,
+	}
+	client.AddBigMap(7, []tzkt.BigMapItem{
+		{
+			Active: true,
+			Key:    json.RawMessage(`{"token": {"token_id": "1", "token_address": "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"}, "kyc": "0501000000096f74686572206f7267"}`),
+			Value:  json.RawMessage(`"100"`),
+		},
+	})
+
+	testcases := []struct {
+		Retirements    []CustodianRetireInfo
+		ExpectProblems int
+	}{
+		{
+			Retirements: []CustodianRetireInfo{
+				{TokenAddress: fa2, TokenID: 1, KYC: "other org", Amount: 60},
+				{TokenAddress: fa2, TokenID: 1, KYC: "other org", Amount: 40},
+			},
+			ExpectProblems: 0,
+		},
+		{
+			// Each row is fine alone, but together they exceed the balance
+			Retirements: []CustodianRetireInfo{
+				{TokenAddress: fa2, TokenID: 1, KYC: "other org", Amount: 60},
+				{TokenAddress: fa2, TokenID: 1, KYC: "other org", Amount: 60},
+			},
+			ExpectProblems: 1,
+		},
+		{
+			Retirements: []CustodianRetireInfo{
+				{TokenAddress: fa2, TokenID: 2, KYC: "other org", Amount: 1},
+				{TokenAddress: fa2, TokenID: 1, KYC: "compsci", Amount: 1},
+				{TokenAddress: fa2, TokenID: 1, KYC: "", Amount: 1},
+				{TokenAddress: fa2, TokenID: 1, KYC: "other org", Amount: -1},
+			},
+			ExpectProblems: 4,
+		},
+	}
+
+	for index, testcase := range testcases {
+		err := ValidateRetireBatch(context.Background(), client, target, testcase.Retirements)
+		if testcase.ExpectProblems == 0 {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			}
+			continue
+		}
+		validation_error, ok := err.(BatchValidationError)
+		if !ok {
+			t.Errorf("%d: Expected validation error, got %v", index, err)
+			continue
+		}
+		if len(validation_error.Problems) != testcase.ExpectProblems {
+			t.Errorf("%d: Expected %d problems, got %v", index, testcase.ExpectProblems, validation_error.Problems)
+		}
+	}
+}
+
+func TestSubmitInBatches(t *testing.T) {
+	testcases := []struct {
+		Count     int
+		BatchSize int
+		// How much gas the simulation says each row needs, which is 10% of the
+		// hard limit when zero
+		RowGas int64
+		// How many rows the chain will actually take in an operation
+		MaxFit              int
+		FailAt              int
+		FailSimulation      bool
+		ExpectError         bool
+		ExpectBatches       []BatchResult
+		ExpectLimitFailures int
+	}{
+		{
+			Count:     5,
+			BatchSize: 2,
+			MaxFit:    10,
+			FailAt:    -1,
+			ExpectBatches: []BatchResult{
+				{Start: 0, End: 2, OperationHash: "op0"},
+				{Start: 2, End: 4, OperationHash: "op2"},
+				{Start: 4, End: 5, OperationHash: "op4"},
+			},
+		},
+		{
+			// Each row needs a quarter of the limit, so with the margin three fit,
+			// and the chain is never asked to take more
+			Count:  7,
+			RowGas: tezos.DefaultParams.HardGasLimitPerOperation / 4,
+			MaxFit: 3,
+			FailAt: -1,
+			ExpectBatches: []BatchResult{
+				{Start: 0, End: 3, OperationHash: "op0"},
+				{Start: 3, End: 6, OperationHash: "op3"},
+				{Start: 6, End: 7, OperationHash: "op6"},
+			},
+		},
+		{
+			// Rows are cheap, so they all go in one operation
+			Count:  250,
+			RowGas: 1000,
+			MaxFit: 1000,
+			FailAt: -1,
+			ExpectBatches: []BatchResult{
+				{Start: 0, End: 250, OperationHash: "op0"},
+			},
+		},
+		{
+			// The simulation says eight fit, but the chain only accepts two rows per
+			// op, so we fall back to halving, 8 -> 4 -> 2
+			Count:  9,
+			MaxFit: 2,
+			FailAt: -1,
+			ExpectBatches: []BatchResult{
+				{Start: 0, End: 2, OperationHash: "op0"},
+				{Start: 2, End: 4, OperationHash: "op2"},
+				{Start: 4, End: 6, OperationHash: "op4"},
+				{Start: 6, End: 8, OperationHash: "op6"},
+				{Start: 8, End: 9, OperationHash: "op8"},
+			},
+			ExpectLimitFailures: 2,
+		},
+		{
+			// A non-limit failure stops submission, but reports what landed
+			Count:       6,
+			BatchSize:   2,
+			MaxFit:      10,
+			FailAt:      2,
+			ExpectError: true,
+			ExpectBatches: []BatchResult{
+				{Start: 0, End: 2, OperationHash: "op0"},
+			},
+		},
+		{
+			// If the sample can't be simulated then nothing is submitted
+			Count:          6,
+			MaxFit:         10,
+			FailAt:         -1,
+			FailSimulation: true,
+			ExpectError:    true,
+			ExpectBatches:  []BatchResult{},
+		},
+		{
+			Count:         3,
+			BatchSize:     -1,
+			MaxFit:        10,
+			FailAt:        -1,
+			ExpectError:   true,
+			ExpectBatches: []BatchResult{},
+		},
+	}
+
+	for index, testcase := range testcases {
+		row_gas := testcase.RowGas
+		if row_gas == 0 {
+			row_gas = tezos.DefaultParams.HardGasLimitPerOperation / 10
+		}
+		limit_error := rpc.GenericError{ID: "proto.015-PtLimaPt.gas_exhausted.operation", Kind: "temporary"}
+		simulate := func(start int, end int) (tzclient.SimulationResult, error) {
+			if testcase.FailSimulation {
+				return tzclient.SimulationResult{}, fmt.Errorf("Test should fail")
+			}
+			if end-start > testcase.MaxFit {
+				return tzclient.SimulationResult{}, limit_error
+			}
+			return tzclient.SimulationResult{GasUsed: row_gas * int64(end-start)}, nil
+		}
+		limit_failures := 0
+		submit := func(start int, end int) (string, error) {
+			if start == testcase.FailAt {
+				return "", fmt.Errorf("Test should fail")
+			}
+			if end-start > testcase.MaxFit {
+				limit_failures += 1
+				return "", limit_error
+			}
+			return fmt.Sprintf("op%d", start), nil
+		}
+		results, err := SubmitInBatches(testcase.Count, testcase.BatchSize, simulate, submit)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			}
+		}
+		if limit_failures != testcase.ExpectLimitFailures {
+			t.Errorf("%d: Expected %d operations to be too large, got %d", index, testcase.ExpectLimitFailures, limit_failures)
+		}
+		if len(results) != len(testcase.ExpectBatches) {
+			t.Errorf("%d: Expected %v, got %v", index, testcase.ExpectBatches, results)
+			continue
+		}
+		for batch, result := range results {
+			if result != testcase.ExpectBatches[batch] {
+				t.Errorf("%d: Batch %d was %v, expected %v", index, batch, result, testcase.ExpectBatches[batch])
+			}
+		}
+	}
+}
+
+func TestCustodianRetireBatch(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2_a, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	fa2_b, _ := tzclient.NewContractWithAddress("other", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+
+	client := &recordingClient{MockClient: tzclient.NewMockClient()}
+	_, err := CustodianRetireBatch(context.Background(), client, target, signer, []CustodianRetireInfo{
+		{TokenAddress: fa2_a, TokenID: 1, KYC: "a", Amount: 10, Metadata: NoteRetirementMetadata("")},
+		{TokenAddress: fa2_b, TokenID: 2, KYC: "a", Amount: 20, Metadata: NoteRetirementMetadata("")},
+		{TokenAddress: fa2_a, TokenID: 3, KYC: "a", Amount: 30, Metadata: NoteRetirementMetadata("")},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Rows for the same FA2 contract are grouped together in the order first seen
+	checkParameters(t, 0, client, "retire",
+		`[{"prim":"Pair","args":[{"string":"KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"},[`+
+			`{"prim":"Pair","args":[{"prim":"Pair","args":[{"int":"10"},{"bytes":"05010000000d7b2276657273696f6e223a317d"}]},{"prim":"Pair","args":[{"bytes":"05010000000161"},{"int":"1"}]}]},`+
+			`{"prim":"Pair","args":[{"prim":"Pair","args":[{"int":"30"},{"bytes":"05010000000d7b2276657273696f6e223a317d"}]},{"prim":"Pair","args":[{"bytes":"05010000000161"},{"int":"3"}]}]}]]},`+
+			`{"prim":"Pair","args":[{"string":"KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"},[`+
+			`{"prim":"Pair","args":[{"prim":"Pair","args":[{"int":"20"},{"bytes":"05010000000d7b2276657273696f6e223a317d"}]},{"prim":"Pair","args":[{"bytes":"05010000000161"},{"int":"2"}]}]}]]}]`)
+
+	_, err = CustodianRetireBatch(context.Background(), client, target, signer, []CustodianRetireInfo{})
+	if err == nil {
+		t.Errorf("Expected error for empty retirement list")
+	}
+}
+
+// A client with a mempool, which like a node refuses an operation from a wallet that
+// already has one waiting, as it would have the same counter. Operations are only
+// included when they are waited for.
+type mempoolClient struct {
+	tzclient.MockClient
+	pending    map[string]string
+	operations int
+}
+
+func (c *mempoolClient) CallContract(ctx context.Context, signedBy tzclient.Wallet, target tzclient.Contract, parameters micheline.Parameters) (string, error) {
+	if hash, ok := c.pending[signedBy.Address.String()]; ok {
+		return "", fmt.Errorf("counter already used by %s", hash)
+	}
+	c.operations += 1
+	hash := fmt.Sprintf("op%d", c.operations)
+	c.pending[signedBy.Address.String()] = hash
+	return hash, nil
+}
+
+func (c *mempoolClient) WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (tzclient.OperationStatus, error) {
+	for signer, pending := range c.pending {
+		if pending == hash {
+			delete(c.pending, signer)
+		}
+	}
+	return c.MockClient.WaitForConfirmation(ctx, hash, confirmations)
+}
+
+func TestSubmitInBatchesConfirmsEach(t *testing.T) {
+	oracle, _ := tzclient.NewWalletWithAddress("oracle", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	mint_list := make([]FA2MintInfo, 0)
+	for index := 0; index < 5; index++ {
+		mint_list = append(mint_list, FA2MintInfo{Owner: oracle.Address, TokenID: 1, Amount: int64(index + 1)})
+	}
+	simulate := func(start int, end int) (tzclient.SimulationResult, error) {
+		return tzclient.SimulationResult{GasUsed: 1000}, nil
+	}
+
+	for _, confirm := range []bool{false, true} {
+		client := &mempoolClient{MockClient: tzclient.NewMockClient(), pending: make(map[string]string)}
+		submit := func(start int, end int) (string, error) {
+			return FA2MintBatch(context.Background(), client, target, oracle, mint_list[start:end])
+		}
+		if confirm {
+			submit = ConfirmEachBatch(context.Background(), client, 1, submit)
+		}
+		results, err := SubmitInBatches(len(mint_list), 2, simulate, submit)
+		if !confirm {
+			// Without waiting the second operation reuses the first's counter
+			if err == nil || len(results) != 1 {
+				t.Errorf("Expected only the first chunk to be accepted, got %v, %v", results, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []BatchResult{
+			{Start: 0, End: 2, OperationHash: "op1"},
+			{Start: 2, End: 4, OperationHash: "op2"},
+			{Start: 4, End: 5, OperationHash: "op3"},
+		}
+		if len(results) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, results)
+		}
+		for index, result := range results {
+			if result != expected[index] {
+				t.Errorf("Batch %d was %v, expected %v", index, result, expected[index])
+			}
+		}
+	}
+
+	// An operation that fails on chain stops the batch
+	client := &statusClient{MockClient: tzclient.NewMockClient(), Status: tzclient.OperationFailed}
+	submit := ConfirmEachBatch(context.Background(), client, 1, func(start int, end int) (string, error) {
+		return FA2MintBatch(context.Background(), client, target, oracle, mint_list[start:end])
+	})
+	results, err := SubmitInBatches(len(mint_list), 2, simulate, submit)
+	if err == nil || len(results) != 0 {
+		t.Errorf("Expected failed operation to stop the batch, got %v, %v", results, err)
+	}
+}
+
+// Reports every operation as having the given status.
+type statusClient struct {
+	tzclient.MockClient
+	Status string
+}
+
+func (c *statusClient) WaitForConfirmation(ctx context.Context, hash string, confirmations int64) (tzclient.OperationStatus, error) {
+	status, err := c.MockClient.WaitForConfirmation(ctx, hash, confirmations)
+	status.Status = c.Status
+	return status, err
+}
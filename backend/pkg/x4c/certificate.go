@@ -0,0 +1,380 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// ErrNotCertifiable is returned when the chain doesn't show an operation retiring
+// anything, as opposed to when we couldn't find out.
+var ErrNotCertifiable = errors.New("no retirement to certify")
+
+// CertifiedRetirement is one retirement within an operation, from either a custodian
+// or an FA2 contract. Only custodian retirements have a KYC.
+type CertifiedRetirement struct {
+	EventID       int64       `json:"eventId"`
+	Contract      string      `json:"contract"`
+	RetiringParty string      `json:"retiringParty"`
+	KYC           string      `json:"kyc,omitempty"`
+	Token         TokenID     `json:"token"`
+	TokenTitle    string      `json:"tokenTitle,omitempty"`
+	TokenURL      string      `json:"tokenUrl,omitempty"`
+	Amount        json.Number `json:"amount"`
+	Reason        string      `json:"reason"`
+}
+
+// Certificate records the retirements made by an operation that has been checked
+// against the chain. Once signed, anyone with the certificate can check with Verify
+// that it was issued by the holder of the signing key and has not been altered.
+type Certificate struct {
+	OperationHash string                `json:"operationHash"`
+	Level         int32                 `json:"level"`
+	Timestamp     time.Time             `json:"timestamp"`
+	IndexerURL    string                `json:"indexerUrl"`
+	Retirements   []CertifiedRetirement `json:"retirements"`
+	Signer        string                `json:"signer,omitempty"`
+	Signature     string                `json:"signature,omitempty"`
+}
+
+// tokenInfoCache looks up the title and URL of tokens, fetching the token metadata of
+// each FA2 contract only once.
+type tokenInfoCache struct {
+	client    tzclient.TezosClient
+	contracts map[string]FA2TokenMetadataMap
+}
+
+func newTokenInfoCache(client tzclient.TezosClient) *tokenInfoCache {
+	return &tokenInfoCache{
+		client:    client,
+		contracts: make(map[string]FA2TokenMetadataMap),
+	}
+}
+
+func (c *tokenInfoCache) lookup(ctx context.Context, token TokenID) (string, string, error) {
+	token_metadata, ok := c.contracts[token.Address]
+	if !ok {
+		contract, err := tzclient.NewContractWithAddress(token.Address, token.Address)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid FA2 contract address %s: %w", token.Address, err)
+		}
+		var storage FA2Storage
+		err = c.client.GetContractStorage(contract, ctx, &storage)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get storage for FA2 contract %s: %w", token.Address, err)
+		}
+		token_metadata, err = storage.GetTokenMetadata(ctx, c.client)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get token metadata for FA2 contract %s: %w", token.Address, err)
+		}
+		c.contracts[token.Address] = token_metadata
+	}
+	token_id, err := token.TokenID.Int64()
+	if err != nil {
+		return "", "", fmt.Errorf("invalid token ID %v: %w", token.TokenID, err)
+	}
+	info, ok := token_metadata[token_id]
+	if !ok {
+		return "", "", nil
+	}
+	return info.Project.Name, info.Project.ExternalURI, nil
+}
+
+// A custodian's retire event and an FA2 contract's are both tagged retire, but only
+// the custodian's says which KYC it is for.
+func isCustodianRetireEvent(event tzkt.Event) bool {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(event.Payload, &fields)
+	if err != nil {
+		return false
+	}
+	_, ok := fields["retiring_party_kyc"]
+	return ok
+}
+
+// GenerateCertificate checks that the operation was applied and builds an unsigned
+// certificate for the retirements it made on the given contracts. Anyone can deploy a
+// contract that emits retire events, so those from any other contract are ignored.
+func GenerateCertificate(ctx context.Context, client tzclient.TezosClient, contracts []tzclient.Contract, hash string) (Certificate, error) {
+	operations, err := client.GetOperationInformation(ctx, hash)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("failed to get operation %s: %w", hash, err)
+	}
+	if len(operations) == 0 {
+		return Certificate{}, fmt.Errorf("operation %s not found: %w", hash, ErrNotCertifiable)
+	}
+	for _, operation := range operations {
+		if operation.Status != "applied" {
+			return Certificate{}, fmt.Errorf("operation %s was not applied, status is %s: %w", hash, operation.Status, ErrNotCertifiable)
+		}
+	}
+
+	// Retiring through a custodian causes the FA2 contract to emit its own retire event
+	// in the same operation, with the custodian as the retiring party, so we only keep
+	// the custodian's event for those
+	custodian_events := make([]CustodianRetireEvent, 0)
+	fa2_events := make([]FA2RetireEvent, 0)
+	custodians := make(map[string]bool)
+	certifiable := make(map[string]bool, len(contracts))
+	for _, contract := range contracts {
+		certifiable[contract.Address.String()] = true
+	}
+	seen := make(map[string]bool)
+	for _, operation := range operations {
+		if operation.Target == nil || !certifiable[operation.Target.Address] || seen[operation.Target.Address] {
+			continue
+		}
+		seen[operation.Target.Address] = true
+		events, err := client.GetContractEvents(ctx, operation.Target.Address, "retire", tzkt.QueryOptions{
+			MinLevel: operation.Level,
+			MaxLevel: operation.Level,
+		})
+		if err != nil {
+			return Certificate{}, fmt.Errorf("failed to get retire events for %s: %w", operation.Target.Address, err)
+		}
+		for _, event := range events {
+			if !operationsContain(operations, event.TransactionID) {
+				continue
+			}
+			if isCustodianRetireEvent(event) {
+				typed_event, err := decodeCustodianRetireEvent(event)
+				if err != nil {
+					return Certificate{}, err
+				}
+				custodian_events = append(custodian_events, typed_event)
+				custodians[operation.Target.Address] = true
+			} else {
+				typed_event, err := decodeFA2RetireEvent(event)
+				if err != nil {
+					return Certificate{}, err
+				}
+				fa2_events = append(fa2_events, typed_event)
+			}
+		}
+	}
+
+	retirements := make([]CertifiedRetirement, 0, len(custodian_events)+len(fa2_events))
+	for _, event := range custodian_events {
+		retirements = append(retirements, CertifiedRetirement{
+			EventID:       event.Identifier,
+			Contract:      eventContractAddress(event.Event),
+			RetiringParty: event.RetiringParty,
+			KYC:           event.RetiringPartyKyc,
+			Token:         event.Token,
+			Amount:        event.Amount,
+			Reason:        event.Metadata.String(),
+		})
+	}
+	for _, event := range fa2_events {
+		if custodians[event.RetiringParty] {
+			continue
+		}
+		contract := eventContractAddress(event.Event)
+		retirements = append(retirements, CertifiedRetirement{
+			EventID:       event.Identifier,
+			Contract:      contract,
+			RetiringParty: event.RetiringParty,
+			Token:         TokenID{TokenID: event.TokenID, Address: contract},
+			Amount:        event.Amount,
+			Reason:        event.Metadata.String(),
+		})
+	}
+	if len(retirements) == 0 {
+		return Certificate{}, fmt.Errorf("operation %s made no retirements: %w", hash, ErrNotCertifiable)
+	}
+
+	token_info := newTokenInfoCache(client)
+	for index, retirement := range retirements {
+		title, url, err := token_info.lookup(ctx, retirement.Token)
+		if err != nil {
+			return Certificate{}, err
+		}
+		retirements[index].TokenTitle = title
+		retirements[index].TokenURL = url
+	}
+
+	return Certificate{
+		OperationHash: hash,
+		Level:         operations[0].Level,
+		Timestamp:     operations[0].Timestamp,
+		IndexerURL:    fmt.Sprintf("%s/%s", client.GetIndexerWebURL(), hash),
+		Retirements:   retirements,
+	}, nil
+}
+
+// GenerateCertificateForEvent builds an unsigned certificate for just the retirement
+// in the given retire event, from either a custodian or an FA2 contract, which must be
+// one of the given contracts.
+func GenerateCertificateForEvent(ctx context.Context, client tzclient.TezosClient, contracts []tzclient.Contract, event tzkt.Event) (Certificate, error) {
+	operation, err := client.GetTransactionByID(ctx, event.TransactionID)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("failed to find operation for event %d: %w", event.Identifier, err)
+	}
+	certificate, err := GenerateCertificate(ctx, client, contracts, operation.Hash)
+	if err != nil {
+		return Certificate{}, err
+	}
+	for _, retirement := range certificate.Retirements {
+		if retirement.EventID == event.Identifier {
+			certificate.Retirements = []CertifiedRetirement{retirement}
+			return certificate, nil
+		}
+	}
+	return Certificate{}, fmt.Errorf("event %d is not a retirement in operation %s: %w", event.Identifier, operation.Hash, ErrNotCertifiable)
+}
+
+func operationsContain(operations []tzkt.Operation, identifier int64) bool {
+	for _, operation := range operations {
+		if operation.Identifier == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+func eventContractAddress(event tzkt.Event) string {
+	if event.Contract.Address == nil {
+		return ""
+	}
+	return *event.Contract.Address
+}
+
+// The signature covers the JSON encoding of the certificate with the signer set and
+// the signature left empty.
+func (c Certificate) digest() ([]byte, error) {
+	c.Signature = ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+	digest := tezos.Digest(data)
+	return digest[:], nil
+}
+
+func (c *Certificate) Sign(key tezos.PrivateKey) error {
+	c.Signer = key.Public().String()
+	digest, err := c.digest()
+	if err != nil {
+		return err
+	}
+	signature, err := key.Sign(digest)
+	if err != nil {
+		return fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	c.Signature = signature.String()
+	return nil
+}
+
+func (c Certificate) Verify() error {
+	if c.Signature == "" {
+		return fmt.Errorf("certificate is not signed")
+	}
+	key, err := tezos.ParseKey(c.Signer)
+	if err != nil {
+		return fmt.Errorf("invalid signer key: %w", err)
+	}
+	signature, err := tezos.ParseSignature(c.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	digest, err := c.digest()
+	if err != nil {
+		return err
+	}
+	err = key.Verify(digest, signature)
+	if err != nil {
+		return fmt.Errorf("signature does not match certificate: %w", err)
+	}
+	return nil
+}
+
+var certificateTemplate = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Certificate of retirement</title>
+</head>
+<body>
+<h1>Certificate of retirement</h1>
+<p>The following carbon credits were retired on the Tezos blockchain by operation
+<a href="{{.IndexerURL}}">{{.OperationHash}}</a>, included at level {{.Level}} on {{.Timestamp.UTC.Format "2 January 2006 15:04:05 MST"}}.</p>
+{{range .Retirements}}
+<h2>{{if .TokenTitle}}{{.TokenTitle}}{{else}}Token {{.Token.TokenID}}{{end}}</h2>
+<table>
+<tr><th>Amount</th><td>{{.Amount}}</td></tr>
+<tr><th>Retired by</th><td>{{.RetiringParty}}</td></tr>
+{{if .KYC}}<tr><th>On behalf of</th><td>{{.KYC}}</td></tr>{{end}}
+<tr><th>Reason</th><td>{{.Reason}}</td></tr>
+<tr><th>Token</th><td>{{.Token.TokenID}} of {{.Token.Address}}</td></tr>
+{{if .TokenURL}}<tr><th>Project</th><td><a href="{{.TokenURL}}">{{.TokenURL}}</a></td></tr>{{end}}
+<tr><th>Contract</th><td>{{.Contract}}</td></tr>
+</table>
+{{end}}
+{{if .Signature}}
+<p>Signed by {{.Signer}}<br>
+<code>{{.Signature}}</code></p>
+{{end}}
+</body>
+</html>
+`))
+
+func (c Certificate) WriteHTML(w io.Writer) error {
+	err := certificateTemplate.Execute(w, c)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return nil
+}
+
+func (c Certificate) WritePDF(w io.Writer) error {
+	lines := []string{
+		"Certificate of retirement",
+		"",
+		"The following carbon credits were retired on the Tezos blockchain.",
+		"",
+		fmt.Sprintf("Operation: %s", c.OperationHash),
+		fmt.Sprintf("Level:     %d", c.Level),
+		fmt.Sprintf("Time:      %s", c.Timestamp.UTC().Format("2 January 2006 15:04:05 MST")),
+		fmt.Sprintf("Link:      %s", c.IndexerURL),
+	}
+	for _, retirement := range c.Retirements {
+		title := retirement.TokenTitle
+		if title == "" {
+			title = fmt.Sprintf("Token %s", retirement.Token.TokenID)
+		}
+		lines = append(lines,
+			"",
+			title,
+			fmt.Sprintf("  Amount:       %s", retirement.Amount),
+			fmt.Sprintf("  Retired by:   %s", retirement.RetiringParty),
+		)
+		if retirement.KYC != "" {
+			lines = append(lines, fmt.Sprintf("  On behalf of: %s", retirement.KYC))
+		}
+		lines = append(lines,
+			fmt.Sprintf("  Reason:       %s", retirement.Reason),
+			fmt.Sprintf("  Token:        %s of %s", retirement.Token.TokenID, retirement.Token.Address),
+		)
+		if retirement.TokenURL != "" {
+			lines = append(lines, fmt.Sprintf("  Project:      %s", retirement.TokenURL))
+		}
+		lines = append(lines, fmt.Sprintf("  Contract:     %s", retirement.Contract))
+	}
+	if c.Signature != "" {
+		lines = append(lines,
+			"",
+			fmt.Sprintf("Signed by %s", c.Signer),
+			c.Signature,
+		)
+	}
+	return writeTextPDF(w, lines)
+}
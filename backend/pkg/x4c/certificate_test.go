@@ -0,0 +1,217 @@
+package x4c
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+const (
+	certificateHash      = "ooQuMEX7Gvyn4fHMvN5cJPjvVCBkr6A1Q8mrJ6okfmFH7pRNR3X"
+	certificateCustodian = "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"
+	certificateFA2       = "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"
+	certificateImpostor  = "KT1Ew9AYYq5RHDRvGcXNWy4JYQMdVHpoqp5k"
+)
+
+func certificateContracts(t *testing.T) []tzclient.Contract {
+	custodian, err := tzclient.NewContractWithAddress("custodian", certificateCustodian)
+	if err != nil {
+		t.Fatalf("Failed to make contract: %v", err)
+	}
+	fa2, err := tzclient.NewContractWithAddress("fa2", certificateFA2)
+	if err != nil {
+		t.Fatalf("Failed to make contract: %v", err)
+	}
+	return []tzclient.Contract{custodian, fa2}
+}
+
+func newCertificateTestClient() tzclient.MockClient {
+	custodian := certificateCustodian
+	fa2 := certificateFA2
+	impostor := certificateImpostor
+	client := tzclient.NewMockClient()
+	client.Operations = map[string][]tzkt.Operation{
+		certificateHash: {
+			{Identifier: 10, Level: 500, Hash: certificateHash, Status: "applied", Target: &tzkt.OperationParty{Address: custodian}},
+			{Identifier: 11, Level: 500, Hash: certificateHash, Status: "applied", Target: &tzkt.OperationParty{Address: fa2}},
+		},
+		"impostor": {
+			{Identifier: 13, Level: 502, Hash: "impostor", Status: "applied", Target: &tzkt.OperationParty{Address: impostor}},
+		},
+		"failed": {
+			{Identifier: 12, Level: 501, Hash: "failed", Status: "backtracked", Target: &tzkt.OperationParty{Address: custodian}},
+		},
+	}
+	client.Events = []tzkt.Event{
+		{
+			Identifier:    100,
+			Level:         500,
+			TransactionID: 10,
+			Contract:      tzkt.EventContractInfo{Address: &custodian},
+			Tag:           "retire",
+			Payload:       json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "retiring_party_kyc": "050100000007636f6d70736369", "token": {"token_id": "1", "token_address": "` + fa2 + `"}, "amount": "5", "retiring_data": "050100000007666c6967687473"}`),
+		},
+		{
+			// The FA2 contract's event for the same retirement, which shouldn't appear twice
+			Identifier:    101,
+			Level:         500,
+			TransactionID: 11,
+			Contract:      tzkt.EventContractInfo{Address: &fa2},
+			Tag:           "retire",
+			Payload:       json.RawMessage(`{"retiring_party": "` + custodian + `", "tokenId": "1", "amount": "5", "retiring_data": "050100000007666c6967687473"}`),
+		},
+		{
+			// A retirement from another operation in the same block
+			Identifier:    102,
+			Level:         500,
+			TransactionID: 20,
+			Contract:      tzkt.EventContractInfo{Address: &custodian},
+			Tag:           "retire",
+			Payload:       json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "retiring_party_kyc": "050100000007636f6d70736369", "token": {"token_id": "1", "token_address": "` + fa2 + `"}, "amount": "7", "retiring_data": "050100000007666c6967687473"}`),
+		},
+		{
+			// A contract we don't know emitting what looks like a custodian retirement
+			Identifier:    103,
+			Level:         502,
+			TransactionID: 13,
+			Contract:      tzkt.EventContractInfo{Address: &impostor},
+			Tag:           "retire",
+			Payload:       json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "retiring_party_kyc": "050100000007636f6d70736369", "token": {"token_id": "1", "token_address": "` + fa2 + `"}, "amount": "5000", "retiring_data": "050100000007666c6967687473"}`),
+		},
+	}
+	client.ContractStorage[fa2] = &FA2Storage{TokenMetadata: 5}
+	client.AddBigMap(5, []tzkt.BigMapItem{{
+		Active: true,
+		Key:    json.RawMessage(`"1"`),
+		Value:  json.RawMessage(`{"token_id": "1", "token_info": {"title": "466f726573742070726f6a656374", "url": "68747470733a2f2f6578616d706c652e636f6d2f666f72657374"}}`),
+	}})
+	return client
+}
+
+func TestGenerateCertificate(t *testing.T) {
+	testcases := []struct {
+		Hash        string
+		ExpectError bool
+	}{
+		{
+			Hash: certificateHash,
+		},
+		{
+			Hash:        "failed",
+			ExpectError: true,
+		},
+		{
+			Hash:        "unknown",
+			ExpectError: true,
+		},
+		{
+			Hash:        "impostor",
+			ExpectError: true,
+		},
+	}
+
+	client := newCertificateTestClient()
+	contracts := certificateContracts(t)
+	for index, testcase := range testcases {
+		certificate, err := GenerateCertificate(context.Background(), client, contracts, testcase.Hash)
+		if testcase.ExpectError {
+			if !errors.Is(err, ErrNotCertifiable) {
+				t.Errorf("%d: Expected not certifiable, got %v and %v", index, err, certificate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if len(certificate.Retirements) != 1 {
+			t.Errorf("%d: Expected one retirement, got %v", index, certificate.Retirements)
+			continue
+		}
+		retirement := certificate.Retirements[0]
+		if retirement.KYC != "compsci" || retirement.Reason != "flights" || retirement.Amount != "5" {
+			t.Errorf("%d: Unexpected retirement %v", index, retirement)
+		}
+		if retirement.TokenTitle != "Forest project" || retirement.TokenURL != "https://example.com/forest" {
+			t.Errorf("%d: Unexpected token metadata %v", index, retirement)
+		}
+		if certificate.IndexerURL != "https://index.web/"+certificateHash {
+			t.Errorf("%d: Unexpected indexer URL %s", index, certificate.IndexerURL)
+		}
+	}
+}
+
+func TestGenerateCertificateForEvent(t *testing.T) {
+	client := newCertificateTestClient()
+	contracts := certificateContracts(t)
+	certificate, err := GenerateCertificateForEvent(context.Background(), client, contracts, tzkt.Event{Identifier: 100, TransactionID: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(certificate.Retirements) != 1 || certificate.Retirements[0].EventID != 100 {
+		t.Errorf("Unexpected retirements %v", certificate.Retirements)
+	}
+
+	// The FA2 event is covered by the custodian's, so isn't certified on its own
+	_, err = GenerateCertificateForEvent(context.Background(), client, contracts, tzkt.Event{Identifier: 101, TransactionID: 11})
+	if err == nil {
+		t.Errorf("Expected error for FA2 event")
+	}
+}
+
+func TestCertificateSignature(t *testing.T) {
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("Failed to make key: %v", err)
+	}
+	certificate := Certificate{
+		OperationHash: certificateHash,
+		Level:         500,
+		Timestamp:     time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC),
+		Retirements:   []CertifiedRetirement{{EventID: 100, Amount: "5", Reason: "flights"}},
+	}
+	if certificate.Verify() == nil {
+		t.Errorf("Expected unsigned certificate to fail verification")
+	}
+
+	err = certificate.Sign(key)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	err = certificate.Verify()
+	if err != nil {
+		t.Errorf("Expected signature to verify: %v", err)
+	}
+
+	tampered := certificate
+	tampered.Retirements = []CertifiedRetirement{{EventID: 100, Amount: "50", Reason: "flights"}}
+	if tampered.Verify() == nil {
+		t.Errorf("Expected tampered certificate to fail verification")
+	}
+
+	var html bytes.Buffer
+	err = certificate.WriteHTML(&html)
+	if err != nil {
+		t.Errorf("Failed to write HTML: %v", err)
+	}
+	if !strings.Contains(html.String(), certificate.Signature) {
+		t.Errorf("Expected signature in HTML")
+	}
+	var pdf bytes.Buffer
+	err = certificate.WritePDF(&pdf)
+	if err != nil {
+		t.Errorf("Failed to write PDF: %v", err)
+	}
+	if !strings.HasPrefix(pdf.String(), "%PDF-") {
+		t.Errorf("PDF output is not framed as a PDF")
+	}
+}
@@ -0,0 +1,374 @@
+package x4c
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// The largest metadata document we will fetch from a URL.
+const maxContractMetadataSize = 1 << 20
+
+type ContractMetadataLicense struct {
+	Name    string `json:"name"`
+	Details string `json:"details,omitempty"`
+}
+
+type ContractMetadataSource struct {
+	Tools    []string `json:"tools,omitempty"`
+	Location string   `json:"location,omitempty"`
+}
+
+// ContractMetadataError maps a value the contract fails with to a description of it.
+// Error and Expansion are Micheline JSON.
+type ContractMetadataError struct {
+	Error     json.RawMessage `json:"error"`
+	Expansion json.RawMessage `json:"expansion,omitempty"`
+	View      string          `json:"view,omitempty"`
+	Languages []string        `json:"languages,omitempty"`
+}
+
+// MichelsonStorageView is an off-chain view run against the contract's storage. The
+// parameter, return type, and code are Micheline JSON.
+type MichelsonStorageView struct {
+	Parameter  json.RawMessage `json:"parameter,omitempty"`
+	ReturnType json.RawMessage `json:"returnType"`
+	Code       json.RawMessage `json:"code"`
+}
+
+type ContractMetadataViewImplementation struct {
+	MichelsonStorageView *MichelsonStorageView `json:"michelsonStorageView,omitempty"`
+	RestAPIQuery         json.RawMessage       `json:"restApiQuery,omitempty"`
+}
+
+type ContractMetadataView struct {
+	Name            string                               `json:"name"`
+	Description     string                               `json:"description,omitempty"`
+	Pure            bool                                 `json:"pure,omitempty"`
+	Implementations []ContractMetadataViewImplementation `json:"implementations"`
+}
+
+// ContractMetadata is the TZIP-16 metadata document for a contract.
+type ContractMetadata struct {
+	Name        string                   `json:"name,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Version     string                   `json:"version,omitempty"`
+	License     *ContractMetadataLicense `json:"license,omitempty"`
+	Authors     []string                 `json:"authors,omitempty"`
+	Homepage    string                   `json:"homepage,omitempty"`
+	Source      *ContractMetadataSource  `json:"source,omitempty"`
+	Interfaces  []string                 `json:"interfaces,omitempty"`
+	Errors      []ContractMetadataError  `json:"errors,omitempty"`
+	Views       []ContractMetadataView   `json:"views,omitempty"`
+}
+
+// The off-chain equivalents of the on-chain views in fa2.mligo, written against the
+// storage layout that FA2Originate creates:
+// (pair (pair (pair ledger metadata) (pair operators oracle)) token_metadata)
+const (
+	fa2BalanceOfViewParameter = `{"prim":"pair","args":[{"prim":"address","annots":["%token_owner"]},{"prim":"nat","annots":["%token_id"]}]}`
+	fa2BalanceOfViewCode      = `[{"prim":"UNPAIR"},{"prim":"SWAP"},{"prim":"CAR"},{"prim":"CAR"},{"prim":"CAR"},{"prim":"SWAP"},{"prim":"GET"},` +
+		`{"prim":"IF_NONE","args":[[{"prim":"PUSH","args":[{"prim":"nat"},{"int":"0"}]}],[]]}]`
+	fa2GetMetadataViewReturnType = `{"prim":"pair","args":[{"prim":"nat","annots":["%token_id"]},{"prim":"map","args":[{"prim":"string"},{"prim":"bytes"}],"annots":["%token_info"]}]}`
+	fa2GetMetadataViewCode       = `[{"prim":"UNPAIR"},{"prim":"SWAP"},{"prim":"CDR"},{"prim":"SWAP"},{"prim":"GET"},` +
+		`{"prim":"IF_NONE","args":[[{"prim":"PUSH","args":[{"prim":"nat"},{"int":"0"}]},{"prim":"FAILWITH"}],[]]}]`
+)
+
+func contractErrorTable(errors map[int64]contractErrorInfo) []ContractMetadataError {
+	codes := make([]int64, 0, len(errors))
+	for code := range errors {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	table := make([]ContractMetadataError, 0, len(codes))
+	for _, code := range codes {
+		expansion, _ := json.Marshal(map[string]string{
+			"string": fmt.Sprintf("%s: %s", errors[code].Name, errors[code].Description),
+		})
+		table = append(table, ContractMetadataError{
+			Error:     json.RawMessage(fmt.Sprintf(`{"int":"%d"}`, code)),
+			Expansion: expansion,
+			Languages: []string{"en"},
+		})
+	}
+	return table
+}
+
+// NewFA2ContractMetadata builds the TZIP-16 metadata for an x4c FA2 contract, with the
+// interfaces it implements, its error codes, and off-chain versions of its views. The
+// caller can fill in the other fields before publishing it.
+func NewFA2ContractMetadata(name string, description string, version string) ContractMetadata {
+	return ContractMetadata{
+		Name:        name,
+		Description: description,
+		Version:     version,
+		Interfaces:  []string{"TZIP-012", "TZIP-016", "TZIP-021"},
+		Errors:      contractErrorTable(fa2Errors),
+		Views: []ContractMetadataView{
+			{
+				Name:        "view_balance_of",
+				Description: "The balance of a token owner for a token ID.",
+				Pure:        true,
+				Implementations: []ContractMetadataViewImplementation{{
+					MichelsonStorageView: &MichelsonStorageView{
+						Parameter:  json.RawMessage(fa2BalanceOfViewParameter),
+						ReturnType: json.RawMessage(`{"prim":"nat"}`),
+						Code:       json.RawMessage(fa2BalanceOfViewCode),
+					},
+				}},
+			},
+			{
+				Name:        "view_get_metadata",
+				Description: "The token metadata for a token ID, failing with TOKEN_UNDEFINED if there is none.",
+				Pure:        true,
+				Implementations: []ContractMetadataViewImplementation{{
+					MichelsonStorageView: &MichelsonStorageView{
+						Parameter:  json.RawMessage(`{"prim":"nat"}`),
+						ReturnType: json.RawMessage(fa2GetMetadataViewReturnType),
+						Code:       json.RawMessage(fa2GetMetadataViewCode),
+					},
+				}},
+			},
+		},
+	}
+}
+
+// ContractMetadataValidationError lists everything in a metadata document that doesn't
+// follow the TZIP-16 schema.
+type ContractMetadataValidationError struct {
+	Problems []string
+}
+
+func (e ContractMetadataValidationError) Error() string {
+	return fmt.Sprintf("%d problems found in contract metadata:\n\t%s", len(e.Problems), strings.Join(e.Problems, "\n\t"))
+}
+
+var interfacePattern = regexp.MustCompile(`^TZIP-\d{3}( .+)?$`)
+
+func isMicheline(value json.RawMessage) bool {
+	var decoded interface{}
+	if json.Unmarshal(value, &decoded) != nil {
+		return false
+	}
+	switch decoded.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks the metadata against the TZIP-16 schema, returning a
+// ContractMetadataValidationError listing all the problems found.
+func (m ContractMetadata) Validate() error {
+	problems := make([]string, 0)
+	if m.License != nil && m.License.Name == "" {
+		problems = append(problems, "license has no name")
+	}
+	for _, name := range m.Interfaces {
+		if !interfacePattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("interface %q is not of the form TZIP-XXX", name))
+		}
+	}
+	for index, entry := range m.Errors {
+		if !isMicheline(entry.Error) {
+			problems = append(problems, fmt.Sprintf("error %d has no Micheline error value", index))
+		}
+		has_expansion := len(entry.Expansion) > 0
+		if has_expansion == (entry.View != "") {
+			problems = append(problems, fmt.Sprintf("error %d must have exactly one of expansion or view", index))
+		}
+		if has_expansion && !isMicheline(entry.Expansion) {
+			problems = append(problems, fmt.Sprintf("error %d expansion is not Micheline", index))
+		}
+	}
+	view_names := make(map[string]bool)
+	for index, view := range m.Views {
+		if view.Name == "" {
+			problems = append(problems, fmt.Sprintf("view %d has no name", index))
+		} else if view_names[view.Name] {
+			problems = append(problems, fmt.Sprintf("view %s is defined more than once", view.Name))
+		}
+		view_names[view.Name] = true
+		if len(view.Implementations) == 0 {
+			problems = append(problems, fmt.Sprintf("view %q has no implementations", view.Name))
+		}
+		for implementation_index, implementation := range view.Implementations {
+			storage_view := implementation.MichelsonStorageView
+			if (storage_view != nil) == (len(implementation.RestAPIQuery) > 0) {
+				problems = append(problems, fmt.Sprintf("view %q implementation %d must be exactly one of michelsonStorageView or restApiQuery", view.Name, implementation_index))
+				continue
+			}
+			if storage_view == nil {
+				continue
+			}
+			if len(storage_view.Parameter) > 0 && !isMicheline(storage_view.Parameter) {
+				problems = append(problems, fmt.Sprintf("view %q parameter is not Micheline", view.Name))
+			}
+			if !isMicheline(storage_view.ReturnType) {
+				problems = append(problems, fmt.Sprintf("view %q return type is not Micheline", view.Name))
+			}
+			if !isMicheline(storage_view.Code) {
+				problems = append(problems, fmt.Sprintf("view %q code is not Micheline", view.Name))
+			}
+		}
+	}
+	for _, entry := range m.Errors {
+		if entry.View != "" && !view_names[entry.View] {
+			problems = append(problems, fmt.Sprintf("error refers to unknown view %q", entry.View))
+		}
+	}
+	if len(problems) > 0 {
+		return ContractMetadataValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// InlineContractMetadata returns the metadata big map for storing the document in the
+// contract itself, under the content key.
+func InlineContractMetadata(m ContractMetadata) (map[string][]byte, error) {
+	err := m.Validate()
+	if err != nil {
+		return nil, err
+	}
+	document, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode contract metadata: %w", err)
+	}
+	return map[string][]byte{
+		"":        []byte("tezos-storage:content"),
+		"content": document,
+	}, nil
+}
+
+// PinnedContractMetadata returns the metadata big map for a document hosted at a URL,
+// pinned to its SHA-256 hash so that readers can tell if it is changed.
+func PinnedContractMetadata(location string, document []byte) map[string][]byte {
+	digest := sha256.Sum256(document)
+	uri := fmt.Sprintf("sha256://0x%s/%s", hex.EncodeToString(digest[:]), url.PathEscape(location))
+	return map[string][]byte{
+		"": []byte(uri),
+	}
+}
+
+// FetchMetadataDocument gets a metadata document from an http or https URL.
+func FetchMetadataDocument(ctx context.Context, http_client *http.Client, location string) ([]byte, error) {
+	parsed, err := url.Parse(location)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return nil, fmt.Errorf("can only fetch metadata from http or https URLs, not %q", location)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	response, err := http_client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", location, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", location, response.StatusCode)
+	}
+	document, err := io.ReadAll(io.LimitReader(response.Body, maxContractMetadataSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", location, err)
+	}
+	if len(document) > maxContractMetadataSize {
+		return nil, fmt.Errorf("metadata at %s is larger than %d bytes", location, maxContractMetadataSize)
+	}
+	return document, nil
+}
+
+// ResolveContractMetadata follows the URI under the empty key of a contract's metadata
+// big map, whose values are hex as tzkt gives them to us, to the metadata document.
+// It supports tezos-storage URIs within the same contract, http and https URLs, and
+// sha256 URIs wrapping either of those, whose hash it checks.
+func ResolveContractMetadata(ctx context.Context, http_client *http.Client, bigmap map[string]string) ([]byte, error) {
+	raw_uri, ok := bigmap[""]
+	if !ok {
+		return nil, fmt.Errorf("contract has no metadata, the empty key is not set")
+	}
+	uri := decodeTokenInformation(raw_uri)
+
+	if strings.HasPrefix(uri, "tezos-storage:") {
+		key := strings.TrimPrefix(uri, "tezos-storage:")
+		if strings.HasPrefix(key, "//") {
+			return nil, fmt.Errorf("metadata in another contract (%s) is not supported", uri)
+		}
+		key, err := url.PathUnescape(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %q: %w", uri, err)
+		}
+		value, ok := bigmap[key]
+		if !ok {
+			return nil, fmt.Errorf("metadata points to key %q, which is not set", key)
+		}
+		return []byte(decodeTokenInformation(value)), nil
+	}
+
+	if strings.HasPrefix(uri, "sha256://0x") {
+		rest := strings.TrimPrefix(uri, "sha256://0x")
+		expected, escaped, found := strings.Cut(rest, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid sha256 URI %q", uri)
+		}
+		location, err := url.PathUnescape(escaped)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL in %q: %w", uri, err)
+		}
+		document, err := FetchMetadataDocument(ctx, http_client, location)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(document)
+		if !strings.EqualFold(hex.EncodeToString(digest[:]), expected) {
+			return nil, fmt.Errorf("metadata at %s does not match its pinned hash", location)
+		}
+		return document, nil
+	}
+
+	return FetchMetadataDocument(ctx, http_client, uri)
+}
+
+// Both FA2 and custodian contracts keep the metadata big map under the same name, so
+// we only decode that much of the storage.
+type contractMetadataStorage struct {
+	Metadata int64 `json:"metadata"`
+}
+
+// VerifyContractMetadata fetches the metadata of an FA2 or custodian contract and
+// checks it against the TZIP-16 schema.
+func VerifyContractMetadata(ctx context.Context, client tzclient.TezosClient, http_client *http.Client, contract tzclient.Contract) (ContractMetadata, error) {
+	var storage contractMetadataStorage
+	err := client.GetContractStorage(contract, ctx, &storage)
+	if err != nil {
+		return ContractMetadata{}, fmt.Errorf("failed to get contract storage: %w", err)
+	}
+	fa2_storage := FA2Storage{Metadata: storage.Metadata}
+	bigmap, err := fa2_storage.GetFA2Metadata(ctx, client)
+	if err != nil {
+		return ContractMetadata{}, err
+	}
+
+	document, err := ResolveContractMetadata(ctx, http_client, bigmap)
+	if err != nil {
+		return ContractMetadata{}, err
+	}
+	var metadata ContractMetadata
+	err = json.Unmarshal(document, &metadata)
+	if err != nil {
+		return ContractMetadata{}, ContractMetadataValidationError{Problems: []string{fmt.Sprintf("metadata is not a JSON object: %v", err)}}
+	}
+	return metadata, metadata.Validate()
+}
@@ -0,0 +1,333 @@
+package x4c
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func TestFA2ContractMetadata(t *testing.T) {
+	metadata := NewFA2ContractMetadata("Test", "A test contract", "1.0")
+	err := metadata.Validate()
+	if err != nil {
+		t.Fatalf("Expected generated metadata to be valid: %v", err)
+	}
+	if len(metadata.Errors) != len(fa2Errors) {
+		t.Errorf("Expected %d errors, got %d", len(fa2Errors), len(metadata.Errors))
+	}
+	if string(metadata.Errors[0].Error) != `{"int":"0"}` {
+		t.Errorf("Expected errors in code order, got %s first", metadata.Errors[0].Error)
+	}
+	if string(metadata.Errors[0].Expansion) != `{"string":"TOKEN_UNDEFINED: the token ID is not defined on the FA2 contract"}` {
+		t.Errorf("Unexpected expansion %s", metadata.Errors[0].Expansion)
+	}
+
+	names := []string{"view_balance_of", "view_get_metadata"}
+	if len(metadata.Views) != len(names) {
+		t.Fatalf("Expected %d views, got %d", len(names), len(metadata.Views))
+	}
+	for idx, view := range metadata.Views {
+		if view.Name != names[idx] {
+			t.Errorf("%d: Expected view %s, got %s", idx, names[idx], view.Name)
+		}
+		storage_view := view.Implementations[0].MichelsonStorageView
+		for _, value := range []json.RawMessage{storage_view.Parameter, storage_view.ReturnType, storage_view.Code} {
+			var prim micheline.Prim
+			err := prim.UnmarshalJSON(value)
+			if err != nil {
+				t.Errorf("%d: Failed to parse %s as Micheline: %v", idx, value, err)
+			}
+		}
+	}
+}
+
+func TestValidateContractMetadata(t *testing.T) {
+	view := ContractMetadataView{
+		Name: "view",
+		Implementations: []ContractMetadataViewImplementation{{
+			MichelsonStorageView: &MichelsonStorageView{
+				ReturnType: json.RawMessage(`{"prim":"nat"}`),
+				Code:       json.RawMessage(`[]`),
+			},
+		}},
+	}
+	testcases := []struct {
+		Metadata ContractMetadata
+		Problems int
+	}{
+		{
+			Metadata: ContractMetadata{},
+		},
+		{
+			Metadata: ContractMetadata{
+				Interfaces: []string{"TZIP-012", "TZIP-016 draft"},
+				Views:      []ContractMetadataView{view},
+				Errors: []ContractMetadataError{
+					{Error: json.RawMessage(`{"int":"1"}`), Expansion: json.RawMessage(`{"string":"oops"}`)},
+					{Error: json.RawMessage(`{"int":"2"}`), View: "view"},
+				},
+			},
+		},
+		{
+			Metadata: ContractMetadata{
+				License:    &ContractMetadataLicense{},
+				Interfaces: []string{"FA2"},
+			},
+			Problems: 2,
+		},
+		{
+			Metadata: ContractMetadata{
+				Errors: []ContractMetadataError{
+					{Error: json.RawMessage(`"1"`), Expansion: json.RawMessage(`{"string":"oops"}`)},
+					{Error: json.RawMessage(`{"int":"2"}`)},
+					{Error: json.RawMessage(`{"int":"3"}`), View: "missing"},
+				},
+			},
+			Problems: 3,
+		},
+		{
+			Metadata: ContractMetadata{
+				Views: []ContractMetadataView{
+					view,
+					view,
+					{Name: "empty"},
+					{Implementations: []ContractMetadataViewImplementation{{}}},
+					{Name: "bad", Implementations: []ContractMetadataViewImplementation{{
+						MichelsonStorageView: &MichelsonStorageView{Code: json.RawMessage(`1`)},
+					}}},
+				},
+			},
+			Problems: 6,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		err := testcase.Metadata.Validate()
+		if testcase.Problems == 0 {
+			if err != nil {
+				t.Errorf("%d: Unexpected error: %v", idx, err)
+			}
+			continue
+		}
+		var validation_err ContractMetadataValidationError
+		if !errors.As(err, &validation_err) {
+			t.Errorf("%d: Expected validation error, got %v", idx, err)
+			continue
+		}
+		if len(validation_err.Problems) != testcase.Problems {
+			t.Errorf("%d: Expected %d problems, got %v", idx, testcase.Problems, validation_err.Problems)
+		}
+	}
+}
+
+func TestInlineContractMetadata(t *testing.T) {
+	metadata := NewFA2ContractMetadata("Test", "", "")
+	bigmap, err := InlineContractMetadata(metadata)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(bigmap[""]) != "tezos-storage:content" {
+		t.Errorf("Unexpected root URI %s", bigmap[""])
+	}
+	var decoded ContractMetadata
+	err = json.Unmarshal(bigmap["content"], &decoded)
+	if err != nil {
+		t.Fatalf("Failed to decode content: %v", err)
+	}
+	if decoded.Name != "Test" || len(decoded.Views) != 2 {
+		t.Errorf("Unexpected content %v", decoded)
+	}
+
+	_, err = InlineContractMetadata(ContractMetadata{Interfaces: []string{"bad"}})
+	if err == nil {
+		t.Errorf("Expected invalid metadata to be refused")
+	}
+}
+
+func TestPinnedContractMetadata(t *testing.T) {
+	bigmap := PinnedContractMetadata("https://example.com/metadata.json", []byte("{}"))
+	expected := "sha256://0x44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a/https:%2F%2Fexample.com%2Fmetadata.json"
+	if string(bigmap[""]) != expected {
+		t.Errorf("Expected %s, got %s", expected, bigmap[""])
+	}
+	if len(bigmap) != 1 {
+		t.Errorf("Expected only the root key, got %v", bigmap)
+	}
+}
+
+func hexMetadata(bigmap map[string][]byte) map[string]string {
+	result := make(map[string]string, len(bigmap))
+	for key, value := range bigmap {
+		result[key] = hex.EncodeToString(value)
+	}
+	return result
+}
+
+func TestResolveContractMetadata(t *testing.T) {
+	document := []byte(`{"name": "hosted"}`)
+	digest := sha256.Sum256(document)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata.json" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(document)
+	}))
+	defer server.Close()
+	location := server.URL + "/metadata.json"
+
+	testcases := []struct {
+		BigMap      map[string][]byte
+		Expected    string
+		ExpectError bool
+	}{
+		{
+			BigMap: map[string][]byte{
+				"":     []byte("tezos-storage:here"),
+				"here": []byte(`{"name": "inline"}`),
+			},
+			Expected: `{"name": "inline"}`,
+		},
+		{
+			BigMap: map[string][]byte{
+				"":           []byte("tezos-storage:with%2Fslash"),
+				"with/slash": []byte(`{}`),
+			},
+			Expected: `{}`,
+		},
+		{
+			BigMap:      map[string][]byte{"": []byte("tezos-storage:missing")},
+			ExpectError: true,
+		},
+		{
+			BigMap:      map[string][]byte{"": []byte("tezos-storage://KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR/content")},
+			ExpectError: true,
+		},
+		{
+			BigMap:   map[string][]byte{"": []byte(location)},
+			Expected: string(document),
+		},
+		{
+			BigMap:   PinnedContractMetadata(location, document),
+			Expected: string(document),
+		},
+		{
+			BigMap:      PinnedContractMetadata(location, []byte(`{"name": "changed"}`)),
+			ExpectError: true,
+		},
+		{
+			BigMap:      map[string][]byte{"": []byte("sha256://0x" + hex.EncodeToString(digest[:]))},
+			ExpectError: true,
+		},
+		{
+			BigMap:      map[string][]byte{"": []byte(server.URL + "/missing.json")},
+			ExpectError: true,
+		},
+		{
+			BigMap:      map[string][]byte{"": []byte("ipfs://QmSomething")},
+			ExpectError: true,
+		},
+		{
+			BigMap:      map[string][]byte{"content": []byte("{}")},
+			ExpectError: true,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		resolved, err := ResolveContractMetadata(context.Background(), server.Client(), hexMetadata(testcase.BigMap))
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %s", idx, resolved)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", idx, err)
+			continue
+		}
+		if string(resolved) != testcase.Expected {
+			t.Errorf("%d: Expected %s, got %s", idx, testcase.Expected, resolved)
+		}
+	}
+}
+
+func TestVerifyContractMetadata(t *testing.T) {
+	contract, err := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	if err != nil {
+		t.Fatalf("Failed to make contract: %v", err)
+	}
+
+	valid, err := InlineContractMetadata(NewFA2ContractMetadata("Test", "", ""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testcases := []struct {
+		BigMap           map[string][]byte
+		ExpectValidation bool
+		ExpectError      bool
+	}{
+		{
+			BigMap: valid,
+		},
+		{
+			BigMap: map[string][]byte{
+				"":        []byte("tezos-storage:content"),
+				"content": []byte(`{"interfaces": ["FA2"]}`),
+			},
+			ExpectValidation: true,
+		},
+		{
+			BigMap: map[string][]byte{
+				"":        []byte("tezos-storage:content"),
+				"content": []byte(`not json`),
+			},
+			ExpectValidation: true,
+		},
+		{
+			BigMap:      map[string][]byte{},
+			ExpectError: true,
+		},
+	}
+
+	for idx, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.ContractStorage[contract.Address.String()] = &contractMetadataStorage{Metadata: 3}
+		items := make([]tzkt.BigMapItem, 0, len(testcase.BigMap))
+		for key, value := range hexMetadata(testcase.BigMap) {
+			raw_key, _ := json.Marshal(key)
+			raw_value, _ := json.Marshal(value)
+			items = append(items, tzkt.BigMapItem{Active: true, Key: raw_key, Value: raw_value})
+		}
+		client.AddBigMap(3, items)
+
+		metadata, err := VerifyContractMetadata(context.Background(), client, http.DefaultClient, contract)
+		var validation_err ContractMetadataValidationError
+		switch {
+		case testcase.ExpectValidation:
+			if !errors.As(err, &validation_err) {
+				t.Errorf("%d: Expected validation error, got %v", idx, err)
+			}
+		case testcase.ExpectError:
+			if err == nil || errors.As(err, &validation_err) {
+				t.Errorf("%d: Expected fetch error, got %v", idx, err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("%d: Unexpected error: %v", idx, err)
+			} else if metadata.Name != "Test" || !strings.HasPrefix(metadata.Interfaces[0], "TZIP-") {
+				t.Errorf("%d: Unexpected metadata %v", idx, metadata)
+			}
+		}
+	}
+}
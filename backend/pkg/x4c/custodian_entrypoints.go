@@ -33,7 +33,7 @@ func CustodianInternalMint(
 		),
 	}
 
-	return client.CallContract(ctx, signer, target, parameters)
+	return callContract(ctx, client, CustodianContract, signer, target, parameters)
 }
 
 func CustodianInternalTransfer(
@@ -88,7 +88,105 @@ func CustodianInternalTransfer(
 			),
 		),
 	}
-	return client.CallContract(ctx, signer, target, parameters)
+	return callContract(ctx, client, CustodianContract, signer, target, parameters)
+}
+
+type CustodianExternalTransferDestination struct {
+	To      tezos.Address
+	TokenID int64
+	Amount  int64
+}
+
+type CustodianExternalTransferBatch struct {
+	FromKYC string
+	Txs     []CustodianExternalTransferDestination
+}
+
+type CustodianExternalTransferInfo struct {
+	TokenAddress tzclient.Contract
+	Batches      []CustodianExternalTransferBatch
+}
+
+func CustodianExternalTransfer(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	transfer_list []CustodianExternalTransferInfo,
+) (string, error) {
+	if len(transfer_list) == 0 {
+		return "", fmt.Errorf("no transfers specified")
+	}
+
+	transfers := make([]micheline.Prim, 0, len(transfer_list))
+	for index, transfer := range transfer_list {
+		if len(transfer.Batches) == 0 {
+			return "", fmt.Errorf("transfer %d has no batches", index)
+		}
+		batches := make([]micheline.Prim, 0, len(transfer.Batches))
+		for batch_index, batch := range transfer.Batches {
+			if len(batch.Txs) == 0 {
+				return "", fmt.Errorf("transfer %d batch %d has no destinations", index, batch_index)
+			}
+			txs := make([]micheline.Prim, 0, len(batch.Txs))
+			for _, tx := range batch.Txs {
+				if !tx.To.IsValid() {
+					return "", fmt.Errorf("transfer %d batch %d has invalid destination address", index, batch_index)
+				}
+				txs = append(txs, micheline.NewPair(
+					micheline.NewString(tx.To.String()),
+					micheline.NewPair(
+						micheline.NewNat(big.NewInt(tx.TokenID)),
+						micheline.NewNat(big.NewInt(tx.Amount)),
+					),
+				))
+			}
+			batches = append(batches, micheline.NewPair(
+				micheline.NewBytes(micheline.NewString(batch.FromKYC).Pack()),
+				micheline.Prim{
+					Type: micheline.PrimSequence,
+					Args: txs,
+				},
+			))
+		}
+		transfers = append(transfers, micheline.NewPair(
+			micheline.NewString(transfer.TokenAddress.Address.String()),
+			micheline.Prim{
+				Type: micheline.PrimSequence,
+				Args: batches,
+			},
+		))
+	}
+
+	// Michelson type:
+	// (list %external_transfer
+	// 	(pair
+	// 		(address %token_address)
+	// 		(list %txn_batch
+	// 			(pair
+	// 				(bytes %from_)
+	// 				(list %txs
+	// 					(pair
+	// 						(address %to_)
+	// 						(pair
+	// 							(nat %token_id)
+	// 							(nat %amount)
+	// 						)
+	// 					)
+	// 				)
+	// 			)
+	// 		)
+	// 	)
+	// )
+	parameters := micheline.Parameters{
+		Entrypoint: "external_transfer",
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: transfers,
+		},
+	}
+
+	return callContract(ctx, client, CustodianContract, signer, target, parameters)
 }
 
 const (
@@ -152,7 +250,83 @@ func CustodianUpdateOperators(
 		},
 	}
 
-	return client.CallContract(ctx, signer, target, parameters)
+	return callContract(ctx, client, CustodianContract, signer, target, parameters)
+}
+
+type CustodianRetireInfo struct {
+	TokenAddress tzclient.Contract
+	TokenID      int64
+	KYC          string
+	Amount       int64
+	Metadata     RetirementMetadata
+}
+
+func CustodianRetireBatch(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	retire_list []CustodianRetireInfo,
+) (string, error) {
+	if len(retire_list) == 0 {
+		return "", fmt.Errorf("no retirements specified")
+	}
+
+	// The contract groups retirements by FA2 contract, so gather them up whilst
+	// keeping the order the caller gave us
+	token_addresses := make([]string, 0)
+	grouped_txs := make(map[string][]micheline.Prim)
+	for index, retirement := range retire_list {
+		if !retirement.TokenAddress.Address.IsValid() {
+			return "", fmt.Errorf("retirement %d has invalid token address", index)
+		}
+		if retirement.KYC == "" {
+			return "", fmt.Errorf("retirement %d has no KYC", index)
+		}
+		retiring_data, err := retirement.Metadata.Encode()
+		if err != nil {
+			return "", fmt.Errorf("retirement %d has invalid metadata: %w", index, err)
+		}
+		token_address := retirement.TokenAddress.Address.String()
+		if _, ok := grouped_txs[token_address]; !ok {
+			token_addresses = append(token_addresses, token_address)
+		}
+		grouped_txs[token_address] = append(grouped_txs[token_address], micheline.NewPair(
+			micheline.NewPair(
+				micheline.NewNat(big.NewInt(retirement.Amount)),
+				micheline.NewBytes(retiring_data),
+			),
+			micheline.NewPair(
+				micheline.NewBytes(micheline.NewString(retirement.KYC).Pack()),
+				micheline.NewNat(big.NewInt(retirement.TokenID)),
+			),
+		))
+	}
+
+	retirements := make([]micheline.Prim, 0, len(token_addresses))
+	for _, token_address := range token_addresses {
+		retirements = append(retirements, micheline.NewPair(
+			micheline.NewString(token_address),
+			micheline.Prim{
+				Type: micheline.PrimSequence,
+				Args: grouped_txs[token_address],
+			},
+		))
+	}
+
+	// Michelson type:
+	// (list %retire (pair (address %token_address)
+	//                	(list %txs (pair (pair (nat %amount) (bytes %retiring_data))
+	//                                	(pair (bytes %retiring_party_kyc) (nat %token_id))))))
+	parameters := micheline.Parameters{
+		Entrypoint: "retire",
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: retirements,
+		},
+	}
+
+	return callContract(ctx, client, CustodianContract, signer, target, parameters)
 }
 
 func CustodianRetire(
@@ -164,35 +338,49 @@ func CustodianRetire(
 	token_id int64,
 	kyc string,
 	amount int64,
-	reason string,
+	metadata RetirementMetadata,
 ) (string, error) {
-	bigAmount := big.NewInt(amount)
-	bigToken := big.NewInt(token_id)
+	retire_list := []CustodianRetireInfo{
+		{
+			TokenAddress: token_address,
+			TokenID:      token_id,
+			KYC:          kyc,
+			Amount:       amount,
+			Metadata:     metadata,
+		},
+	}
+	return CustodianRetireBatch(ctx, client, target, signer, retire_list)
+}
+
+func CustodianUpdateCustodian(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	new_custodian tezos.Address,
+) (string, error) {
+	err := validateAdminAddress(new_custodian)
+	if err != nil {
+		return "", fmt.Errorf("new custodian is not valid: %w", err)
+	}
+
+	// Only the current custodian can hand over the contract, so rather than
+	// pay to find that out on chain, check first
+	var storage CustodianStorage
+	err = client.GetContractStorage(target, ctx, &storage)
+	if err != nil {
+		return "", fmt.Errorf("failed to get contract storage: %w", err)
+	}
+	if storage.Custodian != signer.Address.String() {
+		return "", fmt.Errorf("signer %s is not the current custodian %s", signer.Address, storage.Custodian)
+	}
 
 	// Michelson type:
-	// (list %retire (pair (address %token_address)
-	//                	(list %txs (pair (pair (nat %amount) (bytes %retiring_data))
-	//                                	(pair (bytes %retiring_party_kyc) (nat %token_id))))))
+	// (address %update_custodian)
 	parameters := micheline.Parameters{
-		Entrypoint: "retire",
-		Value: micheline.NewSeq(
-			micheline.NewPair(
-				micheline.NewString(token_address.Address.String()),
-				micheline.NewSeq(
-					micheline.NewPair(
-						micheline.NewPair(
-							micheline.NewNat(bigAmount),
-							micheline.NewBytes([]byte(reason)),
-						),
-						micheline.NewPair(
-							micheline.NewBytes(micheline.NewString(kyc).Pack()),
-							micheline.NewNat(bigToken),
-						),
-					),
-				),
-			),
-		),
+		Entrypoint: "update_custodian",
+		Value:      micheline.NewString(new_custodian.String()),
 	}
 
-	return client.CallContract(ctx, signer, target, parameters)
+	return callContract(ctx, client, CustodianContract, signer, target, parameters)
 }
@@ -11,11 +11,13 @@ import (
 
 type CustodianRetireEvent struct {
 	tzkt.Event
-	RetiringParty       string      `json:"retiring_party"`
+	RetiringParty    string      `json:"retiring_party"`
 	RetiringPartyKyc string      `json:"retiring_party_kyc"`
-	Token               TokenID     `json:"token"`
-	Amount              json.Number `json:"amount"`
-	Reason           string      `json:"retiring_data"`
+	Token            TokenID     `json:"token"`
+	Amount           json.Number `json:"amount"`
+	// The retiring data as text, with what it holds decoded into Metadata
+	Reason   string             `json:"retiring_data"`
+	Metadata RetirementMetadata `json:"metadata"`
 }
 
 type InternalMintEvent struct {
@@ -27,8 +29,8 @@ type InternalMintEvent struct {
 
 type InternalTransferEvent struct {
 	tzkt.Event
-	RawTo   string      `json:"source"`
-	RawFrom string      `json:"destination"`
+	RawFrom string      `json:"source"`
+	RawTo   string      `json:"destination"`
 	Token   TokenID     `json:"token"`
 	Amount  json.Number `json:"amount"`
 }
@@ -50,43 +52,47 @@ func (e InternalTransferEvent) From() string {
 }
 
 func GetCustodianRetireEvents(ctx context.Context, client tzclient.TezosClient, contract tzclient.Contract) ([]CustodianRetireEvent, error) {
-	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "retire")
+	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "retire", tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find retire events: %w", err)
 	}
 
 	result := make([]CustodianRetireEvent, len(raw))
 	for idx, event := range raw {
-		typedEvent := CustodianRetireEvent{
-		    event,
-		    "",
-		    "",
-		    TokenID{},
-		    "0",
-		    "",
-		}
-		err = json.Unmarshal(event.Payload, &typedEvent)
+		result[idx], err = decodeCustodianRetireEvent(event)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshall payload: %w", err)
+			return nil, err
 		}
-        retiring_party_kyc, err := tzclient.MichelsonToString(typedEvent.RetiringPartyKyc)
-        if err != nil {
-            return nil, fmt.Errorf("failed to unmarshall event retiring_party_kyc: %w", err)
-        }
-        typedEvent.RetiringPartyKyc = retiring_party_kyc
-        reason, err := tzclient.MichelsonToString(typedEvent.Reason)
-        if err != nil {
-            return nil, fmt.Errorf("failed to unmarshall event reason: %w", err)
-        }
-        typedEvent.Reason = reason
-
-		result[idx] = typedEvent
 	}
 	return result, nil
 }
 
+func decodeCustodianRetireEvent(event tzkt.Event) (CustodianRetireEvent, error) {
+	typedEvent := CustodianRetireEvent{
+		event,
+		"",
+		"",
+		TokenID{},
+		"0",
+		"",
+		RetirementMetadata{},
+	}
+	err := json.Unmarshal(event.Payload, &typedEvent)
+	if err != nil {
+		return CustodianRetireEvent{}, fmt.Errorf("failed to unmarshall payload: %w", err)
+	}
+	retiring_party_kyc, err := tzclient.MichelsonToString(typedEvent.RetiringPartyKyc)
+	if err != nil {
+		return CustodianRetireEvent{}, fmt.Errorf("failed to unmarshall event retiring_party_kyc: %w", err)
+	}
+	typedEvent.RetiringPartyKyc = retiring_party_kyc
+	typedEvent.Metadata = decodeRetiringData(typedEvent.Reason)
+	typedEvent.Reason = decodeRetiringText(typedEvent.Reason)
+	return typedEvent, nil
+}
+
 func GetInternalTransferEvents(ctx context.Context, client tzclient.TezosClient, contract tzclient.Contract) ([]InternalTransferEvent, error) {
-	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "internal_transfer")
+	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "internal_transfer", tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find internal_transfer events: %w", err)
 	}
@@ -110,7 +116,7 @@ func GetInternalTransferEvents(ctx context.Context, client tzclient.TezosClient,
 }
 
 func GetInternalMintEvents(ctx context.Context, client tzclient.TezosClient, contract tzclient.Contract) ([]InternalMintEvent, error) {
-	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "internal_mint")
+	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "internal_mint", tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find internal_mint events: %w", err)
 	}
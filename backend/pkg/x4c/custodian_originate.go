@@ -2,6 +2,7 @@ package x4c
 
 import (
 	"context"
+	"fmt"
 
 	"blockwatch.cc/tzgo/micheline"
 	"blockwatch.cc/tzgo/tezos"
@@ -32,6 +33,9 @@ func CustodianOriginate(
 	)
 
 	res, err := client.Originate(ctx, signer, contractBytes, storage)
+	if err != nil {
+		return res, fmt.Errorf("failed to originate custodian contract: %w", err)
+	}
 
-	return res, err
+	return res, nil
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
 )
 
 type TokenID struct {
@@ -48,12 +49,7 @@ type CustodianStorage struct {
 	ExternalLedger int64                 `json:"external_ledger"`
 }
 
-func (storage *CustodianStorage) GetLedger(ctx context.Context, client tzclient.TezosClient) (Ledger, error) {
-	bigmap, err := client.GetBigMapContents(ctx, storage.Ledger)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ledger big map: %w", err)
-	}
-
+func decodeLedger(bigmap []tzkt.BigMapItem) (Ledger, error) {
 	result := make(Ledger)
 	for _, item := range bigmap {
 		if !item.Active {
@@ -79,14 +75,42 @@ func (storage *CustodianStorage) GetLedger(ctx context.Context, client tzclient.
 	return result, nil
 }
 
+func (storage *CustodianStorage) GetLedger(ctx context.Context, client tzclient.TezosClient) (Ledger, error) {
+	bigmap, err := client.GetBigMapContents(ctx, storage.Ledger, tzkt.QueryOptions{ActiveOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger big map: %w", err)
+	}
+	return decodeLedger(bigmap)
+}
+
+// GetLedgerAt returns the ledger as it was at the given moment. The storage itself
+// should be current, as the ledger big map doesn't change once the contract is
+// originated.
+func (storage *CustodianStorage) GetLedgerAt(ctx context.Context, client tzclient.TezosClient, at Moment) (Ledger, error) {
+	level, err := at.ResolveLevel(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	bigmap, err := client.GetBigMapContentsAt(ctx, storage.Ledger, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger big map at level %d: %w", level, err)
+	}
+	return decodeLedger(bigmap)
+}
+
 func (storage *CustodianStorage) GetExternalLedger(ctx context.Context, client tzclient.TezosClient) (ExternalLedger, error) {
-	bigmap, err := client.GetBigMapContents(ctx, storage.ExternalLedger)
+	bigmap, err := client.GetBigMapContents(ctx, storage.ExternalLedger, tzkt.QueryOptions{ActiveOnly: true})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get external ledger big map: %w", err)
 	}
 
 	result := make(ExternalLedger)
 	for _, item := range bigmap {
+		// Tokens that were all retired or withdrawn are removed, but keep their last value
+		if !item.Active {
+			continue
+		}
+
 		var key TokenID
 		err := json.Unmarshal(item.Key, &key)
 		if err != nil {
@@ -107,7 +131,7 @@ func (storage *CustodianStorage) GetExternalLedger(ctx context.Context, client t
 }
 
 func (storage *CustodianStorage) GetCustodianMetadata(ctx context.Context, client tzclient.TezosClient) (CustodianMetadata, error) {
-	bigmap, err := client.GetBigMapContents(ctx, storage.Metadata)
+	bigmap, err := client.GetBigMapContents(ctx, storage.Metadata, tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get custodian metadata big map: %w", err)
 	}
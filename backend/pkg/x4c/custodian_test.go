@@ -3,8 +3,11 @@ package x4c
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 
+	"blockwatch.cc/tzgo/tezos"
+
 	"quantify.earth/x4c/pkg/tzclient"
 	"quantify.earth/x4c/pkg/tzkt"
 )
@@ -143,3 +146,395 @@ func TestBasicLedgerLoad(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateCustodian(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+
+	testcases := []struct {
+		CurrentCustodian string
+		NewCustodian     string
+		ExpectError      bool
+	}{
+		{
+			CurrentCustodian: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewCustodian:     "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5",
+			ExpectError:      false,
+		},
+		{
+			CurrentCustodian: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewCustodian:     "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR",
+			ExpectError:      false,
+		},
+		{
+			CurrentCustodian: "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5",
+			NewCustodian:     "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			ExpectError:      true,
+		},
+		{
+			CurrentCustodian: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewCustodian:     "",
+			ExpectError:      true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.Storage = &CustodianStorage{
+			Custodian: testcase.CurrentCustodian,
+		}
+		new_custodian, _ := tezos.ParseAddress(testcase.NewCustodian)
+
+		ctx := context.Background()
+		_, err := CustodianUpdateCustodian(ctx, client, target, signer, new_custodian)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			}
+		}
+	}
+}
+
+// Sets up a fake chain with a custodian holding 100 of token 1 from an FA2 contract,
+// all credited to its own "self" KYC.
+func newFakeChain(t *testing.T) (*tzclient.FakeClient, tzclient.Contract, tzclient.Contract, tzclient.Wallet) {
+	operator, _ := tzclient.NewWalletWithAddress("operator", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	custodian, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	client := tzclient.NewFakeClient()
+	client.AddFA2Contract(fa2, operator.Address)
+	client.AddCustodianContract(custodian, operator.Address)
+
+	ctx := context.Background()
+	_, err := FA2AddToken(ctx, client, fa2, operator, 1, "test", "url")
+	if err != nil {
+		t.Fatalf("Failed to add token: %v", err)
+	}
+	_, err = FA2Mint(ctx, client, fa2, operator, 1, custodian.Address, 100)
+	if err != nil {
+		t.Fatalf("Failed to mint: %v", err)
+	}
+	_, err = CustodianInternalMint(ctx, client, custodian, operator, fa2, 1)
+	if err != nil {
+		t.Fatalf("Failed to mint internally: %v", err)
+	}
+	return client, custodian, fa2, operator
+}
+
+func custodianBalances(t *testing.T, client tzclient.TezosClient, custodian tzclient.Contract) map[string]int64 {
+	var storage CustodianStorage
+	err := client.GetContractStorage(custodian, context.Background(), &storage)
+	if err != nil {
+		t.Fatalf("Failed to get custodian storage: %v", err)
+	}
+	ledger, err := storage.GetLedger(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Failed to get ledger: %v", err)
+	}
+	balances := make(map[string]int64)
+	for key, value := range ledger {
+		kyc, err := key.DecodeKYC()
+		if err != nil {
+			t.Fatalf("Failed to decode KYC %s: %v", key.RawKYC, err)
+		}
+		balances[kyc] = value
+	}
+	return balances
+}
+
+func TestCustodianOnFakeChain(t *testing.T) {
+	client, custodian, fa2, operator := newFakeChain(t)
+	other, _ := tzclient.NewWalletWithAddress("other", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	ctx := context.Background()
+
+	_, err := CustodianInternalTransfer(ctx, client, custodian, operator, fa2, 1, 40, "self", "compsci")
+	if err != nil {
+		t.Fatalf("Failed to transfer: %v", err)
+	}
+	_, err = CustodianRetire(ctx, client, custodian, operator, fa2, 1, "compsci", 15, NoteRetirementMetadata("flights"))
+	if err != nil {
+		t.Fatalf("Failed to retire: %v", err)
+	}
+	balances := custodianBalances(t, client, custodian)
+	if balances["self"] != 60 || balances["compsci"] != 25 || len(balances) != 2 {
+		t.Errorf("Unexpected balances after retiring %v", balances)
+	}
+
+	testcases := []struct {
+		Signer       tzclient.Wallet
+		KYC          string
+		Amount       int64
+		ExpectedName string
+	}{
+		{Signer: operator, KYC: "compsci", Amount: 30, ExpectedName: "INSUFFICIENT_BALANCE"},
+		{Signer: operator, KYC: "nobody", Amount: 1, ExpectedName: "INSUFFICIENT_BALANCE"},
+		{Signer: other, KYC: "compsci", Amount: 1, ExpectedName: "PERMISSIONS_DENIED"},
+	}
+	for index, testcase := range testcases {
+		_, err := CustodianRetire(ctx, client, custodian, testcase.Signer, fa2, 1, testcase.KYC, testcase.Amount, NoteRetirementMetadata("fail"))
+		var contract_error ContractError
+		if !errors.As(err, &contract_error) {
+			t.Errorf("%d: Expected contract error, got %v", index, err)
+		} else if contract_error.Kind != CustodianContract || contract_error.Name != testcase.ExpectedName {
+			t.Errorf("%d: Unexpected error %v", index, contract_error)
+		}
+	}
+	balances = custodianBalances(t, client, custodian)
+	if balances["compsci"] != 25 {
+		t.Errorf("Expected failed retirements to change nothing, got %v", balances)
+	}
+
+	// Once other is an operator for compsci it can retire for them
+	_, err = CustodianUpdateOperators(ctx, client, custodian, operator, []CustodianOperatorUpdateInfo{
+		{Owner: "compsci", Operator: other.Address, TokenID: 1, UpdateType: AddOperator},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add operator: %v", err)
+	}
+	_, err = CustodianRetire(ctx, client, custodian, other, fa2, 1, "compsci", 5, NoteRetirementMetadata("trains"))
+	if err != nil {
+		t.Fatalf("Failed to retire as operator: %v", err)
+	}
+	var storage CustodianStorage
+	err = client.GetContractStorage(custodian, ctx, &storage)
+	if err != nil {
+		t.Fatalf("Failed to get storage: %v", err)
+	}
+	if len(storage.Operators) != 1 || storage.Operators[0].Operator != other.Address.String() {
+		t.Errorf("Unexpected operators %v", storage.Operators)
+	}
+	if kyc, _ := storage.Operators[0].DecodeKYC(); kyc != "compsci" {
+		t.Errorf("Expected operator for compsci, got %s", kyc)
+	}
+
+	// The retirements came out of the custodian's account on the FA2 contract too
+	external, err := storage.GetExternalLedger(ctx, client)
+	if err != nil {
+		t.Fatalf("Failed to get external ledger: %v", err)
+	}
+	if external[TokenID{TokenID: "1", Address: fa2.Address.String()}] != 80 {
+		t.Errorf("Unexpected external ledger %v", external)
+	}
+	var fa2_storage FA2Storage
+	err = client.GetContractStorage(fa2, ctx, &fa2_storage)
+	if err != nil {
+		t.Fatalf("Failed to get FA2 storage: %v", err)
+	}
+	fa2_ledger, err := fa2_storage.GetLedger(ctx, client)
+	if err != nil {
+		t.Fatalf("Failed to get FA2 ledger: %v", err)
+	}
+	if fa2_ledger[FA2Owner{TokenOwnder: custodian.Address.String(), TokenIdentifier: "1"}] != 80 {
+		t.Errorf("Unexpected FA2 ledger %v", fa2_ledger)
+	}
+
+	retirements, err := GetCustodianRetireEvents(ctx, client, custodian)
+	if err != nil {
+		t.Fatalf("Failed to get retire events: %v", err)
+	}
+	if len(retirements) != 2 {
+		t.Fatalf("Expected 2 retirements, got %v", retirements)
+	}
+	if retirements[0].RetiringPartyKyc != "compsci" || retirements[0].Amount != "15" || retirements[0].Metadata.Note != "flights" || retirements[0].Reason != `{"version":1,"note":"flights"}` || retirements[0].RetiringParty != operator.Address.String() {
+		t.Errorf("Unexpected retirement %v", retirements[0])
+	}
+	if retirements[1].Amount != "5" || retirements[1].RetiringParty != other.Address.String() {
+		t.Errorf("Unexpected retirement %v", retirements[1])
+	}
+	fa2_retirements, err := GetFA2RetireEvents(ctx, client, fa2)
+	if err != nil {
+		t.Fatalf("Failed to get FA2 retire events: %v", err)
+	}
+	if len(fa2_retirements) != 2 || fa2_retirements[0].RetiringParty != custodian.Address.String() {
+		t.Errorf("Unexpected FA2 retirements %v", fa2_retirements)
+	}
+	transfers, err := GetInternalTransferEvents(ctx, client, custodian)
+	if err != nil {
+		t.Fatalf("Failed to get transfer events: %v", err)
+	}
+	if len(transfers) != 1 || transfers[0].From() != "self" || transfers[0].To() != "compsci" || transfers[0].Amount != "40" {
+		t.Errorf("Unexpected transfers %v", transfers)
+	}
+	mints, err := GetInternalMintEvents(ctx, client, custodian)
+	if err != nil {
+		t.Fatalf("Failed to get mint events: %v", err)
+	}
+	if len(mints) != 1 || mints[0].Amount != "100" || mints[0].NewTotal != "100" {
+		t.Errorf("Unexpected mints %v", mints)
+	}
+
+	// The ledger as it was before anything was retired
+	ledger, err := storage.GetLedgerAt(ctx, client, AtLevel(retirements[0].Level-1))
+	if err != nil {
+		t.Fatalf("Failed to get old ledger: %v", err)
+	}
+	var old_balance int64
+	for key, value := range ledger {
+		if kyc, _ := key.DecodeKYC(); kyc == "compsci" {
+			old_balance = value
+		}
+	}
+	if old_balance != 40 {
+		t.Errorf("Expected compsci to have had 40, got %d", old_balance)
+	}
+}
+
+func TestCustodianExternalTransferOnFakeChain(t *testing.T) {
+	client, custodian, fa2, operator := newFakeChain(t)
+	other, _ := tzclient.NewWalletWithAddress("other", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	ctx := context.Background()
+
+	transfer := func(signer tzclient.Wallet, token_address tzclient.Contract, amount int64) error {
+		_, err := CustodianExternalTransfer(ctx, client, custodian, signer, []CustodianExternalTransferInfo{
+			{
+				TokenAddress: token_address,
+				Batches: []CustodianExternalTransferBatch{
+					{FromKYC: "self", Txs: []CustodianExternalTransferDestination{{To: other.Address, TokenID: 1, Amount: amount}}},
+				},
+			},
+		})
+		return err
+	}
+
+	testcases := []struct {
+		Signer       tzclient.Wallet
+		Token        tzclient.Contract
+		Amount       int64
+		ExpectedName string
+	}{
+		{Signer: other, Token: fa2, Amount: 10, ExpectedName: "PERMISSIONS_DENIED"},
+		{Signer: operator, Token: fa2, Amount: 101, ExpectedName: "INSUFFICIENT_BALANCE"},
+		{Signer: operator, Token: fa2, Amount: 10},
+	}
+	for index, testcase := range testcases {
+		err := transfer(testcase.Signer, testcase.Token, testcase.Amount)
+		if testcase.ExpectedName == "" {
+			if err != nil {
+				t.Errorf("%d: Unexpected error: %v", index, err)
+			}
+			continue
+		}
+		var contract_error ContractError
+		if !errors.As(err, &contract_error) || contract_error.Name != testcase.ExpectedName {
+			t.Errorf("%d: Expected %s, got %v", index, testcase.ExpectedName, err)
+		}
+	}
+
+	var fa2_storage FA2Storage
+	err := client.GetContractStorage(fa2, ctx, &fa2_storage)
+	if err != nil {
+		t.Fatalf("Failed to get FA2 storage: %v", err)
+	}
+	fa2_ledger, err := fa2_storage.GetLedger(ctx, client)
+	if err != nil {
+		t.Fatalf("Failed to get FA2 ledger: %v", err)
+	}
+	if fa2_ledger[FA2Owner{TokenOwnder: other.Address.String(), TokenIdentifier: "1"}] != 10 || fa2_ledger[FA2Owner{TokenOwnder: custodian.Address.String(), TokenIdentifier: "1"}] != 90 {
+		t.Errorf("Unexpected FA2 ledger %v", fa2_ledger)
+	}
+	balances := custodianBalances(t, client, custodian)
+	if balances["self"] != 90 {
+		t.Errorf("Unexpected balances %v", balances)
+	}
+
+	// Minting again picks up nothing new, so there's no event
+	_, err = CustodianInternalMint(ctx, client, custodian, operator, fa2, 1)
+	if err != nil {
+		t.Fatalf("Failed to mint internally: %v", err)
+	}
+	mints, err := GetInternalMintEvents(ctx, client, custodian)
+	if err != nil || len(mints) != 1 {
+		t.Errorf("Expected just the first mint event, got %v, %v", mints, err)
+	}
+	// And the custodian can't mint from a contract that isn't an FA2 contract
+	_, err = CustodianInternalMint(ctx, client, custodian, operator, custodian, 1)
+	var contract_error ContractError
+	if !errors.As(err, &contract_error) || contract_error.Name != "CALL_VIEW_FAILED" {
+		t.Errorf("Expected CALL_VIEW_FAILED, got %v", err)
+	}
+}
+
+func TestCustodianExternalTransfer(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	token, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	alice, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	bob, _ := tezos.ParseAddress("tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+
+	testcases := []struct {
+		Transfers   []CustodianExternalTransferInfo
+		ExpectError bool
+		Expected    string
+	}{
+		{
+			Transfers: []CustodianExternalTransferInfo{
+				{
+					TokenAddress: token,
+					Batches: []CustodianExternalTransferBatch{
+						{FromKYC: "self", Txs: []CustodianExternalTransferDestination{
+							{To: alice, TokenID: 1, Amount: 10},
+							{To: bob, TokenID: 2, Amount: 20},
+						}},
+					},
+				},
+			},
+			ExpectError: false,
+			Expected: `[{"prim":"Pair","args":[{"string":"KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"},[{"prim":"Pair","args":[{"bytes":"05010000000473656c66"},[` +
+				`{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"prim":"Pair","args":[{"int":"1"},{"int":"10"}]}]},` +
+				`{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"prim":"Pair","args":[{"int":"2"},{"int":"20"}]}]}]]}]]}]`,
+		},
+		{
+			Transfers:   []CustodianExternalTransferInfo{},
+			ExpectError: true,
+		},
+		{
+			Transfers: []CustodianExternalTransferInfo{
+				{TokenAddress: token, Batches: []CustodianExternalTransferBatch{}},
+			},
+			ExpectError: true,
+		},
+		{
+			Transfers: []CustodianExternalTransferInfo{
+				{
+					TokenAddress: token,
+					Batches: []CustodianExternalTransferBatch{
+						{FromKYC: "self", Txs: []CustodianExternalTransferDestination{}},
+					},
+				},
+			},
+			ExpectError: true,
+		},
+		{
+			Transfers: []CustodianExternalTransferInfo{
+				{
+					TokenAddress: token,
+					Batches: []CustodianExternalTransferBatch{
+						{FromKYC: "self", Txs: []CustodianExternalTransferDestination{{TokenID: 1, Amount: 10}}},
+					},
+				},
+			},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err := CustodianExternalTransfer(context.Background(), client, target, signer, testcase.Transfers)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+				continue
+			}
+			checkParameters(t, index, client, "external_transfer", testcase.Expected)
+		}
+	}
+}
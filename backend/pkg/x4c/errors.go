@@ -0,0 +1,191 @@
+package x4c
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+type ContractKind int
+
+const (
+	UnknownContract ContractKind = iota
+	CustodianContract
+	FA2Contract
+)
+
+func (k ContractKind) String() string {
+	switch k {
+	case CustodianContract:
+		return "custodian"
+	case FA2Contract:
+		return "FA2"
+	default:
+		return "unknown"
+	}
+}
+
+// Error codes from custodian.mligo
+const (
+	CustodianPermissionsDenied int64 = iota
+	CustodianAddressNotFound
+	CustodianInsufficientBalance
+	CustodianCallViewFailed
+)
+
+// Error codes from fa2.mligo
+const (
+	FA2TokenUndefined int64 = iota
+	FA2InsufficientBalance
+	FA2TxDenied
+	FA2NotOwner
+	FA2NotOperator
+	FA2OperatorsUnsupported
+	FA2ReceiverHookFailed
+	FA2SenderHookFailed
+	FA2ReceiverHookUndefined
+	FA2SenderHookUndefined
+	FA2PermissionsDenied
+	FA2IDAlreadyInUse
+	FA2Collision
+)
+
+type contractErrorInfo struct {
+	Name        string
+	Description string
+}
+
+var custodianErrors = map[int64]contractErrorInfo{
+	CustodianPermissionsDenied:   {"PERMISSIONS_DENIED", "the sender is not allowed to perform this operation"},
+	CustodianAddressNotFound:     {"ADDRESS_NOT_FOUND", "the FA2 contract could not be found"},
+	CustodianInsufficientBalance: {"INSUFFICIENT_BALANCE", "the owner does not have enough tokens"},
+	CustodianCallViewFailed:      {"CALL_VIEW_FAILED", "the FA2 balance view could not be called"},
+}
+
+var fa2Errors = map[int64]contractErrorInfo{
+	FA2TokenUndefined:        {"TOKEN_UNDEFINED", "the token ID is not defined on the FA2 contract"},
+	FA2InsufficientBalance:   {"INSUFFICIENT_BALANCE", "the owner does not have enough tokens"},
+	FA2TxDenied:              {"TX_DENIED", "transfers are not permitted"},
+	FA2NotOwner:              {"NOT_OWNER", "the sender is not the token owner"},
+	FA2NotOperator:           {"NOT_OPERATOR", "the sender is neither the token owner nor an operator"},
+	FA2OperatorsUnsupported:  {"OPERATORS_UNSUPPORTED", "operators are not supported"},
+	FA2ReceiverHookFailed:    {"RECEIVER_HOOK_FAILED", "the receiver hook failed"},
+	FA2SenderHookFailed:      {"SENDER_HOOK_FAILED", "the sender hook failed"},
+	FA2ReceiverHookUndefined: {"RECEIVER_HOOK_UNDEFINED", "the receiver hook is required but not implemented"},
+	FA2SenderHookUndefined:   {"SENDER_HOOK_UNDEFINED", "the sender hook is required but not implemented"},
+	FA2PermissionsDenied:     {"PERMISSIONS_DENIED", "the sender is not allowed to perform this operation"},
+	FA2IDAlreadyInUse:        {"ID_ALREADY_IN_USE", "the token ID is already in use"},
+	FA2Collision:             {"COLLISION", "an owner can not be their own operator"},
+}
+
+func lookupContractError(kind ContractKind, code int64) (contractErrorInfo, bool) {
+	switch kind {
+	case CustodianContract:
+		info, ok := custodianErrors[code]
+		return info, ok
+	case FA2Contract:
+		info, ok := fa2Errors[code]
+		return info, ok
+	default:
+		return contractErrorInfo{}, false
+	}
+}
+
+// ErrorName returns the name used in the contract source for an error code, or false
+// if the code isn't one the contract defines.
+func ErrorName(kind ContractKind, code int64) (string, bool) {
+	info, ok := lookupContractError(kind, code)
+	return info.Name, ok
+}
+
+// ContractError is returned by the builders when a contract rejects a call. If the
+// contract failed with one of the error codes from the contract source then Name and
+// Description will be set, otherwise With holds whatever the contract failed with.
+type ContractError struct {
+	Kind        ContractKind
+	Contract    tezos.Address
+	Code        int64
+	Name        string
+	Description string
+	With        micheline.Prim
+
+	err error
+}
+
+func (e ContractError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("%s contract %s failed with %s (%d): %s", e.Kind, e.Contract, e.Name, e.Code, e.Description)
+	}
+	return fmt.Sprintf("%s contract %s failed with %s", e.Kind, e.Contract, e.With.Dump())
+}
+
+func (e ContractError) Unwrap() error {
+	return e.err
+}
+
+// Works out what sort of contract raised a rejection. If it wasn't the contract
+// we called then it was one called internally, and the only contracts the custodian
+// calls are FA2 contracts.
+func rejectingContractKind(kind ContractKind, target tezos.Address, rejection tzclient.RejectedError) ContractKind {
+	if !rejection.Contract.IsValid() || rejection.Contract.Equal(target) {
+		return kind
+	}
+	if kind == CustodianContract {
+		return FA2Contract
+	}
+	return UnknownContract
+}
+
+func newContractError(kind ContractKind, target tezos.Address, rejection tzclient.RejectedError) ContractError {
+	result := ContractError{
+		Kind:     rejectingContractKind(kind, target, rejection),
+		Contract: rejection.Contract,
+		Code:     -1,
+		With:     rejection.With,
+		err:      rejection,
+	}
+	if !result.Contract.IsValid() {
+		result.Contract = target
+	}
+	if rejection.With.Type == micheline.PrimInt && rejection.With.Int != nil && rejection.With.Int.IsInt64() {
+		code := rejection.With.Int.Int64()
+		if info, ok := lookupContractError(result.Kind, code); ok {
+			result.Code = code
+			result.Name = info.Name
+			result.Description = info.Description
+		}
+	}
+	return result
+}
+
+// Turns a rejection from the client into a ContractError, leaving any other error
+// untouched.
+func wrapContractError(err error, kind ContractKind, target tezos.Address) error {
+	var rejection tzclient.RejectedError
+	if errors.As(err, &rejection) {
+		return newContractError(kind, target, rejection)
+	}
+	return err
+}
+
+// All the builders call the contract via this so that contract failures are returned
+// as ContractErrors.
+func callContract(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	kind ContractKind,
+	signer tzclient.Wallet,
+	target tzclient.Contract,
+	parameters micheline.Parameters,
+) (string, error) {
+	operation_hash, err := client.CallContract(ctx, signer, target, parameters)
+	if err != nil {
+		return "", wrapContractError(err, kind, target.Address)
+	}
+	return operation_hash, nil
+}
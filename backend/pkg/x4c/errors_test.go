@@ -0,0 +1,176 @@
+package x4c
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+func TestErrorName(t *testing.T) {
+	testcases := []struct {
+		Kind     ContractKind
+		Code     int64
+		Expected string
+		OK       bool
+	}{
+		{Kind: CustodianContract, Code: 0, Expected: "PERMISSIONS_DENIED", OK: true},
+		{Kind: CustodianContract, Code: 2, Expected: "INSUFFICIENT_BALANCE", OK: true},
+		{Kind: CustodianContract, Code: 4, OK: false},
+		{Kind: FA2Contract, Code: 2, Expected: "TX_DENIED", OK: true},
+		{Kind: FA2Contract, Code: 12, Expected: "COLLISION", OK: true},
+		{Kind: FA2Contract, Code: 13, OK: false},
+		{Kind: FA2Contract, Code: -1, OK: false},
+		{Kind: UnknownContract, Code: 0, OK: false},
+	}
+
+	for index, testcase := range testcases {
+		name, ok := ErrorName(testcase.Kind, testcase.Code)
+		if ok != testcase.OK {
+			t.Errorf("%d: Expected ok %v, got %v", index, testcase.OK, ok)
+		}
+		if name != testcase.Expected {
+			t.Errorf("%d: Expected %s, got %s", index, testcase.Expected, name)
+		}
+	}
+}
+
+func TestCallContractRejection(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	custodian, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	testcases := []struct {
+		Kind         ContractKind
+		Target       tzclient.Contract
+		Failed       tezos.Address
+		With         micheline.Prim
+		ExpectKind   ContractKind
+		ExpectTarget tezos.Address
+		ExpectCode   int64
+		ExpectName   string
+	}{
+		{
+			Kind:         CustodianContract,
+			Target:       custodian,
+			Failed:       custodian.Address,
+			With:         micheline.NewInt64(2),
+			ExpectKind:   CustodianContract,
+			ExpectTarget: custodian.Address,
+			ExpectCode:   CustodianInsufficientBalance,
+			ExpectName:   "INSUFFICIENT_BALANCE",
+		},
+		{
+			// The custodian called the FA2 which then failed
+			Kind:         CustodianContract,
+			Target:       custodian,
+			Failed:       fa2.Address,
+			With:         micheline.NewInt64(2),
+			ExpectKind:   FA2Contract,
+			ExpectTarget: fa2.Address,
+			ExpectCode:   FA2TxDenied,
+			ExpectName:   "TX_DENIED",
+		},
+		{
+			// If the node didn't tell us who failed, assume it was the target
+			Kind:         FA2Contract,
+			Target:       fa2,
+			Failed:       tezos.Address{},
+			With:         micheline.NewNat(big.NewInt(11)),
+			ExpectKind:   FA2Contract,
+			ExpectTarget: fa2.Address,
+			ExpectCode:   FA2IDAlreadyInUse,
+			ExpectName:   "ID_ALREADY_IN_USE",
+		},
+		{
+			Kind:         FA2Contract,
+			Target:       fa2,
+			Failed:       fa2.Address,
+			With:         micheline.NewString("oops"),
+			ExpectKind:   FA2Contract,
+			ExpectTarget: fa2.Address,
+			ExpectCode:   -1,
+			ExpectName:   "",
+		},
+		{
+			// The FA2 only calls out to balance_of callbacks, which we know nothing about
+			Kind:         FA2Contract,
+			Target:       fa2,
+			Failed:       custodian.Address,
+			With:         micheline.NewInt64(1),
+			ExpectKind:   UnknownContract,
+			ExpectTarget: custodian.Address,
+			ExpectCode:   -1,
+			ExpectName:   "",
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.CallError = fmt.Errorf("wrapped: %w", tzclient.RejectedError{
+			Contract: testcase.Failed,
+			With:     testcase.With,
+		})
+		_, err := callContract(context.Background(), client, testcase.Kind, signer, testcase.Target, micheline.Parameters{})
+		var contract_error ContractError
+		if !errors.As(err, &contract_error) {
+			t.Errorf("%d: Expected contract error, got %v", index, err)
+			continue
+		}
+		if contract_error.Kind != testcase.ExpectKind {
+			t.Errorf("%d: Expected kind %v, got %v", index, testcase.ExpectKind, contract_error.Kind)
+		}
+		if !contract_error.Contract.Equal(testcase.ExpectTarget) {
+			t.Errorf("%d: Expected contract %v, got %v", index, testcase.ExpectTarget, contract_error.Contract)
+		}
+		if contract_error.Code != testcase.ExpectCode {
+			t.Errorf("%d: Expected code %d, got %d", index, testcase.ExpectCode, contract_error.Code)
+		}
+		if contract_error.Name != testcase.ExpectName {
+			t.Errorf("%d: Expected name %s, got %s", index, testcase.ExpectName, contract_error.Name)
+		}
+		if (testcase.ExpectName != "") && !strings.Contains(err.Error(), testcase.ExpectName) {
+			t.Errorf("%d: Expected message to contain name, got %s", index, err.Error())
+		}
+		var rejection tzclient.RejectedError
+		if !errors.As(err, &rejection) {
+			t.Errorf("%d: Expected to be able to unwrap rejection", index)
+		}
+	}
+
+	// Other errors are passed through untouched
+	client := tzclient.NewMockClient()
+	client.ShouldError = true
+	_, err := callContract(context.Background(), client, FA2Contract, signer, fa2, micheline.Parameters{})
+	var contract_error ContractError
+	if (err == nil) || errors.As(err, &contract_error) {
+		t.Errorf("Expected plain error, got %v", err)
+	}
+}
+
+func TestBuilderReturnsContractError(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	custodian, _ := tzclient.NewContractWithAddress("custodian", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	fa2, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	client := tzclient.NewMockClient()
+	client.CallError = tzclient.RejectedError{
+		Contract: custodian.Address,
+		With:     micheline.NewInt64(CustodianInsufficientBalance),
+	}
+	_, err := CustodianRetire(context.Background(), client, custodian, signer, fa2, 1, "compsci", 10, NoteRetirementMetadata(""))
+	var contract_error ContractError
+	if !errors.As(err, &contract_error) {
+		t.Fatalf("Expected contract error, got %v", err)
+	}
+	if (contract_error.Kind != CustodianContract) || (contract_error.Code != CustodianInsufficientBalance) {
+		t.Errorf("Got unexpected contract error %v", contract_error)
+	}
+}
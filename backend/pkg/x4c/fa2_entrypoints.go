@@ -2,7 +2,9 @@ package x4c
 
 import (
 	"context"
+	"fmt"
 	"math/big"
+	"sort"
 
 	"blockwatch.cc/tzgo/micheline"
 	"blockwatch.cc/tzgo/tezos"
@@ -10,39 +12,129 @@ import (
 	"quantify.earth/x4c/pkg/tzclient"
 )
 
-func FA2AddToken(
+type FA2TokenInfo struct {
+	TokenID int64
+	Info    map[string][]byte
+}
+
+func FA2AddTokens(
 	ctx context.Context,
 	client tzclient.TezosClient,
 	target tzclient.Contract,
 	oracle tzclient.Wallet,
-	token_id int64,
-	title string,
-	url string,
+	token_list []FA2TokenInfo,
 ) (string, error) {
-	bigToken := big.NewInt(token_id)
+	if len(token_list) == 0 {
+		return "", fmt.Errorf("no tokens specified")
+	}
+
+	tokens := make([]micheline.Prim, 0, len(token_list))
+	for _, token := range token_list {
+		tokens = append(tokens, micheline.NewPair(
+			micheline.NewNat(big.NewInt(token.TokenID)),
+			newBytesMap(token.Info),
+		))
+	}
 
 	// Michelson type:
 	// (list %add_token_id (pair (nat %token_id) (map %token_info string bytes)))
 	parameters := micheline.Parameters{
 		Entrypoint: "add_token_id",
-		Value: micheline.NewSeq(
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: tokens,
+		},
+	}
+
+	return callContract(ctx, client, FA2Contract, oracle, target, parameters)
+}
+
+func FA2AddToken(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	oracle tzclient.Wallet,
+	token_id int64,
+	title string,
+	url string,
+) (string, error) {
+	token_list := []FA2TokenInfo{
+		{
+			TokenID: token_id,
+			Info: map[string][]byte{
+				"title": []byte(title),
+				"url":   []byte(url),
+			},
+		},
+	}
+	return FA2AddTokens(ctx, client, target, oracle, token_list)
+}
+
+// FA2AddProject adds a token ID for a project, with the project's metadata as the
+// token info.
+func FA2AddProject(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	oracle tzclient.Wallet,
+	token_id int64,
+	project ProjectMetadata,
+) (string, error) {
+	info, err := project.Encode()
+	if err != nil {
+		return "", fmt.Errorf("invalid project metadata: %w", err)
+	}
+	token_list := []FA2TokenInfo{
+		{
+			TokenID: token_id,
+			Info:    info,
+		},
+	}
+	return FA2AddTokens(ctx, client, target, oracle, token_list)
+}
+
+type FA2MintInfo struct {
+	Owner   tezos.Address
+	TokenID int64
+	Amount  int64
+}
+
+func FA2MintBatch(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	oracle tzclient.Wallet,
+	mint_list []FA2MintInfo,
+) (string, error) {
+	if len(mint_list) == 0 {
+		return "", fmt.Errorf("no mints specified")
+	}
+
+	mints := make([]micheline.Prim, 0, len(mint_list))
+	for index, mint := range mint_list {
+		if !mint.Owner.IsValid() {
+			return "", fmt.Errorf("mint %d has invalid owner address", index)
+		}
+		mints = append(mints, micheline.NewPair(
 			micheline.NewPair(
-				micheline.NewNat(bigToken),
-				micheline.NewSeq(
-					micheline.Prim{Type: micheline.PrimBinary, OpCode: micheline.D_ELT, Args: []micheline.Prim{
-						micheline.NewString("title"),
-						micheline.NewBytes([]byte(title)),
-					}},
-					micheline.Prim{Type: micheline.PrimBinary, OpCode: micheline.D_ELT, Args: []micheline.Prim{
-						micheline.NewString("url"),
-						micheline.NewBytes([]byte(url)),
-					}},
-				),
+				micheline.NewString(mint.Owner.String()),
+				micheline.NewNat(big.NewInt(mint.Amount)),
 			),
-		),
+			micheline.NewNat(big.NewInt(mint.TokenID)),
+		))
+	}
+
+	// Michelson type:
+	// (list %mint (pair (pair (address %owner) (nat %qty)) (nat %token_id)))
+	parameters := micheline.Parameters{
+		Entrypoint: "mint",
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: mints,
+		},
 	}
 
-	return client.CallContract(ctx, oracle, target, parameters)
+	return callContract(ctx, client, FA2Contract, oracle, target, parameters)
 }
 
 func FA2Mint(
@@ -54,23 +146,339 @@ func FA2Mint(
 	token_owner tezos.Address,
 	amount int64,
 ) (string, error) {
-	bigAmount := big.NewInt(amount)
-	bigToken := big.NewInt(token_id)
+	mint_list := []FA2MintInfo{
+		{
+			Owner:   token_owner,
+			TokenID: token_id,
+			Amount:  amount,
+		},
+	}
+	return FA2MintBatch(ctx, client, target, oracle, mint_list)
+}
+
+type FA2TransferDestination struct {
+	To      tezos.Address
+	TokenID int64
+	Amount  int64
+}
+
+type FA2TransferInfo struct {
+	From tezos.Address
+	Txs  []FA2TransferDestination
+}
+
+func FA2Transfer(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	transfer_list []FA2TransferInfo,
+) (string, error) {
+	if len(transfer_list) == 0 {
+		return "", fmt.Errorf("no transfers specified")
+	}
+
+	transfers := make([]micheline.Prim, 0, len(transfer_list))
+	for index, transfer := range transfer_list {
+		if !transfer.From.IsValid() {
+			return "", fmt.Errorf("transfer %d has invalid source address", index)
+		}
+		if len(transfer.Txs) == 0 {
+			return "", fmt.Errorf("transfer %d has no destinations", index)
+		}
+		txs := make([]micheline.Prim, 0, len(transfer.Txs))
+		for _, tx := range transfer.Txs {
+			if !tx.To.IsValid() {
+				return "", fmt.Errorf("transfer %d has invalid destination address", index)
+			}
+			txs = append(txs, micheline.NewPair(
+				micheline.NewString(tx.To.String()),
+				micheline.NewPair(
+					micheline.NewNat(big.NewInt(tx.TokenID)),
+					micheline.NewNat(big.NewInt(tx.Amount)),
+				),
+			))
+		}
+		transfers = append(transfers, micheline.NewPair(
+			micheline.NewString(transfer.From.String()),
+			micheline.Prim{
+				Type: micheline.PrimSequence,
+				Args: txs,
+			},
+		))
+	}
 
 	// Michelson type:
-	// (list %mint (pair (pair (address %owner) (nat %qty)) (nat %token_id)))
+	// (list %transfer
+	// 	(pair
+	// 		(address %from_)
+	// 		(list %txs
+	// 			(pair
+	// 				(address %to_)
+	// 				(pair
+	// 					(nat %token_id)
+	// 					(nat %amount)
+	// 				)
+	// 			)
+	// 		)
+	// 	)
+	// )
 	parameters := micheline.Parameters{
-		Entrypoint: "mint",
-		Value: micheline.NewSeq(
+		Entrypoint: "transfer",
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: transfers,
+		},
+	}
+
+	return callContract(ctx, client, FA2Contract, signer, target, parameters)
+}
+
+type FA2RetireInfo struct {
+	RetiringParty tezos.Address
+	TokenID       int64
+	Amount        int64
+	Metadata      RetirementMetadata
+}
+
+func FA2Retire(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	retire_list []FA2RetireInfo,
+) (string, error) {
+	if len(retire_list) == 0 {
+		return "", fmt.Errorf("no retirements specified")
+	}
+
+	retirements := make([]micheline.Prim, 0, len(retire_list))
+	for index, retirement := range retire_list {
+		if !retirement.RetiringParty.IsValid() {
+			return "", fmt.Errorf("retirement %d has invalid retiring party address", index)
+		}
+		retiring_data, err := retirement.Metadata.Encode()
+		if err != nil {
+			return "", fmt.Errorf("retirement %d has invalid metadata: %w", index, err)
+		}
+		retirements = append(retirements, micheline.NewPair(
+			micheline.NewPair(
+				micheline.NewNat(big.NewInt(retirement.Amount)),
+				micheline.NewBytes(retiring_data),
+			),
 			micheline.NewPair(
+				micheline.NewString(retirement.RetiringParty.String()),
+				micheline.NewNat(big.NewInt(retirement.TokenID)),
+			),
+		))
+	}
+
+	// Michelson type:
+	// (list %retire (pair (pair (nat %amount) (bytes %retiring_data))
+	//                     (pair (address %retiring_party) (nat %token_id))))
+	parameters := micheline.Parameters{
+		Entrypoint: "retire",
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: retirements,
+		},
+	}
+
+	return callContract(ctx, client, FA2Contract, signer, target, parameters)
+}
+
+type FA2OperatorUpdateInfo struct {
+	Owner      tezos.Address
+	Operator   tezos.Address
+	TokenID    int64
+	UpdateType int
+}
+
+func FA2UpdateOperators(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	update_list []FA2OperatorUpdateInfo,
+) (string, error) {
+	if len(update_list) == 0 {
+		return "", fmt.Errorf("no operator updates specified")
+	}
+
+	operator_list := make([]micheline.Prim, 0, len(update_list))
+	for index, operator := range update_list {
+		var update_type micheline.OpCode
+		switch operator.UpdateType {
+		case AddOperator:
+			update_type = micheline.D_LEFT
+		case RemoveOperator:
+			update_type = micheline.D_RIGHT
+		default:
+			return "", fmt.Errorf("update %d had unexpected update type %d", index, operator.UpdateType)
+		}
+		if !operator.Owner.IsValid() || !operator.Operator.IsValid() {
+			return "", fmt.Errorf("update %d has invalid owner or operator address", index)
+		}
+		update := micheline.NewCode(
+			update_type,
+			micheline.NewPair(
+				micheline.NewString(operator.Owner.String()),
 				micheline.NewPair(
-					micheline.NewString(token_owner.String()),
-					micheline.NewNat(bigAmount),
+					micheline.NewString(operator.Operator.String()),
+					micheline.NewNat(big.NewInt(operator.TokenID)),
 				),
-				micheline.NewNat(bigToken),
 			),
+		)
+		operator_list = append(operator_list, update)
+	}
+
+	// Michelson type:
+	// (list %update_operators (or
+	//                          (pair %add_operator (address %owner)
+	//                                              (pair (address %operator)
+	//                                                    (nat %token_id)))
+	//                          (pair %remove_operator (address %owner)
+	//                                                 (pair (address %operator)
+	//                                                       (nat %token_id)))))
+	parameters := micheline.Parameters{
+		Entrypoint: "update_operators",
+		Value: micheline.Prim{
+			Type: micheline.PrimSequence,
+			Args: operator_list,
+		},
+	}
+
+	return callContract(ctx, client, FA2Contract, signer, target, parameters)
+}
+
+type FA2BalanceRequest struct {
+	Owner   tezos.Address
+	TokenID int64
+}
+
+// The FA2 contract will call back the callback contract with the balances, so
+// this does not return the balances directly. If you just want to know the
+// balance, use the ledger in FA2Storage instead.
+func FA2BalanceOf(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	signer tzclient.Wallet,
+	request_list []FA2BalanceRequest,
+	callback tzclient.Contract,
+	callback_entrypoint string,
+) (string, error) {
+	if len(request_list) == 0 {
+		return "", fmt.Errorf("no balance requests specified")
+	}
+
+	requests := make([]micheline.Prim, 0, len(request_list))
+	for index, request := range request_list {
+		if !request.Owner.IsValid() {
+			return "", fmt.Errorf("request %d has invalid owner address", index)
+		}
+		requests = append(requests, micheline.NewPair(
+			micheline.NewString(request.Owner.String()),
+			micheline.NewNat(big.NewInt(request.TokenID)),
+		))
+	}
+
+	callback_address := callback.Address.String()
+	if callback_entrypoint != "" {
+		callback_address = fmt.Sprintf("%s%%%s", callback_address, callback_entrypoint)
+	}
+
+	// Michelson type:
+	// (pair %balance_of
+	// 	(list %requests (pair (address %token_owner) (nat %token_id)))
+	// 	(contract %callback
+	// 		(list (pair (pair %request (address %token_owner) (nat %token_id)) (nat %balance)))
+	// 	)
+	// )
+	parameters := micheline.Parameters{
+		Entrypoint: "balance_of",
+		Value: micheline.NewPair(
+			micheline.Prim{
+				Type: micheline.PrimSequence,
+				Args: requests,
+			},
+			micheline.NewString(callback_address),
 		),
 	}
 
-	return client.CallContract(ctx, oracle, target, parameters)
+	return callContract(ctx, client, FA2Contract, signer, target, parameters)
+}
+
+// Note that this replaces the entire metadata big map on the contract, rather than
+// updating the specified keys.
+func FA2UpdateContractMetadata(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	oracle tzclient.Wallet,
+	metadata map[string][]byte,
+) (string, error) {
+	// Michelson type:
+	// (big_map %update_contract_metadata string bytes)
+	parameters := micheline.Parameters{
+		Entrypoint: "update_contract_metadata",
+		Value:      newBytesMap(metadata),
+	}
+
+	return callContract(ctx, client, FA2Contract, oracle, target, parameters)
+}
+
+// Michelson requires map literals to have their keys in order, so we can't
+// just range over the go map.
+func newBytesMap(values map[string][]byte) micheline.Prim {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	elements := make([]micheline.Prim, 0, len(keys))
+	for _, key := range keys {
+		elements = append(elements, micheline.NewMapElem(
+			micheline.NewString(key),
+			micheline.NewBytes(values[key]),
+		))
+	}
+	return micheline.Prim{
+		Type: micheline.PrimSequence,
+		Args: elements,
+	}
+}
+
+func FA2UpdateOracle(
+	ctx context.Context,
+	client tzclient.TezosClient,
+	target tzclient.Contract,
+	oracle tzclient.Wallet,
+	new_oracle tezos.Address,
+) (string, error) {
+	err := validateAdminAddress(new_oracle)
+	if err != nil {
+		return "", fmt.Errorf("new oracle is not valid: %w", err)
+	}
+
+	// Only the current oracle can hand over the contract, so rather than
+	// pay to find that out on chain, check first
+	var storage FA2Storage
+	err = client.GetContractStorage(target, ctx, &storage)
+	if err != nil {
+		return "", fmt.Errorf("failed to get contract storage: %w", err)
+	}
+	if storage.Oracle != oracle.Address.String() {
+		return "", fmt.Errorf("signer %s is not the current oracle %s", oracle.Address, storage.Oracle)
+	}
+
+	// Michelson type:
+	// (address %update_oracle)
+	parameters := micheline.Parameters{
+		Entrypoint: "update_oracle",
+		Value:      micheline.NewString(new_oracle.String()),
+	}
+
+	return callContract(ctx, client, FA2Contract, oracle, target, parameters)
 }
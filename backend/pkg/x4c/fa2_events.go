@@ -11,37 +11,44 @@ import (
 
 type FA2RetireEvent struct {
 	tzkt.Event
-	RetiringParty       string      `json:"retiring_party"`
-	TokenID             json.Number `json:"tokenId"`
-	Amount              json.Number `json:"amount"`
-	Reason              string      `json:"retiring_data"`
+	RetiringParty string      `json:"retiring_party"`
+	TokenID       json.Number `json:"tokenId"`
+	Amount        json.Number `json:"amount"`
+	// The retiring data as text, with what it holds decoded into Metadata
+	Reason   string             `json:"retiring_data"`
+	Metadata RetirementMetadata `json:"metadata"`
 }
 
 func GetFA2RetireEvents(ctx context.Context, client tzclient.TezosClient, contract tzclient.Contract) ([]FA2RetireEvent, error) {
-	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "retire")
+	raw, err := client.GetContractEvents(ctx, contract.Address.String(), "retire", tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find retire events: %w", err)
 	}
 
 	result := make([]FA2RetireEvent, len(raw))
 	for idx, event := range raw {
-		typedEvent := FA2RetireEvent {
-			event,
-			"",
-			"0",
-			"0",
-			"",
-		}
-		err = json.Unmarshal(event.Payload, &typedEvent)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshall payload: %w", err)
-		}
-		reason, err := tzclient.MichelsonToString(typedEvent.Reason)
+		result[idx], err = decodeFA2RetireEvent(event)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshall event reason: %w", err)
+			return nil, err
 		}
-		typedEvent.Reason = reason
-		result[idx] = typedEvent
 	}
 	return result, nil
 }
+
+func decodeFA2RetireEvent(event tzkt.Event) (FA2RetireEvent, error) {
+	typedEvent := FA2RetireEvent{
+		event,
+		"",
+		"0",
+		"0",
+		"",
+		RetirementMetadata{},
+	}
+	err := json.Unmarshal(event.Payload, &typedEvent)
+	if err != nil {
+		return FA2RetireEvent{}, fmt.Errorf("failed to unmarshall payload: %w", err)
+	}
+	typedEvent.Metadata = decodeRetiringData(typedEvent.Reason)
+	typedEvent.Reason = decodeRetiringText(typedEvent.Reason)
+	return typedEvent, nil
+}
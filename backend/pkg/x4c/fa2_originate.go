@@ -2,6 +2,7 @@ package x4c
 
 import (
 	"context"
+	"fmt"
 
 	"blockwatch.cc/tzgo/micheline"
 	"blockwatch.cc/tzgo/tezos"
@@ -30,6 +31,9 @@ func FA2Originate(
 	)
 
 	res, err := client.Originate(ctx, signer, contractBytes, storage)
+	if err != nil {
+		return res, fmt.Errorf("failed to originate FA2 contract: %w", err)
+	}
 
-	return res, err
+	return res, nil
 }
@@ -6,12 +6,13 @@ import (
 	"fmt"
 
 	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
 )
 
 type FA2Operator struct {
-	TokenOwnder     string `json:"token_owner"`
-	TokenOperator   string `json:"token_operator"`
-	TokenIdentifier int64  `json:"token_id"`
+	TokenOwnder     string      `json:"token_owner"`
+	TokenOperator   string      `json:"token_operator"`
+	TokenIdentifier json.Number `json:"token_id"`
 }
 
 type FA2Owner struct {
@@ -25,9 +26,14 @@ type FA2Ledger map[FA2Owner]int64
 // only ever put strings in there, so this simplifies things for us
 type FA2Metadata map[string]string
 
+// TokenInformation holds the raw hex values from the chain, and Project the same
+// decoded. If some of the values couldn't be decoded then Project only has the ones
+// that could and ProjectError says what was wrong with the rest.
 type FA2TokenMetadata struct {
 	TokenIdentifier  json.Number       `json:"token_id"`
 	TokenInformation map[string]string `json:"token_info"`
+	Project          ProjectMetadata   `json:"-"`
+	ProjectError     error             `json:"-"`
 }
 
 type FA2TokenMetadataMap map[int64]FA2TokenMetadata
@@ -40,12 +46,7 @@ type FA2Storage struct {
 	Metadata      int64         `json:"metadata"`
 }
 
-func (storage *FA2Storage) GetLedger(ctx context.Context, client tzclient.TezosClient) (FA2Ledger, error) {
-	bigmap, err := client.GetBigMapContents(ctx, storage.Ledger)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ledger big map: %w", err)
-	}
-
+func decodeFA2Ledger(bigmap []tzkt.BigMapItem) (FA2Ledger, error) {
 	result := make(FA2Ledger)
 	for _, item := range bigmap {
 		if !item.Active {
@@ -71,8 +72,31 @@ func (storage *FA2Storage) GetLedger(ctx context.Context, client tzclient.TezosC
 	return result, nil
 }
 
+func (storage *FA2Storage) GetLedger(ctx context.Context, client tzclient.TezosClient) (FA2Ledger, error) {
+	bigmap, err := client.GetBigMapContents(ctx, storage.Ledger, tzkt.QueryOptions{ActiveOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger big map: %w", err)
+	}
+	return decodeFA2Ledger(bigmap)
+}
+
+// GetLedgerAt returns the ledger as it was at the given moment. The storage itself
+// should be current, as the ledger big map doesn't change once the contract is
+// originated.
+func (storage *FA2Storage) GetLedgerAt(ctx context.Context, client tzclient.TezosClient, at Moment) (FA2Ledger, error) {
+	level, err := at.ResolveLevel(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	bigmap, err := client.GetBigMapContentsAt(ctx, storage.Ledger, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger big map at level %d: %w", level, err)
+	}
+	return decodeFA2Ledger(bigmap)
+}
+
 func (storage *FA2Storage) GetFA2Metadata(ctx context.Context, client tzclient.TezosClient) (FA2Metadata, error) {
-	bigmap, err := client.GetBigMapContents(ctx, storage.Metadata)
+	bigmap, err := client.GetBigMapContents(ctx, storage.Metadata, tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get custodian metadata big map: %w", err)
 	}
@@ -96,7 +120,7 @@ func (storage *FA2Storage) GetFA2Metadata(ctx context.Context, client tzclient.T
 }
 
 func (storage *FA2Storage) GetTokenMetadata(ctx context.Context, client tzclient.TezosClient) (FA2TokenMetadataMap, error) {
-	bigmap, err := client.GetBigMapContents(ctx, storage.TokenMetadata)
+	bigmap, err := client.GetBigMapContents(ctx, storage.TokenMetadata, tzkt.QueryOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get ledger big map: %w", err)
 	}
@@ -122,6 +146,9 @@ func (storage *FA2Storage) GetTokenMetadata(ctx context.Context, client tzclient
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode ledger value %v: %w", item.Value, err)
 		}
+		// Tokens can be given any token_info, so one that doesn't fit the schema
+		// shouldn't stop us reading the others
+		value.Project, value.ProjectError = DecodeProjectMetadata(value.TokenInformation)
 		result[key] = value
 	}
 
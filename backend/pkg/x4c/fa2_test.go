@@ -0,0 +1,558 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+func TestUpdateOracle(t *testing.T) {
+	oracle, _ := tzclient.NewWalletWithAddress("oracle", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	testcases := []struct {
+		CurrentOracle string
+		NewOracle     string
+		ExpectError   bool
+	}{
+		{
+			CurrentOracle: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewOracle:     "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5",
+			ExpectError:   false,
+		},
+		{
+			CurrentOracle: "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5",
+			NewOracle:     "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			ExpectError:   true,
+		},
+		{
+			CurrentOracle: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewOracle:     "tz3LRNhdn2ZwyH7255tuZhQWXw8uFiXNJRVw",
+			ExpectError:   false,
+		},
+		{
+			CurrentOracle: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewOracle:     "tz496afrNbzJu2jtMFwkELNm5WPumbzCEh2S",
+			ExpectError:   false,
+		},
+		{
+			CurrentOracle: "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq",
+			NewOracle:     "invalid",
+			ExpectError:   true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.Storage = &FA2Storage{
+			Oracle: testcase.CurrentOracle,
+		}
+		new_oracle, _ := tezos.ParseAddress(testcase.NewOracle)
+
+		ctx := context.Background()
+		_, err := FA2UpdateOracle(ctx, client, target, oracle, new_oracle)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			}
+		}
+	}
+}
+
+// Wraps the mock client to capture the parameters each builder generates.
+type recordingClient struct {
+	tzclient.MockClient
+	Parameters []micheline.Parameters
+}
+
+func (c *recordingClient) CallContract(ctx context.Context, signedBy tzclient.Wallet, target tzclient.Contract, parameters micheline.Parameters) (string, error) {
+	c.Parameters = append(c.Parameters, parameters)
+	return c.MockClient.CallContract(ctx, signedBy, target, parameters)
+}
+
+func checkParameters(t *testing.T, index int, client *recordingClient, entrypoint string, expected string) {
+	if len(client.Parameters) != 1 {
+		t.Errorf("%d: Expected one contract call, got %d", index, len(client.Parameters))
+		return
+	}
+	parameters := client.Parameters[0]
+	if parameters.Entrypoint != entrypoint {
+		t.Errorf("%d: Expected entrypoint %s, got %s", index, entrypoint, parameters.Entrypoint)
+	}
+	value, err := json.Marshal(parameters.Value)
+	if err != nil {
+		t.Fatalf("%d: Failed to encode parameters: %v", index, err)
+	}
+	if string(value) != expected {
+		t.Errorf("%d: Unexpected parameters:\n\tgot      %s\n\texpected %s", index, string(value), expected)
+	}
+}
+
+func TestFA2Transfer(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	alice, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	bob, _ := tezos.ParseAddress("tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+
+	testcases := []struct {
+		Transfers   []FA2TransferInfo
+		ExpectError bool
+		Expected    string
+	}{
+		{
+			Transfers: []FA2TransferInfo{
+				{
+					From: alice,
+					Txs: []FA2TransferDestination{
+						{To: bob, TokenID: 1, Amount: 10},
+						{To: bob, TokenID: 2, Amount: 20},
+					},
+				},
+				{
+					From: bob,
+					Txs: []FA2TransferDestination{
+						{To: alice, TokenID: 3, Amount: 30},
+					},
+				},
+			},
+			ExpectError: false,
+			Expected: `[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},[` +
+				`{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"prim":"Pair","args":[{"int":"1"},{"int":"10"}]}]},` +
+				`{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"prim":"Pair","args":[{"int":"2"},{"int":"20"}]}]}]]},` +
+				`{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},[` +
+				`{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"prim":"Pair","args":[{"int":"3"},{"int":"30"}]}]}]]}]`,
+		},
+		{
+			Transfers:   []FA2TransferInfo{},
+			ExpectError: true,
+		},
+		{
+			Transfers: []FA2TransferInfo{
+				{From: alice, Txs: []FA2TransferDestination{}},
+			},
+			ExpectError: true,
+		},
+		{
+			Transfers: []FA2TransferInfo{
+				{From: alice, Txs: []FA2TransferDestination{{TokenID: 1, Amount: 10}}},
+			},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err := FA2Transfer(context.Background(), client, target, signer, testcase.Transfers)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+			if len(client.Parameters) != 0 {
+				t.Errorf("%d: Unexpected contract call on error", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+				continue
+			}
+			checkParameters(t, index, client, "transfer", testcase.Expected)
+		}
+	}
+}
+
+func TestFA2Retire(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	alice, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	bob, _ := tezos.ParseAddress("tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+
+	testcases := []struct {
+		Retirements []FA2RetireInfo
+		ExpectError bool
+		Expected    string
+	}{
+		{
+			Retirements: []FA2RetireInfo{
+				{RetiringParty: alice, TokenID: 1, Amount: 10, Metadata: NoteRetirementMetadata("hi")},
+				{RetiringParty: bob, TokenID: 2, Amount: 20},
+			},
+			ExpectError: false,
+			Expected: `[{"prim":"Pair","args":[{"prim":"Pair","args":[{"int":"10"},{"bytes":"0501000000197b2276657273696f6e223a312c226e6f7465223a226869227d"}]},{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"int":"1"}]}]},` +
+				`{"prim":"Pair","args":[{"prim":"Pair","args":[{"int":"20"},{"bytes":"05010000000d7b2276657273696f6e223a317d"}]},{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"int":"2"}]}]}]`,
+		},
+		{
+			Retirements: []FA2RetireInfo{},
+			ExpectError: true,
+		},
+		{
+			Retirements: []FA2RetireInfo{{TokenID: 1, Amount: 10}},
+			ExpectError: true,
+		},
+		{
+			Retirements: []FA2RetireInfo{
+				{RetiringParty: alice, TokenID: 1, Amount: 10, Metadata: RetirementMetadata{Version: 2}},
+			},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err := FA2Retire(context.Background(), client, target, signer, testcase.Retirements)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+				continue
+			}
+			checkParameters(t, index, client, "retire", testcase.Expected)
+		}
+	}
+}
+
+func TestFA2UpdateOperators(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	alice, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	bob, _ := tezos.ParseAddress("tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	carol, _ := tezos.ParseAddress("tz28QZkJtASQaeeieppeZjx8iaFPUtPpBrZd")
+	dave, _ := tezos.ParseAddress("tz3LRNhdn2ZwyH7255tuZhQWXw8uFiXNJRVw")
+	erin, _ := tezos.ParseAddress("tz496afrNbzJu2jtMFwkELNm5WPumbzCEh2S")
+
+	testcases := []struct {
+		Updates     []FA2OperatorUpdateInfo
+		ExpectError bool
+		Expected    string
+	}{
+		{
+			Updates: []FA2OperatorUpdateInfo{
+				{Owner: alice, Operator: bob, TokenID: 1, UpdateType: AddOperator},
+				{Owner: alice, Operator: bob, TokenID: 2, UpdateType: RemoveOperator},
+			},
+			ExpectError: false,
+			Expected: `[{"prim":"Left","args":[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"int":"1"}]}]}]},` +
+				`{"prim":"Right","args":[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"int":"2"}]}]}]}]`,
+		},
+		{
+			// Owners and operators can be any kind of implicit account
+			Updates: []FA2OperatorUpdateInfo{
+				{Owner: carol, Operator: dave, TokenID: 1, UpdateType: AddOperator},
+				{Owner: dave, Operator: erin, TokenID: 1, UpdateType: AddOperator},
+			},
+			ExpectError: false,
+			Expected: `[{"prim":"Left","args":[{"prim":"Pair","args":[{"string":"tz28QZkJtASQaeeieppeZjx8iaFPUtPpBrZd"},{"prim":"Pair","args":[{"string":"tz3LRNhdn2ZwyH7255tuZhQWXw8uFiXNJRVw"},{"int":"1"}]}]}]},` +
+				`{"prim":"Left","args":[{"prim":"Pair","args":[{"string":"tz3LRNhdn2ZwyH7255tuZhQWXw8uFiXNJRVw"},{"prim":"Pair","args":[{"string":"tz496afrNbzJu2jtMFwkELNm5WPumbzCEh2S"},{"int":"1"}]}]}]}]`,
+		},
+		{
+			Updates:     []FA2OperatorUpdateInfo{},
+			ExpectError: true,
+		},
+		{
+			Updates: []FA2OperatorUpdateInfo{
+				{Owner: alice, Operator: bob, TokenID: 1, UpdateType: 42},
+			},
+			ExpectError: true,
+		},
+		{
+			Updates: []FA2OperatorUpdateInfo{
+				{Owner: alice, TokenID: 1, UpdateType: AddOperator},
+			},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err := FA2UpdateOperators(context.Background(), client, target, signer, testcase.Updates)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+				continue
+			}
+			checkParameters(t, index, client, "update_operators", testcase.Expected)
+		}
+	}
+}
+
+func TestFA2BalanceOf(t *testing.T) {
+	signer, _ := tzclient.NewWalletWithAddress("signer", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	callback, _ := tzclient.NewContractWithAddress("callback", "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")
+	alice, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	bob, _ := tezos.ParseAddress("tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+
+	testcases := []struct {
+		Requests    []FA2BalanceRequest
+		Entrypoint  string
+		ExpectError bool
+		Expected    string
+	}{
+		{
+			Requests: []FA2BalanceRequest{
+				{Owner: alice, TokenID: 1},
+				{Owner: bob, TokenID: 2},
+			},
+			Entrypoint:  "",
+			ExpectError: false,
+			Expected: `{"prim":"Pair","args":[[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"int":"1"}]},` +
+				`{"prim":"Pair","args":[{"string":"tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},{"int":"2"}]}],` +
+				`{"string":"KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"}]}`,
+		},
+		{
+			Requests: []FA2BalanceRequest{
+				{Owner: alice, TokenID: 1},
+			},
+			Entrypoint:  "receive",
+			ExpectError: false,
+			Expected: `{"prim":"Pair","args":[[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"int":"1"}]}],` +
+				`{"string":"KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm%receive"}]}`,
+		},
+		{
+			Requests:    []FA2BalanceRequest{},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err := FA2BalanceOf(context.Background(), client, target, signer, testcase.Requests, callback, testcase.Entrypoint)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("Got unexpected error on test case %d: %v", index, err)
+				continue
+			}
+			checkParameters(t, index, client, "balance_of", testcase.Expected)
+		}
+	}
+}
+
+func TestFA2UpdateContractMetadata(t *testing.T) {
+	oracle, _ := tzclient.NewWalletWithAddress("oracle", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+
+	testcases := []struct {
+		Metadata map[string][]byte
+		Expected string
+	}{
+		{
+			Metadata: map[string][]byte{
+				"name": []byte("x4c"),
+				"":     []byte("hi"),
+				"b":    []byte{},
+			},
+			Expected: `[{"prim":"Elt","args":[{"string":""},{"bytes":"6869"}]},` +
+				`{"prim":"Elt","args":[{"string":"b"},{"bytes":""}]},` +
+				`{"prim":"Elt","args":[{"string":"name"},{"bytes":"783463"}]}]`,
+		},
+		{
+			Metadata: map[string][]byte{},
+			Expected: `[]`,
+		},
+	}
+
+	for index, testcase := range testcases {
+		client := &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err := FA2UpdateContractMetadata(context.Background(), client, target, oracle, testcase.Metadata)
+		if err != nil {
+			t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			continue
+		}
+		checkParameters(t, index, client, "update_contract_metadata", testcase.Expected)
+	}
+}
+
+func TestFA2AddTokensAndMintBatch(t *testing.T) {
+	oracle, _ := tzclient.NewWalletWithAddress("oracle", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	alice, _ := tezos.ParseAddress("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	ctx := context.Background()
+
+	client := &recordingClient{MockClient: tzclient.NewMockClient()}
+	_, err := FA2AddToken(ctx, client, target, oracle, 1, "title", "url")
+	if err != nil {
+		t.Fatalf("Unexpected error adding token: %v", err)
+	}
+	checkParameters(t, 0, client, "add_token_id",
+		`[{"prim":"Pair","args":[{"int":"1"},[{"prim":"Elt","args":[{"string":"title"},{"bytes":"7469746c65"}]},{"prim":"Elt","args":[{"string":"url"},{"bytes":"75726c"}]}]]}]`)
+
+	client = &recordingClient{MockClient: tzclient.NewMockClient()}
+	_, err = FA2AddProject(ctx, client, target, oracle, 2, ProjectMetadata{Name: "p", ExternalURI: "u"})
+	if err != nil {
+		t.Fatalf("Unexpected error adding project: %v", err)
+	}
+	checkParameters(t, 0, client, "add_token_id",
+		`[{"prim":"Pair","args":[{"int":"2"},[{"prim":"Elt","args":[{"string":"decimals"},{"bytes":"30"}]},{"prim":"Elt","args":[{"string":"externalUri"},{"bytes":"75"}]},`+
+			`{"prim":"Elt","args":[{"string":"name"},{"bytes":"70"}]},{"prim":"Elt","args":[{"string":"title"},{"bytes":"70"}]},{"prim":"Elt","args":[{"string":"url"},{"bytes":"75"}]}]]}]`)
+
+	_, err = FA2AddProject(ctx, client, target, oracle, 3, ProjectMetadata{})
+	if err == nil {
+		t.Errorf("Expected error adding project with no name")
+	}
+
+	client = &recordingClient{MockClient: tzclient.NewMockClient()}
+	_, err = FA2MintBatch(ctx, client, target, oracle, []FA2MintInfo{
+		{Owner: alice, TokenID: 1, Amount: 10},
+		{Owner: alice, TokenID: 2, Amount: 20},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error minting: %v", err)
+	}
+	checkParameters(t, 1, client, "mint",
+		`[{"prim":"Pair","args":[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"int":"10"}]},{"int":"1"}]},`+
+			`{"prim":"Pair","args":[{"prim":"Pair","args":[{"string":"tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq"},{"int":"20"}]},{"int":"2"}]}]`)
+
+	// Tokens can be minted to, and by, any kind of implicit account
+	for index, address := range []string{"tz28QZkJtASQaeeieppeZjx8iaFPUtPpBrZd", "tz3LRNhdn2ZwyH7255tuZhQWXw8uFiXNJRVw", "tz496afrNbzJu2jtMFwkELNm5WPumbzCEh2S"} {
+		owner, _ := tezos.ParseAddress(address)
+		curve_oracle, err := tzclient.NewWalletWithAddress("oracle", address)
+		if err != nil {
+			t.Fatalf("%d: Unexpected error making oracle: %v", index, err)
+		}
+		client = &recordingClient{MockClient: tzclient.NewMockClient()}
+		_, err = FA2Mint(ctx, client, target, curve_oracle, 1, owner, 5)
+		if err != nil {
+			t.Fatalf("%d: Unexpected error minting: %v", index, err)
+		}
+		checkParameters(t, index, client, "mint",
+			`[{"prim":"Pair","args":[{"prim":"Pair","args":[{"string":"`+address+`"},{"int":"5"}]},{"int":"1"}]}]`)
+	}
+}
+
+func TestFA2OnFakeChain(t *testing.T) {
+	oracle, _ := tzclient.NewWalletWithAddress("oracle", "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq")
+	alice, _ := tzclient.NewWalletWithAddress("alice", "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5")
+	bob, _ := tzclient.NewWalletWithAddress("bob", "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB")
+	target, _ := tzclient.NewContractWithAddress("fa2", "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR")
+	ctx := context.Background()
+
+	client := tzclient.NewFakeClient()
+	client.AddFA2Contract(target, oracle.Address)
+
+	expectFailure := func(index int, err error, name string) {
+		var contract_error ContractError
+		if !errors.As(err, &contract_error) {
+			t.Errorf("%d: Expected contract error %s, got %v", index, name, err)
+		} else if contract_error.Kind != FA2Contract || contract_error.Name != name {
+			t.Errorf("%d: Expected %s, got %v", index, name, contract_error)
+		}
+	}
+
+	_, err := FA2Mint(ctx, client, target, oracle, 1, alice.Address, 10)
+	expectFailure(0, err, "TOKEN_UNDEFINED")
+	_, err = FA2AddProject(ctx, client, target, oracle, 1, ProjectMetadata{Name: "Forest project", Vintage: 2021})
+	if err != nil {
+		t.Fatalf("Failed to add project: %v", err)
+	}
+	_, err = FA2AddToken(ctx, client, target, oracle, 1, "again", "url")
+	expectFailure(1, err, "ID_ALREADY_IN_USE")
+	_, err = FA2AddToken(ctx, client, target, alice, 2, "title", "url")
+	expectFailure(2, err, "PERMISSIONS_DENIED")
+	_, err = FA2Mint(ctx, client, target, alice, 1, alice.Address, 10)
+	expectFailure(3, err, "PERMISSIONS_DENIED")
+	_, err = FA2Mint(ctx, client, target, oracle, 1, alice.Address, 10)
+	if err != nil {
+		t.Fatalf("Failed to mint: %v", err)
+	}
+
+	transfer := []FA2TransferInfo{{From: alice.Address, Txs: []FA2TransferDestination{{To: bob.Address, TokenID: 1, Amount: 4}}}}
+	_, err = FA2Transfer(ctx, client, target, bob, transfer)
+	expectFailure(4, err, "PERMISSIONS_DENIED")
+	_, err = FA2UpdateOperators(ctx, client, target, alice, []FA2OperatorUpdateInfo{
+		{Owner: alice.Address, Operator: alice.Address, TokenID: 1, UpdateType: AddOperator},
+	})
+	expectFailure(5, err, "COLLISION")
+	_, err = FA2UpdateOperators(ctx, client, target, alice, []FA2OperatorUpdateInfo{
+		{Owner: alice.Address, Operator: bob.Address, TokenID: 1, UpdateType: AddOperator},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add operator: %v", err)
+	}
+	_, err = FA2Transfer(ctx, client, target, bob, transfer)
+	if err != nil {
+		t.Fatalf("Failed to transfer as operator: %v", err)
+	}
+	_, err = FA2Retire(ctx, client, target, alice, []FA2RetireInfo{
+		{RetiringParty: alice.Address, TokenID: 1, Amount: 7, Metadata: NoteRetirementMetadata("too much")},
+	})
+	expectFailure(6, err, "INSUFFICIENT_BALANCE")
+	_, err = FA2Retire(ctx, client, target, alice, []FA2RetireInfo{
+		{RetiringParty: alice.Address, TokenID: 1, Amount: 6, Metadata: NoteRetirementMetadata("flights")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to retire: %v", err)
+	}
+
+	var storage FA2Storage
+	err = client.GetContractStorage(target, ctx, &storage)
+	if err != nil {
+		t.Fatalf("Failed to get storage: %v", err)
+	}
+	if len(storage.Operators) != 1 || storage.Operators[0].TokenOperator != bob.Address.String() || storage.Operators[0].TokenIdentifier != "1" {
+		t.Errorf("Unexpected operators %v", storage.Operators)
+	}
+	ledger, err := storage.GetLedger(ctx, client)
+	if err != nil {
+		t.Fatalf("Failed to get ledger: %v", err)
+	}
+	// Alice's balance went to zero so she is no longer in the ledger
+	expected := FA2Ledger{{TokenOwnder: bob.Address.String(), TokenIdentifier: "1"}: 4}
+	if !reflect.DeepEqual(ledger, expected) {
+		t.Errorf("Expected ledger %v, got %v", expected, ledger)
+	}
+	tokens, err := storage.GetTokenMetadata(ctx, client)
+	if err != nil {
+		t.Fatalf("Failed to get token metadata: %v", err)
+	}
+	if len(tokens) != 1 || tokens[1].Project.Name != "Forest project" || tokens[1].Project.Vintage != 2021 {
+		t.Errorf("Unexpected token metadata %v", tokens)
+	}
+	retirements, err := GetFA2RetireEvents(ctx, client, target)
+	if err != nil {
+		t.Fatalf("Failed to get retire events: %v", err)
+	}
+	if len(retirements) != 1 || retirements[0].RetiringParty != alice.Address.String() || retirements[0].Amount != "6" || retirements[0].Metadata.Note != "flights" || retirements[0].Reason != `{"version":1,"note":"flights"}` {
+		t.Errorf("Unexpected retirements %v", retirements)
+	}
+
+	_, err = FA2UpdateContractMetadata(ctx, client, target, oracle, map[string][]byte{"name": []byte("x4c")})
+	if err != nil {
+		t.Fatalf("Failed to update metadata: %v", err)
+	}
+	_, err = FA2UpdateOracle(ctx, client, target, oracle, alice.Address)
+	if err != nil {
+		t.Fatalf("Failed to update oracle: %v", err)
+	}
+	err = client.GetContractStorage(target, ctx, &storage)
+	if err != nil {
+		t.Fatalf("Failed to get storage: %v", err)
+	}
+	if storage.Oracle != alice.Address.String() {
+		t.Errorf("Expected alice to be oracle, got %s", storage.Oracle)
+	}
+	metadata, err := storage.GetFA2Metadata(ctx, client)
+	if err != nil || metadata["name"] != "783463" {
+		t.Errorf("Unexpected metadata %v, %v", metadata, err)
+	}
+}
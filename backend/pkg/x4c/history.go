@@ -0,0 +1,75 @@
+package x4c
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// Moment is a point in the chain's history at which to read state, given either as a
+// block level or as a time. If it is a time then the state is that at the end of the
+// last block made at or before it.
+type Moment struct {
+	Level int32
+	Time  time.Time
+}
+
+func AtLevel(level int32) Moment {
+	return Moment{Level: level}
+}
+
+func AtTime(at time.Time) Moment {
+	return Moment{Time: at}
+}
+
+// ParseMoment accepts a block level, an RFC 3339 time, or a date. A date means the end
+// of that day in UTC, so "2023-03-31" gives what was held on the 31st of March.
+func ParseMoment(value string) (Moment, error) {
+	if level, err := strconv.ParseInt(value, 10, 32); err == nil {
+		if level < 1 {
+			return Moment{}, fmt.Errorf("level must be positive")
+		}
+		return AtLevel(int32(level)), nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return AtTime(at), nil
+	}
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return AtTime(day.Add(24*time.Hour - time.Second)), nil
+	}
+	return Moment{}, fmt.Errorf("%q is not a level, RFC 3339 time, or YYYY-MM-DD date", value)
+}
+
+// ParseStartMoment is like ParseMoment, except that a date means the start of that day
+// rather than the end, which is what is wanted for the start of a period.
+func ParseStartMoment(value string) (Moment, error) {
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return AtTime(day.Add(-time.Second)), nil
+	}
+	return ParseMoment(value)
+}
+
+func (m Moment) String() string {
+	if m.Level != 0 {
+		return fmt.Sprintf("level %d", m.Level)
+	}
+	return m.Time.UTC().Format(time.RFC3339)
+}
+
+// ResolveLevel works out the block level the moment refers to.
+func (m Moment) ResolveLevel(ctx context.Context, client tzclient.TezosClient) (int32, error) {
+	if m.Level != 0 {
+		return m.Level, nil
+	}
+	if m.Time.IsZero() {
+		return 0, fmt.Errorf("moment has neither a level nor a time")
+	}
+	level, err := client.GetLevelAtTime(ctx, m.Time)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find level at %v: %w", m.Time, err)
+	}
+	return level, nil
+}
@@ -0,0 +1,127 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func TestParseMoment(t *testing.T) {
+	testcases := []struct {
+		Value       string
+		Expected    Moment
+		ExpectError bool
+	}{
+		{
+			Value:    "1234",
+			Expected: AtLevel(1234),
+		},
+		{
+			Value:    "2023-03-31T12:00:00Z",
+			Expected: AtTime(time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)),
+		},
+		{
+			Value:    "2023-03-31",
+			Expected: AtTime(time.Date(2023, 3, 31, 23, 59, 59, 0, time.UTC)),
+		},
+		{
+			Value:       "0",
+			ExpectError: true,
+		},
+		{
+			Value:       "31 March",
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		moment, err := ParseMoment(testcase.Value)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %v", index, moment)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if moment.Level != testcase.Expected.Level || !moment.Time.Equal(testcase.Expected.Time) {
+			t.Errorf("%d: Expected %v, got %v", index, testcase.Expected, moment)
+		}
+	}
+}
+
+func TestGetLedgerAt(t *testing.T) {
+	key := json.RawMessage(`{"token": {"token_id": 42, "token_address": "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB"}, "kyc": "0501000000096f74686572206f7267"}`)
+	client := tzclient.NewMockClient()
+	client.AddHistoricalBigMap(314, 100, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`"10"`)}})
+	client.AddHistoricalBigMap(314, 200, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`"4"`)}})
+	client.Blocks = []tzkt.Block{
+		{Level: 100, Timestamp: time.Date(2023, 3, 30, 12, 0, 0, 0, time.UTC)},
+		{Level: 200, Timestamp: time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)},
+	}
+
+	testcases := []struct {
+		At          Moment
+		Expected    int64
+		ExpectError bool
+	}{
+		{
+			At:       AtLevel(150),
+			Expected: 10,
+		},
+		{
+			At:       AtLevel(200),
+			Expected: 4,
+		},
+		{
+			At:       AtTime(time.Date(2023, 3, 31, 23, 59, 59, 0, time.UTC)),
+			Expected: 10,
+		},
+		{
+			// Before there were any blocks
+			At:          AtTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+			ExpectError: true,
+		},
+		{
+			At:          Moment{},
+			ExpectError: true,
+		},
+	}
+
+	ctx := context.Background()
+	for index, testcase := range testcases {
+		storage := CustodianStorage{Ledger: 314}
+		ledger, err := storage.GetLedgerAt(ctx, client, testcase.At)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %v", index, ledger)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if len(ledger) != 1 {
+			t.Errorf("%d: Expected one item, got %v", index, ledger)
+			continue
+		}
+		for _, value := range ledger {
+			if value != testcase.Expected {
+				t.Errorf("%d: Expected %d, got %d", index, testcase.Expected, value)
+			}
+		}
+
+		fa2_storage := FA2Storage{Ledger: 315}
+		_, err = fa2_storage.GetLedgerAt(ctx, client, testcase.At)
+		if err != nil {
+			t.Errorf("%d: Unexpected error for FA2 ledger: %v", index, err)
+		}
+	}
+}
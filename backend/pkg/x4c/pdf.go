@@ -0,0 +1,106 @@
+package x4c
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// We only need to produce plain documents such as statements, so rather than pull in
+// a PDF library this writes pages of fixed width text in one of the standard fonts,
+// which every PDF reader has built in.
+
+const (
+	pdfPageWidth    = 595 // A4, in points
+	pdfPageHeight   = 842
+	pdfMargin       = 50
+	pdfFontSize     = 9
+	pdfLineHeight   = 12
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+
+	// Every glyph in Courier is 3/5 of the font size wide
+	pdfCharsPerLine = (pdfPageWidth - 2*pdfMargin) * 5 / (3 * pdfFontSize)
+)
+
+func escapePDFText(line string) string {
+	var builder strings.Builder
+	for _, r := range line {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			builder.WriteRune('\\')
+			builder.WriteRune(r)
+		case r < 32 || r > 126:
+			// The standard fonts only cover ASCII without an encoding table
+			builder.WriteRune('?')
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// Splits lines that won't fit across the page.
+func wrapPDFLines(lines []string) []string {
+	wrapped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		runes := []rune(line)
+		for len(runes) > pdfCharsPerLine {
+			wrapped = append(wrapped, string(runes[:pdfCharsPerLine]))
+			runes = runes[pdfCharsPerLine:]
+		}
+		wrapped = append(wrapped, string(runes))
+	}
+	return wrapped
+}
+
+func writeTextPDF(w io.Writer, lines []string) error {
+	lines = wrapPDFLines(lines)
+	pages := make([][]string, 0)
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects are numbered from one: the catalog, the page tree, the font, and then a
+	// page and its contents for each page
+	objects := make([]string, 3, 3+2*len(pages))
+	kids := make([]string, len(pages))
+	for index, page := range pages {
+		page_object := 4 + 2*index
+		kids[index] = fmt.Sprintf("%d 0 R", page_object)
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", escapePDFText(line))
+		}
+		content.WriteString("ET\n")
+
+		objects = append(objects, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, page_object+1))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+	objects[2] = "<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>"
+
+	var document bytes.Buffer
+	document.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for index, object := range objects {
+		offsets[index] = document.Len()
+		fmt.Fprintf(&document, "%d 0 obj\n%s\nendobj\n", index+1, object)
+	}
+	xref_offset := document.Len()
+	fmt.Fprintf(&document, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&document, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&document, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref_offset)
+
+	_, err := w.Write(document.Bytes())
+	return err
+}
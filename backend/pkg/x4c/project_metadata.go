@@ -0,0 +1,269 @@
+package x4c
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coordinates give the location of a project as a point, in degrees.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// TokenAttribute is a TZIP-21 attribute, for anything about the project that doesn't
+// have a field of its own.
+type TokenAttribute struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+// ProjectMetadata describes the project behind a token ID. Each field is stored under
+// its own key in the token_info map, using the TZIP-21 names where TZIP-21 has one.
+// The name and external URI are also written as title and url, which is all that
+// tokens added before there was a schema have, so that older readers still work.
+type ProjectMetadata struct {
+	// TZIP-21 fields
+	Name         string           `json:"name"`
+	Symbol       string           `json:"symbol,omitempty"`
+	Decimals     int              `json:"decimals"`
+	Description  string           `json:"description,omitempty"`
+	Creators     []string         `json:"creators,omitempty"`
+	Tags         []string         `json:"tags,omitempty"`
+	ExternalURI  string           `json:"externalUri,omitempty"`
+	DisplayURI   string           `json:"displayUri,omitempty"`
+	ThumbnailURI string           `json:"thumbnailUri,omitempty"`
+	Attributes   []TokenAttribute `json:"attributes,omitempty"`
+
+	// 4C project fields
+	ProjectType string       `json:"projectType,omitempty"`
+	Coordinates *Coordinates `json:"coordinates,omitempty"`
+	// The project's boundary as a GeoJSON geometry or feature
+	GeoJSON    json.RawMessage `json:"geoJson,omitempty"`
+	Vintage    int             `json:"vintage,omitempty"`
+	RegistryID string          `json:"registryId,omitempty"`
+	// How many years the carbon is expected to stay sequestered
+	PermanenceYears int `json:"permanenceYears,omitempty"`
+	// The PACT pricing factor, where zero means it hasn't been set
+	PACTFactor float64 `json:"pactFactor,omitempty"`
+}
+
+// Validate checks the metadata makes sense before it is written to the chain.
+func (p ProjectMetadata) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("project has no name")
+	}
+	if p.Decimals < 0 {
+		return fmt.Errorf("decimals must not be negative")
+	}
+	if p.Coordinates != nil {
+		if p.Coordinates.Latitude < -90 || p.Coordinates.Latitude > 90 {
+			return fmt.Errorf("latitude %v is out of range", p.Coordinates.Latitude)
+		}
+		if p.Coordinates.Longitude < -180 || p.Coordinates.Longitude > 180 {
+			return fmt.Errorf("longitude %v is out of range", p.Coordinates.Longitude)
+		}
+	}
+	if len(p.GeoJSON) > 0 {
+		var geometry struct {
+			Type string `json:"type"`
+		}
+		err := json.Unmarshal(p.GeoJSON, &geometry)
+		if err != nil || geometry.Type == "" {
+			return fmt.Errorf("geoJson must be a GeoJSON object with a type")
+		}
+	}
+	if p.Vintage != 0 && (p.Vintage < 1900 || p.Vintage > 9999) {
+		return fmt.Errorf("vintage %d is not a year", p.Vintage)
+	}
+	if p.PermanenceYears < 0 {
+		return fmt.Errorf("permanence must not be negative")
+	}
+	if p.PACTFactor < 0 {
+		return fmt.Errorf("PACT factor must not be negative")
+	}
+	for index, attribute := range p.Attributes {
+		if attribute.Name == "" {
+			return fmt.Errorf("attribute %d has no name", index)
+		}
+	}
+	return nil
+}
+
+// Encode validates the metadata and returns the token_info map for it. Strings are
+// stored as their bytes, numbers as their decimal text, and lists and objects as JSON,
+// as TZIP-21 asks.
+func (p ProjectMetadata) Encode() (map[string][]byte, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string][]byte{
+		"name":     []byte(p.Name),
+		"title":    []byte(p.Name),
+		"decimals": []byte(strconv.Itoa(p.Decimals)),
+	}
+	text_fields := map[string]string{
+		"symbol":       p.Symbol,
+		"description":  p.Description,
+		"externalUri":  p.ExternalURI,
+		"url":          p.ExternalURI,
+		"displayUri":   p.DisplayURI,
+		"thumbnailUri": p.ThumbnailURI,
+		"projectType":  p.ProjectType,
+		"registryId":   p.RegistryID,
+	}
+	for key, value := range text_fields {
+		if value != "" {
+			info[key] = []byte(value)
+		}
+	}
+	if p.Vintage != 0 {
+		info["vintage"] = []byte(strconv.Itoa(p.Vintage))
+	}
+	if p.PermanenceYears != 0 {
+		info["permanenceYears"] = []byte(strconv.Itoa(p.PermanenceYears))
+	}
+	if p.PACTFactor != 0 {
+		info["pactFactor"] = []byte(strconv.FormatFloat(p.PACTFactor, 'f', -1, 64))
+	}
+	if len(p.GeoJSON) > 0 {
+		info["geoJson"] = p.GeoJSON
+	}
+	documents := map[string]interface{}{
+		"creators":    p.Creators,
+		"tags":        p.Tags,
+		"attributes":  p.Attributes,
+		"coordinates": p.Coordinates,
+	}
+	for key, value := range documents {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		if string(data) != "null" {
+			info[key] = data
+		}
+	}
+	return info, nil
+}
+
+// LoadProjectMetadata reads and validates project metadata from a JSON file. Unknown
+// fields are refused, as they are most likely misspellings that would otherwise be
+// silently left off the chain.
+func LoadProjectMetadata(path string) (ProjectMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ProjectMetadata{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var project ProjectMetadata
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&project)
+	if err != nil {
+		return ProjectMetadata{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	err = project.Validate()
+	if err != nil {
+		return ProjectMetadata{}, err
+	}
+	return project, nil
+}
+
+// DecodeProjectMetadata reads the token_info map as tzkt gives it to us, with the
+// values hex encoded. Tokens added before there was a schema only have a title and
+// url, which become the name and external URI. As add_token_id takes any token_info,
+// fields that can't be decoded are left empty and the rest of the project is still
+// returned, along with an error listing what was wrong.
+func DecodeProjectMetadata(token_info map[string]string) (ProjectMetadata, error) {
+	info := make(map[string]string, len(token_info))
+	for key, value := range token_info {
+		info[key] = decodeTokenInformation(value)
+	}
+
+	project := ProjectMetadata{
+		Name:         info["name"],
+		Symbol:       info["symbol"],
+		Description:  info["description"],
+		ExternalURI:  info["externalUri"],
+		DisplayURI:   info["displayUri"],
+		ThumbnailURI: info["thumbnailUri"],
+		ProjectType:  info["projectType"],
+		RegistryID:   info["registryId"],
+	}
+	if project.Name == "" {
+		project.Name = info["title"]
+	}
+	if project.ExternalURI == "" {
+		project.ExternalURI = info["url"]
+	}
+
+	problems := make([]string, 0)
+	numbers := map[string]*int{
+		"decimals":        &project.Decimals,
+		"vintage":         &project.Vintage,
+		"permanenceYears": &project.PermanenceYears,
+	}
+	for key, field := range numbers {
+		value, ok := info[key]
+		if !ok {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s %q", key, value))
+			continue
+		}
+		*field = number
+	}
+	if value, ok := info["pactFactor"]; ok {
+		factor, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pactFactor %q", value))
+		} else {
+			project.PACTFactor = factor
+		}
+	}
+	if value, ok := info["geoJson"]; ok {
+		project.GeoJSON = json.RawMessage(value)
+	}
+	documents := map[string]interface{}{
+		"creators":    &project.Creators,
+		"tags":        &project.Tags,
+		"attributes":  &project.Attributes,
+		"coordinates": &project.Coordinates,
+	}
+	for key, field := range documents {
+		value, ok := info[key]
+		if !ok {
+			continue
+		}
+		err := json.Unmarshal([]byte(value), field)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %v", key, err))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return project, fmt.Errorf("failed to decode project metadata: %s", strings.Join(problems, ", "))
+	}
+	return project, nil
+}
+
+// Token metadata values are bytes on chain, which tzkt gives us as hex.
+func decodeTokenInformation(value string) string {
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	return string(decoded)
+}
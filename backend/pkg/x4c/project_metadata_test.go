@@ -0,0 +1,218 @@
+package x4c
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func hexTokenInfo(info map[string][]byte) map[string]string {
+	result := make(map[string]string, len(info))
+	for key, value := range info {
+		result[key] = hex.EncodeToString(value)
+	}
+	return result
+}
+
+func TestProjectMetadataRoundTrip(t *testing.T) {
+	testcases := []ProjectMetadata{
+		{
+			Name: "Forest project",
+		},
+		{
+			Name:            "Forest project",
+			Symbol:          "FOREST",
+			Decimals:        3,
+			Description:     "Avoided deforestation",
+			Creators:        []string{"Quantify Earth"},
+			Tags:            []string{"forest", "redd+"},
+			ExternalURI:     "https://example.com/forest",
+			Attributes:      []TokenAttribute{{Name: "country", Value: "Peru"}},
+			ProjectType:     "REDD+",
+			Coordinates:     &Coordinates{Latitude: -12.5, Longitude: -69.25},
+			GeoJSON:         json.RawMessage(`{"type":"Point","coordinates":[-69.25,-12.5]}`),
+			Vintage:         2021,
+			RegistryID:      "VCS-1234",
+			PermanenceYears: 40,
+			PACTFactor:      0.85,
+		},
+	}
+
+	for index, testcase := range testcases {
+		info, err := testcase.Encode()
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if string(info["title"]) != testcase.Name || string(info["url"]) != testcase.ExternalURI {
+			t.Errorf("%d: Expected legacy title and url, got %v", index, info)
+		}
+		decoded, err := DecodeProjectMetadata(hexTokenInfo(info))
+		if err != nil {
+			t.Errorf("%d: Unexpected decode error: %v", index, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, testcase) {
+			t.Errorf("%d: Expected %v, got %v", index, testcase, decoded)
+		}
+	}
+}
+
+func TestDecodeLegacyTokenInfo(t *testing.T) {
+	project, err := DecodeProjectMetadata(hexTokenInfo(map[string][]byte{
+		"title": []byte("My project"),
+		"url":   []byte("http://project.url"),
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if project.Name != "My project" || project.ExternalURI != "http://project.url" {
+		t.Errorf("Unexpected project %v", project)
+	}
+
+	// The fields that can be decoded are still returned
+	project, err = DecodeProjectMetadata(hexTokenInfo(map[string][]byte{
+		"title":    []byte("My project"),
+		"vintage":  []byte("last year"),
+		"decimals": []byte("6"),
+		"tags":     []byte("forest"),
+	}))
+	if err == nil {
+		t.Errorf("Expected error for invalid vintage and tags")
+	} else if !strings.Contains(err.Error(), "vintage") || !strings.Contains(err.Error(), "tags") {
+		t.Errorf("Expected error to name vintage and tags, got %v", err)
+	}
+	if project.Name != "My project" || project.Decimals != 6 || project.Vintage != 0 {
+		t.Errorf("Unexpected project %v", project)
+	}
+}
+
+func TestGetTokenMetadataWithBadToken(t *testing.T) {
+	client := tzclient.NewMockClient()
+	client.AddBigMap(5, []tzkt.BigMapItem{
+		{
+			Active: true,
+			Key:    json.RawMessage(`"1"`),
+			Value:  json.RawMessage(`{"token_id": "1", "token_info": {"name": "466f72657374"}}`),
+		},
+		{
+			// A decimals of "many", added with add_token_id directly
+			Active: true,
+			Key:    json.RawMessage(`"2"`),
+			Value:  json.RawMessage(`{"token_id": "2", "token_info": {"name": "5765746c616e64", "decimals": "6d616e79"}}`),
+		},
+	})
+	storage := FA2Storage{TokenMetadata: 5}
+	tokens, err := storage.GetTokenMetadata(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("Expected both tokens, got %v", tokens)
+	}
+	if tokens[1].Project.Name != "Forest" || tokens[1].ProjectError != nil {
+		t.Errorf("Unexpected token 1 %v", tokens[1])
+	}
+	if tokens[2].Project.Name != "Wetland" || tokens[2].ProjectError == nil || tokens[2].TokenInformation["decimals"] != "6d616e79" {
+		t.Errorf("Unexpected token 2 %v", tokens[2])
+	}
+}
+
+func TestProjectMetadataValidate(t *testing.T) {
+	testcases := []struct {
+		Project     ProjectMetadata
+		ExpectError bool
+	}{
+		{
+			Project:     ProjectMetadata{Name: "Forest"},
+			ExpectError: false,
+		},
+		{
+			Project:     ProjectMetadata{},
+			ExpectError: true,
+		},
+		{
+			Project:     ProjectMetadata{Name: "Forest", Coordinates: &Coordinates{Latitude: 91}},
+			ExpectError: true,
+		},
+		{
+			Project:     ProjectMetadata{Name: "Forest", Coordinates: &Coordinates{Longitude: -181}},
+			ExpectError: true,
+		},
+		{
+			Project:     ProjectMetadata{Name: "Forest", GeoJSON: json.RawMessage(`[1, 2]`)},
+			ExpectError: true,
+		},
+		{
+			Project:     ProjectMetadata{Name: "Forest", Vintage: 21},
+			ExpectError: true,
+		},
+		{
+			Project:     ProjectMetadata{Name: "Forest", PACTFactor: -1},
+			ExpectError: true,
+		},
+		{
+			Project:     ProjectMetadata{Name: "Forest", Attributes: []TokenAttribute{{Value: "Peru"}}},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		err := testcase.Project.Validate()
+		if testcase.ExpectError && (err == nil) {
+			t.Errorf("Expected error on test case %d", index)
+		} else if !testcase.ExpectError && (err != nil) {
+			t.Errorf("Got unexpected error on test case %d: %v", index, err)
+		}
+	}
+}
+
+func TestLoadProjectMetadata(t *testing.T) {
+	dir := t.TempDir()
+	testcases := []struct {
+		Contents    string
+		ExpectError bool
+	}{
+		{
+			Contents: `{"name": "Forest", "vintage": 2021, "coordinates": {"latitude": 1, "longitude": 2}}`,
+		},
+		{
+			Contents:    `{"name": "Forest", "vintgae": 2021}`,
+			ExpectError: true,
+		},
+		{
+			Contents:    `{"vintage": 2021}`,
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		path := filepath.Join(dir, "project.json")
+		err := os.WriteFile(path, []byte(testcase.Contents), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		project, err := LoadProjectMetadata(path)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("Expected error on test case %d", index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Got unexpected error on test case %d: %v", index, err)
+			continue
+		}
+		if project.Name != "Forest" || project.Vintage != 2021 || project.Coordinates == nil {
+			t.Errorf("%d: Unexpected project %v", index, project)
+		}
+	}
+}
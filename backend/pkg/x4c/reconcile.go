@@ -0,0 +1,173 @@
+package x4c
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+type DiscrepancyKind string
+
+const (
+	// The external ledger doesn't match the sum of what the custodian has assigned to
+	// each KYC, which the contract should never allow
+	DiscrepancyInternalExternal DiscrepancyKind = "internal_external_mismatch"
+
+	// The custodian owns more on the FA2 contract than it has recorded, which is normal
+	// until someone calls internal_mint
+	DiscrepancyUnminted DiscrepancyKind = "unminted"
+
+	// The custodian has recorded more than it owns on the FA2 contract, which means
+	// credits it thinks it holds have gone
+	DiscrepancyMissing DiscrepancyKind = "missing"
+)
+
+type Discrepancy struct {
+	Token  TokenID         `json:"token"`
+	Kind   DiscrepancyKind `json:"kind"`
+	Detail string          `json:"detail"`
+}
+
+// TokenReconciliation has the three views of how much of a token the custodian holds.
+type TokenReconciliation struct {
+	Token    TokenID `json:"token"`
+	Internal int64   `json:"internal"`
+	External int64   `json:"external"`
+	FA2      int64   `json:"fa2"`
+}
+
+type ReconciliationReport struct {
+	Custodian     string                `json:"custodian"`
+	Tokens        []TokenReconciliation `json:"tokens"`
+	Discrepancies []Discrepancy         `json:"discrepancies"`
+}
+
+// Problems returns the discrepancies, leaving out unminted balances if asked to, as
+// those are expected whilst credits are waiting to be assigned.
+func (r ReconciliationReport) Problems(include_unminted bool) []Discrepancy {
+	problems := make([]Discrepancy, 0, len(r.Discrepancies))
+	for _, discrepancy := range r.Discrepancies {
+		if discrepancy.Kind == DiscrepancyUnminted && !include_unminted {
+			continue
+		}
+		problems = append(problems, discrepancy)
+	}
+	return problems
+}
+
+func compareTokenIDs(a TokenID, b TokenID) bool {
+	if a.Address != b.Address {
+		return a.Address < b.Address
+	}
+	if len(a.TokenID) != len(b.TokenID) {
+		return len(a.TokenID) < len(b.TokenID)
+	}
+	return a.TokenID < b.TokenID
+}
+
+// Reconcile checks that the custodian's internal ledger adds up to its external ledger
+// for each token, and that the external ledger matches what the custodian owns on each
+// FA2 contract. The FA2 contracts checked are those named in either ledger, along with
+// any others given, which lets you find credits sent to the custodian on contracts it
+// has never minted from.
+func Reconcile(ctx context.Context, client tzclient.TezosClient, custodian tzclient.Contract, fa2_contracts ...tzclient.Contract) (ReconciliationReport, error) {
+	var storage CustodianStorage
+	err := client.GetContractStorage(custodian, ctx, &storage)
+	if err != nil {
+		return ReconciliationReport{}, fmt.Errorf("failed to get custodian storage: %w", err)
+	}
+	ledger, err := storage.GetLedger(ctx, client)
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	external_ledger, err := storage.GetExternalLedger(ctx, client)
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+
+	tokens := make(map[TokenID]*TokenReconciliation)
+	token := func(id TokenID) *TokenReconciliation {
+		if _, ok := tokens[id]; !ok {
+			tokens[id] = &TokenReconciliation{Token: id}
+		}
+		return tokens[id]
+	}
+	for key, value := range ledger {
+		token(key.Token).Internal += value
+	}
+	for key, value := range external_ledger {
+		token(key).External = value
+	}
+
+	contracts := make(map[string]tzclient.Contract)
+	for _, contract := range fa2_contracts {
+		contracts[contract.Address.String()] = contract
+	}
+	for id := range tokens {
+		if _, ok := contracts[id.Address]; ok {
+			continue
+		}
+		contract, err := tzclient.NewContractWithAddress(id.Address, id.Address)
+		if err != nil {
+			return ReconciliationReport{}, fmt.Errorf("invalid FA2 contract address %s: %w", id.Address, err)
+		}
+		contracts[id.Address] = contract
+	}
+
+	custodian_address := custodian.Address.String()
+	for address, contract := range contracts {
+		var fa2_storage FA2Storage
+		err = client.GetContractStorage(contract, ctx, &fa2_storage)
+		if err != nil {
+			return ReconciliationReport{}, fmt.Errorf("failed to get storage for FA2 contract %s: %w", address, err)
+		}
+		fa2_ledger, err := fa2_storage.GetLedger(ctx, client)
+		if err != nil {
+			return ReconciliationReport{}, fmt.Errorf("failed to get ledger for FA2 contract %s: %w", address, err)
+		}
+		for owner, value := range fa2_ledger {
+			if owner.TokenOwnder != custodian_address {
+				continue
+			}
+			token(TokenID{TokenID: owner.TokenIdentifier, Address: address}).FA2 = value
+		}
+	}
+
+	report := ReconciliationReport{
+		Custodian:     custodian_address,
+		Tokens:        make([]TokenReconciliation, 0, len(tokens)),
+		Discrepancies: make([]Discrepancy, 0),
+	}
+	for _, token := range tokens {
+		report.Tokens = append(report.Tokens, *token)
+	}
+	sort.Slice(report.Tokens, func(i, j int) bool {
+		return compareTokenIDs(report.Tokens[i].Token, report.Tokens[j].Token)
+	})
+
+	for _, token := range report.Tokens {
+		if token.Internal != token.External {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Token:  token.Token,
+				Kind:   DiscrepancyInternalExternal,
+				Detail: fmt.Sprintf("internal ledger totals %d but external ledger has %d", token.Internal, token.External),
+			})
+		}
+		if token.FA2 > token.External {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Token:  token.Token,
+				Kind:   DiscrepancyUnminted,
+				Detail: fmt.Sprintf("%d held on the FA2 contract is awaiting internal_mint", token.FA2-token.External),
+			})
+		} else if token.FA2 < token.External {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Token:  token.Token,
+				Kind:   DiscrepancyMissing,
+				Detail: fmt.Sprintf("external ledger has %d but only %d is held on the FA2 contract", token.External, token.FA2),
+			})
+		}
+	}
+	return report, nil
+}
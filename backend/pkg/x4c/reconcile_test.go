@@ -0,0 +1,175 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+const (
+	reconcileCustodian = "KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm"
+	reconcileFA2       = "KT1MHx2nw8y2JyryGbuAvTYPNGwrfTp4PEYR"
+	reconcileOtherFA2  = "KT1Lw1p7rDaZixeX1SpmdNAueWW3QihZ31C6"
+)
+
+func reconcileLedgerItem(kyc string, amount int64) tzkt.BigMapItem {
+	return tzkt.BigMapItem{
+		Active: true,
+		Key:    json.RawMessage(fmt.Sprintf(`{"token": {"token_id": "1", "token_address": "%s"}, "kyc": "%s"}`, reconcileFA2, kyc)),
+		Value:  json.RawMessage(fmt.Sprintf(`"%d"`, amount)),
+	}
+}
+
+func reconcileFA2Item(owner string, token_id int64, amount int64) tzkt.BigMapItem {
+	return tzkt.BigMapItem{
+		Active: true,
+		Key:    json.RawMessage(fmt.Sprintf(`{"token_owner": "%s", "token_id": "%d"}`, owner, token_id)),
+		Value:  json.RawMessage(fmt.Sprintf(`"%d"`, amount)),
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	testcases := []struct {
+		Self          int64
+		Other         int64
+		External      int64
+		FA2           int64
+		OtherFA2      int64
+		ExpectedKinds []DiscrepancyKind
+	}{
+		{
+			Self:     4,
+			Other:    6,
+			External: 10,
+			FA2:      10,
+		},
+		{
+			Self:          4,
+			Other:         6,
+			External:      10,
+			FA2:           15,
+			ExpectedKinds: []DiscrepancyKind{DiscrepancyUnminted},
+		},
+		{
+			Self:          4,
+			Other:         6,
+			External:      10,
+			FA2:           7,
+			ExpectedKinds: []DiscrepancyKind{DiscrepancyMissing},
+		},
+		{
+			Self:          4,
+			Other:         5,
+			External:      10,
+			FA2:           10,
+			ExpectedKinds: []DiscrepancyKind{DiscrepancyInternalExternal},
+		},
+		{
+			// Credits on a contract the custodian has never minted from
+			Self:          4,
+			Other:         6,
+			External:      10,
+			FA2:           10,
+			OtherFA2:      3,
+			ExpectedKinds: []DiscrepancyKind{DiscrepancyUnminted},
+		},
+	}
+
+	custodian := tzclient.Contract{Address: tezos.MustParseAddress(reconcileCustodian)}
+	other_fa2 := tzclient.Contract{Address: tezos.MustParseAddress(reconcileOtherFA2)}
+	for index, testcase := range testcases {
+		client := tzclient.NewMockClient()
+		client.ContractStorage[reconcileCustodian] = &CustodianStorage{Ledger: 1, ExternalLedger: 2}
+		client.ContractStorage[reconcileFA2] = &FA2Storage{Ledger: 3}
+		client.ContractStorage[reconcileOtherFA2] = &FA2Storage{Ledger: 4}
+		client.AddBigMap(1, []tzkt.BigMapItem{
+			reconcileLedgerItem("05010000000473656c66", testcase.Self),
+			reconcileLedgerItem("0501000000096f74686572206f7267", testcase.Other),
+		})
+		client.AddBigMap(2, []tzkt.BigMapItem{{
+			Active: true,
+			Key:    json.RawMessage(fmt.Sprintf(`{"token_id": "1", "token_address": "%s"}`, reconcileFA2)),
+			Value:  json.RawMessage(fmt.Sprintf(`"%d"`, testcase.External)),
+		}})
+		client.AddBigMap(3, []tzkt.BigMapItem{
+			reconcileFA2Item(reconcileCustodian, 1, testcase.FA2),
+			reconcileFA2Item("tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", 1, 1000),
+		})
+		client.AddBigMap(4, []tzkt.BigMapItem{reconcileFA2Item(reconcileCustodian, 7, testcase.OtherFA2)})
+
+		report, err := Reconcile(context.Background(), client, custodian, other_fa2)
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if len(report.Discrepancies) != len(testcase.ExpectedKinds) {
+			t.Errorf("%d: Expected %d discrepancies, got %v", index, len(testcase.ExpectedKinds), report.Discrepancies)
+			continue
+		}
+		for discrepancy_index, discrepancy := range report.Discrepancies {
+			if discrepancy.Kind != testcase.ExpectedKinds[discrepancy_index] {
+				t.Errorf("%d: Expected %s, got %s", index, testcase.ExpectedKinds[discrepancy_index], discrepancy.Kind)
+			}
+		}
+		for _, token := range report.Tokens {
+			if token.Token.Address == reconcileFA2 && token.Internal != testcase.Self+testcase.Other {
+				t.Errorf("%d: Expected internal total %d, got %d", index, testcase.Self+testcase.Other, token.Internal)
+			}
+		}
+		if len(report.Problems(true)) != len(report.Discrepancies) {
+			t.Errorf("%d: Expected all discrepancies to be problems, got %v", index, report.Problems(true))
+		}
+		for _, problem := range report.Problems(false) {
+			if problem.Kind == DiscrepancyUnminted {
+				t.Errorf("%d: Expected unminted balances to be left out", index)
+			}
+		}
+	}
+}
+
+func TestReconcileRemovedExternalToken(t *testing.T) {
+	client := tzclient.NewMockClient()
+	client.ContractStorage[reconcileCustodian] = &CustodianStorage{Ledger: 1, ExternalLedger: 2}
+	client.ContractStorage[reconcileFA2] = &FA2Storage{Ledger: 3}
+	client.AddBigMap(1, []tzkt.BigMapItem{reconcileLedgerItem("05010000000473656c66", 10)})
+	client.AddBigMap(2, []tzkt.BigMapItem{
+		{
+			Active: true,
+			Key:    json.RawMessage(fmt.Sprintf(`{"token_id": "1", "token_address": "%s"}`, reconcileFA2)),
+			Value:  json.RawMessage(`"10"`),
+		},
+		{
+			// All of token 2 was retired, so it was removed but TzKT keeps its last value
+			Active: false,
+			Key:    json.RawMessage(fmt.Sprintf(`{"token_id": "2", "token_address": "%s"}`, reconcileFA2)),
+			Value:  json.RawMessage(`"25"`),
+		},
+	})
+	client.AddBigMap(3, []tzkt.BigMapItem{reconcileFA2Item(reconcileCustodian, 1, 10)})
+
+	custodian := tzclient.Contract{Address: tezos.MustParseAddress(reconcileCustodian)}
+	report, err := Reconcile(context.Background(), client, custodian)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(report.Discrepancies) != 0 {
+		t.Errorf("Expected no discrepancies, got %v", report.Discrepancies)
+	}
+}
+
+func TestReconcileFail(t *testing.T) {
+	client := tzclient.MockClient{
+		ShouldError: true,
+	}
+	custodian := tzclient.Contract{Address: tezos.MustParseAddress(reconcileCustodian)}
+	_, err := Reconcile(context.Background(), client, custodian)
+	if err == nil {
+		t.Errorf("Expected error")
+	}
+}
@@ -0,0 +1,235 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+// RegisteredRetirement is a retirement as listed in the public registry, from either a
+// custodian or an FA2 contract. Only custodian retirements have a KYC.
+type RegisteredRetirement struct {
+	EventID       int64              `json:"eventId"`
+	TransactionID int64              `json:"transactionId"`
+	Level         int32              `json:"level"`
+	Timestamp     time.Time          `json:"timestamp"`
+	Contract      string             `json:"contract"`
+	RetiringParty string             `json:"retiringParty"`
+	KYC           string             `json:"kyc,omitempty"`
+	Token         TokenID            `json:"token"`
+	Amount        int64              `json:"amount"`
+	Metadata      RetirementMetadata `json:"metadata"`
+}
+
+// RetirementFilter picks out retirements from the registry. The zero value matches
+// everything.
+type RetirementFilter struct {
+	TokenAddress  string
+	TokenID       *int64
+	RetiringParty string
+	KYC           string
+
+	// Only include retirements at or after From and before To, where the zero time
+	// means unbounded.
+	From time.Time
+	To   time.Time
+
+	MinAmount int64
+}
+
+func (f RetirementFilter) matches(retirement RegisteredRetirement) bool {
+	if f.TokenAddress != "" && retirement.Token.Address != f.TokenAddress {
+		return false
+	}
+	if f.TokenID != nil && retirement.Token.TokenID.String() != strconv.FormatInt(*f.TokenID, 10) {
+		return false
+	}
+	if f.RetiringParty != "" && retirement.RetiringParty != f.RetiringParty {
+		return false
+	}
+	if f.KYC != "" && retirement.KYC != f.KYC {
+		return false
+	}
+	if !f.From.IsZero() && retirement.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !retirement.Timestamp.Before(f.To) {
+		return false
+	}
+	return retirement.Amount >= f.MinAmount
+}
+
+// FilterRetirements returns the retirements that match the filter, in the order given,
+// so that a list fetched once can be filtered many times.
+func FilterRetirements(retirements []RegisteredRetirement, filter RetirementFilter) []RegisteredRetirement {
+	matching := make([]RegisteredRetirement, 0, len(retirements))
+	for _, retirement := range retirements {
+		if filter.matches(retirement) {
+			matching = append(matching, retirement)
+		}
+	}
+	return matching
+}
+
+// ListRetirements gathers the retirements made on the given contracts that match the
+// filter, newest first. The contracts can be any mix of custodian and FA2 contracts.
+func ListRetirements(ctx context.Context, client tzclient.TezosClient, contracts []tzclient.Contract, filter RetirementFilter) ([]RegisteredRetirement, error) {
+	options := tzkt.QueryOptions{
+		After:  filter.From,
+		Before: filter.To,
+	}
+
+	// As with certificates, a retirement through a custodian also causes the FA2
+	// contract to emit a retire event with the custodian as the retiring party, which
+	// we drop so that the retirement is only counted once.
+	retirements := make([]RegisteredRetirement, 0)
+	fa2_retirements := make([]RegisteredRetirement, 0)
+	custodians := make(map[string]bool)
+	for _, contract := range contracts {
+		events, err := client.GetContractEvents(ctx, contract.Address.String(), "retire", options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get retire events for %s: %w", contract.Address, err)
+		}
+		for _, event := range events {
+			var retirement RegisteredRetirement
+			var amount json.Number
+			from_custodian := isCustodianRetireEvent(event)
+			if from_custodian {
+				typed_event, err := decodeCustodianRetireEvent(event)
+				if err != nil {
+					return nil, err
+				}
+				custodians[contract.Address.String()] = true
+				retirement = RegisteredRetirement{
+					RetiringParty: typed_event.RetiringParty,
+					KYC:           typed_event.RetiringPartyKyc,
+					Token:         typed_event.Token,
+					Metadata:      typed_event.Metadata,
+				}
+				amount = typed_event.Amount
+			} else {
+				typed_event, err := decodeFA2RetireEvent(event)
+				if err != nil {
+					return nil, err
+				}
+				retirement = RegisteredRetirement{
+					RetiringParty: typed_event.RetiringParty,
+					Token:         TokenID{TokenID: typed_event.TokenID, Address: contract.Address.String()},
+					Metadata:      typed_event.Metadata,
+				}
+				amount = typed_event.Amount
+			}
+			retirement.Amount, err = amount.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount %v in event %d: %w", amount, event.Identifier, err)
+			}
+			retirement.EventID = event.Identifier
+			retirement.TransactionID = event.TransactionID
+			retirement.Level = event.Level
+			retirement.Timestamp = event.Timestamp
+			retirement.Contract = contract.Address.String()
+			if from_custodian {
+				retirements = append(retirements, retirement)
+			} else {
+				fa2_retirements = append(fa2_retirements, retirement)
+			}
+		}
+	}
+	for _, retirement := range fa2_retirements {
+		if !custodians[retirement.RetiringParty] {
+			retirements = append(retirements, retirement)
+		}
+	}
+
+	matching := FilterRetirements(retirements, filter)
+	sort.Slice(matching, func(i, j int) bool {
+		if !matching[i].Timestamp.Equal(matching[j].Timestamp) {
+			return matching[i].Timestamp.After(matching[j].Timestamp)
+		}
+		return matching[i].EventID > matching[j].EventID
+	})
+	return matching, nil
+}
+
+type TokenRetirementTotal struct {
+	Token       TokenID `json:"token"`
+	TokenTitle  string  `json:"tokenTitle,omitempty"`
+	Amount      int64   `json:"amount"`
+	Retirements int     `json:"retirements"`
+}
+
+// Retirements made without a beneficiary in their metadata, including all those made
+// before there was metadata, are totalled under an empty beneficiary.
+type BeneficiaryRetirementTotal struct {
+	Beneficiary string `json:"beneficiary"`
+	Amount      int64  `json:"amount"`
+	Retirements int    `json:"retirements"`
+}
+
+type RetirementTotals struct {
+	ByToken       []TokenRetirementTotal       `json:"byToken"`
+	ByBeneficiary []BeneficiaryRetirementTotal `json:"byBeneficiary"`
+}
+
+// TotalRetirements adds up the retirements per token, which is to say per project,
+// and per beneficiary, largest first. Tokens are given their title from the FA2
+// contract's token metadata.
+func TotalRetirements(ctx context.Context, client tzclient.TezosClient, retirements []RegisteredRetirement) (RetirementTotals, error) {
+	token_totals := make(map[TokenID]*TokenRetirementTotal)
+	beneficiary_totals := make(map[string]*BeneficiaryRetirementTotal)
+	totals := RetirementTotals{
+		ByToken:       make([]TokenRetirementTotal, 0),
+		ByBeneficiary: make([]BeneficiaryRetirementTotal, 0),
+	}
+	for _, retirement := range retirements {
+		token_total, ok := token_totals[retirement.Token]
+		if !ok {
+			token_total = &TokenRetirementTotal{Token: retirement.Token}
+			token_totals[retirement.Token] = token_total
+		}
+		token_total.Amount += retirement.Amount
+		token_total.Retirements += 1
+
+		beneficiary := retirement.Metadata.Beneficiary
+		beneficiary_total, ok := beneficiary_totals[beneficiary]
+		if !ok {
+			beneficiary_total = &BeneficiaryRetirementTotal{Beneficiary: beneficiary}
+			beneficiary_totals[beneficiary] = beneficiary_total
+		}
+		beneficiary_total.Amount += retirement.Amount
+		beneficiary_total.Retirements += 1
+	}
+
+	token_info := newTokenInfoCache(client)
+	for _, total := range token_totals {
+		title, _, err := token_info.lookup(ctx, total.Token)
+		if err != nil {
+			return RetirementTotals{}, err
+		}
+		total.TokenTitle = title
+		totals.ByToken = append(totals.ByToken, *total)
+	}
+	sort.Slice(totals.ByToken, func(i, j int) bool {
+		if totals.ByToken[i].Amount != totals.ByToken[j].Amount {
+			return totals.ByToken[i].Amount > totals.ByToken[j].Amount
+		}
+		return compareTokenIDs(totals.ByToken[i].Token, totals.ByToken[j].Token)
+	})
+
+	for _, total := range beneficiary_totals {
+		totals.ByBeneficiary = append(totals.ByBeneficiary, *total)
+	}
+	sort.Slice(totals.ByBeneficiary, func(i, j int) bool {
+		if totals.ByBeneficiary[i].Amount != totals.ByBeneficiary[j].Amount {
+			return totals.ByBeneficiary[i].Amount > totals.ByBeneficiary[j].Amount
+		}
+		return totals.ByBeneficiary[i].Beneficiary < totals.ByBeneficiary[j].Beneficiary
+	})
+	return totals, nil
+}
@@ -0,0 +1,143 @@
+package x4c
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+func newRegistryTestClient() tzclient.MockClient {
+	fa2 := certificateFA2
+	client := newCertificateTestClient()
+	for index := range client.Events {
+		client.Events[index].Timestamp = time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	}
+	client.Events = append(client.Events, tzkt.Event{
+		// Retired directly on the FA2 contract, with structured metadata
+		Identifier:    103,
+		Level:         600,
+		Timestamp:     time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC),
+		TransactionID: 30,
+		Contract:      tzkt.EventContractInfo{Address: &fa2},
+		Tag:           "retire",
+		Payload:       json.RawMessage(`{"retiring_party": "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5", "tokenId": "1", "amount": "20", "retiring_data": "0501000000267b2276657273696f6e223a312c2262656e6566696369617279223a2241636d65204c7464227d"}`),
+	})
+	return client
+}
+
+func TestListRetirements(t *testing.T) {
+	custodian, _ := tzclient.NewContractWithAddress("custodian", certificateCustodian)
+	fa2, _ := tzclient.NewContractWithAddress("fa2", certificateFA2)
+	contracts := []tzclient.Contract{custodian, fa2}
+	token_id := int64(1)
+	other_token_id := int64(2)
+
+	testcases := []struct {
+		Filter   RetirementFilter
+		Expected []int64
+	}{
+		{
+			Filter:   RetirementFilter{},
+			Expected: []int64{103, 102, 100},
+		},
+		{
+			Filter:   RetirementFilter{KYC: "compsci"},
+			Expected: []int64{102, 100},
+		},
+		{
+			Filter:   RetirementFilter{RetiringParty: "tz1bWfY2RfUMCgjrSooaFuXfGpMCwUzJL7P5"},
+			Expected: []int64{103},
+		},
+		{
+			Filter:   RetirementFilter{TokenAddress: certificateFA2, TokenID: &token_id},
+			Expected: []int64{103, 102, 100},
+		},
+		{
+			Filter:   RetirementFilter{TokenID: &other_token_id},
+			Expected: []int64{},
+		},
+		{
+			Filter:   RetirementFilter{MinAmount: 6},
+			Expected: []int64{103, 102},
+		},
+		{
+			Filter:   RetirementFilter{From: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC)},
+			Expected: []int64{103},
+		},
+		{
+			Filter:   RetirementFilter{To: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC)},
+			Expected: []int64{102, 100},
+		},
+	}
+
+	client := newRegistryTestClient()
+	for index, testcase := range testcases {
+		retirements, err := ListRetirements(context.Background(), client, contracts, testcase.Filter)
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if len(retirements) != len(testcase.Expected) {
+			t.Errorf("%d: Expected %d retirements, got %v", index, len(testcase.Expected), retirements)
+			continue
+		}
+		for row, retirement := range retirements {
+			if retirement.EventID != testcase.Expected[row] {
+				t.Errorf("%d: Expected event %d at row %d, got %d", index, testcase.Expected[row], row, retirement.EventID)
+			}
+		}
+	}
+
+	retirements, _ := ListRetirements(context.Background(), client, contracts, RetirementFilter{})
+	if retirements[0].Metadata.Beneficiary != "Acme Ltd" || retirements[0].Amount != 20 || retirements[0].KYC != "" {
+		t.Errorf("Unexpected FA2 retirement %v", retirements[0])
+	}
+	if retirements[2].KYC != "compsci" || retirements[2].Metadata.Note != "flights" || retirements[2].Contract != certificateCustodian {
+		t.Errorf("Unexpected custodian retirement %v", retirements[2])
+	}
+
+	client.ShouldError = true
+	_, err := ListRetirements(context.Background(), client, contracts, RetirementFilter{})
+	if err == nil {
+		t.Errorf("Expected error when events can't be fetched")
+	}
+}
+
+func TestTotalRetirements(t *testing.T) {
+	custodian, _ := tzclient.NewContractWithAddress("custodian", certificateCustodian)
+	fa2, _ := tzclient.NewContractWithAddress("fa2", certificateFA2)
+	client := newRegistryTestClient()
+
+	retirements, err := ListRetirements(context.Background(), client, []tzclient.Contract{custodian, fa2}, RetirementFilter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	totals, err := TotalRetirements(context.Background(), client, retirements)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(totals.ByToken) != 1 {
+		t.Fatalf("Expected one token, got %v", totals.ByToken)
+	}
+	if token := totals.ByToken[0]; token.Amount != 32 || token.Retirements != 3 || token.TokenTitle != "Forest project" {
+		t.Errorf("Unexpected token total %v", token)
+	}
+
+	expected := []BeneficiaryRetirementTotal{
+		{Beneficiary: "Acme Ltd", Amount: 20, Retirements: 1},
+		{Beneficiary: "", Amount: 12, Retirements: 2},
+	}
+	if len(totals.ByBeneficiary) != len(expected) {
+		t.Fatalf("Expected %d beneficiaries, got %v", len(expected), totals.ByBeneficiary)
+	}
+	for index, total := range totals.ByBeneficiary {
+		if total != expected[index] {
+			t.Errorf("%d: Expected %v, got %v", index, expected[index], total)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package x4c
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"blockwatch.cc/tzgo/micheline"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// The version of RetirementMetadata that we write. Version 0 is used for reasons that
+// were written as free text before there was a schema.
+const RetirementMetadataVersion = 1
+
+const (
+	// Retiring data goes into the operation, so we keep it small enough not to add
+	// noticeably to the fees
+	maxRetirementMetadataSize  = 1024
+	maxRetirementMetadataField = 256
+)
+
+type ReportingPeriod struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// RetirementMetadata says who a retirement was for and why. It is written to the
+// retiring_data bytes of the retire entrypoints as a Michelson packed string holding
+// JSON, so that anything reading the bytes as a packed string, as the x4c event
+// decoders always have, still gets something readable.
+type RetirementMetadata struct {
+	Version          int              `json:"version"`
+	Beneficiary      string           `json:"beneficiary,omitempty"`
+	Purpose          string           `json:"purpose,omitempty"`
+	ReportingPeriod  *ReportingPeriod `json:"reporting_period,omitempty"`
+	ComplianceScheme string           `json:"compliance_scheme,omitempty"`
+	Note             string           `json:"note,omitempty"`
+}
+
+// NoteRetirementMetadata is metadata with just a free text note, which is what a plain
+// reason becomes.
+func NoteRetirementMetadata(note string) RetirementMetadata {
+	return RetirementMetadata{
+		Version: RetirementMetadataVersion,
+		Note:    note,
+	}
+}
+
+// Validate checks the metadata can be written. A version of zero is taken to mean the
+// current version, so callers don't need to set it.
+func (m RetirementMetadata) Validate() error {
+	if m.Version != 0 && m.Version != RetirementMetadataVersion {
+		return fmt.Errorf("unsupported retirement metadata version %d", m.Version)
+	}
+	fields := map[string]string{
+		"beneficiary":       m.Beneficiary,
+		"purpose":           m.Purpose,
+		"compliance scheme": m.ComplianceScheme,
+		"note":              m.Note,
+	}
+	for name, value := range fields {
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("%s is not valid UTF-8", name)
+		}
+		if len(value) > maxRetirementMetadataField {
+			return fmt.Errorf("%s is longer than %d bytes", name, maxRetirementMetadataField)
+		}
+	}
+	if m.ReportingPeriod != nil {
+		start, err := time.Parse("2006-01-02", m.ReportingPeriod.Start)
+		if err != nil {
+			return fmt.Errorf("reporting period start must be a YYYY-MM-DD date")
+		}
+		end, err := time.Parse("2006-01-02", m.ReportingPeriod.End)
+		if err != nil {
+			return fmt.Errorf("reporting period end must be a YYYY-MM-DD date")
+		}
+		if end.Before(start) {
+			return fmt.Errorf("reporting period ends before it starts")
+		}
+	}
+	return nil
+}
+
+// Encode validates the metadata and returns the bytes to put in retiring_data.
+func (m RetirementMetadata) Encode() ([]byte, error) {
+	err := m.Validate()
+	if err != nil {
+		return nil, err
+	}
+	m.Version = RetirementMetadataVersion
+	document, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode retirement metadata: %w", err)
+	}
+	packed := micheline.NewString(string(document)).Pack()
+	if len(packed) > maxRetirementMetadataSize {
+		return nil, fmt.Errorf("retirement metadata is %d bytes, which is more than the limit of %d", len(packed), maxRetirementMetadataSize)
+	}
+	return packed, nil
+}
+
+// DecodeRetirementMetadata reads retiring_data bytes. As well as what Encode writes it
+// accepts the two forms of free text reason written before there was a schema: a
+// packed Michelson string, and the raw bytes of the text. Free text comes back as
+// version 0 metadata with the text as the note.
+func DecodeRetirementMetadata(data []byte) RetirementMetadata {
+	text := string(data)
+	unpacked, err := micheline.NewBytes(data).Unpack()
+	if err == nil && unpacked.Type == micheline.PrimString {
+		text = unpacked.String
+	}
+
+	if strings.HasPrefix(text, "{") {
+		var metadata RetirementMetadata
+		err = json.Unmarshal([]byte(text), &metadata)
+		if err == nil && metadata.Version == RetirementMetadataVersion {
+			return metadata
+		}
+	}
+	return RetirementMetadata{Note: text}
+}
+
+// decodeRetiringText decodes the hex that tzkt gives us for event bytes as text, which
+// is the packed string that Encode writes, or for older retirements the free text
+// reason. Bytes that are neither are left as hex.
+func decodeRetiringText(raw string) string {
+	text, err := tzclient.MichelsonToString(raw)
+	if err == nil {
+		return text
+	}
+	data, err := hex.DecodeString(raw)
+	if err != nil || !utf8.Valid(data) {
+		return raw
+	}
+	return string(data)
+}
+
+// decodeRetiringData decodes the hex that tzkt gives us for event bytes.
+func decodeRetiringData(raw string) RetirementMetadata {
+	data, err := hex.DecodeString(raw)
+	if err != nil {
+		return RetirementMetadata{Note: raw}
+	}
+	return DecodeRetirementMetadata(data)
+}
+
+// String gives a one line summary for people to read.
+func (m RetirementMetadata) String() string {
+	parts := make([]string, 0, 5)
+	if m.Beneficiary != "" {
+		parts = append(parts, fmt.Sprintf("for %s", m.Beneficiary))
+	}
+	if m.Purpose != "" {
+		parts = append(parts, m.Purpose)
+	}
+	if m.ReportingPeriod != nil {
+		parts = append(parts, fmt.Sprintf("period %s to %s", m.ReportingPeriod.Start, m.ReportingPeriod.End))
+	}
+	if m.ComplianceScheme != "" {
+		parts = append(parts, fmt.Sprintf("under %s", m.ComplianceScheme))
+	}
+	if m.Note != "" {
+		parts = append(parts, m.Note)
+	}
+	return strings.Join(parts, "; ")
+}
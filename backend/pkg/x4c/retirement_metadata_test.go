@@ -0,0 +1,160 @@
+package x4c
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"blockwatch.cc/tzgo/micheline"
+)
+
+func TestRetirementMetadataRoundTrip(t *testing.T) {
+	testcases := []RetirementMetadata{
+		NoteRetirementMetadata(""),
+		NoteRetirementMetadata("flights"),
+		{
+			Version:          RetirementMetadataVersion,
+			Beneficiary:      "Acme Ltd",
+			Purpose:          "Offsetting business travel",
+			ReportingPeriod:  &ReportingPeriod{Start: "2023-01-01", End: "2023-12-31"},
+			ComplianceScheme: "CORSIA",
+			Note:             "ünïcode is fine",
+		},
+	}
+
+	for index, testcase := range testcases {
+		data, err := testcase.Encode()
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		decoded := DecodeRetirementMetadata(data)
+		if decoded.String() != testcase.String() || decoded.Version != RetirementMetadataVersion {
+			t.Errorf("%d: Expected %v, got %v", index, testcase, decoded)
+		}
+		if (testcase.ReportingPeriod != nil) && (*decoded.ReportingPeriod != *testcase.ReportingPeriod) {
+			t.Errorf("%d: Expected reporting period %v, got %v", index, testcase.ReportingPeriod, decoded.ReportingPeriod)
+		}
+	}
+}
+
+func TestDecodeLegacyRetiringData(t *testing.T) {
+	testcases := []struct {
+		Data     []byte
+		Expected string
+	}{
+		{
+			Data:     micheline.NewString("flights").Pack(),
+			Expected: "flights",
+		},
+		{
+			Data:     []byte("hi"),
+			Expected: "hi",
+		},
+		{
+			Data:     []byte{},
+			Expected: "",
+		},
+		{
+			// JSON that isn't ours is still just text
+			Data:     micheline.NewString(`{"reason": "flights"}`).Pack(),
+			Expected: `{"reason": "flights"}`,
+		},
+	}
+
+	for index, testcase := range testcases {
+		metadata := DecodeRetirementMetadata(testcase.Data)
+		if metadata.Version != 0 || metadata.Note != testcase.Expected {
+			t.Errorf("%d: Expected legacy note %q, got %v", index, testcase.Expected, metadata)
+		}
+	}
+}
+
+func TestDecodeRetiringText(t *testing.T) {
+	structured, err := RetirementMetadata{Beneficiary: "Acme Ltd"}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		Raw      string
+		Expected string
+	}{
+		{
+			// Structured metadata is left as its JSON
+			Raw:      hex.EncodeToString(structured),
+			Expected: `{"version":1,"beneficiary":"Acme Ltd"}`,
+		},
+		{
+			Raw:      hex.EncodeToString(micheline.NewString("flights").Pack()),
+			Expected: "flights",
+		},
+		{
+			Raw:      hex.EncodeToString([]byte("hi")),
+			Expected: "hi",
+		},
+		{
+			Raw:      "ff00",
+			Expected: "ff00",
+		},
+		{
+			Raw:      "not hex",
+			Expected: "not hex",
+		},
+	}
+
+	for index, testcase := range testcases {
+		text := decodeRetiringText(testcase.Raw)
+		if text != testcase.Expected {
+			t.Errorf("%d: Expected %q, got %q", index, testcase.Expected, text)
+		}
+	}
+}
+
+func TestRetirementMetadataValidate(t *testing.T) {
+	testcases := []struct {
+		Metadata    RetirementMetadata
+		ExpectError bool
+	}{
+		{
+			Metadata:    RetirementMetadata{Purpose: "flights"},
+			ExpectError: false,
+		},
+		{
+			Metadata:    RetirementMetadata{Version: 2},
+			ExpectError: true,
+		},
+		{
+			Metadata:    RetirementMetadata{Beneficiary: strings.Repeat("a", maxRetirementMetadataField+1)},
+			ExpectError: true,
+		},
+		{
+			Metadata:    RetirementMetadata{Note: "\xff"},
+			ExpectError: true,
+		},
+		{
+			Metadata:    RetirementMetadata{ReportingPeriod: &ReportingPeriod{Start: "2023-01-01", End: "2023-01-01"}},
+			ExpectError: false,
+		},
+		{
+			Metadata:    RetirementMetadata{ReportingPeriod: &ReportingPeriod{Start: "2023-01-01"}},
+			ExpectError: true,
+		},
+		{
+			Metadata:    RetirementMetadata{ReportingPeriod: &ReportingPeriod{Start: "2023-02-01", End: "2023-01-01"}},
+			ExpectError: true,
+		},
+		{
+			Metadata:    RetirementMetadata{ReportingPeriod: &ReportingPeriod{Start: "1/1/2023", End: "2023-12-31"}},
+			ExpectError: true,
+		},
+	}
+
+	for index, testcase := range testcases {
+		err := testcase.Metadata.Validate()
+		if testcase.ExpectError && (err == nil) {
+			t.Errorf("Expected error on test case %d", index)
+		} else if !testcase.ExpectError && (err != nil) {
+			t.Errorf("Got unexpected error on test case %d: %v", index, err)
+		}
+	}
+}
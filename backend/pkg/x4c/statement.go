@@ -0,0 +1,307 @@
+package x4c
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"quantify.earth/x4c/pkg/tzclient"
+)
+
+// The KYC that internal mints credit, which holds credits the custodian has received
+// from the FA2 contract but not yet assigned to anyone.
+const SelfKYC = "self"
+
+type StatementEntryType string
+
+const (
+	StatementMint        StatementEntryType = "mint"
+	StatementTransferIn  StatementEntryType = "transfer_in"
+	StatementTransferOut StatementEntryType = "transfer_out"
+	StatementRetire      StatementEntryType = "retire"
+)
+
+// StatementEntry is a single movement of credits on a statement. Amounts are positive
+// for credits to the KYC and negative for debits.
+type StatementEntry struct {
+	Time          time.Time          `json:"time"`
+	Level         int32              `json:"level"`
+	TransactionID int64              `json:"transactionId"`
+	Type          StatementEntryType `json:"type"`
+	Amount        int64              `json:"amount"`
+	Counterparty  string             `json:"counterparty,omitempty"`
+	Reason        string             `json:"reason,omitempty"`
+}
+
+// TokenStatement covers one token held by the KYC. External transfers out of the
+// custodian don't emit events, so anything that changed the balance without an event
+// is reported as Other rather than left to make the balances not add up.
+type TokenStatement struct {
+	Token   TokenID          `json:"token"`
+	Opening int64            `json:"opening"`
+	Entries []StatementEntry `json:"entries"`
+	Other   int64            `json:"other"`
+	Closing int64            `json:"closing"`
+}
+
+type Statement struct {
+	Custodian string           `json:"custodian"`
+	KYC       string           `json:"kyc"`
+	FromLevel int32            `json:"fromLevel"`
+	ToLevel   int32            `json:"toLevel"`
+	Tokens    []TokenStatement `json:"tokens"`
+}
+
+func (l Ledger) balancesFor(kyc string) map[TokenID]int64 {
+	balances := make(map[TokenID]int64)
+	for key, value := range l {
+		decoded, err := key.DecodeKYC()
+		if err != nil {
+			decoded = key.RawKYC
+		}
+		if decoded == kyc {
+			balances[key.Token] = value
+		}
+	}
+	return balances
+}
+
+// GenerateStatement works out the movements of credits for the KYC on the custodian in
+// the period after from up to and including to, along with the balances at either end.
+// If from is the zero Moment then the statement covers everything up to to.
+func GenerateStatement(ctx context.Context, client tzclient.TezosClient, custodian tzclient.Contract, kyc string, from Moment, to Moment) (Statement, error) {
+	to_level, err := to.ResolveLevel(ctx, client)
+	if err != nil {
+		return Statement{}, err
+	}
+	var from_level int32
+	if from != (Moment{}) {
+		from_level, err = from.ResolveLevel(ctx, client)
+		if err != nil {
+			return Statement{}, err
+		}
+	}
+	if from_level > to_level {
+		return Statement{}, fmt.Errorf("statement starts at level %d, which is after it ends at level %d", from_level, to_level)
+	}
+
+	var storage CustodianStorage
+	err = client.GetContractStorage(custodian, ctx, &storage)
+	if err != nil {
+		return Statement{}, fmt.Errorf("failed to get custodian storage: %w", err)
+	}
+	opening := make(map[TokenID]int64)
+	if from_level > 0 {
+		ledger, err := storage.GetLedgerAt(ctx, client, AtLevel(from_level))
+		if err != nil {
+			return Statement{}, err
+		}
+		opening = ledger.balancesFor(kyc)
+	}
+	closing_ledger, err := storage.GetLedgerAt(ctx, client, AtLevel(to_level))
+	if err != nil {
+		return Statement{}, err
+	}
+	closing := closing_ledger.balancesFor(kyc)
+
+	in_period := func(level int32) bool {
+		return level > from_level && level <= to_level
+	}
+	entries := make(map[TokenID][]StatementEntry)
+
+	if kyc == SelfKYC {
+		mints, err := GetInternalMintEvents(ctx, client, custodian)
+		if err != nil {
+			return Statement{}, err
+		}
+		for _, event := range mints {
+			if !in_period(event.Level) {
+				continue
+			}
+			amount, err := event.Amount.Int64()
+			if err != nil {
+				return Statement{}, fmt.Errorf("invalid amount in mint event %d: %w", event.Identifier, err)
+			}
+			entries[event.Token] = append(entries[event.Token], StatementEntry{
+				Time:          event.Timestamp,
+				Level:         event.Level,
+				TransactionID: event.TransactionID,
+				Type:          StatementMint,
+				Amount:        amount,
+			})
+		}
+	}
+
+	transfers, err := GetInternalTransferEvents(ctx, client, custodian)
+	if err != nil {
+		return Statement{}, err
+	}
+	for _, event := range transfers {
+		if !in_period(event.Level) {
+			continue
+		}
+		amount, err := event.Amount.Int64()
+		if err != nil {
+			return Statement{}, fmt.Errorf("invalid amount in transfer event %d: %w", event.Identifier, err)
+		}
+		entry := StatementEntry{
+			Time:          event.Timestamp,
+			Level:         event.Level,
+			TransactionID: event.TransactionID,
+		}
+		// A transfer to yourself is both in and out, which nets to nothing
+		if event.From() == kyc {
+			out := entry
+			out.Type = StatementTransferOut
+			out.Amount = -amount
+			out.Counterparty = event.To()
+			entries[event.Token] = append(entries[event.Token], out)
+		}
+		if event.To() == kyc {
+			in := entry
+			in.Type = StatementTransferIn
+			in.Amount = amount
+			in.Counterparty = event.From()
+			entries[event.Token] = append(entries[event.Token], in)
+		}
+	}
+
+	retirements, err := GetCustodianRetireEvents(ctx, client, custodian)
+	if err != nil {
+		return Statement{}, err
+	}
+	for _, event := range retirements {
+		if !in_period(event.Level) || event.RetiringPartyKyc != kyc {
+			continue
+		}
+		amount, err := event.Amount.Int64()
+		if err != nil {
+			return Statement{}, fmt.Errorf("invalid amount in retire event %d: %w", event.Identifier, err)
+		}
+		entries[event.Token] = append(entries[event.Token], StatementEntry{
+			Time:          event.Timestamp,
+			Level:         event.Level,
+			TransactionID: event.TransactionID,
+			Type:          StatementRetire,
+			Amount:        -amount,
+			Counterparty:  event.RetiringParty,
+			Reason:        event.Metadata.String(),
+		})
+	}
+
+	tokens := make(map[TokenID]bool)
+	for _, balances := range []map[TokenID]int64{opening, closing} {
+		for token := range balances {
+			tokens[token] = true
+		}
+	}
+	for token := range entries {
+		tokens[token] = true
+	}
+
+	statement := Statement{
+		Custodian: custodian.Address.String(),
+		KYC:       kyc,
+		FromLevel: from_level,
+		ToLevel:   to_level,
+		Tokens:    make([]TokenStatement, 0, len(tokens)),
+	}
+	for token := range tokens {
+		token_entries := entries[token]
+		if token_entries == nil {
+			token_entries = make([]StatementEntry, 0)
+		}
+		sort.SliceStable(token_entries, func(i, j int) bool {
+			return token_entries[i].Level < token_entries[j].Level
+		})
+		token_statement := TokenStatement{
+			Token:   token,
+			Opening: opening[token],
+			Entries: token_entries,
+			Closing: closing[token],
+		}
+		token_statement.Other = token_statement.Closing - token_statement.Opening
+		for _, entry := range token_entries {
+			token_statement.Other -= entry.Amount
+		}
+		statement.Tokens = append(statement.Tokens, token_statement)
+	}
+	sort.Slice(statement.Tokens, func(i, j int) bool {
+		return compareTokenIDs(statement.Tokens[i].Token, statement.Tokens[j].Token)
+	})
+	return statement, nil
+}
+
+// WriteCSV writes the statement with one row per entry, and rows for the opening,
+// other, and closing amounts of each token so that the file stands on its own.
+func (s Statement) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	rows := [][]string{{"token_address", "token_id", "time", "level", "type", "amount", "counterparty", "reason"}}
+	for _, token := range s.Tokens {
+		address := token.Token.Address
+		id := token.Token.TokenID.String()
+		rows = append(rows, []string{address, id, "", strconv.FormatInt(int64(s.FromLevel), 10), "opening", strconv.FormatInt(token.Opening, 10), "", ""})
+		for _, entry := range token.Entries {
+			rows = append(rows, []string{
+				address,
+				id,
+				entry.Time.UTC().Format(time.RFC3339),
+				strconv.FormatInt(int64(entry.Level), 10),
+				string(entry.Type),
+				strconv.FormatInt(entry.Amount, 10),
+				entry.Counterparty,
+				entry.Reason,
+			})
+		}
+		if token.Other != 0 {
+			rows = append(rows, []string{address, id, "", "", "other", strconv.FormatInt(token.Other, 10), "", ""})
+		}
+		rows = append(rows, []string{address, id, "", strconv.FormatInt(int64(s.ToLevel), 10), "closing", strconv.FormatInt(token.Closing, 10), "", ""})
+	}
+	err := writer.WriteAll(rows)
+	if err != nil {
+		return fmt.Errorf("failed to write statement: %w", err)
+	}
+	return nil
+}
+
+// WritePDF writes the statement as a plain text document suitable for sending to the
+// client.
+func (s Statement) WritePDF(w io.Writer) error {
+	lines := []string{
+		"Statement of carbon credits",
+		"",
+		fmt.Sprintf("Custodian: %s", s.Custodian),
+		fmt.Sprintf("Account:   %s", s.KYC),
+		fmt.Sprintf("Period:    after level %d up to and including level %d", s.FromLevel, s.ToLevel),
+	}
+	if len(s.Tokens) == 0 {
+		lines = append(lines, "", "No credits were held or moved in this period.")
+	}
+	for _, token := range s.Tokens {
+		lines = append(lines,
+			"",
+			fmt.Sprintf("Token %s of %s", token.Token.TokenID, token.Token.Address),
+			"",
+			fmt.Sprintf("  %-20s %-9s %-13s %12s  %s", "Time", "Level", "Type", "Amount", "Details"),
+			fmt.Sprintf("  %-20s %-9s %-13s %12d", "", "", "Opening", token.Opening),
+		)
+		for _, entry := range token.Entries {
+			details := entry.Counterparty
+			if entry.Reason != "" {
+				details = fmt.Sprintf("%s: %s", details, entry.Reason)
+			}
+			lines = append(lines, fmt.Sprintf("  %-20s %-9d %-13s %12d  %s",
+				entry.Time.UTC().Format("2006-01-02 15:04:05"), entry.Level, entry.Type, entry.Amount, details))
+		}
+		if token.Other != 0 {
+			lines = append(lines, fmt.Sprintf("  %-20s %-9s %-13s %12d  %s", "", "", "Other", token.Other, "e.g. external transfers"))
+		}
+		lines = append(lines, fmt.Sprintf("  %-20s %-9s %-13s %12d", "", "", "Closing", token.Closing))
+	}
+	return writeTextPDF(w, lines)
+}
@@ -0,0 +1,194 @@
+package x4c
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"blockwatch.cc/tzgo/tezos"
+
+	"quantify.earth/x4c/pkg/tzclient"
+	"quantify.earth/x4c/pkg/tzkt"
+)
+
+const (
+	packedSelf       = "05010000000473656c66"
+	packedOtherOrg   = "0501000000096f74686572206f7267"
+	packedThirdParty = "05010000000b7468697264207061727479"
+	packedReason     = "0501000000126f666673657474696e6720666c6967687473"
+	statementToken   = `{"token_id": "42", "token_address": "tz1deC7DBmyTU7DtfV7f4YmpbW3xQkBYEwVB"}`
+)
+
+func newStatementTestClient() tzclient.MockClient {
+	key := json.RawMessage(`{"token": ` + statementToken + `, "kyc": "` + packedOtherOrg + `"}`)
+	client := tzclient.NewMockClient()
+	client.Storage = &CustodianStorage{Ledger: 314}
+	client.AddHistoricalBigMap(314, 100, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`"10"`)}})
+	client.AddHistoricalBigMap(314, 300, []tzkt.BigMapItem{{Active: true, Key: key, Value: json.RawMessage(`"4"`)}})
+	client.Events = []tzkt.Event{
+		{
+			Level:   50,
+			Tag:     "internal_transfer",
+			Payload: json.RawMessage(`{"source": "` + packedSelf + `", "destination": "` + packedOtherOrg + `", "token": ` + statementToken + `, "amount": "10"}`),
+		},
+		{
+			Level:   150,
+			Tag:     "internal_transfer",
+			Payload: json.RawMessage(`{"source": "` + packedSelf + `", "destination": "` + packedOtherOrg + `", "token": ` + statementToken + `, "amount": "3"}`),
+		},
+		{
+			Level:   200,
+			Tag:     "retire",
+			Payload: json.RawMessage(`{"retiring_party": "tz1TJcX5DuAuH2Fgsx5PpKspXU4G3D7TKxZq", "retiring_party_kyc": "` + packedOtherOrg + `", "token": ` + statementToken + `, "amount": "2", "retiring_data": "` + packedReason + `"}`),
+		},
+		{
+			Level:   250,
+			Tag:     "internal_transfer",
+			Payload: json.RawMessage(`{"source": "` + packedOtherOrg + `", "destination": "` + packedThirdParty + `", "token": ` + statementToken + `, "amount": "1"}`),
+		},
+		{
+			Level:   250,
+			Tag:     "internal_mint",
+			Payload: json.RawMessage(`{"token": ` + statementToken + `, "amount": "7", "new_total": "7"}`),
+		},
+	}
+	return client
+}
+
+func TestGenerateStatement(t *testing.T) {
+	custodian := tzclient.Contract{Address: tezos.MustParseAddress("KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")}
+
+	testcases := []struct {
+		KYC             string
+		From            Moment
+		To              Moment
+		ExpectedOpening int64
+		ExpectedClosing int64
+		ExpectedOther   int64
+		ExpectedEntries []StatementEntryType
+		ExpectError     bool
+	}{
+		{
+			// The closing balance is 6 less than the events account for, as would happen
+			// with an external transfer out
+			KYC:             "other org",
+			From:            AtLevel(100),
+			To:              AtLevel(300),
+			ExpectedOpening: 10,
+			ExpectedClosing: 4,
+			ExpectedOther:   -6,
+			ExpectedEntries: []StatementEntryType{StatementTransferIn, StatementRetire, StatementTransferOut},
+		},
+		{
+			KYC:             "other org",
+			To:              AtLevel(100),
+			ExpectedOpening: 0,
+			ExpectedClosing: 10,
+			ExpectedEntries: []StatementEntryType{StatementTransferIn},
+		},
+		{
+			// Only the custodian's own KYC sees mints, and we don't have its ledger
+			KYC:             SelfKYC,
+			From:            AtLevel(200),
+			To:              AtLevel(300),
+			ExpectedOther:   -7,
+			ExpectedEntries: []StatementEntryType{StatementMint},
+		},
+		{
+			KYC:         "other org",
+			From:        AtLevel(300),
+			To:          AtLevel(100),
+			ExpectError: true,
+		},
+	}
+
+	client := newStatementTestClient()
+	for index, testcase := range testcases {
+		statement, err := GenerateStatement(context.Background(), client, custodian, testcase.KYC, testcase.From, testcase.To)
+		if testcase.ExpectError {
+			if err == nil {
+				t.Errorf("%d: Expected error, got %v", index, statement)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error: %v", index, err)
+			continue
+		}
+		if len(statement.Tokens) != 1 {
+			t.Errorf("%d: Expected one token, got %v", index, statement.Tokens)
+			continue
+		}
+		token := statement.Tokens[0]
+		if token.Opening != testcase.ExpectedOpening {
+			t.Errorf("%d: Expected opening %d, got %d", index, testcase.ExpectedOpening, token.Opening)
+		}
+		if token.Closing != testcase.ExpectedClosing {
+			t.Errorf("%d: Expected closing %d, got %d", index, testcase.ExpectedClosing, token.Closing)
+		}
+		if token.Other != testcase.ExpectedOther {
+			t.Errorf("%d: Expected other %d, got %d", index, testcase.ExpectedOther, token.Other)
+		}
+		if len(token.Entries) != len(testcase.ExpectedEntries) {
+			t.Errorf("%d: Expected %d entries, got %v", index, len(testcase.ExpectedEntries), token.Entries)
+			continue
+		}
+		for entry_index, entry := range token.Entries {
+			if entry.Type != testcase.ExpectedEntries[entry_index] {
+				t.Errorf("%d: Expected entry %d to be %s, got %s", index, entry_index, testcase.ExpectedEntries[entry_index], entry.Type)
+			}
+		}
+	}
+}
+
+func TestStatementOutput(t *testing.T) {
+	custodian := tzclient.Contract{Address: tezos.MustParseAddress("KT1QjwDCohN4BEewsWgzkQHLsrv1Sf3s2PCm")}
+	client := newStatementTestClient()
+	statement, err := GenerateStatement(context.Background(), client, custodian, "other org", AtLevel(100), AtLevel(300))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var csv_output bytes.Buffer
+	err = statement.WriteCSV(&csv_output)
+	if err != nil {
+		t.Fatalf("Unexpected error writing CSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csv_output.String()), "\n")
+	// Header, opening, three entries, other, and closing
+	if len(lines) != 7 {
+		t.Errorf("Expected 7 CSV lines, got %d: %v", len(lines), lines)
+	}
+	if !strings.Contains(csv_output.String(), "offsetting flights") {
+		t.Errorf("Expected retirement reason in CSV: %s", csv_output.String())
+	}
+
+	var pdf_output bytes.Buffer
+	err = statement.WritePDF(&pdf_output)
+	if err != nil {
+		t.Fatalf("Unexpected error writing PDF: %v", err)
+	}
+	pdf := pdf_output.String()
+	if !strings.HasPrefix(pdf, "%PDF-") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Errorf("PDF output is not framed as a PDF")
+	}
+	if !strings.Contains(pdf, "(Account:   other org) '") {
+		t.Errorf("Expected account line in PDF")
+	}
+}
+
+func TestWrapPDFLines(t *testing.T) {
+	long := strings.Repeat("x", pdfCharsPerLine*2+3)
+	wrapped := wrapPDFLines([]string{"short", long})
+	if len(wrapped) != 4 {
+		t.Fatalf("Expected 4 lines, got %d", len(wrapped))
+	}
+	if len(wrapped[3]) != 3 {
+		t.Errorf("Expected last line to hold the remaining 3 characters, got %d", len(wrapped[3]))
+	}
+	if escaped := escapePDFText(`a (b) \ é`); escaped != `a \(b\) \\ ?` {
+		t.Errorf("Unexpected escaping: %s", escaped)
+	}
+}